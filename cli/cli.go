@@ -13,7 +13,8 @@ import (
 )
 
 var rootArgs struct {
-	configPath string
+	configPath    string
+	remoteControl string
 }
 
 var rootCmd = &cobra.Command{
@@ -23,6 +24,12 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&rootArgs.configPath, "config", "", "config file path")
+	rootCmd.PersistentFlags().StringVar(&rootArgs.remoteControl, "remote", "", "talk to the daemon's remote control listener at host:port instead of the local control socket")
+}
+
+// RemoteControl returns the address specified with --remote, or "" to use the local control socket.
+func RemoteControl() string {
+	return rootArgs.remoteControl
 }
 
 var genCompletionCmd = &cobra.Command{
@@ -96,17 +103,42 @@ func (s *Subcommand) Config() *config.Config {
 	return s.config
 }
 
-func (s *Subcommand) run(cmd *cobra.Command, args []string) {
+// ExitCodeError is returned by a Subcommand's Run to make the process exit with Code,
+// e.g., if the exit code is part of the command's interface like for Nagios plugins.
+// Err is printed to stderr unless it is nil.
+type ExitCodeError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitCodeError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit code %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (s *Subcommand) run(cmd *cobra.Command, args []string) error {
 	s.tryParseConfig()
 	ctx := context.Background()
 	endTask := trace.WithTaskFromStackUpdateCtx(&ctx)
 	defer endTask()
 	err := s.Run(ctx, s, args)
 	endTask()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
-		os.Exit(1)
+	if err == nil {
+		return nil
+	}
+	// we report the error ourselves, and it is not a usage error
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	exitErr, ok := err.(*ExitCodeError)
+	if !ok {
+		exitErr = &ExitCodeError{Code: 1, Err: err}
 	}
+	if exitErr.Err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", exitErr.Err)
+	}
+	return exitErr
 }
 
 func (s *Subcommand) tryParseConfig() {
@@ -135,7 +167,7 @@ func addSubcommandToCobraCmd(c *cobra.Command, s *Subcommand) {
 		Example: s.Example,
 	}
 	if s.SetupSubcommands == nil {
-		cmd.Run = s.run
+		cmd.RunE = s.run
 	} else {
 		for _, sub := range s.SetupSubcommands() {
 			addSubcommandToCobraCmd(&cmd, sub)
@@ -147,8 +179,14 @@ func addSubcommandToCobraCmd(c *cobra.Command, s *Subcommand) {
 	c.AddCommand(&cmd)
 }
 
-func Run() {
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+// Run executes the command line and returns the exit code for the process.
+func Run() int {
+	err := rootCmd.Execute()
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*ExitCodeError); ok {
+		return exitErr.Code
 	}
+	return 1
 }
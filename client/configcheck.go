@@ -69,8 +69,8 @@ var ConfigcheckCmd = &cli.Subcommand{
 			}
 		}
 
-		// further: try to build logging outlets
-		outlets, err := logging.OutletsFromConfig(*subcommand.Config().Global.Logging)
+		// further: try to build logging outlets, without creating log files
+		outlets, err := logging.CheckOutletsFromConfig(*subcommand.Config().Global.Logging)
 		if err != nil {
 			err := errors.Wrap(err, "cannot build logging from config")
 			if configcheckArgs.what == "logging" {
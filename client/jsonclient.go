@@ -3,32 +3,87 @@ package client
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"io"
 	"net"
 	"net/http"
 
 	"github.com/pkg/errors"
+
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/tlsconf"
 )
 
-func controlHttpClient(sockpath string) (client http.Client, err error) {
-	return http.Client{
-		Transport: &http.Transport{
-			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
-				return net.Dial("unix", sockpath)
+// controlClient talks to the daemon's control socket or,
+// if --remote is specified, to its remote control listener.
+type controlClient struct {
+	http.Client
+	baseURL string
+}
+
+func (c *controlClient) url(endpoint string) string {
+	return c.baseURL + endpoint
+}
+
+func controlHttpClient(conf *config.Config) (client controlClient, err error) {
+	if remote := cli.RemoteControl(); remote != "" {
+		return remoteControlHttpClient(conf.Global.Control.RemoteClient, remote)
+	}
+	sockpath := conf.Global.Control.SockPath
+	return controlClient{
+		Client: http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.Dial("unix", sockpath)
+				},
 			},
 		},
+		baseURL: "http://unix",
+	}, nil
+}
+
+func remoteControlHttpClient(in *config.GlobalControlRemoteClient, address string) (controlClient, error) {
+	if in == nil {
+		return controlClient{}, errors.New("--remote requires global.control.remote_client in the config")
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return controlClient{}, errors.Wrap(err, "invalid --remote address")
+	}
+	serverName := in.ServerCN
+	if serverName == "" {
+		serverName = host
+	}
+	rootCA, err := tlsconf.ParseCAFile(in.Ca)
+	if err != nil {
+		return controlClient{}, errors.Wrap(err, "cannot parse ca file")
+	}
+	cert, err := tls.LoadX509KeyPair(in.Cert, in.Key)
+	if err != nil {
+		return controlClient{}, errors.Wrap(err, "cannot parse cert/key pair")
+	}
+	tlsConfig, err := tlsconf.ClientAuthClient(serverName, rootCA, cert)
+	if err != nil {
+		return controlClient{}, errors.Wrap(err, "cannot build tls config")
+	}
+	return controlClient{
+		Client: http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		baseURL: "https://" + address,
 	}, nil
 }
 
-func jsonRequestResponse(c http.Client, endpoint string, req interface{}, res interface{}) error {
+func jsonRequestResponse(c controlClient, endpoint string, req interface{}, res interface{}) error {
 	var buf bytes.Buffer
 	encodeErr := json.NewEncoder(&buf).Encode(req)
 	if encodeErr != nil {
 		return encodeErr
 	}
 
-	resp, err := c.Post("http://unix"+endpoint, "application/json", &buf)
+	resp, err := c.Post(c.url(endpoint), "application/json", &buf)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/daemon"
+	"github.com/zrepl/zrepl/daemon/logging"
+	"github.com/zrepl/zrepl/logger"
+)
+
+var loglevelArgs struct {
+	job, subsystem string
+	duration       time.Duration
+	reset          bool
+}
+
+var LoglevelCmd = &cli.Subcommand{
+	Use:   "loglevel [LEVEL]",
+	Short: "temporarily change the log level of the running daemon for a job and / or subsystem, or list the active changes",
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.StringVar(&loglevelArgs.job, "job", "", "only change the level of this job's entries")
+		f.StringVar(&loglevelArgs.subsystem, "subsystem", "", "only change the level of this subsystem's entries (e.g. repl, rpc, zfs.cmd, trace.data)")
+		f.DurationVar(&loglevelArgs.duration, "for", 1*time.Hour, "revert the change after this duration")
+		f.BoolVar(&loglevelArgs.reset, "reset", false, "revert all changes")
+	},
+	Run: func(ctx context.Context, subcommand *cli.Subcommand, args []string) error {
+		return runLoglevelCmd(subcommand, args)
+	},
+}
+
+func runLoglevelCmd(subcommand *cli.Subcommand, args []string) error {
+	var req daemon.LogLevelsRequest
+	req.Reset = loglevelArgs.reset
+	switch len(args) {
+	case 0:
+		if loglevelArgs.job != "" || loglevelArgs.subsystem != "" {
+			return errors.Errorf("must specify LEVEL")
+		}
+	case 1:
+		level, err := logger.ParseLevel(args[0])
+		if err != nil {
+			return err
+		}
+		req.Set = &logging.LevelOverride{Job: loglevelArgs.job, Level: level}
+		if loglevelArgs.subsystem != "" {
+			if req.Set.Subsystem, err = logging.ParseSubsystem(loglevelArgs.subsystem); err != nil {
+				return err
+			}
+		}
+		req.For = loglevelArgs.duration
+	default:
+		return errors.Errorf("expected at most one argument: LEVEL")
+	}
+
+	httpc, err := controlHttpClient(subcommand.Config())
+	if err != nil {
+		return err
+	}
+	var active []logging.RuntimeLevelOverride
+	if err := jsonRequestResponse(httpc, daemon.ControlJobEndpointLogLevels, req, &active); err != nil {
+		return err
+	}
+
+	if len(active) == 0 {
+		fmt.Println("no active log level changes, the levels in the config apply")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tSUBSYSTEM\tLEVEL\tUNTIL")
+	orAll := func(s string) string {
+		if s == "" {
+			return "*"
+		}
+		return s
+	}
+	for _, o := range active {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", orAll(o.Job), orAll(string(o.Subsystem)), o.Level, o.Until.Local().Format(time.RFC3339))
+	}
+	return w.Flush()
+}
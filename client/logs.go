@@ -0,0 +1,132 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/daemon"
+	"github.com/zrepl/zrepl/daemon/logging"
+	"github.com/zrepl/zrepl/logger"
+)
+
+var logsArgs struct {
+	job, subsystem, span string
+	level                string
+	follow               bool
+	since                string
+	format               string
+	noColor              bool
+}
+
+var LogsCmd = &cli.Subcommand{
+	Use:   "logs",
+	Short: "show the daemon's recent log entries from its in-memory log buffer",
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.StringVar(&logsArgs.job, "job", "", "only show entries of this job")
+		f.StringVar(&logsArgs.subsystem, "subsystem", "", "only show entries of this subsystem (e.g. repl, snapshot, pruning)")
+		f.StringVar(&logsArgs.span, "span", "", "only show entries of this span and its child spans")
+		f.StringVar(&logsArgs.level, "level", logger.Debug.String(), "minimum level (debug|info|warn|error)")
+		f.BoolVarP(&logsArgs.follow, "follow", "f", false, "keep streaming new entries")
+		f.StringVar(&logsArgs.since, "since", "", "only show entries newer than a relative duration (e.g. 10m) or RFC3339 timestamp")
+		f.StringVar(&logsArgs.format, "format", "human", "output format (human|logfmt|json)")
+		f.BoolVar(&logsArgs.noColor, "no-color", false, "do not colorize output on terminals")
+	},
+	Run: func(ctx context.Context, subcommand *cli.Subcommand, args []string) error {
+		return runLogsCmd(ctx, subcommand, args)
+	},
+}
+
+func parseLogsSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return time.Time{}, errors.Errorf("duration must be positive: %q", s)
+		}
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.Errorf("must be a duration or RFC3339 timestamp: %q", s)
+	}
+	return t, nil
+}
+
+func runLogsCmd(ctx context.Context, subcommand *cli.Subcommand, args []string) error {
+	if len(args) != 0 {
+		return errors.Errorf("subcommand takes no arguments")
+	}
+
+	var req daemon.LogsRequest
+	req.Job = logsArgs.job
+	req.Subsystem = logsArgs.subsystem
+	req.Span = logsArgs.span
+	req.Follow = logsArgs.follow
+	var err error
+	if req.MinLevel, err = logger.ParseLevel(logsArgs.level); err != nil {
+		return errors.Wrap(err, "invalid --level")
+	}
+	if req.Since, err = parseLogsSince(logsArgs.since, time.Now()); err != nil {
+		return errors.Wrap(err, "invalid --since")
+	}
+
+	out, err := logging.NewStdoutOutlet(logsArgs.format, true, !logsArgs.noColor)
+	if err != nil {
+		return errors.Wrap(err, "invalid --format")
+	}
+
+	// no timeout, the response is streamed for as long as --follow is in effect
+	httpc, err := controlHttpClient(subcommand.Config())
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(req); err != nil {
+		return err
+	}
+	hreq, err := http.NewRequest(http.MethodPost, httpc.url(daemon.ControlJobEndpointLogs), &buf)
+	if err != nil {
+		return err
+	}
+	hreq.Header.Set("Content-Type", "application/json")
+	resp, err := httpc.Do(hreq.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var msg bytes.Buffer
+		_, _ = io.CopyN(&msg, resp.Body, 4096) // ignore error, just display what we got
+		return errors.Errorf("%s", msg.String())
+	}
+
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var e logger.Entry
+			if err := json.Unmarshal(line, &e); err != nil {
+				return errors.Wrap(err, "cannot decode log entry")
+			}
+			if err := out.WriteEntry(e); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || (err != nil && ctx.Err() != nil) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "cannot read log stream")
+		}
+	}
+}
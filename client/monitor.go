@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+
+	"github.com/zrepl/zrepl/cli"
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon"
+	"github.com/zrepl/zrepl/daemon/checks"
+)
+
+var monitorArgs struct {
+	checks []string
+}
+
+var MonitorCmd = &cli.Subcommand{
+	Use:   "monitor",
+	Short: "evaluate the configured monitoring checks (Nagios / Icinga plugin)",
+	Example: `  zrepl monitor
+  zrepl monitor --check prod_rpo --check prod_failures`,
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.StringSliceVar(&monitorArgs.checks, "check", nil, "only evaluate the check with this name (repeatable, default: all checks)")
+	},
+	Run: func(ctx context.Context, subcommand *cli.Subcommand, args []string) error {
+		state, results, err := runMonitor(subcommand.Config())
+		if err != nil {
+			// Nagios plugins report errors on stdout
+			fmt.Printf("ZREPL %s - %s\n", checks.StateUnknown, err)
+			return &cli.ExitCodeError{Code: int(checks.StateUnknown)}
+		}
+		if err := checks.WriteNagios(os.Stdout, state, results); err != nil {
+			return &cli.ExitCodeError{Code: int(checks.StateUnknown), Err: err}
+		}
+		if state != checks.StateOK {
+			return &cli.ExitCodeError{Code: int(state)}
+		}
+		return nil
+	},
+}
+
+func runMonitor(conf *config.Config) (checks.State, []*checks.Result, error) {
+	var cs []*checks.Check
+	var found bool
+	for _, m := range conf.Global.Monitoring {
+		in, ok := m.Ret.(*config.ChecksMonitoring)
+		if !ok {
+			continue
+		}
+		if found {
+			return 0, nil, errors.New("config must contain at most one monitoring section of type `checks`")
+		}
+		found = true
+		var err error
+		cs, err = checks.FromConfig(in, conf.Jobs)
+		if err != nil {
+			return 0, nil, errors.Wrap(err, "invalid monitoring checks")
+		}
+	}
+	if !found {
+		return 0, nil, errors.New("config does not contain a monitoring section of type `checks`")
+	}
+
+	if len(monitorArgs.checks) > 0 {
+		byName := make(map[string]*checks.Check, len(cs))
+		for _, c := range cs {
+			byName[c.Name()] = c
+		}
+		selected := make([]*checks.Check, 0, len(monitorArgs.checks))
+		for _, name := range monitorArgs.checks {
+			c, ok := byName[name]
+			if !ok {
+				return 0, nil, errors.Errorf("check %q does not exist", name)
+			}
+			selected = append(selected, c)
+		}
+		cs = selected
+	}
+
+	httpc, err := controlHttpClient(conf)
+	if err != nil {
+		return 0, nil, err
+	}
+	var status daemon.Status
+	if err := jsonRequestResponse(httpc, daemon.ControlJobEndpointStatus, struct{}{}, &status); err != nil {
+		return 0, nil, errors.Wrap(err, "cannot get status from daemon")
+	}
+
+	state, results := checks.EvaluateAll(cs, status.Jobs, time.Now())
+	return state, results, nil
+}
@@ -54,7 +54,7 @@ func RunPProf(conf *config.Config) {
 
 	log.Printf("connecting to zrepl daemon")
 
-	httpc, err := controlHttpClient(conf.Global.Control.SockPath)
+	httpc, err := controlHttpClient(conf)
 	if err != nil {
 		log.Printf("error creating http client: %s", err)
 		die()
@@ -23,7 +23,7 @@ func runSignalCmd(config *config.Config, args []string) error {
 		return errors.Errorf("Expected 2 arguments: [wakeup|reset] JOB")
 	}
 
-	httpc, err := controlHttpClient(config.Global.Control.SockPath)
+	httpc, err := controlHttpClient(config)
 	if err != nil {
 		return err
 	}
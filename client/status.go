@@ -182,13 +182,13 @@ var StatusCmd = &cli.Subcommand{
 }
 
 func runStatus(ctx context.Context, s *cli.Subcommand, args []string) error {
-	httpc, err := controlHttpClient(s.Config().Global.Control.SockPath)
+	httpc, err := controlHttpClient(s.Config())
 	if err != nil {
 		return err
 	}
 
 	if statusFlags.Raw {
-		resp, err := httpc.Get("http://unix" + daemon.ControlJobEndpointStatus)
+		resp, err := httpc.Get(httpc.url(daemon.ControlJobEndpointStatus))
 		if err != nil {
 			return err
 		}
@@ -353,6 +353,13 @@ func (t *tui) draw() {
 					t.addIndent(-1)
 				}
 
+				if activeStatus.RemoteCapabilities == nil {
+					t.printf("Remote Capabilities: not connected yet")
+				} else {
+					t.printf("Remote Capabilities: %s", formatCapabilities(activeStatus.RemoteCapabilities))
+				}
+				t.newline()
+
 			} else if v.Type == job.TypeSnap {
 				snapStatus, ok := v.JobSpecific.(*job.SnapJobStatus)
 				if !ok || snapStatus == nil {
@@ -377,6 +384,31 @@ func (t *tui) draw() {
 				t.addIndent(1)
 				t.renderSnapperReport(st.Snapper)
 				t.addIndent(-1)
+				t.renderClientCapabilities(st.ClientCapabilities)
+
+			} else if v.Type == job.TypeSink {
+
+				st := v.JobSpecific.(*job.PassiveStatus)
+				t.printf("Pruning:")
+				t.newline()
+				t.addIndent(1)
+				if st.Pruning == nil {
+					t.printf("...\n")
+				}
+				clientIdentities := make([]string, 0, len(st.Pruning))
+				for clientIdentity := range st.Pruning {
+					clientIdentities = append(clientIdentities, clientIdentity)
+				}
+				sort.Strings(clientIdentities)
+				for _, clientIdentity := range clientIdentities {
+					t.printf("Client %s:", clientIdentity)
+					t.newline()
+					t.addIndent(1)
+					t.renderPrunerReport(st.Pruning[clientIdentity])
+					t.addIndent(-1)
+				}
+				t.addIndent(-1)
+				t.renderClientCapabilities(st.ClientCapabilities)
 
 			} else {
 				t.printf("No status representation for job type '%s', dumping as YAML", v.Type)
@@ -428,13 +460,22 @@ func (t *tui) renderReplicationReport(rep *report.Report, history *bytesProgress
 		t.printf("no attempts made yet")
 		return
 	} else {
-		t.printf("Attempt #%d", len(rep.Attempts))
+		latest := rep.Attempts[len(rep.Attempts)-1]
+		if latest.MaxAttempts > 0 {
+			t.printf("Attempt #%d of %d", len(rep.Attempts), latest.MaxAttempts)
+		} else {
+			t.printf("Attempt #%d", len(rep.Attempts))
+		}
 		if len(rep.Attempts) > 1 {
 			t.printf(". Previous attempts failed with the following statuses:")
 			t.newline()
 			t.addIndent(1)
 			for i, a := range rep.Attempts[:len(rep.Attempts)-1] {
-				t.printfDrawIndentedAndWrappedIfMultiline("#%d: %s (failed at %s) (ran %s)", i+1, a.State, a.FinishAt, a.FinishAt.Sub(a.StartAt))
+				errorClass := ""
+				if a.ErrorClass != "" {
+					errorClass = fmt.Sprintf(" (%s error)", a.ErrorClass)
+				}
+				t.printfDrawIndentedAndWrappedIfMultiline("#%d: %s%s (failed at %s) (ran %s)", i+1, a.State, errorClass, a.FinishAt, a.FinishAt.Sub(a.StartAt))
 				t.newline()
 			}
 			t.addIndent(-1)
@@ -450,6 +491,14 @@ func (t *tui) renderReplicationReport(rep *report.Report, history *bytesProgress
 
 	t.printf("Status: %s", latest.State)
 	t.newline()
+	if !latest.NextRetryAt.IsZero() {
+		if delta := time.Until(latest.NextRetryAt).Round(time.Second); delta > 0 {
+			t.printf("Retry: %s error, next attempt in %s @ %s", latest.ErrorClass, delta, latest.NextRetryAt)
+		} else {
+			t.printf("Retry: %s error, next attempt started or waiting for reconnect", latest.ErrorClass)
+		}
+		t.newline()
+	}
 	if latest.State == report.AttemptPlanningError {
 		t.printf("Problem: ")
 		t.printfDrawIndentedAndWrappedIfMultiline("%s", latest.PlanError)
@@ -494,6 +543,33 @@ func (t *tui) renderReplicationReport(rep *report.Report, history *bytesProgress
 
 }
 
+func formatCapabilities(capabilities []string) string {
+	if len(capabilities) == 0 {
+		return "none"
+	}
+	return strings.Join(capabilities, ", ")
+}
+
+func (t *tui) renderClientCapabilities(byClient map[string][]string) {
+	t.printf("Client Capabilities:")
+	t.newline()
+	t.addIndent(1)
+	if len(byClient) == 0 {
+		t.printf("no client connected yet")
+		t.newline()
+	}
+	clientIdentities := make([]string, 0, len(byClient))
+	for clientIdentity := range byClient {
+		clientIdentities = append(clientIdentities, clientIdentity)
+	}
+	sort.Strings(clientIdentities)
+	for _, clientIdentity := range clientIdentities {
+		t.printf("%s: %s", clientIdentity, formatCapabilities(byClient[clientIdentity]))
+		t.newline()
+	}
+	t.addIndent(-1)
+}
+
 func (t *tui) renderPrunerReport(r *pruner.Report) {
 	if r == nil {
 		t.printf("...\n")
@@ -745,7 +821,14 @@ func (t *tui) printFilesystemStatus(rep *report.FilesystemReport, active bool, m
 		status)
 
 	next := ""
-	if err := rep.Error(); err != nil {
+	if stalled := rep.StalledStep(); stalled != nil {
+		step := fmt.Sprintf("full send %s", stalled.Info.To)
+		if stalled.IsIncremental() {
+			step = fmt.Sprintf("%s => %s", stalled.Info.From, stalled.Info.To)
+		}
+		next = fmt.Sprintf("STALLED: %s aborted at %s: %s",
+			step, stalled.Stalled.Time.Format(time.Stamp), stalled.Stalled.Err)
+	} else if err := rep.Error(); err != nil {
 		next = err.Err
 	} else if rep.State != report.FilesystemDone {
 		if nextStep := rep.NextStep(); nextStep != nil {
@@ -762,6 +845,14 @@ func (t *tui) printFilesystemStatus(rep *report.FilesystemReport, active bool, m
 
 			attribs = append(attribs, fmt.Sprintf("encrypted=%s", nextStep.Info.Encrypted))
 
+			if nextStep.Info.Compression != "" {
+				if ratio := nextStep.Info.CompressionRatio(); ratio > 0 {
+					attribs = append(attribs, fmt.Sprintf("compression=%s ratio=%.2f", nextStep.Info.Compression, ratio))
+				} else {
+					attribs = append(attribs, fmt.Sprintf("compression=%s", nextStep.Info.Compression))
+				}
+			}
+
 			next += fmt.Sprintf(" (%s)", strings.Join(attribs, ", "))
 		} else {
 			next = "" // individual FSes may still be in planning state
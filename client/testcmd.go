@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
@@ -12,13 +13,14 @@ import (
 	"github.com/zrepl/zrepl/cli"
 	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/daemon/filters"
+	"github.com/zrepl/zrepl/daemon/job"
 	"github.com/zrepl/zrepl/zfs"
 )
 
 var TestCmd = &cli.Subcommand{
 	Use: "test",
 	SetupSubcommands: func() []*cli.Subcommand {
-		return []*cli.Subcommand{testFilter, testPlaceholder, testDecodeResumeToken}
+		return []*cli.Subcommand{testFilter, testPlaceholder, testDecodeResumeToken, testReplication}
 	},
 }
 
@@ -210,3 +212,90 @@ func runTestDecodeResumeTokenCmd(ctx context.Context, subcommand *cli.Subcommand
 	}
 	return nil
 }
+
+var testReplicationArgs struct {
+	job  string
+	json bool
+}
+
+var testReplication = &cli.Subcommand{
+	Use:   "replication --job JOB [--json]",
+	Short: "connect to the push or pull job's passive side and show what replication would do, without replicating",
+	SetupFlags: func(f *pflag.FlagSet) {
+		f.StringVar(&testReplicationArgs.job, "job", "", "the name of the push or pull job")
+		f.BoolVar(&testReplicationArgs.json, "json", false, "emit the plan as JSON")
+	},
+	Run: runTestReplicationCmd,
+}
+
+func runTestReplicationCmd(ctx context.Context, subcommand *cli.Subcommand, args []string) error {
+	if testReplicationArgs.job == "" {
+		return fmt.Errorf("must specify --job flag")
+	}
+
+	conf := subcommand.Config()
+	jobConf, err := conf.Job(testReplicationArgs.job)
+	if err != nil {
+		return err
+	}
+
+	fss, err := job.ReplicationDryRun(ctx, conf.Global, *jobConf)
+	if err != nil {
+		return err
+	}
+
+	if testReplicationArgs.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(fss); err != nil {
+			panic(err)
+		}
+	} else {
+		for _, fs := range fss {
+			fmt.Printf("%s\n", fs.Filesystem)
+			if fs.CommonAncestor != "" {
+				fmt.Printf("  common ancestor: %s\n", fs.CommonAncestor)
+			} else {
+				fmt.Printf("  common ancestor: none\n")
+			}
+			if fs.ResumeToken != "" {
+				fmt.Printf("  resume token: %s\n", fs.ResumeToken)
+			}
+			if fs.Conflict != "" {
+				fmt.Printf("  conflict: %s\n", strings.TrimSpace(fs.Conflict))
+				fmt.Printf("  conflict resolution: %s\n", fs.ConflictResolution)
+			}
+			if fs.PlanError != "" {
+				fmt.Printf("  planning error: %s\n", strings.TrimSpace(fs.PlanError))
+				continue
+			}
+			if len(fs.Steps) == 0 {
+				fmt.Printf("  steps: none, up to date\n")
+				continue
+			}
+			fmt.Printf("  steps:\n")
+			for _, step := range fs.Steps {
+				from := step.From
+				if from == "" {
+					from = "(full)"
+				}
+				resumed := ""
+				if step.Resumed {
+					resumed = " (resumed)"
+				}
+				size := "unknown size"
+				if step.BytesExpected > 0 {
+					size = ByteCountBinary(step.BytesExpected)
+				}
+				fmt.Printf("    %s => %s%s, %s, encrypted=%s\n", from, step.To, resumed, size, step.Encrypted)
+			}
+		}
+	}
+
+	for _, fs := range fss {
+		if fs.PlanError != "" {
+			return fmt.Errorf("planning failed for some filesystems")
+		}
+	}
+	return nil
+}
@@ -51,7 +51,7 @@ func runVersionCmd() error {
 			return fmt.Errorf("config parsing error: %s", args.ConfigErr)
 		}
 
-		httpc, err := controlHttpClient(args.Config.Global.Control.SockPath)
+		httpc, err := controlHttpClient(args.Config)
 		if err != nil {
 			return fmt.Errorf("server: error: %s\n", err)
 		}
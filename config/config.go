@@ -58,6 +58,17 @@ type ActiveJob struct {
 	Pruning     PruningSenderReceiver `yaml:"pruning"`
 	Debug       JobDebugSettings      `yaml:"debug,optional"`
 	Replication *Replication          `yaml:"replication,optional,fromdefaults"`
+	Monitoring  *ActiveJobMonitoring  `yaml:"monitoring,optional,fromdefaults"`
+}
+
+type ActiveJobMonitoring struct {
+	Freshness *FreshnessMetrics `yaml:"freshness,optional,fromdefaults"`
+}
+
+// FreshnessMetrics limits the label cardinality of the per-filesystem freshness metrics.
+type FreshnessMetrics struct {
+	Filesystems    FilesystemsFilter `yaml:"filesystems,optional"` // default: all filesystems
+	MaxFilesystems int               `yaml:"max_filesystems,optional,default=1000"`
 }
 
 type PassiveJob struct {
@@ -89,7 +100,14 @@ type RecvOptions struct {
 }
 
 type Replication struct {
-	Protection *ReplicationOptionsProtection `yaml:"protection,optional,fromdefaults"`
+	Protection  *ReplicationOptionsProtection  `yaml:"protection,optional,fromdefaults"`
+	Retry       *ReplicationOptionsRetry       `yaml:"retry,optional,fromdefaults"`
+	Compression *ReplicationOptionsCompression `yaml:"compression,optional,fromdefaults"`
+	Checksum    string                         `yaml:"checksum,optional,default=off"`
+	Stripes     int                            `yaml:"stripes,optional,default=1"`
+
+	StepTimeout   time.Duration `yaml:"step_timeout,optional,zeropositive,default=0s"`
+	MinThroughput int64         `yaml:"min_throughput,optional,default=0"`
 }
 
 type ReplicationOptionsProtection struct {
@@ -97,6 +115,30 @@ type ReplicationOptionsProtection struct {
 	Incremental string `yaml:"incremental,optional,default=guarantee_resumability"`
 }
 
+type ReplicationOptionsCompression struct {
+	Algorithm string `yaml:"algorithm,optional,default=off"`
+	Level     int    `yaml:"level,optional,default=0"`
+}
+
+type ReplicationOptionsRetry struct {
+	MaxAttempts      int                             `yaml:"max_attempts,optional,default=3"`
+	ReconnectTimeout time.Duration                   `yaml:"reconnect_timeout,optional,zeropositive,default=10m"`
+	Backoff          *ReplicationOptionsRetryBackoff `yaml:"backoff,optional,fromdefaults"`
+	OnError          *ReplicationOptionsRetryOnError `yaml:"on_error,optional,fromdefaults"`
+}
+
+type ReplicationOptionsRetryBackoff struct {
+	Initial    time.Duration `yaml:"initial,optional,zeropositive,default=10s"`
+	Max        time.Duration `yaml:"max,optional,zeropositive,default=5m"`
+	Multiplier float64       `yaml:"multiplier,optional,default=2"`
+	Jitter     float64       `yaml:"jitter,optional,default=0.1"`
+}
+
+type ReplicationOptionsRetryOnError struct {
+	Network   string `yaml:"network,optional,default=retry"`
+	Permanent string `yaml:"permanent,optional,default=fail"`
+}
+
 type PushJob struct {
 	ActiveJob    `yaml:",inline"`
 	Snapshotting SnapshottingEnum  `yaml:"snapshotting"`
@@ -153,6 +195,7 @@ type SinkJob struct {
 	PassiveJob `yaml:",inline"`
 	RootFS     string       `yaml:"root_fs"`
 	Recv       *RecvOptions `yaml:"recv,optional,fromdefaults"`
+	Pruning    *PruningSink `yaml:"pruning,optional"`
 }
 
 func (j *SinkJob) GetRootFS() string             { return j.RootFS }
@@ -195,6 +238,11 @@ type PruningLocal struct {
 	Keep []PruningEnum `yaml:"keep"`
 }
 
+type PruningSink struct {
+	PruningLocal `yaml:",inline"`
+	Interval     time.Duration `yaml:"interval,positive"`
+}
+
 type LoggingOutletEnumList []LoggingOutletEnum
 
 func (l *LoggingOutletEnumList) SetDefault() {
@@ -216,7 +264,7 @@ var _ yaml.Defaulter = &LoggingOutletEnumList{}
 
 type Global struct {
 	Logging    *LoggingOutletEnumList `yaml:"logging,optional,fromdefaults"`
-	Monitoring []MonitoringEnum       `yaml:"monitoring,optional"`
+	Monitoring MonitoringEnumList     `yaml:"monitoring,optional"`
 	Control    *GlobalControl         `yaml:"control,optional,fromdefaults"`
 	Serve      *GlobalServe           `yaml:"serve,optional,fromdefaults"`
 }
@@ -245,16 +293,46 @@ type TCPConnect struct {
 	ConnectCommon `yaml:",inline"`
 	Address       string        `yaml:"address,hostport"`
 	DialTimeout   time.Duration `yaml:"dial_timeout,zeropositive,default=10s"`
+	Proxy         *ConnectProxy `yaml:"proxy,optional"`
+}
+
+// ConnectProxy configures a SOCKS5 or HTTP CONNECT proxy through which a connecter dials.
+type ConnectProxy struct {
+	URL             string   `yaml:"url"`                       // socks5://host:port or http://host:port
+	CredentialsFile string   `yaml:"credentials_file,optional"` // file containing username:password
+	NoProxy         []string `yaml:"no_proxy,optional"`
+}
+
+type TCPPSKConnect struct {
+	ConnectCommon  `yaml:",inline"`
+	Address        string        `yaml:"address,hostport"`
+	ClientIdentity string        `yaml:"client_identity"`
+	KeyFile        string        `yaml:"key_file"`
+	Encrypt        bool          `yaml:"encrypt,default=false"`
+	DialTimeout    time.Duration `yaml:"dial_timeout,zeropositive,default=10s"`
 }
 
 type TLSConnect struct {
-	ConnectCommon `yaml:",inline"`
-	Address       string        `yaml:"address,hostport"`
-	Ca            string        `yaml:"ca"`
-	Cert          string        `yaml:"cert"`
-	Key           string        `yaml:"key"`
-	ServerCN      string        `yaml:"server_cn"`
-	DialTimeout   time.Duration `yaml:"dial_timeout,zeropositive,default=10s"`
+	ConnectCommon  `yaml:",inline"`
+	Address        string             `yaml:"address,hostport"`
+	Ca             string             `yaml:"ca"`
+	Cert           string             `yaml:"cert"`
+	Key            string             `yaml:"key"`
+	ServerCN       string             `yaml:"server_cn,optional"`
+	ServerIdentity *TLSServerIdentity `yaml:"server_identity,optional"`
+	DialTimeout    time.Duration      `yaml:"dial_timeout,zeropositive,default=10s"`
+	Proxy          *ConnectProxy      `yaml:"proxy,optional"`
+}
+
+// TLSIdentity specifies how an identity is extracted from a certificate.
+type TLSIdentity struct {
+	From  string `yaml:"from"` // cn, dns_san or uri_san
+	Regex string `yaml:"regex,optional"`
+}
+
+type TLSServerIdentity struct {
+	TLSIdentity `yaml:",inline"`
+	Expect      string `yaml:"expect"`
 }
 
 type SSHStdinserverConnect struct {
@@ -269,6 +347,22 @@ type SSHStdinserverConnect struct {
 	DialTimeout          time.Duration `yaml:"dial_timeout,zeropositive,default=10s"`
 }
 
+type SSHConnect struct {
+	ConnectCommon     `yaml:",inline"`
+	Address           string        `yaml:"address,hostport"`
+	IdentityFile      string        `yaml:"identity_file"`
+	KnownHosts        string        `yaml:"known_hosts"`
+	DialTimeout       time.Duration `yaml:"dial_timeout,zeropositive,default=10s"`
+	KeepaliveInterval time.Duration `yaml:"keepalive_interval,zeropositive,default=15s"`
+	KeepaliveCountMax int           `yaml:"keepalive_count_max,optional,default=3"`
+}
+
+type UnixConnect struct {
+	ConnectCommon `yaml:",inline"`
+	Path          string        `yaml:"path"`
+	DialTimeout   time.Duration `yaml:"dial_timeout,zeropositive,default=10s"`
+}
+
 type LocalConnect struct {
 	ConnectCommon  `yaml:",inline"`
 	ListenerName   string        `yaml:"listener_name"`
@@ -281,7 +375,40 @@ type ServeEnum struct {
 }
 
 type ServeCommon struct {
-	Type string `yaml:"type"`
+	Type   string       `yaml:"type"`
+	Limits *ServeLimits `yaml:"limits,optional,fromdefaults"`
+}
+
+// Common returns the fields shared by all serve types.
+func (t *ServeEnum) Common() *ServeCommon {
+	switch v := t.Ret.(type) {
+	case *TCPServe:
+		return &v.ServeCommon
+	case *TCPPSKServe:
+		return &v.ServeCommon
+	case *TLSServe:
+		return &v.ServeCommon
+	case *StdinserverServer:
+		return &v.ServeCommon
+	case *SSHServe:
+		return &v.ServeCommon
+	case *UnixServe:
+		return &v.ServeCommon
+	case *LocalServe:
+		return &v.ServeCommon
+	default:
+		panic(fmt.Sprintf("implementation error: unknown serve type %T", v))
+	}
+}
+
+type ServeLimits struct {
+	RetryAfter time.Duration                 `yaml:"retry_after,optional,positive,default=30s"`
+	Default    *ServeClientLimits            `yaml:"default,optional,fromdefaults"`
+	Clients    map[string]*ServeClientLimits `yaml:"clients,optional"`
+}
+
+type ServeClientLimits struct {
+	MaxConcurrentTransfers int `yaml:"max_concurrent_transfers,optional,default=0"`
 }
 
 type TCPServe struct {
@@ -291,6 +418,19 @@ type TCPServe struct {
 	Clients        map[string]string `yaml:"clients"`
 }
 
+type TCPPSKServe struct {
+	ServeCommon      `yaml:",inline"`
+	Listen           string                        `yaml:"listen,hostport"`
+	ListenFreeBind   bool                          `yaml:"listen_freebind,default=false"`
+	Clients          map[string]*TCPPSKServeClient `yaml:"clients"`
+	HandshakeTimeout time.Duration                 `yaml:"handshake_timeout,zeropositive,default=10s"`
+}
+
+type TCPPSKServeClient struct {
+	KeyFile string `yaml:"key_file"`
+	Encrypt bool   `yaml:"encrypt,default=false"`
+}
+
 type TLSServe struct {
 	ServeCommon      `yaml:",inline"`
 	Listen           string        `yaml:"listen,hostport"`
@@ -298,7 +438,11 @@ type TLSServe struct {
 	Ca               string        `yaml:"ca"`
 	Cert             string        `yaml:"cert"`
 	Key              string        `yaml:"key"`
-	ClientCNs        []string      `yaml:"client_cns"`
+	ClientCNs        []string      `yaml:"client_cns,optional"`
+	ClientIdentities []string      `yaml:"client_identities,optional"`
+	ClientIdentity   *TLSIdentity  `yaml:"client_identity,optional"`
+	CRL              string        `yaml:"crl,optional"`
+	CRLRejectExpired bool          `yaml:"crl_reject_expired,default=false"`
 	HandshakeTimeout time.Duration `yaml:"handshake_timeout,zeropositive,default=10s"`
 }
 
@@ -307,6 +451,21 @@ type StdinserverServer struct {
 	ClientIdentities []string `yaml:"client_identities"`
 }
 
+type SSHServe struct {
+	ServeCommon      `yaml:",inline"`
+	Listen           string            `yaml:"listen,hostport"`
+	ListenFreeBind   bool              `yaml:"listen_freebind,default=false"`
+	HostKeys         []string          `yaml:"host_keys"`
+	Clients          map[string]string `yaml:"clients"`
+	HandshakeTimeout time.Duration     `yaml:"handshake_timeout,zeropositive,default=10s"`
+}
+
+type UnixServe struct {
+	ServeCommon `yaml:",inline"`
+	Path        string            `yaml:"path"`
+	Clients     map[string]string `yaml:"clients"` // uid:N, gid:N, user:NAME or group:NAME => client identity
+}
+
 type LocalServe struct {
 	ServeCommon  `yaml:",inline"`
 	ListenerName string `yaml:"listener_name"`
@@ -337,9 +496,17 @@ type LoggingOutletEnum struct {
 }
 
 type LoggingOutletCommon struct {
-	Type   string `yaml:"type"`
-	Level  string `yaml:"level"`
-	Format string `yaml:"format"`
+	Type      string                 `yaml:"type"`
+	Level     string                 `yaml:"level"`
+	Format    string                 `yaml:"format"`
+	Overrides []LoggingLevelOverride `yaml:"overrides,optional"`
+}
+
+// LoggingLevelOverride replaces the outlet's level for entries of a job and / or subsystem.
+type LoggingLevelOverride struct {
+	Job       string `yaml:"job,optional"`
+	Subsystem string `yaml:"subsystem,optional"`
+	Level     string `yaml:"level"`
 }
 
 type StdoutLoggingOutlet struct {
@@ -354,6 +521,25 @@ type SyslogLoggingOutlet struct {
 	RetryInterval       time.Duration   `yaml:"retry_interval,positive,default=10s"`
 }
 
+type JournaldLoggingOutlet struct {
+	LoggingOutletCommon `yaml:",inline"`
+	Socket              string        `yaml:"socket,optional,default=/run/systemd/journal/socket"`
+	RetryInterval       time.Duration `yaml:"retry_interval,positive,default=10s"`
+}
+
+type FileLoggingOutlet struct {
+	LoggingOutletCommon `yaml:",inline"`
+	Path                string `yaml:"path"`
+	// rotate when the file would exceed max_size bytes (0 disables size-based rotation)
+	MaxSize int64 `yaml:"max_size,optional,default=104857600"`
+	// rotate when the file has been written to for longer than max_age (0 disables age-based rotation)
+	MaxAge time.Duration `yaml:"max_age,optional,zeropositive,default=0s"`
+	// number of rotated files to keep (0 keeps all)
+	MaxBackups int  `yaml:"max_backups,optional,default=10"`
+	Compress   bool `yaml:"compress,optional,default=true"`
+	Time       bool `yaml:"time,optional,default=true"`
+}
+
 type TCPLoggingOutlet struct {
 	LoggingOutletCommon `yaml:",inline"`
 	Address             string               `yaml:"address,hostport"`
@@ -378,6 +564,83 @@ type PrometheusMonitoring struct {
 	ListenFreeBind bool   `yaml:"listen_freebind,default=false"`
 }
 
+// HealthMonitoring serves HTTP health (/healthz) and readiness (/readyz) endpoints.
+type HealthMonitoring struct {
+	Type           string `yaml:"type"`
+	Listen         string `yaml:"listen,hostport"`
+	ListenFreeBind bool   `yaml:"listen_freebind,default=false"`
+	// a job whose current invocation runs longer than this is considered stuck, 0 disables the check
+	MaxInvocationDuration time.Duration `yaml:"max_invocation_duration,optional,zeropositive,default=0s"`
+}
+
+type ChecksMonitoring struct {
+	Type   string             `yaml:"type"`
+	Checks []*MonitoringCheck `yaml:"checks"`
+}
+
+// MonitoringCheck is evaluated by `zrepl monitor` and exported as Prometheus metrics.
+type MonitoringCheck struct {
+	Name        string            `yaml:"name"`
+	Job         string            `yaml:"job"`
+	Filesystems FilesystemsFilter `yaml:"filesystems,optional"` // default: all filesystems of the job
+	// 0 means that the snapshot age is not checked
+	MaxSnapshotAge time.Duration `yaml:"max_snapshot_age,optional,zeropositive,default=0s"`
+	// nil means that failures are not checked
+	MaxConsecutiveFailures *int `yaml:"max_consecutive_failures,optional"`
+}
+
+// OpenTelemetryMonitoring exports tasks and spans to an OpenTelemetry collector using OTLP/HTTP.
+type OpenTelemetryMonitoring struct {
+	Type string `yaml:"type"`
+	// Base URL of the collector's OTLP/HTTP receiver, e.g. http://localhost:4318
+	Endpoint    string            `yaml:"endpoint"`
+	ServiceName string            `yaml:"service_name,optional,default=zrepl"`
+	Headers     map[string]string `yaml:"headers,optional"`
+	Timeout     time.Duration     `yaml:"timeout,optional,positive,default=10s"`
+}
+
+// MonitoringPushCommon contains the settings shared by the monitoring types
+// that periodically push the daemon's metrics to a remote endpoint.
+type MonitoringPushCommon struct {
+	URL       string                   `yaml:"url"`
+	Interval  time.Duration            `yaml:"interval,optional,positive,default=30s"`
+	Timeout   time.Duration            `yaml:"timeout,optional,positive,default=10s"`
+	TLS       *MonitoringPushTLS       `yaml:"tls,optional"`
+	BasicAuth *MonitoringPushBasicAuth `yaml:"basic_auth,optional"`
+}
+
+type MonitoringPushTLS struct {
+	CA string `yaml:"ca,optional"` // default: system cert pool
+	// client certificate, optional
+	Cert       string `yaml:"cert,optional"`
+	Key        string `yaml:"key,optional"`
+	ServerName string `yaml:"server_name,optional"` // default: host of the url
+}
+
+type MonitoringPushBasicAuth struct {
+	Username     string `yaml:"username"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// PushgatewayMonitoring pushes the daemon's metrics to a Prometheus Pushgateway.
+type PushgatewayMonitoring struct {
+	Type                 string `yaml:"type"`
+	MonitoringPushCommon `yaml:",inline"`
+	Job                  string            `yaml:"job,optional,default=zrepl"`
+	Instance             string            `yaml:"instance,optional"` // default: hostname
+	Grouping             map[string]string `yaml:"grouping,optional"`
+}
+
+// RemoteWriteMonitoring pushes the daemon's metrics to an endpoint that
+// implements the Prometheus remote write protocol.
+type RemoteWriteMonitoring struct {
+	Type                 string `yaml:"type"`
+	MonitoringPushCommon `yaml:",inline"`
+	Job                  string            `yaml:"job,optional,default=zrepl"`
+	Instance             string            `yaml:"instance,optional"` // default: hostname
+	Labels               map[string]string `yaml:"labels,optional"`
+}
+
 type SyslogFacility syslog.Priority
 
 func (f *SyslogFacility) SetDefault() {
@@ -388,6 +651,34 @@ var _ yaml.Defaulter = (*SyslogFacility)(nil)
 
 type GlobalControl struct {
 	SockPath string `yaml:"sockpath,default=/var/run/zrepl/control"`
+	// size in bytes of the in-memory buffer of recent log entries served by `zrepl logs`
+	LogBufferSize int `yaml:"log_buffer_size,optional,default=8388608"`
+	// optional control listener over TLS, in addition to the control socket
+	Remote *GlobalControlRemote `yaml:"remote,optional"`
+	// TLS client identity used by `zrepl --remote`
+	RemoteClient *GlobalControlRemoteClient `yaml:"remote_client,optional"`
+}
+
+type GlobalControlRemote struct {
+	Listen           string        `yaml:"listen,hostport"`
+	ListenFreeBind   bool          `yaml:"listen_freebind,default=false"`
+	Ca               string        `yaml:"ca"`
+	Cert             string        `yaml:"cert"`
+	Key              string        `yaml:"key"`
+	CRL              string        `yaml:"crl,optional"`
+	CRLRejectExpired bool          `yaml:"crl_reject_expired,default=false"`
+	ClientIdentity   *TLSIdentity  `yaml:"client_identity,optional"`
+	HandshakeTimeout time.Duration `yaml:"handshake_timeout,zeropositive,default=10s"`
+	// client identity => role (read_only or operator)
+	Clients map[string]string `yaml:"clients"`
+}
+
+type GlobalControlRemoteClient struct {
+	Ca   string `yaml:"ca"`
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	// defaults to the host of the --remote address
+	ServerCN string `yaml:"server_cn,optional"`
 }
 
 type GlobalServe struct {
@@ -473,8 +764,11 @@ func (t *JobEnum) UnmarshalYAML(u func(interface{}, bool) error) (err error) {
 func (t *ConnectEnum) UnmarshalYAML(u func(interface{}, bool) error) (err error) {
 	t.Ret, err = enumUnmarshal(u, map[string]interface{}{
 		"tcp":             &TCPConnect{},
+		"tcp+psk":         &TCPPSKConnect{},
 		"tls":             &TLSConnect{},
 		"ssh+stdinserver": &SSHStdinserverConnect{},
+		"ssh":             &SSHConnect{},
+		"unix":            &UnixConnect{},
 		"local":           &LocalConnect{},
 	})
 	return
@@ -483,8 +777,11 @@ func (t *ConnectEnum) UnmarshalYAML(u func(interface{}, bool) error) (err error)
 func (t *ServeEnum) UnmarshalYAML(u func(interface{}, bool) error) (err error) {
 	t.Ret, err = enumUnmarshal(u, map[string]interface{}{
 		"tcp":         &TCPServe{},
+		"tcp+psk":     &TCPPSKServe{},
 		"tls":         &TLSServe{},
 		"stdinserver": &StdinserverServer{},
+		"ssh":         &SSHServe{},
+		"unix":        &UnixServe{},
 		"local":       &LocalServe{},
 	})
 	return
@@ -510,20 +807,47 @@ func (t *SnapshottingEnum) UnmarshalYAML(u func(interface{}, bool) error) (err e
 
 func (t *LoggingOutletEnum) UnmarshalYAML(u func(interface{}, bool) error) (err error) {
 	t.Ret, err = enumUnmarshal(u, map[string]interface{}{
-		"stdout": &StdoutLoggingOutlet{},
-		"syslog": &SyslogLoggingOutlet{},
-		"tcp":    &TCPLoggingOutlet{},
+		"stdout":   &StdoutLoggingOutlet{},
+		"syslog":   &SyslogLoggingOutlet{},
+		"tcp":      &TCPLoggingOutlet{},
+		"file":     &FileLoggingOutlet{},
+		"journald": &JournaldLoggingOutlet{},
 	})
 	return
 }
 
 func (t *MonitoringEnum) UnmarshalYAML(u func(interface{}, bool) error) (err error) {
 	t.Ret, err = enumUnmarshal(u, map[string]interface{}{
-		"prometheus": &PrometheusMonitoring{},
+		"prometheus":    &PrometheusMonitoring{},
+		"checks":        &ChecksMonitoring{},
+		"health":        &HealthMonitoring{},
+		"opentelemetry": &OpenTelemetryMonitoring{},
+		"pushgateway":   &PushgatewayMonitoring{},
+		"remote_write":  &RemoteWriteMonitoring{},
 	})
 	return
 }
 
+type MonitoringEnumList []MonitoringEnum
+
+func (l *MonitoringEnumList) UnmarshalYAML(u func(interface{}, bool) error) (err error) {
+	var list []MonitoringEnum
+	if err := u(&list, true); err != nil {
+		return err
+	}
+	// each type is run as an internal job with a fixed name
+	seen := make(map[reflect.Type]bool, len(list))
+	for i, m := range list {
+		t := reflect.TypeOf(m.Ret)
+		if seen[t] {
+			return fmt.Errorf("monitoring #%d: each monitoring type may be specified at most once", i)
+		}
+		seen[t] = true
+	}
+	*l = list
+	return nil
+}
+
 func (t *SyslogFacility) UnmarshalYAML(u func(interface{}, bool) error) (err error) {
 	var s string
 	if err := u(&s, true); err != nil {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/syslog"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -57,6 +58,75 @@ global:
 	assert.NotNil(t, (*conf.Global.Logging)[3].Ret.(*TCPLoggingOutlet).TLS)
 }
 
+func TestFileLoggingOutlet(t *testing.T) {
+	conf := testValidGlobalSection(t, `
+global:
+  logging:
+  - type: stdout
+    level: warn
+    format: human
+  - type: file
+    level: debug
+    format: json
+    path: /var/log/zrepl/zrepl.log
+  - type: file
+    level: info
+    format: logfmt
+    path: /var/log/zrepl/info.log
+    max_size: 1048576
+    max_age: 24h
+    max_backups: 0
+    compress: false
+`)
+	def := (*conf.Global.Logging)[1].Ret.(*FileLoggingOutlet)
+	assert.Equal(t, "/var/log/zrepl/zrepl.log", def.Path)
+	assert.Equal(t, int64(100<<20), def.MaxSize)
+	assert.Equal(t, time.Duration(0), def.MaxAge)
+	assert.Equal(t, 10, def.MaxBackups)
+	assert.True(t, def.Compress)
+	assert.True(t, def.Time)
+
+	o := (*conf.Global.Logging)[2].Ret.(*FileLoggingOutlet)
+	assert.Equal(t, int64(1<<20), o.MaxSize)
+	assert.Equal(t, 24*time.Hour, o.MaxAge)
+	assert.Equal(t, 0, o.MaxBackups)
+	assert.False(t, o.Compress)
+}
+
+func TestJournaldLoggingOutlet(t *testing.T) {
+	conf := testValidGlobalSection(t, `
+global:
+  logging:
+  - type: journald
+    level: info
+    format: human
+`)
+	o := (*conf.Global.Logging)[0].Ret.(*JournaldLoggingOutlet)
+	assert.Equal(t, "/run/systemd/journal/socket", o.Socket)
+	assert.Equal(t, 10*time.Second, o.RetryInterval)
+}
+
+func TestLoggingLevelOverrides(t *testing.T) {
+	conf := testValidGlobalSection(t, `
+global:
+  logging:
+  - type: stdout
+    level: warn
+    format: human
+    overrides:
+    - job: prod
+      level: debug
+    - job: prod
+      subsystem: rpc
+      level: info
+`)
+	o := (*conf.Global.Logging)[0].Ret.(*StdoutLoggingOutlet)
+	assert.Equal(t, []LoggingLevelOverride{
+		{Job: "prod", Level: "debug"},
+		{Job: "prod", Subsystem: "rpc", Level: "info"},
+	}, o.Overrides)
+}
+
 func TestDefaultLoggingOutlet(t *testing.T) {
 	conf := testValidGlobalSection(t, "")
 	assert.Equal(t, 1, len(*conf.Global.Logging))
@@ -65,6 +135,47 @@ func TestDefaultLoggingOutlet(t *testing.T) {
 	assert.Equal(t, "human", o.Format)
 }
 
+func TestControlLogBufferSize(t *testing.T) {
+	conf := testValidGlobalSection(t, "")
+	assert.Equal(t, 8<<20, conf.Global.Control.LogBufferSize)
+
+	conf = testValidGlobalSection(t, `
+global:
+  control:
+    log_buffer_size: 1048576
+`)
+	assert.Equal(t, 1<<20, conf.Global.Control.LogBufferSize)
+}
+
+func TestControlRemote(t *testing.T) {
+	conf := testValidGlobalSection(t, "")
+	assert.Nil(t, conf.Global.Control.Remote)
+	assert.Nil(t, conf.Global.Control.RemoteClient)
+
+	conf = testValidGlobalSection(t, `
+global:
+  control:
+    remote:
+      listen: ":8889"
+      ca: /etc/zrepl/ca.crt
+      cert: /etc/zrepl/prod.crt
+      key: /etc/zrepl/prod.key
+      clients:
+        dashboard: read_only
+        ops: operator
+    remote_client:
+      ca: /etc/zrepl/ca.crt
+      cert: /etc/zrepl/ops.crt
+      key: /etc/zrepl/ops.key
+`)
+	r := conf.Global.Control.Remote
+	assert.Equal(t, ":8889", r.Listen)
+	assert.Equal(t, 10*time.Second, r.HandshakeTimeout)
+	assert.Equal(t, map[string]string{"dashboard": "read_only", "ops": "operator"}, r.Clients)
+	assert.Equal(t, "/etc/zrepl/ops.crt", conf.Global.Control.RemoteClient.Cert)
+	assert.Equal(t, "", conf.Global.Control.RemoteClient.ServerCN)
+}
+
 func TestPrometheusMonitoring(t *testing.T) {
 	conf := testValidGlobalSection(t, `
 global:
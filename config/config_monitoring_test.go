@@ -0,0 +1,212 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActiveJobMonitoringFreshness(t *testing.T) {
+	tmpl := `
+jobs:
+- name: foo
+  type: push
+  connect:
+    type: local
+    listener_name: bar
+    client_identity: baz
+  filesystems: {"<": true}
+  snapshotting:
+    type: manual
+  pruning:
+    keep_sender:
+    - type: last_n
+      count: 10
+    keep_receiver:
+    - type: last_n
+      count: 10
+  %s
+`
+	fill := func(s string) string { return fmt.Sprintf(tmpl, s) }
+
+	t.Run("defaults", func(t *testing.T) {
+		c := testValidConfig(t, fill(""))
+		m := c.Jobs[0].Ret.(*PushJob).Monitoring
+		require.NotNil(t, m)
+		require.NotNil(t, m.Freshness)
+		assert.Equal(t, 1000, m.Freshness.MaxFilesystems)
+		assert.Empty(t, m.Freshness.Filesystems)
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		c := testValidConfig(t, fill(`monitoring: {freshness: {filesystems: {"pool/important<": true}, max_filesystems: 10}}`))
+		f := c.Jobs[0].Ret.(*PushJob).Monitoring.Freshness
+		assert.Equal(t, 10, f.MaxFilesystems)
+		assert.Equal(t, FilesystemsFilter{"pool/important<": true}, f.Filesystems)
+	})
+}
+
+func TestChecksMonitoring(t *testing.T) {
+	c := testValidConfig(t, `
+global:
+  monitoring:
+  - type: checks
+    checks:
+    - name: rpo
+      job: foo
+      filesystems: {"pool/important<": true}
+      max_snapshot_age: 6h
+    - name: failures
+      job: foo
+      max_consecutive_failures: 0
+jobs:
+- {name: foo, type: snap, filesystems: {"<": true}, snapshotting: {type: manual}, pruning: {keep: [{type: last_n, count: 1}]}}
+`)
+	require.Len(t, c.Global.Monitoring, 1)
+	m, ok := c.Global.Monitoring[0].Ret.(*ChecksMonitoring)
+	require.True(t, ok)
+	require.Len(t, m.Checks, 2)
+
+	assert.Equal(t, "rpo", m.Checks[0].Name)
+	assert.Equal(t, 6*time.Hour, m.Checks[0].MaxSnapshotAge)
+	assert.Nil(t, m.Checks[0].MaxConsecutiveFailures)
+	assert.Equal(t, FilesystemsFilter{"pool/important<": true}, m.Checks[0].Filesystems)
+
+	assert.Equal(t, time.Duration(0), m.Checks[1].MaxSnapshotAge)
+	require.NotNil(t, m.Checks[1].MaxConsecutiveFailures)
+	assert.Equal(t, 0, *m.Checks[1].MaxConsecutiveFailures)
+}
+
+func TestOpenTelemetryMonitoring(t *testing.T) {
+	tmpl := `
+global:
+  monitoring:
+  - type: opentelemetry
+    endpoint: http://localhost:4318
+    %s
+jobs:
+- {name: foo, type: snap, filesystems: {"<": true}, snapshotting: {type: manual}, pruning: {keep: [{type: last_n, count: 1}]}}
+`
+	fill := func(s string) string { return fmt.Sprintf(tmpl, s) }
+
+	t.Run("defaults", func(t *testing.T) {
+		c := testValidConfig(t, fill(""))
+		m, ok := c.Global.Monitoring[0].Ret.(*OpenTelemetryMonitoring)
+		require.True(t, ok)
+		assert.Equal(t, "http://localhost:4318", m.Endpoint)
+		assert.Equal(t, "zrepl", m.ServiceName)
+		assert.Equal(t, 10*time.Second, m.Timeout)
+		assert.Empty(t, m.Headers)
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		c := testValidConfig(t, fill(`service_name: backup-server
+    timeout: 3s
+    headers: {Authorization: "Bearer secret"}`))
+		m := c.Global.Monitoring[0].Ret.(*OpenTelemetryMonitoring)
+		assert.Equal(t, "backup-server", m.ServiceName)
+		assert.Equal(t, 3*time.Second, m.Timeout)
+		assert.Equal(t, map[string]string{"Authorization": "Bearer secret"}, m.Headers)
+	})
+}
+
+func TestPushMonitoring(t *testing.T) {
+	c := testValidConfig(t, `
+global:
+  monitoring:
+  - type: pushgateway
+    url: https://pushgateway.example.com:9091
+    grouping: {site: home}
+    tls:
+      ca: /etc/zrepl/ca.crt
+    basic_auth:
+      username: zrepl
+      password_file: /etc/zrepl/pushgateway.password
+  - type: remote_write
+    url: https://prometheus.example.com/api/v1/write
+    instance: laptop
+    interval: 1m
+jobs:
+- {name: foo, type: snap, filesystems: {"<": true}, snapshotting: {type: manual}, pruning: {keep: [{type: last_n, count: 1}]}}
+`)
+	require.Len(t, c.Global.Monitoring, 2)
+
+	pg, ok := c.Global.Monitoring[0].Ret.(*PushgatewayMonitoring)
+	require.True(t, ok)
+	assert.Equal(t, "https://pushgateway.example.com:9091", pg.URL)
+	assert.Equal(t, "zrepl", pg.Job)
+	assert.Equal(t, "", pg.Instance)
+	assert.Equal(t, map[string]string{"site": "home"}, pg.Grouping)
+	assert.Equal(t, 30*time.Second, pg.Interval)
+	assert.Equal(t, 10*time.Second, pg.Timeout)
+	require.NotNil(t, pg.TLS)
+	assert.Equal(t, "/etc/zrepl/ca.crt", pg.TLS.CA)
+	assert.Equal(t, "", pg.TLS.Cert)
+	require.NotNil(t, pg.BasicAuth)
+	assert.Equal(t, "zrepl", pg.BasicAuth.Username)
+	assert.Equal(t, "/etc/zrepl/pushgateway.password", pg.BasicAuth.PasswordFile)
+
+	rw, ok := c.Global.Monitoring[1].Ret.(*RemoteWriteMonitoring)
+	require.True(t, ok)
+	assert.Equal(t, "laptop", rw.Instance)
+	assert.Equal(t, time.Minute, rw.Interval)
+	assert.Nil(t, rw.TLS)
+	assert.Nil(t, rw.BasicAuth)
+}
+
+func TestHealthMonitoring(t *testing.T) {
+	tmpl := `
+global:
+  monitoring:
+  - type: health
+%s
+jobs:
+- {name: foo, type: snap, filesystems: {"<": true}, snapshotting: {type: manual}, pruning: {keep: [{type: last_n, count: 1}]}}
+`
+	c := testValidConfig(t, fmt.Sprintf(tmpl, `    listen: ':9811'`))
+	require.Len(t, c.Global.Monitoring, 1)
+	h := c.Global.Monitoring[0].Ret.(*HealthMonitoring)
+	assert.Equal(t, ":9811", h.Listen)
+	assert.False(t, h.ListenFreeBind)
+	assert.Equal(t, time.Duration(0), h.MaxInvocationDuration)
+
+	c = testValidConfig(t, fmt.Sprintf(tmpl, `
+    listen: '127.0.0.1:9812'
+    max_invocation_duration: 12h`))
+	h = c.Global.Monitoring[0].Ret.(*HealthMonitoring)
+	assert.Equal(t, 12*time.Hour, h.MaxInvocationDuration)
+}
+
+func TestMonitoringTypesAtMostOnce(t *testing.T) {
+	tmpl := `
+global:
+  monitoring:
+%s
+jobs:
+- {name: foo, type: snap, filesystems: {"<": true}, snapshotting: {type: manual}, pruning: {keep: [{type: last_n, count: 1}]}}
+`
+	for _, entry := range []string{
+		`  - {type: prometheus, listen: ':9811'}`,
+		`  - {type: checks, checks: []}`,
+		`  - {type: health, listen: ':9812'}`,
+		`  - {type: opentelemetry, endpoint: 'http://localhost:4318'}`,
+		`  - {type: pushgateway, url: 'http://localhost:9091'}`,
+		`  - {type: remote_write, url: 'http://localhost:9090/api/v1/write'}`,
+	} {
+		_, err := testConfig(t, fmt.Sprintf(tmpl, entry))
+		require.NoError(t, err, entry)
+		_, err = testConfig(t, fmt.Sprintf(tmpl, entry+"\n"+entry))
+		require.Error(t, err, entry)
+		assert.Contains(t, err.Error(), "at most once")
+	}
+
+	// different types can be combined
+	c := testValidConfig(t, fmt.Sprintf(tmpl, `
+  - {type: prometheus, listen: ':9811'}
+  - {type: health, listen: ':9812'}
+  - {type: pushgateway, url: 'http://localhost:9091'}`))
+	assert.Len(t, c.Global.Monitoring, 3)
+}
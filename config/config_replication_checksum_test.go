@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicationChecksum(t *testing.T) {
+	tmpl := `
+jobs:
+- name: foo
+  type: push
+  connect:
+    type: local
+    listener_name: foo
+    client_identity: bar
+  filesystems: {"<": true}
+  %s
+  snapshotting:
+    type: manual
+  pruning:
+    keep_sender:
+    - type: last_n
+      count: 10
+    keep_receiver:
+    - type: last_n
+      count: 10
+`
+	fill := func(s string) string { return fmt.Sprintf(tmpl, s) }
+
+	c := testValidConfig(t, fill(""))
+	assert.Equal(t, "off", c.Jobs[0].Ret.(*PushJob).Replication.Checksum)
+
+	c = testValidConfig(t, fill(`
+  replication:
+    checksum: xxhash64
+`))
+	assert.Equal(t, "xxhash64", c.Jobs[0].Ret.(*PushJob).Replication.Checksum)
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicationCompression(t *testing.T) {
+	tmpl := `
+jobs:
+- name: foo
+  type: push
+  connect:
+    type: local
+    listener_name: foo
+    client_identity: bar
+  filesystems: {"<": true}
+  %s
+  snapshotting:
+    type: manual
+  pruning:
+    keep_sender:
+    - type: last_n
+      count: 10
+    keep_receiver:
+    - type: last_n
+      count: 10
+`
+	fill := func(s string) string { return fmt.Sprintf(tmpl, s) }
+
+	c := testValidConfig(t, fill(""))
+	r := c.Jobs[0].Ret.(*PushJob).Replication.Compression
+	require.NotNil(t, r)
+	assert.Equal(t, "off", r.Algorithm)
+	assert.Equal(t, 0, r.Level)
+
+	c = testValidConfig(t, fill(`
+  replication:
+    compression:
+      algorithm: zstd
+      level: 3
+`))
+	r = c.Jobs[0].Ret.(*PushJob).Replication.Compression
+	assert.Equal(t, "zstd", r.Algorithm)
+	assert.Equal(t, 3, r.Level)
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicationRetry(t *testing.T) {
+	tmpl := `
+jobs:
+- name: foo
+  type: push
+  connect:
+    type: local
+    listener_name: foo
+    client_identity: bar
+  filesystems: {"<": true}
+  %s
+  snapshotting:
+    type: manual
+  pruning:
+    keep_sender:
+    - type: last_n
+      count: 10
+    keep_receiver:
+    - type: last_n
+      count: 10
+`
+	fill := func(s string) string { return fmt.Sprintf(tmpl, s) }
+
+	t.Run("defaults", func(t *testing.T) {
+		c := testValidConfig(t, fill(""))
+		r := c.Jobs[0].Ret.(*PushJob).Replication.Retry
+		require.NotNil(t, r)
+		assert.Equal(t, 3, r.MaxAttempts)
+		assert.Equal(t, 10*time.Minute, r.ReconnectTimeout)
+		require.NotNil(t, r.Backoff)
+		assert.Equal(t, 10*time.Second, r.Backoff.Initial)
+		assert.Equal(t, 5*time.Minute, r.Backoff.Max)
+		assert.Equal(t, 2.0, r.Backoff.Multiplier)
+		assert.Equal(t, 0.1, r.Backoff.Jitter)
+		require.NotNil(t, r.OnError)
+		assert.Equal(t, "retry", r.OnError.Network)
+		assert.Equal(t, "fail", r.OnError.Permanent)
+	})
+
+	t.Run("stall_detection", func(t *testing.T) {
+		c := testValidConfig(t, fill(""))
+		r := c.Jobs[0].Ret.(*PushJob).Replication
+		assert.Equal(t, time.Duration(0), r.StepTimeout)
+		assert.Equal(t, int64(0), r.MinThroughput)
+
+		c = testValidConfig(t, fill(`
+  replication:
+    step_timeout: 5m
+    min_throughput: 1024
+`))
+		r = c.Jobs[0].Ret.(*PushJob).Replication
+		assert.Equal(t, 5*time.Minute, r.StepTimeout)
+		assert.Equal(t, int64(1024), r.MinThroughput)
+	})
+
+	t.Run("specified", func(t *testing.T) {
+		c := testValidConfig(t, fill(`
+  replication:
+    retry:
+      max_attempts: 0
+      reconnect_timeout: 0s
+      backoff:
+        initial: 1m
+        jitter: 0.5
+      on_error:
+        permanent: retry
+`))
+		r := c.Jobs[0].Ret.(*PushJob).Replication.Retry
+		assert.Equal(t, 0, r.MaxAttempts)
+		assert.Equal(t, time.Duration(0), r.ReconnectTimeout)
+		assert.Equal(t, time.Minute, r.Backoff.Initial)
+		assert.Equal(t, 5*time.Minute, r.Backoff.Max)
+		assert.Equal(t, 0.5, r.Backoff.Jitter)
+		assert.Equal(t, "retry", r.OnError.Network)
+		assert.Equal(t, "retry", r.OnError.Permanent)
+	})
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicationStripes(t *testing.T) {
+	tmpl := `
+jobs:
+- name: foo
+  type: push
+  connect:
+    type: local
+    listener_name: foo
+    client_identity: bar
+  filesystems: {"<": true}
+  %s
+  snapshotting:
+    type: manual
+  pruning:
+    keep_sender:
+    - type: last_n
+      count: 10
+    keep_receiver:
+    - type: last_n
+      count: 10
+`
+	fill := func(s string) string { return fmt.Sprintf(tmpl, s) }
+
+	c := testValidConfig(t, fill(""))
+	assert.Equal(t, 1, c.Jobs[0].Ret.(*PushJob).Replication.Stripes)
+
+	c = testValidConfig(t, fill(`
+  replication:
+    stripes: 4
+`))
+	assert.Equal(t, 4, c.Jobs[0].Ret.(*PushJob).Replication.Stripes)
+}
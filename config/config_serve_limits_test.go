@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeLimits(t *testing.T) {
+	tmpl := `
+jobs:
+- name: foo
+  type: source
+  serve:
+    type: tcp
+    listen: ":8888"
+    clients: {"192.168.0.1": "prod1"}
+    %s
+  filesystems: {"<": true}
+  snapshotting:
+    type: manual
+`
+	fill := func(s string) string { return fmt.Sprintf(tmpl, s) }
+
+	t.Run("defaults", func(t *testing.T) {
+		c := testValidConfig(t, fill(""))
+		l := c.Jobs[0].Ret.(*SourceJob).Serve.Common().Limits
+		require.NotNil(t, l)
+		assert.Equal(t, 30*time.Second, l.RetryAfter)
+		require.NotNil(t, l.Default)
+		assert.Equal(t, 0, l.Default.MaxConcurrentTransfers)
+		assert.Empty(t, l.Clients)
+	})
+
+	t.Run("per_client", func(t *testing.T) {
+		c := testValidConfig(t, fill(`limits: {retry_after: 1m, default: {max_concurrent_transfers: 2}, clients: {prod1: {max_concurrent_transfers: 4}}}`))
+		l := c.Jobs[0].Ret.(*SourceJob).Serve.Common().Limits
+		assert.Equal(t, time.Minute, l.RetryAfter)
+		assert.Equal(t, 2, l.Default.MaxConcurrentTransfers)
+		require.Contains(t, l.Clients, "prod1")
+		assert.Equal(t, 4, l.Clients["prod1"].MaxConcurrentTransfers)
+	})
+
+	t.Run("invalid_retry_after", func(t *testing.T) {
+		_, err := testConfig(t, fill(`limits: {retry_after: 0s}`))
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSinkPruning(t *testing.T) {
+	tmpl := `
+jobs:
+- name: foo
+  type: sink
+  root_fs: "pool/backups"
+  serve:
+    type: local
+    listener_name: foo
+  %s
+`
+	fill := func(s string) string { return fmt.Sprintf(tmpl, s) }
+
+	t.Run("not_specified", func(t *testing.T) {
+		c := testValidConfig(t, fill(""))
+		assert.Nil(t, c.Jobs[0].Ret.(*SinkJob).Pruning)
+	})
+
+	t.Run("specified", func(t *testing.T) {
+		c := testValidConfig(t, fill(`
+  pruning:
+    interval: 1h
+    keep:
+    - type: last_n
+      count: 10
+`))
+		p := c.Jobs[0].Ret.(*SinkJob).Pruning
+		require.NotNil(t, p)
+		assert.Equal(t, time.Hour, p.Interval)
+		require.Len(t, p.Keep, 1)
+		assert.IsType(t, &PruneKeepLastN{}, p.Keep[0].Ret)
+	})
+
+	t.Run("interval_required", func(t *testing.T) {
+		_, err := testConfig(t, fill(`
+  pruning:
+    keep:
+    - type: last_n
+      count: 10
+`))
+		assert.Error(t, err)
+	})
+
+	t.Run("interval_must_be_positive", func(t *testing.T) {
+		_, err := testConfig(t, fill(`
+  pruning:
+    interval: 0s
+    keep:
+    - type: last_n
+      count: 10
+`))
+		assert.Error(t, err)
+	})
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -55,6 +56,64 @@ jobs:
 			server_cn: "server1"
 			`,
 		},
+		{
+			Name:        "tcp_with_proxy",
+			ExpectError: false,
+			Connect: `
+			type: tcp
+			address: 10.0.0.23:42
+			proxy: {url: "socks5://proxy.example.com:1080", credentials_file: /etc/zrepl/proxy-credentials, no_proxy: ["10.0.0.0/8", "*.example.com"]}
+			`,
+		},
+		{
+			Name:        "tls_with_proxy_without_url",
+			ExpectError: true,
+			Connect: `
+			type: tls
+			address: "server1.foo.bar:8888"
+			ca:   /etc/zrepl/ca.crt
+			cert: /etc/zrepl/backupserver.fullchain
+			key:  /etc/zrepl/backupserver.key
+			server_cn: "server1"
+			proxy: {no_proxy: ["10.0.0.0/8"]}
+			`,
+		},
+		{
+			Name:        "tcp+psk_with_address_and_port",
+			ExpectError: false,
+			Connect: `
+			type: tcp+psk
+			address: 10.0.0.23:42
+			client_identity: laptop1
+			key_file: /etc/zrepl/psk/laptop1.key
+			encrypt: true
+			`,
+		},
+		{
+			Name:        "tcp+psk_without_port",
+			ExpectError: true,
+			Connect: `
+			type: tcp+psk
+			address: 10.0.0.23
+			client_identity: laptop1
+			key_file: /etc/zrepl/psk/laptop1.key
+			`,
+		},
+		{
+			Name:        "unix",
+			ExpectError: false,
+			Connect: `
+			type: unix
+			path: /var/run/zrepl-tenant1/backup.sock
+			`,
+		},
+		{
+			Name:        "unix_without_path",
+			ExpectError: true,
+			Connect: `
+			type: unix
+			`,
+		},
 		{
 			Name:        "tcp_without_port",
 			ExpectError: true,
@@ -97,3 +156,45 @@ jobs:
 	}
 
 }
+
+func TestTransportServeTCPPSK(t *testing.T) {
+	c := testValidConfig(t, `
+jobs:
+- name: foo
+  type: sink
+  root_fs: "pool/backups"
+  serve:
+    type: tcp+psk
+    listen: ":8888"
+    clients:
+      laptop1:
+        key_file: /etc/zrepl/psk/laptop1.key
+        encrypt: true
+      server1:
+        key_file: /etc/zrepl/psk/server1.key
+`)
+	serve := c.Jobs[0].Ret.(*SinkJob).Serve.Ret.(*TCPPSKServe)
+	require.Len(t, serve.Clients, 2)
+	require.Equal(t, "/etc/zrepl/psk/laptop1.key", serve.Clients["laptop1"].KeyFile)
+	require.True(t, serve.Clients["laptop1"].Encrypt)
+	require.False(t, serve.Clients["server1"].Encrypt)
+	require.Equal(t, 10*time.Second, serve.HandshakeTimeout)
+}
+
+func TestTransportServeUnix(t *testing.T) {
+	c := testValidConfig(t, `
+jobs:
+- name: foo
+  type: sink
+  root_fs: "pool/backups"
+  serve:
+    type: unix
+    path: /var/run/zrepl-tenant1/backup.sock
+    clients:
+      "uid:1001": tenant2
+      "group:backup": tenants
+`)
+	serve := c.Jobs[0].Ret.(*SinkJob).Serve.Ret.(*UnixServe)
+	require.Equal(t, "/var/run/zrepl-tenant1/backup.sock", serve.Path)
+	require.Equal(t, map[string]string{"uid:1001": "tenant2", "group:backup": "tenants"}, serve.Clients)
+}
@@ -0,0 +1,288 @@
+// Package checks evaluates the RPO / SLA checks configured in a `type: checks` monitoring section
+// against the status of the daemon's jobs.
+//
+// The results are consumed by `zrepl monitor`, which follows the Nagios plugin conventions,
+// and exported as Prometheus metrics by the daemon.
+package checks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/filters"
+	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/zfs"
+)
+
+// State is the outcome of a check.
+// The numeric values are the Nagios plugin exit codes.
+// Checks have no warning thresholds, hence there is no state for the WARNING exit code 1.
+type State int
+
+const (
+	StateOK       State = 0
+	StateCritical State = 2
+	StateUnknown  State = 3
+)
+
+func (s State) String() string {
+	switch s {
+	case StateOK:
+		return "OK"
+	case StateCritical:
+		return "CRITICAL"
+	case StateUnknown:
+		return "UNKNOWN"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+func (s State) severity() int {
+	switch s {
+	case StateOK:
+		return 0
+	case StateUnknown:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Worse returns the more severe of s and o,
+// where CRITICAL > UNKNOWN > OK.
+func (s State) Worse(o State) State {
+	if o.severity() > s.severity() {
+		return o
+	}
+	return s
+}
+
+type Check struct {
+	name                   string
+	job                    string
+	filter                 zfs.DatasetFilter // nil means all filesystems
+	maxSnapshotAge         time.Duration     // 0 means not checked
+	maxConsecutiveFailures int               // -1 means not checked
+}
+
+func (c *Check) Name() string { return c.name }
+
+// FromConfig builds the checks of in.
+// jobs are the jobs of the config, used to validate the job names.
+func FromConfig(in *config.ChecksMonitoring, jobs []config.JobEnum) ([]*Check, error) {
+	activeJobs := make(map[string]bool, len(jobs))
+	for _, j := range jobs {
+		switch j.Ret.(type) {
+		case *config.PushJob, *config.PullJob:
+			activeJobs[j.Name()] = true
+		default:
+			activeJobs[j.Name()] = false
+		}
+	}
+
+	names := make(map[string]bool, len(in.Checks))
+	checks := make([]*Check, 0, len(in.Checks))
+	for i, cc := range in.Checks {
+		if cc.Name == "" {
+			return nil, errors.Errorf("check #%d: name must not be empty", i)
+		}
+		if names[cc.Name] {
+			return nil, errors.Errorf("check %q: duplicate check name", cc.Name)
+		}
+		names[cc.Name] = true
+
+		active, ok := activeJobs[cc.Job]
+		if !ok {
+			return nil, errors.Errorf("check %q: job %q does not exist", cc.Name, cc.Job)
+		}
+		if !active {
+			return nil, errors.Errorf("check %q: job %q is not a push or pull job", cc.Name, cc.Job)
+		}
+
+		c := &Check{
+			name:                   cc.Name,
+			job:                    cc.Job,
+			maxSnapshotAge:         cc.MaxSnapshotAge,
+			maxConsecutiveFailures: -1,
+		}
+		if cc.MaxConsecutiveFailures != nil {
+			if *cc.MaxConsecutiveFailures < 0 {
+				return nil, errors.Errorf("check %q: max_consecutive_failures must not be negative", cc.Name)
+			}
+			c.maxConsecutiveFailures = *cc.MaxConsecutiveFailures
+		}
+		if c.maxSnapshotAge == 0 && c.maxConsecutiveFailures == -1 {
+			return nil, errors.Errorf("check %q: must specify max_snapshot_age and/or max_consecutive_failures", cc.Name)
+		}
+		if len(cc.Filesystems) > 0 {
+			f, err := filters.DatasetMapFilterFromConfig(cc.Filesystems)
+			if err != nil {
+				return nil, errors.Wrapf(err, "check %q: invalid filesystems filter", cc.Name)
+			}
+			c.filter = f
+		}
+		checks = append(checks, c)
+	}
+	return checks, nil
+}
+
+type Result struct {
+	Check   string
+	State   State
+	Message string
+
+	// Number of filesystems of the job that match the check's filter
+	Filesystems int
+	// Maximum age of the newest snapshot on the receiver over all matching filesystems,
+	// -1 if unknown or not checked
+	SnapshotAge time.Duration
+	// Maximum number of consecutive failed replication runs over all matching filesystems,
+	// -1 if unknown or not checked
+	ConsecutiveFailures int
+
+	// The check's thresholds, see config.MonitoringCheck
+	MaxSnapshotAge         time.Duration
+	MaxConsecutiveFailures int
+}
+
+// max number of problematic filesystems listed in Result.Message
+const maxListedFilesystems = 3
+
+func (c *Check) matches(fs string) bool {
+	if c.filter == nil {
+		return true
+	}
+	dp, err := zfs.NewDatasetPath(fs)
+	if err != nil {
+		return false
+	}
+	pass, err := c.filter.Filter(dp)
+	return err == nil && pass
+}
+
+// Evaluate evaluates c against the job statuses reported by the daemon.
+func (c *Check) Evaluate(jobs map[string]*job.Status, now time.Time) *Result {
+	r := &Result{
+		Check:                  c.name,
+		SnapshotAge:            -1,
+		ConsecutiveFailures:    -1,
+		MaxSnapshotAge:         c.maxSnapshotAge,
+		MaxConsecutiveFailures: c.maxConsecutiveFailures,
+	}
+	unknown := func(format string, args ...interface{}) *Result {
+		r.State = StateUnknown
+		r.Message = fmt.Sprintf(format, args...)
+		return r
+	}
+
+	st, ok := jobs[c.job]
+	if !ok {
+		return unknown("job %q is not running", c.job)
+	}
+	as, ok := st.JobSpecific.(*job.ActiveSideStatus)
+	if !ok {
+		return unknown("job %q is not a push or pull job", c.job)
+	}
+	h := as.History
+	if h == nil {
+		return unknown("job %q has not finished a replication run since the daemon started", c.job)
+	}
+
+	fss := make([]string, 0, len(h.Filesystems))
+	for name := range h.Filesystems {
+		if c.matches(name) {
+			fss = append(fss, name)
+		}
+	}
+	sort.Strings(fss)
+	r.Filesystems = len(fss)
+
+	var problems []string
+	if c.maxConsecutiveFailures >= 0 {
+		r.ConsecutiveFailures = h.ConsecutiveFailedRuns
+		if h.ConsecutiveFailedRuns > c.maxConsecutiveFailures {
+			problems = append(problems, fmt.Sprintf("%d consecutive replication runs failed", h.ConsecutiveFailedRuns))
+		}
+		var failing []string
+		for _, name := range fss {
+			fs := h.Filesystems[name]
+			if fs.ConsecutiveFailures > r.ConsecutiveFailures {
+				r.ConsecutiveFailures = fs.ConsecutiveFailures
+			}
+			if fs.ConsecutiveFailures > c.maxConsecutiveFailures && fs.ConsecutiveFailures > h.ConsecutiveFailedRuns {
+				failing = append(failing, fmt.Sprintf("%s failed %d consecutive times", name, fs.ConsecutiveFailures))
+			}
+		}
+		problems = append(problems, listFilesystems(failing)...)
+	}
+
+	if c.maxSnapshotAge > 0 {
+		if len(fss) == 0 {
+			if len(problems) == 0 {
+				return unknown("no filesystem of job %q matches the check's filter", c.job)
+			}
+		} else {
+			r.SnapshotAge = 0
+		}
+		var stale []string
+		for _, name := range fss {
+			fs := h.Filesystems[name]
+			if fs.NewestReceiverSnapshot.IsZero() {
+				if !fs.NewestSenderSnapshot.IsZero() {
+					stale = append(stale, fmt.Sprintf("%s has no snapshot on the receiver", name))
+				}
+				continue
+			}
+			age := now.Sub(fs.NewestReceiverSnapshot)
+			if age > r.SnapshotAge {
+				r.SnapshotAge = age
+			}
+			if age > c.maxSnapshotAge {
+				stale = append(stale, fmt.Sprintf("%s newest snapshot on receiver is %s old", name, age.Truncate(time.Second)))
+			}
+		}
+		problems = append(problems, listFilesystems(stale)...)
+	}
+
+	if len(problems) > 0 {
+		r.State = StateCritical
+		r.Message = strings.Join(problems, ", ")
+		return r
+	}
+
+	r.State = StateOK
+	msg := []string{fmt.Sprintf("%d filesystems", r.Filesystems)}
+	if r.SnapshotAge >= 0 {
+		msg = append(msg, fmt.Sprintf("max snapshot age %s", r.SnapshotAge.Truncate(time.Second)))
+	}
+	if r.ConsecutiveFailures >= 0 {
+		msg = append(msg, fmt.Sprintf("max %d consecutive failures", r.ConsecutiveFailures))
+	}
+	r.Message = strings.Join(msg, ", ")
+	return r
+}
+
+func listFilesystems(problems []string) []string {
+	if len(problems) <= maxListedFilesystems {
+		return problems
+	}
+	more := len(problems) - maxListedFilesystems
+	return append(problems[:maxListedFilesystems:maxListedFilesystems], fmt.Sprintf("and %d more filesystems", more))
+}
+
+// EvaluateAll evaluates all checks and returns the results together with the worst state.
+func EvaluateAll(checks []*Check, jobs map[string]*job.Status, now time.Time) (State, []*Result) {
+	worst := StateOK
+	results := make([]*Result, len(checks))
+	for i, c := range checks {
+		results[i] = c.Evaluate(jobs, now)
+		worst = worst.Worse(results[i].State)
+	}
+	return worst, results
+}
@@ -0,0 +1,50 @@
+package checks
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteNagios writes the results in the Nagios plugin output format:
+// a summary line with performance data, followed by one line per check.
+// The exit code of the plugin is the numeric value of state.
+func WriteNagios(w io.Writer, state State, results []*Result) error {
+	counts := make(map[State]int)
+	for _, r := range results {
+		counts[r.State]++
+	}
+	var summary []string
+	for _, s := range []State{StateCritical, StateUnknown, StateOK} {
+		if counts[s] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[s], strings.ToLower(s.String())))
+		}
+	}
+	if len(summary) == 0 {
+		summary = append(summary, "no checks")
+	}
+
+	var perfdata []string
+	for _, r := range results {
+		if r.SnapshotAge >= 0 {
+			perfdata = append(perfdata, fmt.Sprintf("'%s_snapshot_age'=%ds;;%d;0",
+				r.Check, int64(r.SnapshotAge.Seconds()), int64(r.MaxSnapshotAge.Seconds())))
+		}
+		if r.ConsecutiveFailures >= 0 {
+			perfdata = append(perfdata, fmt.Sprintf("'%s_consecutive_failures'=%d;;%d;0",
+				r.Check, r.ConsecutiveFailures, r.MaxConsecutiveFailures))
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "ZREPL %s - %s", state, strings.Join(summary, ", "))
+	if len(perfdata) > 0 {
+		fmt.Fprintf(&b, " | %s", strings.Join(perfdata, " "))
+	}
+	b.WriteString("\n")
+	for _, r := range results {
+		fmt.Fprintf(&b, "%s: %s: %s\n", r.State, r.Check, r.Message)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,58 @@
+package checks
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zrepl/zrepl/daemon/job"
+)
+
+// Collector exports the results of checks as Prometheus metrics.
+// The checks are evaluated on every scrape.
+type Collector struct {
+	checks []*Check
+	status func() map[string]*job.Status
+
+	state               *prometheus.Desc
+	snapshotAge         *prometheus.Desc
+	consecutiveFailures *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector returns a collector that evaluates checks against the job statuses returned by status.
+func NewCollector(checks []*Check, status func() map[string]*job.Status) *Collector {
+	return &Collector{
+		checks: checks,
+		status: status,
+		state: prometheus.NewDesc("zrepl_monitoring_check_state",
+			"result of the check: 0=OK, 2=CRITICAL, 3=UNKNOWN",
+			[]string{"check"}, nil),
+		snapshotAge: prometheus.NewDesc("zrepl_monitoring_check_snapshot_age_seconds",
+			"maximum age of the newest snapshot on the receiver over all filesystems of the check",
+			[]string{"check"}, nil),
+		consecutiveFailures: prometheus.NewDesc("zrepl_monitoring_check_consecutive_failures",
+			"maximum number of consecutive failed replication runs over all filesystems of the check",
+			[]string{"check"}, nil),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+	ch <- c.snapshotAge
+	ch <- c.consecutiveFailures
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	_, results := EvaluateAll(c.checks, c.status(), time.Now())
+	for _, r := range results {
+		ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, float64(r.State), r.Check)
+		if r.SnapshotAge >= 0 {
+			ch <- prometheus.MustNewConstMetric(c.snapshotAge, prometheus.GaugeValue, r.SnapshotAge.Seconds(), r.Check)
+		}
+		if r.ConsecutiveFailures >= 0 {
+			ch <- prometheus.MustNewConstMetric(c.consecutiveFailures, prometheus.GaugeValue, float64(r.ConsecutiveFailures), r.Check)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package checks
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/job"
+)
+
+func intPtr(i int) *int { return &i }
+
+func TestFromConfig(t *testing.T) {
+	jobs := []config.JobEnum{
+		{Ret: &config.PushJob{ActiveJob: config.ActiveJob{Name: "push"}}},
+		{Ret: &config.SinkJob{PassiveJob: config.PassiveJob{Name: "sink"}}},
+	}
+
+	tcs := []struct {
+		name    string
+		check   config.MonitoringCheck
+		wantErr string
+	}{
+		{"valid", config.MonitoringCheck{Name: "a", Job: "push", MaxSnapshotAge: time.Hour}, ""},
+		{"unknown_job", config.MonitoringCheck{Name: "a", Job: "nope", MaxSnapshotAge: time.Hour}, "does not exist"},
+		{"passive_job", config.MonitoringCheck{Name: "a", Job: "sink", MaxSnapshotAge: time.Hour}, "not a push or pull job"},
+		{"no_thresholds", config.MonitoringCheck{Name: "a", Job: "push"}, "must specify"},
+		{"negative_failures", config.MonitoringCheck{Name: "a", Job: "push", MaxConsecutiveFailures: intPtr(-1)}, "must not be negative"},
+		{"invalid_filter", config.MonitoringCheck{Name: "a", Job: "push", MaxSnapshotAge: time.Hour, Filesystems: config.FilesystemsFilter{"pool<a": true}}, "filter"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			check := tc.check
+			_, err := FromConfig(&config.ChecksMonitoring{Checks: []*config.MonitoringCheck{&check}}, jobs)
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+			}
+		})
+	}
+
+	t.Run("duplicate_name", func(t *testing.T) {
+		c := &config.MonitoringCheck{Name: "a", Job: "push", MaxSnapshotAge: time.Hour}
+		_, err := FromConfig(&config.ChecksMonitoring{Checks: []*config.MonitoringCheck{c, c}}, jobs)
+		assert.Error(t, err)
+	})
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	statuses := func(h *job.ReplicationHistory) map[string]*job.Status {
+		return map[string]*job.Status{
+			"push": {Type: job.TypePush, JobSpecific: &job.ActiveSideStatus{History: h}},
+			"snap": {Type: job.TypeSnap, JobSpecific: &job.SnapJobStatus{}},
+		}
+	}
+	history := &job.ReplicationHistory{
+		Runs: 5,
+		Filesystems: map[string]*job.FilesystemReplicationHistory{
+			"pool/fresh": {
+				NewestSenderSnapshot:   now.Add(-10 * time.Minute),
+				NewestReceiverSnapshot: now.Add(-10 * time.Minute),
+			},
+			"pool/stale": {
+				ConsecutiveFailures:    4,
+				NewestSenderSnapshot:   now.Add(-10 * time.Minute),
+				NewestReceiverSnapshot: now.Add(-7 * time.Hour),
+			},
+			"pool/new": {
+				NewestSenderSnapshot: now.Add(-10 * time.Minute),
+			},
+		},
+	}
+
+	tcs := []struct {
+		name      string
+		check     *Check
+		statuses  map[string]*job.Status
+		state     State
+		age       time.Duration
+		failures  int
+		msgSubstr string
+	}{
+		{
+			name:     "ok",
+			check:    &Check{name: "c", job: "push", maxSnapshotAge: time.Hour, maxConsecutiveFailures: -1},
+			statuses: statuses(&job.ReplicationHistory{Runs: 1, Filesystems: map[string]*job.FilesystemReplicationHistory{"pool/fresh": history.Filesystems["pool/fresh"]}}),
+			state:    StateOK, age: 10 * time.Minute, failures: -1,
+		},
+		{
+			name:     "stale",
+			check:    &Check{name: "c", job: "push", maxSnapshotAge: 6 * time.Hour, maxConsecutiveFailures: -1},
+			statuses: statuses(history),
+			state:    StateCritical, age: 7 * time.Hour, failures: -1,
+			msgSubstr: "pool/stale newest snapshot on receiver is 7h0m0s old",
+		},
+		{
+			name:     "no_receiver_snapshot",
+			check:    &Check{name: "c", job: "push", maxSnapshotAge: 6 * time.Hour, maxConsecutiveFailures: -1},
+			statuses: statuses(history),
+			state:    StateCritical, age: 7 * time.Hour, failures: -1,
+			msgSubstr: "pool/new has no snapshot on the receiver",
+		},
+		{
+			name:     "failures",
+			check:    &Check{name: "c", job: "push", maxConsecutiveFailures: 3},
+			statuses: statuses(history),
+			state:    StateCritical, age: -1, failures: 4,
+			msgSubstr: "pool/stale failed 4 consecutive times",
+		},
+		{
+			name:     "failed_runs",
+			check:    &Check{name: "c", job: "push", maxConsecutiveFailures: 1},
+			statuses: statuses(&job.ReplicationHistory{Runs: 2, ConsecutiveFailedRuns: 2}),
+			state:    StateCritical, age: -1, failures: 2,
+			msgSubstr: "2 consecutive replication runs failed",
+		},
+		{
+			name:     "job_not_running",
+			check:    &Check{name: "c", job: "other", maxSnapshotAge: time.Hour, maxConsecutiveFailures: -1},
+			statuses: statuses(history),
+			state:    StateUnknown, age: -1, failures: -1,
+		},
+		{
+			name:     "not_active_job",
+			check:    &Check{name: "c", job: "snap", maxSnapshotAge: time.Hour, maxConsecutiveFailures: -1},
+			statuses: statuses(history),
+			state:    StateUnknown, age: -1, failures: -1,
+		},
+		{
+			name:     "no_history",
+			check:    &Check{name: "c", job: "push", maxSnapshotAge: time.Hour, maxConsecutiveFailures: -1},
+			statuses: statuses(nil),
+			state:    StateUnknown, age: -1, failures: -1,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			r := tc.check.Evaluate(tc.statuses, now)
+			assert.Equal(t, tc.state, r.State, r.Message)
+			assert.Equal(t, tc.age, r.SnapshotAge)
+			assert.Equal(t, tc.failures, r.ConsecutiveFailures)
+			assert.Contains(t, r.Message, tc.msgSubstr)
+		})
+	}
+}
+
+func TestStateWorse(t *testing.T) {
+	assert.Equal(t, StateUnknown, StateOK.Worse(StateUnknown))
+	assert.Equal(t, StateUnknown, StateUnknown.Worse(StateOK))
+	assert.Equal(t, StateCritical, StateCritical.Worse(StateUnknown))
+	assert.Equal(t, StateCritical, StateUnknown.Worse(StateCritical))
+}
+
+func TestWriteNagios(t *testing.T) {
+	results := []*Result{
+		{Check: "rpo", State: StateCritical, Message: "pool/a is stale", Filesystems: 1, SnapshotAge: 2 * time.Hour, MaxSnapshotAge: time.Hour, ConsecutiveFailures: -1, MaxConsecutiveFailures: -1},
+		{Check: "failures", State: StateOK, Message: "1 filesystems", Filesystems: 1, SnapshotAge: -1, ConsecutiveFailures: 0, MaxConsecutiveFailures: 3},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, WriteNagios(&buf, StateCritical, results))
+	expect := "ZREPL CRITICAL - 1 critical, 1 ok | 'rpo_snapshot_age'=7200s;;3600;0 'failures_consecutive_failures'=0;;3;0\n" +
+		"CRITICAL: rpo: pool/a is stale\n" +
+		"OK: failures: 1 filesystems\n"
+	assert.Equal(t, expect, buf.String())
+}
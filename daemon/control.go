@@ -13,7 +13,9 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/daemon/logging"
 	"github.com/zrepl/zrepl/daemon/nethelpers"
 	"github.com/zrepl/zrepl/endpoint"
 	"github.com/zrepl/zrepl/logger"
@@ -24,19 +26,34 @@ import (
 )
 
 type controlJob struct {
-	sockaddr *net.UnixAddr
-	jobs     *jobs
+	sockaddr  *net.UnixAddr
+	jobs      *jobs
+	logRing   *logging.RingOutlet
+	logLevels *logging.RuntimeLevels
+	remote    *remoteControl // nil if not configured
 }
 
-func newControlJob(sockpath string, jobs *jobs) (j *controlJob, err error) {
-	j = &controlJob{jobs: jobs}
+func newControlJob(conf *config.GlobalControl, jobs *jobs, logRing *logging.RingOutlet, logLevels *logging.RuntimeLevels) (j *controlJob, err error) {
+	j = &controlJob{jobs: jobs, logRing: logRing, logLevels: logLevels}
 
-	j.sockaddr, err = net.ResolveUnixAddr("unix", sockpath)
+	j.sockaddr, err = net.ResolveUnixAddr("unix", conf.SockPath)
 	if err != nil {
 		err = errors.Wrap(err, "cannot resolve unix address")
 		return
 	}
 
+	if conf.Remote != nil {
+		j.remote, err = newRemoteControlFromConfig(conf.Remote)
+		if err != nil {
+			err = errors.Wrap(err, "cannot build remote control listener")
+			return
+		}
+		if err = j.remote.Listen(); err != nil {
+			err = errors.Wrap(err, "cannot listen for remote control connections")
+			return
+		}
+	}
+
 	return
 }
 
@@ -73,10 +90,12 @@ func (j *controlJob) RegisterMetrics(registerer prometheus.Registerer) {
 }
 
 const (
-	ControlJobEndpointPProf   string = "/debug/pprof"
-	ControlJobEndpointVersion string = "/version"
-	ControlJobEndpointStatus  string = "/status"
-	ControlJobEndpointSignal  string = "/signal"
+	ControlJobEndpointPProf     string = "/debug/pprof"
+	ControlJobEndpointVersion   string = "/version"
+	ControlJobEndpointStatus    string = "/status"
+	ControlJobEndpointSignal    string = "/signal"
+	ControlJobEndpointLogs      string = "/logs"
+	ControlJobEndpointLogLevels string = "/loglevels"
 )
 
 func (j *controlJob) Run(ctx context.Context) {
@@ -98,8 +117,8 @@ func (j *controlJob) Run(ctx context.Context) {
 		})
 	}
 
-	mux := http.NewServeMux()
-	mux.Handle(ControlJobEndpointPProf,
+	mux := newControlMux()
+	mux.handle(ControlJobEndpointPProf, "/pprof", controlRoleOperator,
 		requestLogger{log: log, handler: jsonRequestResponder{log, func(decoder jsonDecoder) (interface{}, error) {
 			var msg PprofServerControlMsg
 			err := decoder(&msg)
@@ -110,12 +129,12 @@ func (j *controlJob) Run(ctx context.Context) {
 			return struct{}{}, nil
 		}}})
 
-	mux.Handle(ControlJobEndpointVersion,
+	mux.handle(ControlJobEndpointVersion, "/version", controlRoleReadOnly,
 		requestLogger{log: log, handler: jsonResponder{log, func() (interface{}, error) {
 			return version.NewZreplVersionInformation(), nil
 		}}})
 
-	mux.Handle(ControlJobEndpointStatus,
+	mux.handle(ControlJobEndpointStatus, "/status", controlRoleReadOnly,
 		// don't log requests to status endpoint, too spammy
 		jsonResponder{log, func() (interface{}, error) {
 			jobs := j.jobs.status()
@@ -130,7 +149,19 @@ func (j *controlJob) Run(ctx context.Context) {
 			return s, nil
 		}})
 
-	mux.Handle(ControlJobEndpointSignal,
+	mux.handle(ControlJobEndpointLogs, "/logs", controlRoleReadOnly,
+		requestLogger{log: log, handler: logsHandler{ctx, log, j.logRing}})
+
+	mux.handle(ControlJobEndpointLogLevels, "/loglevels", controlRoleOperator,
+		requestLogger{log: log, handler: jsonRequestResponder{log, func(decoder jsonDecoder) (interface{}, error) {
+			var req LogLevelsRequest
+			if decoder(&req) != nil {
+				return nil, errors.Errorf("decode failed")
+			}
+			return j.handleLogLevelsRequest(log, &req, time.Now())
+		}}})
+
+	mux.handle(ControlJobEndpointSignal, "/signal", controlRoleOperator,
 		requestLogger{log: log, handler: jsonRequestResponder{log, func(decoder jsonDecoder) (interface{}, error) {
 			type reqT struct {
 				Name string
@@ -153,6 +184,13 @@ func (j *controlJob) Run(ctx context.Context) {
 
 			return struct{}{}, err
 		}}})
+
+	mux.handle("", "/openapi.json", controlRoleReadOnly, controlOpenAPIHandler{})
+
+	if j.remote != nil {
+		go j.remote.serve(ctx, log, mux)
+	}
+
 	server := http.Server{
 		Handler: mux,
 		// control socket is local, 1s timeout should be more than sufficient, even on a loaded system
@@ -188,6 +226,40 @@ outer:
 
 }
 
+// LogLevelsRequest is the request body of ControlJobEndpointLogLevels.
+// The response is the list of active runtime overrides ([]logging.RuntimeLevelOverride), after applying the request.
+type LogLevelsRequest struct {
+	Reset bool                   // remove all runtime overrides, applied before Set
+	Set   *logging.LevelOverride // nil to only list the active overrides
+	For   time.Duration          // how long Set is in effect
+}
+
+func (j *controlJob) handleLogLevelsRequest(log Logger, req *LogLevelsRequest, now time.Time) ([]logging.RuntimeLevelOverride, error) {
+	if req.Set != nil {
+		if req.Set.Subsystem != "" {
+			if _, err := logging.ParseSubsystem(string(req.Set.Subsystem)); err != nil {
+				return nil, err
+			}
+		}
+		if req.For <= 0 {
+			return nil, errors.Errorf("duration must be positive, got %s", req.For)
+		}
+	}
+	if req.Reset {
+		j.logLevels.Reset()
+		log.Info("reset runtime log level overrides")
+	}
+	if req.Set != nil {
+		j.logLevels.Set(*req.Set, now.Add(req.For))
+		log.WithField("override_job", req.Set.Job).
+			WithField("override_subsystem", req.Set.Subsystem).
+			WithField("override_level", req.Set.Level.String()).
+			WithField("override_until", now.Add(req.For).Format(time.RFC3339)).
+			Info("set runtime log level override")
+	}
+	return j.logLevels.Active(now), nil
+}
+
 type jsonResponder struct {
 	log      Logger
 	producer func() (interface{}, error)
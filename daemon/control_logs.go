@@ -0,0 +1,129 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/zrepl/zrepl/daemon/logging"
+	"github.com/zrepl/zrepl/logger"
+)
+
+// LogsRequest is the request body of ControlJobEndpointLogs.
+//
+// The response body is a stream of JSON-encoded logger.Entry values, one per line,
+// that match the filter: first the matching entries in the daemon's log buffer,
+// then, if Follow is set, new entries as they are logged.
+type LogsRequest struct {
+	logging.EntryFilter
+	Follow bool
+}
+
+// writes to the client must not take longer than this, otherwise the client is considered gone
+const logsWriteTimeout = 5 * time.Second
+
+type logsHandler struct {
+	ctx  context.Context // the control job's context
+	log  logger.Logger
+	ring *logging.RingOutlet
+}
+
+func (h logsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req LogsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = io.WriteString(w, err.Error())
+		return
+	}
+
+	// The control server's WriteTimeout applies to the whole response, which would end
+	// long-running streams. Hence, we take over the connection and manage deadlines ourselves.
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = io.WriteString(w, "connection does not support streaming")
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		h.log.WithError(err).Error("cannot hijack connection for log streaming")
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(h.ctx)
+	defer cancel()
+	go func() {
+		// the client does not send anything after the request, so this returns when the client goes away
+		_ = conn.SetReadDeadline(time.Time{})
+		_, _ = io.Copy(ioutil.Discard, conn)
+		cancel()
+	}()
+
+	bw := bufio.NewWriter(conn)
+	flush := func() error {
+		if err := conn.SetWriteDeadline(time.Now().Add(logsWriteTimeout)); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	// no Content-Length and Connection: close => the body ends when the connection is closed
+	_, _ = io.WriteString(bw, "HTTP/1.1 200 OK\r\nContent-Type: application/x-ndjson\r\nConnection: close\r\n\r\n")
+	enc := json.NewEncoder(bw)
+	write := func(e logger.Entry) error {
+		if !req.Matches(&e) {
+			return nil
+		}
+		return enc.Encode(e)
+	}
+
+	var backlog []logger.Entry
+	var sub *logging.RingSubscription
+	if req.Follow {
+		backlog, sub = h.ring.Subscribe()
+		defer sub.Close()
+	} else {
+		backlog = h.ring.Entries()
+	}
+	for _, e := range backlog {
+		if err := write(e); err != nil {
+			return
+		}
+	}
+	if err := flush(); err != nil || !req.Follow {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-sub.Entries():
+			if dropped := sub.TakeDropped(); dropped > 0 {
+				err := enc.Encode(logger.Entry{
+					Level:   logger.Warn,
+					Message: "log stream dropped entries because the client did not keep up",
+					Time:    time.Now(),
+					Fields:  logger.Fields{"dropped": dropped},
+				})
+				if err != nil {
+					return
+				}
+			}
+			if err := write(e); err != nil {
+				return
+			}
+			// batch entries that are already queued
+			if len(sub.Entries()) > 0 && bw.Buffered() < bw.Size()/2 {
+				continue
+			}
+			if err := flush(); err != nil {
+				return
+			}
+		}
+	}
+}
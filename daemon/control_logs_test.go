@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/daemon/logging"
+	"github.com/zrepl/zrepl/logger"
+)
+
+func TestLogsHandler(t *testing.T) {
+	ring, err := logging.NewRingOutlet(1 << 20)
+	require.NoError(t, err)
+	write := func(job, msg string) {
+		require.NoError(t, ring.WriteEntry(logger.Entry{
+			Level:   logger.Info,
+			Message: msg,
+			Time:    time.Now(),
+			Fields:  logger.Fields{logging.JobField: job},
+		}))
+	}
+	write("foo", "foo 1")
+	write("bar", "bar 1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := httptest.NewServer(logsHandler{ctx, logger.NewNullLogger(), ring})
+	defer srv.Close()
+
+	request := func(req LogsRequest) *bufio.Reader {
+		var buf bytes.Buffer
+		require.NoError(t, json.NewEncoder(&buf).Encode(req))
+		res, err := http.Post(srv.URL, "application/json", &buf)
+		require.NoError(t, err)
+		t.Cleanup(func() { res.Body.Close() })
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		return bufio.NewReader(res.Body)
+	}
+	read := func(r *bufio.Reader) string {
+		line, err := r.ReadBytes('\n')
+		require.NoError(t, err)
+		var e logger.Entry
+		require.NoError(t, json.Unmarshal(line, &e))
+		return e.Message
+	}
+
+	r := request(LogsRequest{EntryFilter: logging.EntryFilter{Job: "foo"}})
+	assert.Equal(t, "foo 1", read(r))
+	_, err = r.ReadBytes('\n')
+	assert.Error(t, err, "stream must end without --follow")
+
+	r = request(LogsRequest{EntryFilter: logging.EntryFilter{Job: "bar"}, Follow: true})
+	assert.Equal(t, "bar 1", read(r))
+	write("foo", "foo 2")
+	write("bar", "bar 2")
+	assert.Equal(t, "bar 2", read(r))
+
+	cancel()
+	_, err = r.ReadBytes('\n')
+	assert.Error(t, err, "stream must end when the daemon shuts down")
+}
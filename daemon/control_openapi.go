@@ -0,0 +1,176 @@
+package daemon
+
+import (
+	"io"
+	"net/http"
+)
+
+// controlOpenAPIHandler serves the OpenAPI description of the versioned control API at ControlAPIV1Prefix+"/openapi.json".
+type controlOpenAPIHandler struct{}
+
+func (controlOpenAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = io.WriteString(w, controlOpenAPI)
+}
+
+// Keep in sync with the handlers registered in controlJob.Run and their request / response types.
+const controlOpenAPI = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "zrepl control API",
+    "version": "1",
+    "description": "Served on the daemon's control socket and, if configured, on the remote control listener (global.control.remote) over TLS with client certificate authentication. Remote clients need the role noted in each operation."
+  },
+  "servers": [{"url": "/api/v1"}],
+  "paths": {
+    "/version": {
+      "get": {
+        "summary": "version of the daemon (role: read_only)",
+        "responses": {
+          "200": {"description": "version information", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Version"}}}}
+        }
+      }
+    },
+    "/status": {
+      "get": {
+        "summary": "status of all jobs, as shown by zrepl status (role: read_only)",
+        "responses": {
+          "200": {"description": "status", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Status"}}}}
+        }
+      }
+    },
+    "/logs": {
+      "post": {
+        "summary": "recent log entries from the daemon's log buffer, as shown by zrepl logs (role: read_only)",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/LogsRequest"}}}},
+        "responses": {
+          "200": {"description": "one JSON-encoded LogEntry per line; with Follow, the stream continues until the client disconnects", "content": {"application/x-ndjson": {"schema": {"$ref": "#/components/schemas/LogEntry"}}}}
+        }
+      }
+    },
+    "/signal": {
+      "post": {
+        "summary": "wake up a job or reset its current invocation, as done by zrepl signal (role: operator)",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SignalRequest"}}}},
+        "responses": {
+          "200": {"description": "signal delivered", "content": {"application/json": {"schema": {"type": "object"}}}},
+          "500": {"description": "unknown job or operation", "content": {"text/plain": {}}}
+        }
+      }
+    },
+    "/loglevels": {
+      "post": {
+        "summary": "list, set or reset runtime log level overrides, as done by zrepl loglevel (role: operator)",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/LogLevelsRequest"}}}},
+        "responses": {
+          "200": {"description": "the overrides in effect after the request", "content": {"application/json": {"schema": {"type": "array", "nullable": true, "items": {"$ref": "#/components/schemas/RuntimeLevelOverride"}}}}}
+        }
+      }
+    },
+    "/pprof": {
+      "post": {
+        "summary": "start or stop the daemon's pprof HTTP server, as done by zrepl pprof listen (role: operator)",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PprofRequest"}}}},
+        "responses": {
+          "200": {"description": "done", "content": {"application/json": {"schema": {"type": "object"}}}}
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "summary": "this document (role: read_only)",
+        "responses": {"200": {"description": "OpenAPI document", "content": {"application/json": {}}}}
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "Level": {"type": "string", "enum": ["debug", "info", "warn", "error"]},
+      "Version": {
+        "type": "object",
+        "properties": {
+          "Version": {"type": "string"},
+          "RuntimeGOOS": {"type": "string"},
+          "RuntimeGOARCH": {"type": "string"},
+          "RUNTIMECompiler": {"type": "string"}
+        }
+      },
+      "Status": {
+        "type": "object",
+        "properties": {
+          "Jobs": {
+            "type": "object",
+            "description": "job name => job status; 'type' is the job type, the job-specific status is in the property named like the type",
+            "additionalProperties": {"type": "object", "properties": {"type": {"type": "string"}}}
+          },
+          "Global": {
+            "type": "object",
+            "properties": {
+              "ZFSCmds": {"type": "object", "nullable": true},
+              "Envconst": {"type": "object", "nullable": true}
+            }
+          }
+        }
+      },
+      "LogsRequest": {
+        "type": "object",
+        "properties": {
+          "Job": {"type": "string", "description": "exact match, empty matches all"},
+          "Subsystem": {"type": "string", "description": "exact match, empty matches all"},
+          "Span": {"type": "string", "description": "entries of this span and its child spans, empty matches all"},
+          "MinLevel": {"$ref": "#/components/schemas/Level"},
+          "Since": {"type": "string", "format": "date-time"},
+          "Follow": {"type": "boolean"}
+        }
+      },
+      "LogEntry": {
+        "type": "object",
+        "properties": {
+          "Level": {"$ref": "#/components/schemas/Level"},
+          "Message": {"type": "string"},
+          "Time": {"type": "string", "format": "date-time"},
+          "Fields": {"type": "object", "nullable": true, "description": "e.g. job, subsystem, span"}
+        }
+      },
+      "SignalRequest": {
+        "type": "object",
+        "required": ["Name", "Op"],
+        "properties": {
+          "Name": {"type": "string", "description": "job name"},
+          "Op": {"type": "string", "enum": ["wakeup", "reset"]}
+        }
+      },
+      "LevelOverride": {
+        "type": "object",
+        "required": ["Level"],
+        "properties": {
+          "Job": {"type": "string", "description": "empty matches all jobs"},
+          "Subsystem": {"type": "string", "description": "empty matches all subsystems"},
+          "Level": {"$ref": "#/components/schemas/Level"}
+        }
+      },
+      "LogLevelsRequest": {
+        "type": "object",
+        "properties": {
+          "Reset": {"type": "boolean", "description": "remove all overrides before applying Set"},
+          "Set": {"allOf": [{"$ref": "#/components/schemas/LevelOverride"}], "nullable": true},
+          "For": {"type": "integer", "format": "int64", "description": "how long Set is in effect, in nanoseconds"}
+        }
+      },
+      "RuntimeLevelOverride": {
+        "allOf": [
+          {"$ref": "#/components/schemas/LevelOverride"},
+          {"type": "object", "properties": {"Until": {"type": "string", "format": "date-time"}}}
+        ]
+      },
+      "PprofRequest": {
+        "type": "object",
+        "properties": {
+          "Run": {"type": "boolean"},
+          "HttpListenAddress": {"type": "string", "description": "required if Run is true"}
+        }
+      }
+    }
+  }
+}
+`
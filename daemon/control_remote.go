@@ -0,0 +1,207 @@
+package daemon
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/tlsconf"
+	"github.com/zrepl/zrepl/transport"
+	"github.com/zrepl/zrepl/util/envconst"
+	"github.com/zrepl/zrepl/util/tcpsock"
+)
+
+// ControlAPIV1Prefix is the path prefix of the versioned control API, described by ControlAPIV1Prefix+"/openapi.json".
+// The unversioned endpoints (ControlJobEndpoint*) are used by the zrepl CLI and may change between releases.
+const ControlAPIV1Prefix = "/api/v1"
+
+// controlRole is the role a client of the remote control listener needs to use an endpoint.
+// Clients of the control socket have all roles.
+type controlRole int
+
+const (
+	controlRoleReadOnly controlRole = iota + 1
+	controlRoleOperator             // includes controlRoleReadOnly
+)
+
+func parseControlRole(s string) (controlRole, error) {
+	switch s {
+	case "read_only":
+		return controlRoleReadOnly, nil
+	case "operator":
+		return controlRoleOperator, nil
+	default:
+		return 0, errors.Errorf("invalid role %q, must be 'read_only' or 'operator'", s)
+	}
+}
+
+// controlMux registers each handler under its endpoint and its versioned API path,
+// together with the role that remote clients need to use it.
+type controlMux struct {
+	*http.ServeMux
+	roles map[string]controlRole
+}
+
+func newControlMux() *controlMux {
+	return &controlMux{http.NewServeMux(), make(map[string]controlRole)}
+}
+
+// endpoint may be empty if the handler is only part of the versioned API
+func (m *controlMux) handle(endpoint, apiPath string, role controlRole, h http.Handler) {
+	paths := []string{ControlAPIV1Prefix + apiPath}
+	if endpoint != "" {
+		paths = append(paths, endpoint)
+	}
+	for _, p := range paths {
+		m.ServeMux.Handle(p, h)
+		m.roles[p] = role
+	}
+}
+
+type remoteControl struct {
+	listen           string
+	freeBind         bool
+	store            *tlsconf.Store
+	identity         *tlsconf.IdentityExtractor
+	handshakeTimeout time.Duration
+	clients          map[string]controlRole // client identity => role
+
+	listener net.Listener // set by Listen
+}
+
+func newRemoteControlFromConfig(in *config.GlobalControlRemote) (*remoteControl, error) {
+	if in.Ca == "" || in.Cert == "" || in.Key == "" {
+		return nil, errors.New("fields 'ca', 'cert' and 'key' must be specified")
+	}
+	if len(in.Clients) == 0 {
+		return nil, errors.New("field 'clients' must not be empty")
+	}
+	r := &remoteControl{
+		listen:           in.Listen,
+		freeBind:         in.ListenFreeBind,
+		handshakeTimeout: in.HandshakeTimeout,
+		clients:          make(map[string]controlRole, len(in.Clients)),
+	}
+	for identity, roleStr := range in.Clients {
+		if err := transport.ValidateClientIdentity(identity); err != nil {
+			return nil, errors.Wrapf(err, "unsuitable client identity %q", identity)
+		}
+		role, err := parseControlRole(roleStr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "client %q", identity)
+		}
+		r.clients[identity] = role
+	}
+	from, regex := tlsconf.IdentityFromCN, ""
+	if in.ClientIdentity != nil {
+		from, regex = in.ClientIdentity.From, in.ClientIdentity.Regex
+	}
+	var err error
+	r.identity, err = tlsconf.NewIdentityExtractor(from, regex)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid client_identity")
+	}
+	r.store, err = tlsconf.NewStore(tlsconf.Files{CA: in.Ca, Cert: in.Cert, Key: in.Key, CRL: in.CRL, RejectExpiredCRL: in.CRLRejectExpired})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot load TLS material")
+	}
+	return r, nil
+}
+
+// Listen binds the listen address, so that errors can be reported before the daemon starts.
+func (r *remoteControl) Listen() error {
+	tcpListener, err := tcpsock.Listen(r.listen, r.freeBind)
+	if err != nil {
+		return err
+	}
+	// The TLS handshake happens in the connection's goroutine of the http.Server,
+	// not in Accept, so that a slow client cannot hold up other clients.
+	r.listener = tls.NewListener(tcpListener, tlsconf.ClientAuthServerConfig(r.store))
+	return nil
+}
+
+// remoteControlListener reports errors of reloads of the TLS material and an expired CRL
+type remoteControlListener struct {
+	net.Listener
+	log   Logger
+	store *tlsconf.Store
+}
+
+func (l remoteControlListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if reloadErr := l.store.TakeReloadError(); reloadErr != nil {
+		// the reload was triggered by a previous handshake
+		l.log.WithError(reloadErr).Error("cannot reload TLS material")
+	}
+	if crlErr := l.store.TakeCRLExpiredError(); crlErr != nil {
+		l.log.WithError(crlErr).Error("TLS CRL expired")
+	}
+	return conn, err
+}
+
+var remoteControlTimeout = envconst.Duration("ZREPL_DAEMON_CONTROL_REMOTE_TIMEOUT", 10*time.Second)
+
+func (r *remoteControl) serve(ctx context.Context, log Logger, mux *controlMux) {
+	log = log.WithField("listen", r.listen)
+	server := http.Server{
+		Handler: remoteControlAuthorizer{log, r.identity, r.clients, mux},
+		// net/http bounds the TLS handshake by the smallest of its timeouts
+		ReadHeaderTimeout: r.handshakeTimeout,
+		WriteTimeout:      remoteControlTimeout,
+		ReadTimeout:       remoteControlTimeout,
+	}
+	go func() {
+		<-ctx.Done()
+		if err := server.Shutdown(context.Background()); err != nil {
+			log.WithError(err).Error("cannot shutdown remote control server")
+		}
+	}()
+	log.Info("serving remote control API")
+	if err := server.Serve(remoteControlListener{r.listener, log, r.store}); err != nil && err != http.ErrServerClosed {
+		log.WithError(err).Error("error serving remote control API")
+	}
+}
+
+// remoteControlAuthorizer allows a request if the client's role permits the endpoint.
+type remoteControlAuthorizer struct {
+	log      Logger
+	identity *tlsconf.IdentityExtractor
+	clients  map[string]controlRole
+	mux      *controlMux
+}
+
+func (a remoteControlAuthorizer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the certificate chain has been verified during the handshake
+	if r.TLS == nil || len(r.TLS.PeerCertificates) < 1 {
+		http.Error(w, "client certificate required", http.StatusForbidden)
+		return
+	}
+	identity, err := a.identity.Extract(r.TLS.PeerCertificates[0])
+	if err != nil {
+		a.log.WithError(err).Warn("cannot determine remote control client identity from certificate")
+		http.Error(w, "cannot determine client identity from certificate", http.StatusForbidden)
+		return
+	}
+	clientRole, ok := a.clients[identity]
+	if !ok {
+		a.log.WithField("client_identity", identity).Warn("unauthorized remote control client")
+		http.Error(w, "unauthorized client identity", http.StatusForbidden)
+		return
+	}
+	required, ok := a.mux.roles[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if clientRole < required {
+		a.log.WithField("client_identity", identity).WithField("url", r.URL.Path).Warn("remote control client lacks role for endpoint")
+		http.Error(w, "operation requires role 'operator'", http.StatusForbidden)
+		return
+	}
+	a.mux.ServeHTTP(w, r)
+}
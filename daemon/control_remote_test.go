@@ -0,0 +1,194 @@
+package daemon
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/tlsconf"
+)
+
+func TestRemoteControlAuthorizer(t *testing.T) {
+	mux := newControlMux()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mux.handle(ControlJobEndpointStatus, "/status", controlRoleReadOnly, ok)
+	mux.handle(ControlJobEndpointSignal, "/signal", controlRoleOperator, ok)
+	mux.handle("", "/openapi.json", controlRoleReadOnly, controlOpenAPIHandler{})
+
+	cn, err := tlsconf.NewIdentityExtractor(tlsconf.IdentityFromCN, "")
+	require.NoError(t, err)
+	a := remoteControlAuthorizer{
+		log:      logger.NewNullLogger(),
+		identity: cn,
+		clients: map[string]controlRole{
+			"dashboard": controlRoleReadOnly,
+			"ops":       controlRoleOperator,
+		},
+		mux: mux,
+	}
+
+	tcs := []struct {
+		identity, path string
+		status         int
+	}{
+		{"dashboard", ControlJobEndpointStatus, http.StatusOK},
+		{"dashboard", ControlAPIV1Prefix + "/status", http.StatusOK},
+		{"dashboard", ControlAPIV1Prefix + "/openapi.json", http.StatusOK},
+		{"dashboard", ControlJobEndpointSignal, http.StatusForbidden},
+		{"dashboard", ControlAPIV1Prefix + "/signal", http.StatusForbidden},
+		{"ops", ControlAPIV1Prefix + "/signal", http.StatusOK},
+		{"ops", ControlJobEndpointStatus, http.StatusOK},
+		{"ops", "/nonexistent", http.StatusNotFound},
+		{"unknown", ControlJobEndpointStatus, http.StatusForbidden},
+		{"", ControlJobEndpointStatus, http.StatusForbidden},
+	}
+	for _, tc := range tcs {
+		req := httptest.NewRequest(http.MethodPost, tc.path, nil)
+		if tc.identity != "" {
+			req.TLS = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: tc.identity}}},
+			}
+		}
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, req)
+		assert.Equal(t, tc.status, rec.Code, "identity=%q path=%q", tc.identity, tc.path)
+	}
+}
+
+func TestNewRemoteControlFromConfigRoles(t *testing.T) {
+	in := &config.GlobalControlRemote{
+		Listen: ":8889",
+		Ca:     "/nonexistent/ca.crt", Cert: "/nonexistent/cert.crt", Key: "/nonexistent/key.pem",
+		Clients: map[string]string{"dashboard": "admin"},
+	}
+	_, err := newRemoteControlFromConfig(in)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid role")
+
+	in.Clients = nil
+	_, err = newRemoteControlFromConfig(in)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "clients")
+}
+
+func TestControlOpenAPIIsValidJSON(t *testing.T) {
+	rec := httptest.NewRecorder()
+	controlOpenAPIHandler{}.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, ControlAPIV1Prefix+"/openapi.json", nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, controlOpenAPI, rec.Body.String())
+}
+
+// writeTestPKI writes a CA, a server certificate for 127.0.0.1 and a client certificate for clientCN to dir
+func writeTestPKI(t *testing.T, dir, clientCN string) (ca, cert, key, clientCert, clientKey string) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	write := func(name, typ string, der []byte) string {
+		p := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600))
+		return p
+	}
+	issue := func(name string, serial int64, tmpl *x509.Certificate) (certPath, keyPath string) {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		tmpl.SerialNumber = big.NewInt(serial)
+		tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &k.PublicKey, caKey)
+		require.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(k)
+		require.NoError(t, err)
+		return write(name+".crt", "CERTIFICATE", der), write(name+".key", "EC PRIVATE KEY", keyDER)
+	}
+	ca = write("ca.crt", "CERTIFICATE", caDER)
+	cert, key = issue("server", 2, &x509.Certificate{Subject: pkix.Name{CommonName: "server"}, IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)}})
+	clientCert, clientKey = issue("client", 3, &x509.Certificate{Subject: pkix.Name{CommonName: clientCN}})
+	return
+}
+
+func TestRemoteControlServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-control-remote")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ca, cert, key, clientCertPath, clientKeyPath := writeTestPKI(t, dir, "dashboard")
+
+	r, err := newRemoteControlFromConfig(&config.GlobalControlRemote{
+		Listen: "127.0.0.1:0",
+		Ca:     ca, Cert: cert, Key: key,
+		HandshakeTimeout: 10 * time.Second,
+		Clients:          map[string]string{"dashboard": "read_only"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, r.Listen())
+	addr := r.listener.Addr().String()
+
+	// the address is in use, the error must be reported by Listen
+	r2, err := newRemoteControlFromConfig(&config.GlobalControlRemote{
+		Listen: addr,
+		Ca:     ca, Cert: cert, Key: key,
+		Clients: map[string]string{"dashboard": "read_only"},
+	})
+	require.NoError(t, err)
+	require.Error(t, r2.Listen())
+
+	mux := newControlMux()
+	mux.handle(ControlJobEndpointStatus, "/status", controlRoleReadOnly, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.serve(ctx, logger.NewNullLogger(), mux)
+
+	// a peer that never starts the handshake must not hold up other clients
+	stalled, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer stalled.Close()
+
+	rootCA, err := tlsconf.ParseCAFile(ca)
+	require.NoError(t, err)
+	clientCert, err := tls.LoadX509KeyPair(clientCertPath, clientKeyPath)
+	require.NoError(t, err)
+	tlsConfig, err := tlsconf.ClientAuthClient("127.0.0.1", rootCA, clientCert)
+	require.NoError(t, err)
+	client := http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}, Timeout: 2 * time.Second}
+	resp, err := client.Get("https://" + addr + ControlAPIV1Prefix + "/status")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", string(body))
+}
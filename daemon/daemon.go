@@ -22,6 +22,7 @@ import (
 	"github.com/zrepl/zrepl/daemon/job/wakeup"
 	"github.com/zrepl/zrepl/daemon/logging"
 	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/util/sdnotify"
 	"github.com/zrepl/zrepl/version"
 	"github.com/zrepl/zrepl/zfs/zfscmd"
 )
@@ -37,11 +38,17 @@ func Run(ctx context.Context, conf *config.Config) error {
 		cancel()
 	}()
 
-	outlets, err := logging.OutletsFromConfig(*conf.Global.Logging)
+	logLevels := logging.NewRuntimeLevels()
+	outlets, err := logging.OutletsFromConfig(*conf.Global.Logging, logLevels)
 	if err != nil {
 		return errors.Wrap(err, "cannot build logging from config")
 	}
 	outlets.Add(newPrometheusLogOutlet(), logger.Debug)
+	logRing, err := logging.NewRingOutlet(conf.Global.Control.LogBufferSize)
+	if err != nil {
+		return errors.Wrap(err, "cannot create log buffer")
+	}
+	outlets.Add(logRing, logger.Debug)
 
 	confJobs, err := job.JobsFromConfig(conf)
 	if err != nil {
@@ -51,6 +58,24 @@ func Run(ctx context.Context, conf *config.Config) error {
 	log := logger.NewLogger(outlets, 1*time.Second)
 	log.Info(version.NewZreplVersionInformation().String())
 
+	// SIGUSR1 reopens log files, e.g., after logrotate moved them
+	reopenChan := make(chan os.Signal, 1)
+	signal.Notify(reopenChan, syscall.SIGUSR1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reopenChan:
+			}
+			if err := logging.ReopenFileOutlets(outlets); err != nil {
+				log.WithError(err).Error("cannot reopen log files")
+			} else {
+				log.Info("reopened log files")
+			}
+		}
+	}()
+
 	ctx = logging.WithLoggers(ctx, logging.SubsystemLoggersWithUniversalLogger(log))
 	trace.RegisterCallback(trace.Callback{
 		OnBegin: func(ctx context.Context) { logging.GetLogger(ctx, logging.SubsysTraceData).Debug("begin span") },
@@ -71,12 +96,14 @@ func Run(ctx context.Context, conf *config.Config) error {
 	jobs := newJobs()
 
 	// start control socket
-	controlJob, err := newControlJob(conf.Global.Control.SockPath, jobs)
+	controlJob, err := newControlJob(conf.Global.Control, jobs, logRing, logLevels)
 	if err != nil {
-		panic(err) // FIXME
+		return errors.Wrap(err, "cannot build control job")
 	}
 	jobs.start(ctx, controlJob, true)
 
+	health := &healthChecker{jobs: jobs, controlSockpath: conf.Global.Control.SockPath}
+
 	for i, jc := range conf.Global.Monitoring {
 		var (
 			job job.Job
@@ -85,6 +112,16 @@ func Run(ctx context.Context, conf *config.Config) error {
 		switch v := jc.Ret.(type) {
 		case *config.PrometheusMonitoring:
 			job, err = newPrometheusJobFromConfig(v)
+		case *config.ChecksMonitoring:
+			job, err = newChecksJobFromConfig(v, conf.Jobs, jobs)
+		case *config.HealthMonitoring:
+			job, err = newHealthJobFromConfig(v, health)
+		case *config.OpenTelemetryMonitoring:
+			job, err = newOpenTelemetryJobFromConfig(v)
+		case *config.PushgatewayMonitoring:
+			job, err = newPushgatewayJobFromConfig(v)
+		case *config.RemoteWriteMonitoring:
+			job, err = newRemoteWriteJobFromConfig(v)
 		default:
 			return errors.Errorf("unknown monitoring job #%d (type %T)", i, v)
 		}
@@ -106,6 +143,8 @@ func Run(ctx context.Context, conf *config.Config) error {
 	for _, j := range confJobs {
 		jobs.start(ctx, j, false)
 	}
+	jobs.markAllStarted()
+	notifySystemd(ctx, log, health)
 
 	select {
 	case <-jobs.wait():
@@ -113,6 +152,9 @@ func Run(ctx context.Context, conf *config.Config) error {
 	case <-ctx.Done():
 		log.WithError(ctx.Err()).Info("context finished")
 	}
+	if _, err := sdnotify.Notify(sdnotify.Stopping); err != nil {
+		log.WithError(err).Error("cannot notify service manager")
+	}
 	log.Info("waiting for jobs to finish")
 	<-jobs.wait()
 	log.Info("daemon exiting")
@@ -127,6 +169,9 @@ type jobs struct {
 	wakeups map[string]wakeup.Func // by Job.Name
 	resets  map[string]reset.Func  // by Job.Name
 	jobs    map[string]job.Job
+	exited  map[string]bool // by Job.Name, jobs whose Run returned
+	// set after all jobs in the config have been started
+	allStarted bool
 }
 
 func newJobs() *jobs {
@@ -134,6 +179,7 @@ func newJobs() *jobs {
 		wakeups: make(map[string]wakeup.Func),
 		resets:  make(map[string]reset.Func),
 		jobs:    make(map[string]job.Job),
+		exited:  make(map[string]bool),
 	}
 }
 
@@ -182,6 +228,12 @@ func (s *jobs) status() map[string]*job.Status {
 	return ret
 }
 
+func (s *jobs) markAllStarted() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.allStarted = true
+}
+
 func (s *jobs) wakeup(job string) error {
 	s.m.RLock()
 	defer s.m.RUnlock()
@@ -205,8 +257,13 @@ func (s *jobs) reset(job string) error {
 }
 
 const (
-	jobNamePrometheus = "_prometheus"
-	jobNameControl    = "_control"
+	jobNamePrometheus    = "_prometheus"
+	jobNameControl       = "_control"
+	jobNameChecks        = "_checks"
+	jobNameOpenTelemetry = "_opentelemetry"
+	jobNamePushgateway   = "_pushgateway"
+	jobNameRemoteWrite   = "_remote_write"
+	jobNameHealth        = "_health"
 )
 
 func IsInternalJobName(s string) bool {
@@ -244,6 +301,11 @@ func (s *jobs) start(ctx context.Context, j job.Job, internal bool) {
 		defer s.wg.Done()
 		job.GetLogger(ctx).Info("starting job")
 		defer job.GetLogger(ctx).Info("job exited")
+		defer func() {
+			s.m.Lock()
+			defer s.m.Unlock()
+			s.exited[jobName] = true
+		}()
 		j.Run(ctx)
 	}()
 }
@@ -0,0 +1,237 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/endpoint"
+	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/util/envconst"
+	"github.com/zrepl/zrepl/util/sdnotify"
+	"github.com/zrepl/zrepl/util/tcpsock"
+	"github.com/zrepl/zrepl/zfs"
+)
+
+// healthChecker implements the checks behind the /healthz and /readyz endpoints
+// of the health monitoring job and the systemd watchdog.
+type healthChecker struct {
+	jobs            *jobs
+	controlSockpath string
+}
+
+type healthCheckResult struct {
+	name string
+	err  error // nil if the check passed
+}
+
+var healthControlTimeout = envconst.Duration("ZREPL_DAEMON_HEALTH_CONTROL_TIMEOUT", 5*time.Second)
+
+// live checks whether the daemon is responsive, i.e., whether the control socket serves requests.
+func (c *healthChecker) live(ctx context.Context) []healthCheckResult {
+	return []healthCheckResult{{"control_socket", c.checkControlSocket(ctx)}}
+}
+
+func (c *healthChecker) checkControlSocket(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthControlTimeout)
+	defer cancel()
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", c.controlSockpath)
+			},
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://unix"+ControlJobEndpointVersion, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "control socket is not responsive")
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("control socket responded with %s", res.Status)
+	}
+	return nil
+}
+
+// ready checks whether the daemon is live, all jobs have been started and are running,
+// and no job's invocation has been running for longer than maxInvocationDuration (0 disables the latter).
+func (c *healthChecker) ready(ctx context.Context, maxInvocationDuration time.Duration, now time.Time) []healthCheckResult {
+	results := c.live(ctx)
+
+	c.jobs.m.RLock()
+	defer c.jobs.m.RUnlock()
+
+	if !c.jobs.allStarted {
+		results = append(results, healthCheckResult{"startup", errors.New("daemon is starting")})
+	}
+
+	names := make([]string, 0, len(c.jobs.jobs))
+	for name := range c.jobs.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var err error
+		if c.jobs.exited[name] {
+			err = errors.New("job is not running")
+		} else if r, ok := c.jobs.jobs[name].(job.InvocationReporter); ok && maxInvocationDuration > 0 {
+			if startedAt, running := r.RunningInvocationSince(); running && now.Sub(startedAt) > maxInvocationDuration {
+				err = errors.Errorf("invocation has been running for %s (max_invocation_duration is %s)",
+					now.Sub(startedAt).Truncate(time.Second), maxInvocationDuration)
+			}
+		}
+		results = append(results, healthCheckResult{"job " + name, err})
+	}
+	return results
+}
+
+// writeHealthCheckResults writes results in the format used by the Kubernetes health endpoints
+// and returns true if all checks passed.
+func writeHealthCheckResults(w io.Writer, endpoint string, results []healthCheckResult) (ok bool) {
+	ok = true
+	for _, r := range results {
+		if r.err == nil {
+			fmt.Fprintf(w, "[+]%s ok\n", r.name)
+		} else {
+			ok = false
+			fmt.Fprintf(w, "[-]%s failed: %s\n", r.name, r.err)
+		}
+	}
+	if ok {
+		fmt.Fprintf(w, "%s check passed\n", endpoint)
+	} else {
+		fmt.Fprintf(w, "%s check failed\n", endpoint)
+	}
+	return ok
+}
+
+type healthHandler struct {
+	endpoint string
+	check    func(ctx context.Context) []healthCheckResult
+}
+
+func (h healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	ok := writeHealthCheckResults(&buf, h.endpoint, h.check(r.Context()))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = io.Copy(w, &buf)
+}
+
+type healthJob struct {
+	listen                string
+	freeBind              bool
+	maxInvocationDuration time.Duration
+	checker               *healthChecker
+}
+
+func newHealthJobFromConfig(in *config.HealthMonitoring, checker *healthChecker) (*healthJob, error) {
+	if _, _, err := net.SplitHostPort(in.Listen); err != nil {
+		return nil, err
+	}
+	return &healthJob{in.Listen, in.ListenFreeBind, in.MaxInvocationDuration, checker}, nil
+}
+
+func (j *healthJob) Name() string { return jobNameHealth }
+
+func (j *healthJob) Status() *job.Status { return &job.Status{Type: job.TypeInternal} }
+
+func (j *healthJob) OwnedDatasetSubtreeRoot() (p *zfs.DatasetPath, ok bool) { return nil, false }
+
+func (j *healthJob) SenderConfig() *endpoint.SenderConfig { return nil }
+
+func (j *healthJob) RegisterMetrics(registerer prometheus.Registerer) {}
+
+func (j *healthJob) Run(ctx context.Context) {
+	log := job.GetLogger(ctx)
+
+	l, err := tcpsock.Listen(j.listen, j.freeBind)
+	if err != nil {
+		log.WithError(err).Error("cannot listen")
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", healthHandler{"healthz", j.checker.live})
+	mux.Handle("/readyz", healthHandler{"readyz", func(ctx context.Context) []healthCheckResult {
+		return j.checker.ready(ctx, j.maxInvocationDuration, time.Now())
+	}})
+
+	err = http.Serve(l, mux)
+	if err != nil && ctx.Err() == nil {
+		log.WithError(err).Error("error while serving")
+	}
+}
+
+// notifySystemd reports readiness to systemd (Type=notify) and, if the service has
+// a WatchdogSec= configured, notifies the watchdog as long as the daemon is live.
+// It returns immediately, the watchdog notifications stop when ctx is done.
+func notifySystemd(ctx context.Context, log logger.Logger, checker *healthChecker) {
+	sent, err := sdnotify.Notify(sdnotify.Ready)
+	if err != nil {
+		log.WithError(err).Error("cannot notify service manager")
+		return
+	}
+	if !sent {
+		return
+	}
+
+	timeout, enabled, err := sdnotify.WatchdogInterval()
+	if err != nil {
+		log.WithError(err).Error("cannot determine watchdog interval")
+		return
+	}
+	if !enabled {
+		return
+	}
+	interval := timeout / 2
+	log.WithField("interval", interval).Info("notifying service manager watchdog")
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			ok := true
+			for _, r := range checker.live(ctx) {
+				if r.err != nil {
+					ok = false
+					log.WithError(r.err).WithField("check", r.name).Warn("liveness check failed, not notifying watchdog")
+				}
+			}
+			if !ok {
+				continue
+			}
+			if _, err := sdnotify.Notify(sdnotify.Watchdog); err != nil {
+				log.WithError(err).Error("cannot notify service manager watchdog")
+			}
+		}
+	}()
+}
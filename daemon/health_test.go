@@ -0,0 +1,93 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/endpoint"
+	"github.com/zrepl/zrepl/zfs"
+)
+
+type healthTestJob struct {
+	name              string
+	invocationStarted time.Time // zero if not running
+}
+
+func (j *healthTestJob) Name() string                                      { return j.name }
+func (j *healthTestJob) Run(ctx context.Context)                           {}
+func (j *healthTestJob) Status() *job.Status                               { return &job.Status{Type: job.TypeInternal} }
+func (j *healthTestJob) RegisterMetrics(registerer prometheus.Registerer)  {}
+func (j *healthTestJob) OwnedDatasetSubtreeRoot() (*zfs.DatasetPath, bool) { return nil, false }
+func (j *healthTestJob) SenderConfig() *endpoint.SenderConfig              { return nil }
+func (j *healthTestJob) RunningInvocationSince() (time.Time, bool) {
+	return j.invocationStarted, !j.invocationStarted.IsZero()
+}
+
+func serveTestControlSocket(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "zrepl-health-test")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sockpath := filepath.Join(dir, "control")
+	l, err := net.Listen("unix", sockpath)
+	require.NoError(t, err)
+	mux := http.NewServeMux()
+	mux.HandleFunc(ControlJobEndpointVersion, func(w http.ResponseWriter, r *http.Request) {})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return sockpath
+}
+
+func failed(results []healthCheckResult) (names []string) {
+	for _, r := range results {
+		if r.err != nil {
+			names = append(names, r.name)
+		}
+	}
+	return names
+}
+
+func TestHealthChecker(t *testing.T) {
+	now := time.Now()
+	jobs := newJobs()
+	jobs.jobs["idle"] = &healthTestJob{name: "idle"}
+	jobs.jobs["busy"] = &healthTestJob{name: "busy", invocationStarted: now.Add(-time.Hour)}
+	jobs.jobs["exited"] = &healthTestJob{name: "exited"}
+	jobs.exited["exited"] = true
+
+	c := &healthChecker{jobs: jobs, controlSockpath: serveTestControlSocket(t)}
+	ctx := context.Background()
+
+	assert.Empty(t, failed(c.live(ctx)))
+	assert.Equal(t, []string{"startup", "job exited"}, failed(c.ready(ctx, 0, now)))
+
+	jobs.markAllStarted()
+	assert.Equal(t, []string{"job exited"}, failed(c.ready(ctx, 0, now)))
+	assert.Equal(t, []string{"job exited"}, failed(c.ready(ctx, 2*time.Hour, now)))
+	assert.Equal(t, []string{"job busy", "job exited"}, failed(c.ready(ctx, 30*time.Minute, now)))
+
+	c.controlSockpath = filepath.Join(filepath.Dir(c.controlSockpath), "nonexistent")
+	assert.Equal(t, []string{"control_socket"}, failed(c.live(ctx)))
+}
+
+func TestWriteHealthCheckResults(t *testing.T) {
+	var buf bytes.Buffer
+	ok := writeHealthCheckResults(&buf, "readyz", []healthCheckResult{
+		{"control_socket", nil},
+		{"job foo", os.ErrClosed},
+	})
+	assert.False(t, ok)
+	assert.Equal(t, "[+]control_socket ok\n[-]job foo failed: file already closed\nreadyz check failed\n", buf.String())
+}
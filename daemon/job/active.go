@@ -12,6 +12,7 @@ import (
 	"github.com/zrepl/zrepl/daemon/logging/trace"
 
 	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/filters"
 	"github.com/zrepl/zrepl/daemon/job/reset"
 	"github.com/zrepl/zrepl/daemon/job/wakeup"
 	"github.com/zrepl/zrepl/daemon/pruner"
@@ -22,6 +23,10 @@ import (
 	"github.com/zrepl/zrepl/replication/logic"
 	"github.com/zrepl/zrepl/replication/report"
 	"github.com/zrepl/zrepl/rpc"
+	"github.com/zrepl/zrepl/rpc/dataconn"
+	"github.com/zrepl/zrepl/rpc/dataconn/checksum"
+	"github.com/zrepl/zrepl/rpc/dataconn/compression"
+	"github.com/zrepl/zrepl/rpc/dataconn/stripe"
 	"github.com/zrepl/zrepl/transport"
 	"github.com/zrepl/zrepl/transport/fromconfig"
 	"github.com/zrepl/zrepl/zfs"
@@ -33,11 +38,18 @@ type ActiveSide struct {
 	connecter transport.Connecter
 
 	prunerFactory *pruner.PrunerFactory
+	driverConfig  *driver.Config
 
 	promRepStateSecs      *prometheus.HistogramVec // labels: state
 	promPruneSecs         *prometheus.HistogramVec // labels: prune_side
 	promBytesReplicated   *prometheus.CounterVec   // labels: filesystem
 	promReplicationErrors prometheus.Gauge
+	freshness             *logic.FreshnessMetrics // nil if disabled
+
+	historyMtx sync.Mutex
+	history    ReplicationHistory
+
+	invocations invocationTracker
 
 	tasksMtx sync.Mutex
 	tasks    activeSideTasks
@@ -65,6 +77,14 @@ type activeSideTasks struct {
 
 	// valid for state ActiveSidePruneReceiver, ActiveSideDone
 	prunerSenderCancel, prunerReceiverCancel context.CancelFunc
+
+	// valid for state ActiveSideReplicating, ActiveSidePruneSender, ActiveSidePruneReceiver, ActiveSideDone
+	remoteCapabilities func() (capabilities []string, ok bool)
+}
+
+// capabilityNegotiator is implemented by the remote endpoint (rpc.Client) of an active side.
+type capabilityNegotiator interface {
+	NegotiatedCapabilities() (capabilities []string, ok bool)
 }
 
 func (a *ActiveSide) updateTasks(u func(*activeSideTasks)) activeSideTasks {
@@ -91,12 +111,13 @@ type activeMode interface {
 }
 
 type modePush struct {
-	setupMtx      sync.Mutex
-	sender        *endpoint.Sender
-	receiver      *rpc.Client
-	senderConfig  *endpoint.SenderConfig
-	plannerPolicy *logic.PlannerPolicy
-	snapper       *snapper.PeriodicOrManual
+	setupMtx        sync.Mutex
+	sender          *endpoint.Sender
+	receiver        *rpc.Client
+	rpcClientConfig *rpc.ClientConfig
+	senderConfig    *endpoint.SenderConfig
+	plannerPolicy   *logic.PlannerPolicy
+	snapper         *snapper.PeriodicOrManual
 }
 
 func (m *modePush) ConnectEndpoints(ctx context.Context, connecter transport.Connecter) {
@@ -106,7 +127,7 @@ func (m *modePush) ConnectEndpoints(ctx context.Context, connecter transport.Con
 		panic("inconsistent use of ConnectEndpoints and DisconnectEndpoints")
 	}
 	m.sender = endpoint.NewSender(*m.senderConfig)
-	m.receiver = rpc.NewClient(connecter, rpc.GetLoggersOrPanic(ctx))
+	m.receiver = rpc.NewClient(connecter, rpc.GetLoggersOrPanic(ctx), *m.rpcClientConfig)
 }
 
 func (m *modePush) DisconnectEndpoints() {
@@ -162,6 +183,10 @@ func modePushFromConfig(g *config.Global, in *config.PushJob, jobID endpoint.Job
 		ReplicationConfig: *replicationConfig,
 	}
 
+	if m.rpcClientConfig, err = rpcClientConfigFromConfig(in.Replication); err != nil {
+		return nil, errors.Wrap(err, "field `replication`")
+	}
+
 	if m.snapper, err = snapper.FromConfig(g, m.senderConfig.FSF, in.Snapshotting); err != nil {
 		return nil, errors.Wrap(err, "cannot build snapper")
 	}
@@ -170,12 +195,13 @@ func modePushFromConfig(g *config.Global, in *config.PushJob, jobID endpoint.Job
 }
 
 type modePull struct {
-	setupMtx       sync.Mutex
-	receiver       *endpoint.Receiver
-	receiverConfig endpoint.ReceiverConfig
-	sender         *rpc.Client
-	plannerPolicy  *logic.PlannerPolicy
-	interval       config.PositiveDurationOrManual
+	setupMtx        sync.Mutex
+	receiver        *endpoint.Receiver
+	receiverConfig  endpoint.ReceiverConfig
+	sender          *rpc.Client
+	rpcClientConfig *rpc.ClientConfig
+	plannerPolicy   *logic.PlannerPolicy
+	interval        config.PositiveDurationOrManual
 }
 
 func (m *modePull) ConnectEndpoints(ctx context.Context, connecter transport.Connecter) {
@@ -185,7 +211,7 @@ func (m *modePull) ConnectEndpoints(ctx context.Context, connecter transport.Con
 		panic("inconsistent use of ConnectEndpoints and DisconnectEndpoints")
 	}
 	m.receiver = endpoint.NewReceiver(m.receiverConfig)
-	m.sender = rpc.NewClient(connecter, rpc.GetLoggersOrPanic(ctx))
+	m.sender = rpc.NewClient(connecter, rpc.GetLoggersOrPanic(ctx), *m.rpcClientConfig)
 }
 
 func (m *modePull) DisconnectEndpoints() {
@@ -257,6 +283,10 @@ func modePullFromConfig(g *config.Global, in *config.PullJob, jobID endpoint.Job
 		ReplicationConfig: *replicationConfig,
 	}
 
+	if m.rpcClientConfig, err = rpcClientConfigFromConfig(in.Replication); err != nil {
+		return nil, errors.Wrap(err, "field `replication`")
+	}
+
 	m.receiverConfig, err = buildReceiverConfig(in, jobID)
 	if err != nil {
 		return nil, err
@@ -265,6 +295,31 @@ func modePullFromConfig(g *config.Global, in *config.PullJob, jobID endpoint.Job
 	return m, nil
 }
 
+func rpcClientConfigFromConfig(in *config.Replication) (*rpc.ClientConfig, error) {
+	alg, err := compression.AlgorithmFromString(in.Compression.Algorithm)
+	if err != nil {
+		return nil, errors.Wrap(err, "field `compression.algorithm`")
+	}
+	c := compression.Config{Algorithm: alg, Level: in.Compression.Level}
+	if err := c.Validate(); err != nil {
+		return nil, errors.Wrap(err, "field `compression`")
+	}
+	cs, err := checksum.AlgorithmFromString(in.Checksum)
+	if err != nil {
+		return nil, errors.Wrap(err, "field `checksum`")
+	}
+	if in.Stripes < 1 || in.Stripes > stripe.MaxStripes {
+		return nil, errors.Errorf("field `stripes` must be in [1, %d]", stripe.MaxStripes)
+	}
+	return &rpc.ClientConfig{
+		Data: dataconn.ClientConfig{
+			Compression: c,
+			Checksum:    cs,
+			Stripes:     in.Stripes,
+		},
+	}, nil
+}
+
 func activeSide(g *config.Global, in *config.ActiveJob, configJob interface{}) (j *ActiveSide, err error) {
 
 	j = &ActiveSide{}
@@ -285,6 +340,11 @@ func activeSide(g *config.Global, in *config.ActiveJob, configJob interface{}) (
 		return nil, err // no wrapping required
 	}
 
+	j.driverConfig, err = driver.ConfigFromConfig(in.Replication)
+	if err != nil {
+		return nil, errors.Wrap(err, "field `replication`")
+	}
+
 	j.promRepStateSecs = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace:   "zrepl",
 		Subsystem:   "replication",
@@ -320,7 +380,16 @@ func activeSide(g *config.Global, in *config.ActiveJob, configJob interface{}) (
 		Help:        "seconds spent in pruner",
 		ConstLabels: prometheus.Labels{"zrepl_job": j.name.String()},
 	}, []string{"prune_side"})
-	j.prunerFactory, err = pruner.NewPrunerFactory(in.Pruning, j.promPruneSecs)
+	j.freshness, err = freshnessMetricsFromConfig(j.name.String(), in.Monitoring)
+	if err != nil {
+		return nil, errors.Wrap(err, "field `monitoring.freshness`")
+	}
+
+	var snapshotCounts pruner.SnapshotCountObserver
+	if j.freshness != nil {
+		snapshotCounts = j.freshness
+	}
+	j.prunerFactory, err = pruner.NewPrunerFactory(in.Pruning, j.promPruneSecs, snapshotCounts)
 	if err != nil {
 		return nil, err
 	}
@@ -328,11 +397,33 @@ func activeSide(g *config.Global, in *config.ActiveJob, configJob interface{}) (
 	return j, nil
 }
 
+// returns nil if freshness metrics are disabled
+func freshnessMetricsFromConfig(jobName string, in *config.ActiveJobMonitoring) (*logic.FreshnessMetrics, error) {
+	if in == nil || in.Freshness == nil {
+		return nil, nil
+	}
+	if in.Freshness.MaxFilesystems < 0 {
+		return nil, fmt.Errorf("max_filesystems must not be negative, got %d", in.Freshness.MaxFilesystems)
+	}
+	var filter zfs.DatasetFilter
+	if len(in.Freshness.Filesystems) > 0 {
+		f, err := filters.DatasetMapFilterFromConfig(in.Freshness.Filesystems)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot build filesystem filter")
+		}
+		filter = f
+	}
+	return logic.NewFreshnessMetrics(jobName, filter, in.Freshness.MaxFilesystems), nil
+}
+
 func (j *ActiveSide) RegisterMetrics(registerer prometheus.Registerer) {
 	registerer.MustRegister(j.promRepStateSecs)
 	registerer.MustRegister(j.promPruneSecs)
 	registerer.MustRegister(j.promBytesReplicated)
 	registerer.MustRegister(j.promReplicationErrors)
+	if j.freshness != nil {
+		j.freshness.RegisterMetrics(registerer)
+	}
 }
 
 func (j *ActiveSide) Name() string { return j.name.String() }
@@ -341,6 +432,15 @@ type ActiveSideStatus struct {
 	Replication                    *report.Report
 	PruningSender, PruningReceiver *pruner.Report
 	Snapshotting                   *snapper.Report
+	// capabilities negotiated with the remote side on the most recent connection,
+	// nil if no connection has been established yet
+	RemoteCapabilities []string
+	// nil if no replication run has finished yet
+	History *ReplicationHistory
+}
+
+func (j *ActiveSide) RunningInvocationSince() (time.Time, bool) {
+	return j.invocations.runningSince()
 }
 
 func (j *ActiveSide) Status() *Status {
@@ -358,6 +458,14 @@ func (j *ActiveSide) Status() *Status {
 		s.PruningReceiver = tasks.prunerReceiver.Report()
 	}
 	s.Snapshotting = j.mode.SnapperReport()
+	if tasks.remoteCapabilities != nil {
+		s.RemoteCapabilities, _ = tasks.remoteCapabilities()
+	}
+	j.historyMtx.Lock()
+	if j.history.Runs > 0 {
+		s.History = j.history.copyWithFreshness(j.freshness.Report())
+	}
+	j.historyMtx.Unlock()
 	return &Status{Type: t, JobSpecific: s}
 }
 
@@ -419,8 +527,11 @@ outer:
 		case <-periodicDone:
 		}
 		invocationCount++
-		invocationCtx, endSpan := trace.WithSpan(ctx, fmt.Sprintf("invocation-%d", invocationCount))
+		// each invocation is a separate trace, see daemon/logging/trace
+		invocationCtx, endSpan := trace.WithSpan(trace.WithNewTrace(ctx), fmt.Sprintf("invocation-%d", invocationCount))
+		endInvocation := j.invocations.begin()
 		j.do(invocationCtx)
+		endInvocation()
 		endSpan()
 	}
 }
@@ -456,18 +567,31 @@ func (j *ActiveSide) do(ctx context.Context) {
 		j.updateTasks(func(tasks *activeSideTasks) {
 			// reset it
 			*tasks = activeSideTasks{}
+			for _, ep := range []interface{}{sender, receiver} {
+				if n, ok := ep.(capabilityNegotiator); ok {
+					tasks.remoteCapabilities = n.NegotiatedCapabilities
+				}
+			}
 			tasks.replicationCancel = func() { repCancel(); endSpan() }
 			tasks.replicationReport, repWait = replication.Do(
-				ctx, logic.NewPlanner(j.promRepStateSecs, j.promBytesReplicated, sender, receiver, j.mode.PlannerPolicy()),
+				ctx, logic.NewPlanner(j.promRepStateSecs, j.promBytesReplicated, j.freshness, sender, receiver, j.mode.PlannerPolicy()),
+				*j.driverConfig,
 			)
 			tasks.state = ActiveSideReplicating
 		})
 		GetLogger(ctx).Info("start replication")
 		repWait(true) // wait blocking
-		repCancel()   // always cancel to free up context resources
+		// cancelled runs say nothing about the job's health
+		cancelled := ctx.Err() != nil
+		repCancel() // always cancel to free up context resources
 
 		replicationReport := j.tasks.replicationReport()
 		j.promReplicationErrors.Set(float64(replicationReport.GetFailedFilesystemsCountInLatestAttempt()))
+		if !cancelled {
+			j.historyMtx.Lock()
+			j.history.update(replicationReport, time.Now())
+			j.historyMtx.Unlock()
+		}
 
 		endSpan()
 	}
@@ -516,3 +640,35 @@ func (j *ActiveSide) do(ctx context.Context) {
 	})
 
 }
+
+// ReplicationDryRun builds the push or pull job `in`, connects to the passive side
+// using the job's transport, and plans replication without replicating
+// (see logic.Planner.DryRun).
+// Neither side's holds, bookmarks or placeholder filesystems are modified.
+func ReplicationDryRun(ctx context.Context, g *config.Global, in config.JobEnum) ([]*logic.FilesystemDryRunReport, error) {
+	var j *ActiveSide
+	var err error
+	switch v := in.Ret.(type) {
+	case *config.PushJob:
+		j, err = activeSide(g, &v.ActiveJob, v)
+	case *config.PullJob:
+		j, err = activeSide(g, &v.ActiveJob, v)
+	default:
+		return nil, fmt.Errorf("job type %T does not replicate actively, use a push or pull job", v)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot build job %q", in.Name())
+	}
+
+	ctx = context.WithValue(ctx, endpoint.ClientIdentityKey, FakeActiveSideDirectMethodInvocationClientIdentity(j.name))
+
+	j.mode.ConnectEndpoints(ctx, j.connecter)
+	defer j.mode.DisconnectEndpoints()
+
+	sender, receiver := j.mode.SenderReceiver()
+	planner := logic.NewPlanner(nil, nil, nil, sender, receiver, j.mode.PlannerPolicy())
+	if err := planner.WaitForConnectivity(ctx); err != nil {
+		return nil, err
+	}
+	return planner.DryRun(ctx)
+}
@@ -0,0 +1,88 @@
+package job
+
+import (
+	"time"
+
+	"github.com/zrepl/zrepl/replication/logic"
+	"github.com/zrepl/zrepl/replication/report"
+)
+
+// ReplicationHistory summarizes the outcome of an active job's replication runs since the daemon started.
+type ReplicationHistory struct {
+	Runs              int
+	LastRunFinishedAt time.Time
+	// Number of most recent consecutive runs that failed before any filesystem was replicated,
+	// e.g., because the passive side was unreachable.
+	ConsecutiveFailedRuns int
+	// by filesystem name
+	Filesystems map[string]*FilesystemReplicationHistory
+}
+
+type FilesystemReplicationHistory struct {
+	// Number of most recent consecutive runs in which replication of the filesystem failed
+	// (including runs that failed before any filesystem was replicated)
+	ConsecutiveFailures int
+	// zero if no run has replicated the filesystem successfully
+	LastSuccessAt time.Time
+	// From the freshness metrics (see monitoring.freshness), zero if unknown or if the side has no snapshots
+	NewestSenderSnapshot, NewestReceiverSnapshot time.Time
+}
+
+// update records the outcome of the replication run that produced r and finished at finishedAt.
+func (h *ReplicationHistory) update(r *report.Report, finishedAt time.Time) {
+	if len(r.Attempts) == 0 {
+		return
+	}
+	if h.Filesystems == nil {
+		h.Filesystems = make(map[string]*FilesystemReplicationHistory)
+	}
+	h.Runs++
+	h.LastRunFinishedAt = finishedAt
+
+	a := r.Attempts[len(r.Attempts)-1]
+	if a.State == report.AttemptPlanningError {
+		h.ConsecutiveFailedRuns++
+		for _, fs := range h.Filesystems {
+			fs.ConsecutiveFailures++
+		}
+		return
+	}
+	h.ConsecutiveFailedRuns = 0
+
+	seen := make(map[string]bool, len(a.Filesystems))
+	for _, f := range a.Filesystems {
+		seen[f.Info.Name] = true
+		fs, ok := h.Filesystems[f.Info.Name]
+		if !ok {
+			fs = &FilesystemReplicationHistory{}
+			h.Filesystems[f.Info.Name] = fs
+		}
+		if f.Error() != nil {
+			fs.ConsecutiveFailures++
+		} else if f.State == report.FilesystemDone {
+			fs.ConsecutiveFailures = 0
+			fs.LastSuccessAt = finishedAt
+		}
+	}
+	// filesystems that are no longer replicated
+	for name := range h.Filesystems {
+		if !seen[name] {
+			delete(h.Filesystems, name)
+		}
+	}
+}
+
+// copyWithFreshness returns a deep copy of h, with the newest snapshots filled in from freshness.
+func (h *ReplicationHistory) copyWithFreshness(freshness map[string]*logic.FilesystemFreshness) *ReplicationHistory {
+	c := *h
+	c.Filesystems = make(map[string]*FilesystemReplicationHistory, len(h.Filesystems))
+	for name, fs := range h.Filesystems {
+		fsc := *fs
+		if f, ok := freshness[name]; ok {
+			fsc.NewestSenderSnapshot = f.NewestSenderSnapshot
+			fsc.NewestReceiverSnapshot = f.NewestReceiverSnapshot
+		}
+		c.Filesystems[name] = &fsc
+	}
+	return &c
+}
@@ -0,0 +1,60 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/replication/logic"
+	"github.com/zrepl/zrepl/replication/report"
+)
+
+func TestReplicationHistory(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsReport := func(name string, state report.FilesystemState) *report.FilesystemReport {
+		r := &report.FilesystemReport{Info: &report.FilesystemInfo{Name: name}, State: state}
+		if state == report.FilesystemSteppingErrored {
+			r.StepError = report.NewTimedError("step failed", t0)
+		}
+		return r
+	}
+	run := func(state report.AttemptState, fss ...*report.FilesystemReport) *report.Report {
+		return &report.Report{Attempts: []*report.AttemptReport{{State: state, Filesystems: fss}}}
+	}
+
+	var h ReplicationHistory
+
+	h.update(&report.Report{}, t0) // no attempts, e.g. cancelled
+	assert.Equal(t, 0, h.Runs)
+
+	h.update(run(report.AttemptFanOutError,
+		fsReport("pool/a", report.FilesystemDone),
+		fsReport("pool/b", report.FilesystemSteppingErrored),
+	), t0)
+	assert.Equal(t, 1, h.Runs)
+	assert.Equal(t, 0, h.ConsecutiveFailedRuns)
+	assert.Equal(t, t0, h.Filesystems["pool/a"].LastSuccessAt)
+	assert.Equal(t, 1, h.Filesystems["pool/b"].ConsecutiveFailures)
+	assert.True(t, h.Filesystems["pool/b"].LastSuccessAt.IsZero())
+
+	h.update(run(report.AttemptPlanningError), t0.Add(time.Hour))
+	assert.Equal(t, 1, h.ConsecutiveFailedRuns)
+	assert.Equal(t, 1, h.Filesystems["pool/a"].ConsecutiveFailures)
+	assert.Equal(t, 2, h.Filesystems["pool/b"].ConsecutiveFailures)
+
+	// pool/a is no longer replicated
+	h.update(run(report.AttemptDone, fsReport("pool/b", report.FilesystemDone)), t0.Add(2*time.Hour))
+	assert.Equal(t, 0, h.ConsecutiveFailedRuns)
+	assert.NotContains(t, h.Filesystems, "pool/a")
+	assert.Equal(t, 0, h.Filesystems["pool/b"].ConsecutiveFailures)
+	assert.Equal(t, t0.Add(2*time.Hour), h.Filesystems["pool/b"].LastSuccessAt)
+
+	c := h.copyWithFreshness(map[string]*logic.FilesystemFreshness{
+		"pool/b": {NewestSenderSnapshot: t0, NewestReceiverSnapshot: t0.Add(-time.Hour)},
+	})
+	require.Contains(t, c.Filesystems, "pool/b")
+	assert.Equal(t, t0.Add(-time.Hour), c.Filesystems["pool/b"].NewestReceiverSnapshot)
+	assert.True(t, h.Filesystems["pool/b"].NewestReceiverSnapshot.IsZero(), "copy must not modify the original")
+}
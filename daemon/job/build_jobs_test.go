@@ -5,12 +5,14 @@ import (
 	"path"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/kr/pretty"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/transport/sshnative"
 	"github.com/zrepl/zrepl/transport/tls"
 )
 
@@ -50,6 +52,35 @@ func TestValidateReceivingSidesDoNotOverlap(t *testing.T) {
 	}
 }
 
+func TestLimiterFromConfig(t *testing.T) {
+	l, err := limiterFromConfig(nil)
+	require.NoError(t, err)
+	assert.Nil(t, l)
+
+	// no limits configured => no limiter
+	l, err = limiterFromConfig(&config.ServeLimits{
+		RetryAfter: time.Minute,
+		Default:    &config.ServeClientLimits{},
+		Clients:    map[string]*config.ServeClientLimits{"prod1": {}},
+	})
+	require.NoError(t, err)
+	assert.Nil(t, l)
+
+	l, err = limiterFromConfig(&config.ServeLimits{
+		RetryAfter: time.Minute,
+		Default:    &config.ServeClientLimits{},
+		Clients:    map[string]*config.ServeClientLimits{"prod1": {MaxConcurrentTransfers: 1}},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, l)
+	assert.Equal(t, time.Minute, l.RetryAfter())
+
+	_, err = limiterFromConfig(&config.ServeLimits{
+		Default: &config.ServeClientLimits{MaxConcurrentTransfers: -1},
+	})
+	assert.Error(t, err)
+}
+
 func TestJobIDErrorHandling(t *testing.T) {
 	tmpl := `
 jobs:
@@ -136,6 +167,7 @@ func TestSampleConfigsAreBuiltWithoutErrors(t *testing.T) {
 			t.Log(pretty.Sprint(c))
 
 			tls.FakeCertificateLoading(t)
+			sshnative.FakeKeyLoading(t)
 			jobs, err := JobsFromConfig(c)
 			t.Logf("jobs: %#v", jobs)
 			assert.NoError(t, err)
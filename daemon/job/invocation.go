@@ -0,0 +1,37 @@
+package job
+
+import (
+	"sync"
+	"time"
+)
+
+// InvocationReporter is implemented by jobs that do their work in invocations,
+// e.g., the replication and pruning runs of push and pull jobs.
+type InvocationReporter interface {
+	// RunningInvocationSince returns when the currently running invocation started,
+	// or false if no invocation is running.
+	RunningInvocationSince() (startedAt time.Time, running bool)
+}
+
+type invocationTracker struct {
+	mtx       sync.Mutex
+	startedAt time.Time // zero if no invocation is running
+}
+
+// begin marks the start of an invocation, the returned function marks its end.
+func (t *invocationTracker) begin() (end func()) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.startedAt = time.Now()
+	return func() {
+		t.mtx.Lock()
+		defer t.mtx.Unlock()
+		t.startedAt = time.Time{}
+	}
+}
+
+func (t *invocationTracker) runningSince() (time.Time, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.startedAt, !t.startedAt.IsZero()
+}
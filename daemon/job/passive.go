@@ -3,18 +3,25 @@ package job
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/zrepl/zrepl/daemon/logging/trace"
 
 	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/job/wakeup"
 	"github.com/zrepl/zrepl/daemon/logging"
+	"github.com/zrepl/zrepl/daemon/pruner"
 	"github.com/zrepl/zrepl/daemon/snapper"
 	"github.com/zrepl/zrepl/endpoint"
+	"github.com/zrepl/zrepl/replication/logic/pdu"
 	"github.com/zrepl/zrepl/rpc"
 	"github.com/zrepl/zrepl/transport"
 	"github.com/zrepl/zrepl/transport/fromconfig"
+	"github.com/zrepl/zrepl/util/admission"
 	"github.com/zrepl/zrepl/zfs"
 )
 
@@ -22,17 +29,32 @@ type PassiveSide struct {
 	mode   passiveMode
 	name   endpoint.JobID
 	listen transport.AuthenticatedListenerFactory
+
+	serverMtx sync.Mutex
+	server    *rpc.Server // nil until Run
 }
 
 type passiveMode interface {
 	Handler() rpc.Handler
 	RunPeriodic(ctx context.Context)
-	SnapperReport() *snapper.Report // may be nil
+	SnapperReport() *snapper.Report           // may be nil
+	PrunerReports() map[string]*pruner.Report // may be nil
+	RegisterMetrics(registerer prometheus.Registerer)
 	Type() Type
 }
 
 type modeSink struct {
 	receiverConfig endpoint.ReceiverConfig
+
+	// nil if sink-side pruning is not configured
+	prunerFactory *pruner.LocalPrunerFactory
+	pruneInterval time.Duration
+	promPruneSecs *prometheus.HistogramVec // labels: prune_side
+
+	prunersMtx sync.Mutex
+	pruners    map[string]*pruner.Pruner // by client identity
+
+	invocations invocationTracker
 }
 
 func (m *modeSink) Type() Type { return TypeSink }
@@ -41,9 +63,113 @@ func (m *modeSink) Handler() rpc.Handler {
 	return endpoint.NewReceiver(m.receiverConfig)
 }
 
-func (m *modeSink) RunPeriodic(_ context.Context)  {}
 func (m *modeSink) SnapperReport() *snapper.Report { return nil }
 
+func (m *modeSink) RunPeriodic(ctx context.Context) {
+	if m.prunerFactory == nil {
+		return
+	}
+	log := GetLogger(ctx)
+	t := time.NewTicker(m.pruneInterval)
+	defer t.Stop()
+	invocationCount := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-wakeup.Wait(ctx):
+		}
+		invocationCount++
+		invocationCtx, endSpan := trace.WithSpan(trace.WithNewTrace(ctx), fmt.Sprintf("prune-invocation-%d", invocationCount))
+		log.Info("start sink-side pruning")
+		endInvocation := m.invocations.begin()
+		m.doPrune(invocationCtx)
+		endInvocation()
+		log.Info("finished sink-side pruning")
+		endSpan()
+	}
+}
+
+// sinkClientRootFilter passes the direct children of a sink job's root_fs,
+// i.e., the filesystems that contain the data received from a single client.
+type sinkClientRootFilter struct {
+	root *zfs.DatasetPath
+}
+
+func (f sinkClientRootFilter) Filter(p *zfs.DatasetPath) (pass bool, err error) {
+	return p.HasPrefix(f.root) && p.Length() == f.root.Length()+1, nil
+}
+
+func (m *modeSink) doPrune(ctx context.Context) {
+	defer trace.WithSpanFromStackUpdateCtx(&ctx)()
+	log := GetLogger(ctx)
+
+	root := m.receiverConfig.RootWithoutClientComponent
+	clientRoots, err := zfs.ZFSListMapping(ctx, sinkClientRootFilter{root})
+	if err != nil {
+		log.WithError(err).Error("cannot list client filesystems below root_fs")
+		return
+	}
+
+	receiver := endpoint.NewReceiver(m.receiverConfig)
+	pruners := make(map[string]*pruner.Pruner, len(clientRoots))
+	for _, clientRoot := range clientRoots {
+		clientRoot := clientRoot.Copy()
+		clientRoot.TrimPrefix(root)
+		clientIdentity := clientRoot.ToString()
+		if err := endpoint.TestClientIdentity(root, clientIdentity); err != nil {
+			log.WithError(err).WithField("client_identity", clientIdentity).Error("skipping invalid client filesystem")
+			continue
+		}
+		clientCtx := context.WithValue(ctx, endpoint.ClientIdentityKey, clientIdentity)
+		history := lastReceivedHoldHistory{
+			target:     receiver,
+			rootFS:     root,
+			clientRoot: clientRoot,
+			jobID:      m.receiverConfig.JobID,
+			getHold:    endpoint.GetMostRecentLastReceivedHoldOfJob,
+		}
+		pruners[clientIdentity] = m.prunerFactory.BuildSinkPruner(clientCtx, receiver, history)
+	}
+
+	m.prunersMtx.Lock()
+	m.pruners = pruners
+	m.prunersMtx.Unlock()
+
+	clientIdentities := make([]string, 0, len(pruners))
+	for clientIdentity := range pruners {
+		clientIdentities = append(clientIdentities, clientIdentity)
+	}
+	sort.Strings(clientIdentities)
+	for _, clientIdentity := range clientIdentities {
+		if ctx.Err() != nil {
+			return
+		}
+		log.WithField("client_identity", clientIdentity).Debug("prune client filesystems")
+		pruners[clientIdentity].Prune()
+	}
+}
+
+func (m *modeSink) PrunerReports() map[string]*pruner.Report {
+	m.prunersMtx.Lock()
+	defer m.prunersMtx.Unlock()
+	if m.pruners == nil {
+		return nil
+	}
+	reports := make(map[string]*pruner.Report, len(m.pruners))
+	for clientIdentity, p := range m.pruners {
+		reports[clientIdentity] = p.Report()
+	}
+	return reports
+}
+
+func (m *modeSink) RegisterMetrics(registerer prometheus.Registerer) {
+	if m.promPruneSecs != nil {
+		registerer.MustRegister(m.promPruneSecs)
+	}
+}
+
 func modeSinkFromConfig(g *config.Global, in *config.SinkJob, jobID endpoint.JobID) (m *modeSink, err error) {
 	m = &modeSink{}
 
@@ -51,10 +177,73 @@ func modeSinkFromConfig(g *config.Global, in *config.SinkJob, jobID endpoint.Job
 	if err != nil {
 		return nil, err
 	}
+	m.receiverConfig.Limiter, err = limiterFromConfig(in.Serve.Common().Limits)
+	if err != nil {
+		return nil, errors.Wrap(err, "serve limits")
+	}
+
+	if in.Pruning != nil {
+		m.promPruneSecs = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   "zrepl",
+			Subsystem:   "pruning",
+			Name:        "time",
+			Help:        "seconds spent in pruner",
+			ConstLabels: prometheus.Labels{"zrepl_job": jobID.String()},
+		}, []string{"prune_side"})
+		m.prunerFactory, err = pruner.NewLocalPrunerFactory(in.Pruning.PruningLocal, m.promPruneSecs)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot build sink pruning rules")
+		}
+		m.pruneInterval = in.Pruning.Interval
+	}
 
 	return m, nil
 }
 
+// Adaptor that implements pruner.History for the filesystems
+// that a sink job received from a single client.
+//
+// The ReplicationCursor method returns the snapshot held by the
+// job's last-received-hold, or, if the filesystem has no such hold
+// (e.g. with replication guarantee kind `none`), the filesystem's
+// most recent version.
+// In combination with pruner.LocalPrunerFactory.BuildSinkPruner,
+// this ensures that sink-side pruning never destroys the snapshot
+// that future incremental replications depend on.
+type lastReceivedHoldHistory struct {
+	// the Target passed as Target to BuildSinkPruner
+	target     pruner.Target
+	rootFS     *zfs.DatasetPath
+	clientRoot *zfs.DatasetPath // relative to rootFS
+	jobID      endpoint.JobID
+	// endpoint.GetMostRecentLastReceivedHoldOfJob, replaceable for tests
+	getHold func(ctx context.Context, fs string, jobID endpoint.JobID) (*zfs.FilesystemVersion, error)
+}
+
+var _ pruner.History = lastReceivedHoldHistory{}
+
+func (h lastReceivedHoldHistory) ReplicationCursor(ctx context.Context, req *pdu.ReplicationCursorReq) (*pdu.ReplicationCursorRes, error) {
+	fs, err := zfs.NewDatasetPath(req.GetFilesystem())
+	if err != nil {
+		return nil, err
+	}
+	lp := h.rootFS.Copy()
+	lp.Extend(h.clientRoot)
+	lp.Extend(fs)
+	held, err := h.getHold(ctx, lp.ToString(), h.jobID)
+	if err != nil {
+		return nil, err
+	}
+	if held != nil {
+		return &pdu.ReplicationCursorRes{Result: &pdu.ReplicationCursorRes_Guid{Guid: held.Guid}}, nil
+	}
+	return alwaysUpToDateReplicationCursorHistory{h.target}.ReplicationCursor(ctx, req)
+}
+
+func (h lastReceivedHoldHistory) ListFilesystems(ctx context.Context, req *pdu.ListFilesystemReq) (*pdu.ListFilesystemRes, error) {
+	return h.target.ListFilesystems(ctx, req)
+}
+
 type modeSource struct {
 	senderConfig *endpoint.SenderConfig
 	snapper      *snapper.PeriodicOrManual
@@ -68,6 +257,10 @@ func modeSourceFromConfig(g *config.Global, in *config.SourceJob, jobID endpoint
 	if err != nil {
 		return nil, errors.Wrap(err, "send options")
 	}
+	m.senderConfig.Limiter, err = limiterFromConfig(in.Serve.Common().Limits)
+	if err != nil {
+		return nil, errors.Wrap(err, "serve limits")
+	}
 
 	if m.snapper, err = snapper.FromConfig(g, m.senderConfig.FSF, in.Snapshotting); err != nil {
 		return nil, errors.Wrap(err, "cannot build snapper")
@@ -90,6 +283,45 @@ func (m *modeSource) SnapperReport() *snapper.Report {
 	return m.snapper.Report()
 }
 
+func (m *modeSource) PrunerReports() map[string]*pruner.Report { return nil }
+
+func (m *modeSource) RegisterMetrics(registerer prometheus.Registerer) {}
+
+// limiterFromConfig returns nil if in does not limit any client.
+func limiterFromConfig(in *config.ServeLimits) (*admission.Limiter, error) {
+	if in == nil {
+		return nil, nil
+	}
+	validate := func(l *config.ServeClientLimits) error {
+		if l != nil && l.MaxConcurrentTransfers < 0 {
+			return errors.Errorf("max_concurrent_transfers must not be negative, got %d", l.MaxConcurrentTransfers)
+		}
+		return nil
+	}
+	var defaultLimit int
+	if in.Default != nil {
+		if err := validate(in.Default); err != nil {
+			return nil, errors.Wrap(err, "default")
+		}
+		defaultLimit = in.Default.MaxConcurrentTransfers
+	}
+	limited := defaultLimit > 0
+	clientLimits := make(map[string]int, len(in.Clients))
+	for client, l := range in.Clients {
+		if err := validate(l); err != nil {
+			return nil, errors.Wrapf(err, "client %q", client)
+		}
+		if l != nil {
+			clientLimits[client] = l.MaxConcurrentTransfers
+			limited = limited || l.MaxConcurrentTransfers > 0
+		}
+	}
+	if !limited {
+		return nil, nil
+	}
+	return admission.NewLimiter("concurrent transfers", defaultLimit, clientLimits, in.RetryAfter), nil
+}
+
 func passiveSideFromConfig(g *config.Global, in *config.PassiveJob, configJob interface{}) (s *PassiveSide, err error) {
 
 	s = &PassiveSide{}
@@ -120,12 +352,29 @@ func (j *PassiveSide) Name() string { return j.name.String() }
 
 type PassiveStatus struct {
 	Snapper *snapper.Report
+	Pruning map[string]*pruner.Report // by client identity, nil if the job does not prune
+	// by client identity, the capabilities negotiated on the client's most recent connection
+	ClientCapabilities map[string][]string
+}
+
+// RunningInvocationSince reports the sink-side pruning invocations of sink jobs.
+func (s *PassiveSide) RunningInvocationSince() (time.Time, bool) {
+	if m, ok := s.mode.(*modeSink); ok {
+		return m.invocations.runningSince()
+	}
+	return time.Time{}, false
 }
 
 func (s *PassiveSide) Status() *Status {
 	st := &PassiveStatus{
 		Snapper: s.mode.SnapperReport(),
+		Pruning: s.mode.PrunerReports(),
 	}
+	s.serverMtx.Lock()
+	if s.server != nil {
+		st.ClientCapabilities = s.server.ClientCapabilities()
+	}
+	s.serverMtx.Unlock()
 	return &Status{Type: s.mode.Type(), JobSpecific: st}
 }
 
@@ -147,7 +396,9 @@ func (j *PassiveSide) SenderConfig() *endpoint.SenderConfig {
 	return source.senderConfig
 }
 
-func (*PassiveSide) RegisterMetrics(registerer prometheus.Registerer) {}
+func (j *PassiveSide) RegisterMetrics(registerer prometheus.Registerer) {
+	j.mode.RegisterMetrics(registerer)
+}
 
 func (j *PassiveSide) Run(ctx context.Context) {
 	ctx, endTask := trace.WithTaskAndSpan(ctx, "passive-side-job", j.Name())
@@ -179,6 +430,9 @@ func (j *PassiveSide) Run(ctx context.Context) {
 
 	rpcLoggers := rpc.GetLoggersOrPanic(ctx) // WithSubsystemLoggers above
 	server := rpc.NewServer(handler, rpcLoggers, ctxInterceptor)
+	j.serverMtx.Lock()
+	j.server = server
+	j.serverMtx.Unlock()
 
 	listener, err := j.listen()
 	if err != nil {
@@ -0,0 +1,110 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/endpoint"
+	"github.com/zrepl/zrepl/replication/logic/pdu"
+	"github.com/zrepl/zrepl/zfs"
+)
+
+// versionsTarget implements the parts of pruner.Target used by lastReceivedHoldHistory
+type versionsTarget struct {
+	versions []*pdu.FilesystemVersion
+}
+
+func (t versionsTarget) ListFilesystems(ctx context.Context, req *pdu.ListFilesystemReq) (*pdu.ListFilesystemRes, error) {
+	return &pdu.ListFilesystemRes{}, nil
+}
+
+func (t versionsTarget) ListFilesystemVersions(ctx context.Context, req *pdu.ListFilesystemVersionsReq) (*pdu.ListFilesystemVersionsRes, error) {
+	return &pdu.ListFilesystemVersionsRes{Versions: t.versions}, nil
+}
+
+func (t versionsTarget) DestroySnapshots(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsRes, error) {
+	panic("not implemented")
+}
+
+func TestLastReceivedHoldHistory(t *testing.T) {
+	jobID, err := endpoint.MakeJobID("sinkjob")
+	require.NoError(t, err)
+	target := versionsTarget{[]*pdu.FilesystemVersion{
+		{Type: pdu.FilesystemVersion_Snapshot, Name: "b", Guid: 2, CreateTXG: 20},
+		{Type: pdu.FilesystemVersion_Snapshot, Name: "a", Guid: 1, CreateTXG: 10},
+	}}
+
+	var held *zfs.FilesystemVersion
+	var lookedUp []string
+	h := lastReceivedHoldHistory{
+		target:     target,
+		rootFS:     mustDatasetPath(t, "pool/sink"),
+		clientRoot: mustDatasetPath(t, "client"),
+		jobID:      jobID,
+		getHold: func(ctx context.Context, fs string, id endpoint.JobID) (*zfs.FilesystemVersion, error) {
+			assert.Equal(t, jobID, id)
+			lookedUp = append(lookedUp, fs)
+			return held, nil
+		},
+	}
+	req := &pdu.ReplicationCursorReq{Filesystem: "fs/child"}
+
+	// the held snapshot is the cursor, even if newer snapshots exist
+	held = &zfs.FilesystemVersion{Type: zfs.Snapshot, Name: "a", Guid: 1, CreateTXG: 10}
+	res, err := h.ReplicationCursor(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), res.GetGuid())
+
+	// without a hold, the most recent snapshot is the cursor
+	held = nil
+	res, err = h.ReplicationCursor(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), res.GetGuid())
+
+	assert.Equal(t, []string{"pool/sink/client/fs/child", "pool/sink/client/fs/child"}, lookedUp)
+}
+
+func mustDatasetPath(t *testing.T, s string) *zfs.DatasetPath {
+	p, err := zfs.NewDatasetPath(s)
+	require.NoError(t, err)
+	return p
+}
+
+func TestSinkPruningFromConfig(t *testing.T) {
+	tmpl := `
+jobs:
+- name: sinkjob
+  type: sink
+  root_fs: "pool/sink"
+  serve:
+    type: local
+    listener_name: sinkjob
+`
+	build := func(t *testing.T, pruning string) *modeSink {
+		conf, err := config.ParseConfigBytes([]byte(tmpl + pruning))
+		require.NoError(t, err)
+		jobs, err := JobsFromConfig(conf)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		return jobs[0].(*PassiveSide).mode.(*modeSink)
+	}
+
+	m := build(t, "")
+	assert.Nil(t, m.prunerFactory)
+	assert.Nil(t, m.PrunerReports())
+
+	m = build(t, `
+  pruning:
+    interval: 30m
+    keep:
+    - type: last_n
+      count: 10
+`)
+	assert.NotNil(t, m.prunerFactory)
+	assert.Equal(t, 30*time.Minute, m.pruneInterval)
+}
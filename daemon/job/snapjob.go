@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -29,6 +30,8 @@ type SnapJob struct {
 	promPruneSecs *prometheus.HistogramVec // labels: prune_side
 
 	pruner *pruner.Pruner
+
+	invocations invocationTracker
 }
 
 func (j *SnapJob) Name() string { return j.name.String() }
@@ -83,6 +86,10 @@ func (j *SnapJob) Status() *Status {
 	return &Status{Type: t, JobSpecific: s}
 }
 
+func (j *SnapJob) RunningInvocationSince() (time.Time, bool) {
+	return j.invocations.runningSince()
+}
+
 func (j *SnapJob) OwnedDatasetSubtreeRoot() (rfs *zfs.DatasetPath, ok bool) {
 	return nil, false
 }
@@ -117,8 +124,10 @@ outer:
 		}
 		invocationCount++
 
-		invocationCtx, endSpan := trace.WithSpan(ctx, fmt.Sprintf("invocation-%d", invocationCount))
+		invocationCtx, endSpan := trace.WithSpan(trace.WithNewTrace(ctx), fmt.Sprintf("invocation-%d", invocationCount))
+		endInvocation := j.invocations.begin()
 		j.doPrune(invocationCtx)
+		endInvocation()
 		endSpan()
 	}
 }
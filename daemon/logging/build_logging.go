@@ -6,6 +6,7 @@ import (
 	"crypto/x509"
 	"log/syslog"
 	"os"
+	"path/filepath"
 
 	"github.com/mattn/go-isatty"
 	"github.com/pkg/errors"
@@ -15,34 +16,59 @@ import (
 	"github.com/zrepl/zrepl/tlsconf"
 )
 
-func OutletsFromConfig(in config.LoggingOutletEnumList) (*logger.Outlets, error) {
+// OutletsFromConfig builds the outlets configured in `in`.
+// The level overrides in runtime apply to all of them, runtime may be nil.
+func OutletsFromConfig(in config.LoggingOutletEnumList, runtime *RuntimeLevels) (*logger.Outlets, error) {
+	return outletsFromConfig(in, runtime, true)
+}
+
+// CheckOutletsFromConfig validates the outlets configured in `in` like OutletsFromConfig,
+// but without side effects on the filesystem, i.e., log files are not opened or created.
+// It is meant for zrepl configcheck.
+func CheckOutletsFromConfig(in config.LoggingOutletEnumList) (*logger.Outlets, error) {
+	return outletsFromConfig(in, nil, false)
+}
+
+func outletsFromConfig(in config.LoggingOutletEnumList, runtime *RuntimeLevels, openFiles bool) (*logger.Outlets, error) {
 
 	outlets := logger.NewOutlets()
 
 	if len(in) == 0 {
 		// Default config
 		out := WriterOutlet{&HumanFormatter{}, os.Stdout}
-		outlets.Add(out, logger.Warn)
+		addOutletWithOverrides(outlets, out, logger.Warn, nil, runtime)
 		return outlets, nil
 	}
 
-	var syslogOutlets, stdoutOutlets int
+	var syslogOutlets, stdoutOutlets, journaldOutlets int
+	filePaths := make(map[string]bool)
 	for lei, le := range in {
 
-		outlet, minLevel, err := ParseOutlet(le)
+		outlet, minLevel, err := parseOutlet(le, openFiles)
 		if err != nil {
 			return nil, errors.Wrapf(err, "cannot parse outlet #%d", lei)
 		}
 		var _ logger.Outlet = WriterOutlet{}
 		var _ logger.Outlet = &SyslogOutlet{}
-		switch outlet.(type) {
+		switch outlet := outlet.(type) {
 		case *SyslogOutlet:
 			syslogOutlets++
 		case WriterOutlet:
 			stdoutOutlets++
+		case *JournaldOutlet:
+			journaldOutlets++
+		case *FileOutlet:
+			if filePaths[outlet.path] {
+				return nil, errors.Errorf("can only define one 'file' outlet per path: %q", outlet.path)
+			}
+			filePaths[outlet.path] = true
 		}
 
-		outlets.Add(outlet, minLevel)
+		overrides, err := parseLevelOverrides(le)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse outlet #%d", lei)
+		}
+		addOutletWithOverrides(outlets, outlet, minLevel, overrides, runtime)
 
 	}
 
@@ -52,6 +78,9 @@ func OutletsFromConfig(in config.LoggingOutletEnumList) (*logger.Outlets, error)
 	if stdoutOutlets > 1 {
 		return nil, errors.Errorf("can only define one 'stdout' outlet")
 	}
+	if journaldOutlets > 1 {
+		return nil, errors.Errorf("can only define one 'journald' outlet")
+	}
 
 	return outlets, nil
 
@@ -194,7 +223,50 @@ func parseLogFormat(i interface{}) (f EntryFormatter, err error) {
 
 }
 
+func parseLevelOverrides(in config.LoggingOutletEnum) ([]LevelOverride, error) {
+	var common config.LoggingOutletCommon
+	switch v := in.Ret.(type) {
+	case *config.StdoutLoggingOutlet:
+		common = v.LoggingOutletCommon
+	case *config.TCPLoggingOutlet:
+		common = v.LoggingOutletCommon
+	case *config.SyslogLoggingOutlet:
+		common = v.LoggingOutletCommon
+	case *config.JournaldLoggingOutlet:
+		common = v.LoggingOutletCommon
+	case *config.FileLoggingOutlet:
+		common = v.LoggingOutletCommon
+	default:
+		panic(v)
+	}
+	overrides := make([]LevelOverride, len(common.Overrides))
+	for i, in := range common.Overrides {
+		o := &overrides[i]
+		o.Job = in.Job
+		if in.Subsystem != "" {
+			var err error
+			if o.Subsystem, err = ParseSubsystem(in.Subsystem); err != nil {
+				return nil, errors.Wrapf(err, "override #%d", i)
+			}
+		}
+		var err error
+		if o.Level, err = logger.ParseLevel(in.Level); err != nil {
+			return nil, errors.Wrapf(err, "override #%d: cannot parse 'level' field", i)
+		}
+		for _, prev := range overrides[:i] {
+			if prev.sameKey(o) {
+				return nil, errors.Errorf("override #%d: duplicate override for job %q and subsystem %q", i, o.Job, o.Subsystem)
+			}
+		}
+	}
+	return overrides, nil
+}
+
 func ParseOutlet(in config.LoggingOutletEnum) (o logger.Outlet, level logger.Level, err error) {
+	return parseOutlet(in, true)
+}
+
+func parseOutlet(in config.LoggingOutletEnum, openFiles bool) (o logger.Outlet, level logger.Level, err error) {
 
 	parseCommon := func(common config.LoggingOutletCommon) (logger.Level, EntryFormatter, error) {
 		if common.Level == "" || common.Format == "" {
@@ -233,6 +305,18 @@ func ParseOutlet(in config.LoggingOutletEnum) (o logger.Outlet, level logger.Lev
 			break
 		}
 		o, err = parseSyslogOutlet(v, f)
+	case *config.JournaldLoggingOutlet:
+		level, f, err = parseCommon(v.LoggingOutletCommon)
+		if err != nil {
+			break
+		}
+		o, err = parseJournaldOutlet(v, f)
+	case *config.FileLoggingOutlet:
+		level, f, err = parseCommon(v.LoggingOutletCommon)
+		if err != nil {
+			break
+		}
+		o, err = parseFileOutlet(v, f, openFiles)
 	default:
 		panic(v)
 	}
@@ -256,6 +340,41 @@ func parseStdoutOutlet(in *config.StdoutLoggingOutlet, formatter EntryFormatter)
 	}, nil
 }
 
+// NewStdoutOutlet returns an outlet that writes entries to stdout
+// in format (human, logfmt or json), like a stdout outlet in the config would.
+func NewStdoutOutlet(format string, time, color bool) (WriterOutlet, error) {
+	f, err := parseLogFormat(format)
+	if err != nil {
+		return WriterOutlet{}, err
+	}
+	return parseStdoutOutlet(&config.StdoutLoggingOutlet{Time: time, Color: color}, f)
+}
+
+func parseJournaldOutlet(in *config.JournaldLoggingOutlet, formatter EntryFormatter) (*JournaldOutlet, error) {
+	// the journal records time and priority itself
+	formatter.SetMetadataFlags(MetadataNone)
+	return &JournaldOutlet{
+		Formatter:     formatter,
+		Socket:        in.Socket,
+		RetryInterval: in.RetryInterval,
+	}, nil
+}
+
+func parseFileOutlet(in *config.FileLoggingOutlet, formatter EntryFormatter, open bool) (*FileOutlet, error) {
+	if !filepath.IsAbs(in.Path) {
+		return nil, errors.Errorf("path must be absolute: %q", in.Path)
+	}
+	flags := MetadataAll &^ MetadataColor
+	if !in.Time {
+		flags &= ^MetadataTime
+	}
+	formatter.SetMetadataFlags(flags)
+	if !open {
+		return newFileOutlet(formatter, in.Path, in.MaxSize, in.MaxAge, in.MaxBackups, in.Compress)
+	}
+	return NewFileOutlet(formatter, in.Path, in.MaxSize, in.MaxAge, in.MaxBackups, in.Compress)
+}
+
 func parseTCPOutlet(in *config.TCPLoggingOutlet, formatter EntryFormatter) (out *TCPOutlet, err error) {
 	var tlsConfig *tls.Config
 	if in.TLS != nil {
@@ -0,0 +1,283 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zrepl/zrepl/logger"
+)
+
+// FileOutlet writes entries to a file and rotates it based on size and age.
+//
+// Rotated files are renamed to PATH.TIMESTAMP, optionally gzipped to PATH.TIMESTAMP.gz,
+// and the oldest rotated files beyond maxBackups are removed.
+// Compression and removal happen asynchronously to not block the logger;
+// their errors are returned from the next call to WriteEntry.
+//
+// Reopen supports external rotation tools such as logrotate (see ReopenFileOutlets).
+type FileOutlet struct {
+	formatter  EntryFormatter
+	path       string
+	maxSize    int64         // 0 disables size-based rotation
+	maxAge     time.Duration // 0 disables age-based rotation
+	maxBackups int           // 0 keeps all rotated files
+	compress   bool
+
+	mtx      sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	maintenance    sync.WaitGroup
+	maintenanceMtx sync.Mutex // serializes maintain
+	maintenanceErr error      // protected by mtx
+}
+
+var _ logger.Outlet = (*FileOutlet)(nil)
+
+// rotated files are named PATH.fileOutletRotationTimeFormat, which sorts chronologically
+const fileOutletRotationTimeFormat = "20060102T150405.000000000"
+
+func NewFileOutlet(formatter EntryFormatter, path string, maxSize int64, maxAge time.Duration, maxBackups int, compress bool) (*FileOutlet, error) {
+	o, err := newFileOutlet(formatter, path, maxSize, maxAge, maxBackups, compress)
+	if err != nil {
+		return nil, err
+	}
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	if err := o.openLocked(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// newFileOutlet validates the arguments but does not open the file,
+// which happens on the first WriteEntry.
+func newFileOutlet(formatter EntryFormatter, path string, maxSize int64, maxAge time.Duration, maxBackups int, compress bool) (*FileOutlet, error) {
+	if maxSize < 0 {
+		return nil, errors.New("max_size must not be negative")
+	}
+	if maxBackups < 0 {
+		return nil, errors.New("max_backups must not be negative")
+	}
+	o := &FileOutlet{
+		formatter:  formatter,
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+		compress:   compress,
+	}
+	return o, nil
+}
+
+func (o *FileOutlet) String() string { return "file:" + o.path }
+
+func (o *FileOutlet) openLocked() error {
+	f, err := os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return errors.Wrap(err, "cannot open log file")
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "cannot stat log file")
+	}
+	o.file = f
+	o.size = fi.Size()
+	o.openedAt = time.Now()
+	return nil
+}
+
+func (o *FileOutlet) WriteEntry(entry logger.Entry) error {
+	line, err := o.formatter.Format(&entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	if o.file == nil {
+		// a previous rotation or Reopen failed
+		if err := o.openLocked(); err != nil {
+			return err
+		}
+	}
+
+	needsRotation := o.size > 0 &&
+		((o.maxSize > 0 && o.size+int64(len(line)) > o.maxSize) ||
+			(o.maxAge > 0 && entry.Time.Sub(o.openedAt) >= o.maxAge))
+	if needsRotation {
+		if err := o.rotateLocked(entry.Time); err != nil {
+			return err
+		}
+	}
+
+	n, err := o.file.Write(line)
+	o.size += int64(n)
+	if err != nil {
+		return err
+	}
+	// report errors of asynchronous maintenance, see FileOutlet
+	maintenanceErr := o.maintenanceErr
+	o.maintenanceErr = nil
+	return maintenanceErr
+}
+
+func (o *FileOutlet) rotateLocked(now time.Time) error {
+	if err := o.file.Close(); err != nil {
+		o.file = nil
+		return errors.Wrap(err, "cannot close log file for rotation")
+	}
+	o.file = nil
+	rotated := o.path + "." + now.UTC().Format(fileOutletRotationTimeFormat)
+	if err := os.Rename(o.path, rotated); err != nil {
+		return errors.Wrap(err, "cannot rename log file for rotation")
+	}
+	if err := o.openLocked(); err != nil {
+		return err
+	}
+	o.maintenance.Add(1)
+	go o.maintain(rotated)
+	return nil
+}
+
+// Reopen closes and reopens the file, e.g., after it has been moved by an external rotation tool.
+func (o *FileOutlet) Reopen() error {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	if o.file != nil {
+		if err := o.file.Close(); err != nil {
+			o.file = nil
+			return errors.Wrap(err, "cannot close log file")
+		}
+		o.file = nil
+	}
+	return o.openLocked()
+}
+
+// maintain compresses the rotated file and removes old rotated files.
+func (o *FileOutlet) maintain(rotated string) {
+	defer o.maintenance.Done()
+	o.maintenanceMtx.Lock()
+	defer o.maintenanceMtx.Unlock()
+
+	err := func() error {
+		if o.compress {
+			if err := gzipFile(rotated); err != nil {
+				return errors.Wrapf(err, "cannot compress rotated log file %q", rotated)
+			}
+		}
+		return o.removeOldBackups()
+	}()
+	if err != nil {
+		o.mtx.Lock()
+		o.maintenanceErr = err
+		o.mtx.Unlock()
+	}
+}
+
+// backups returns the rotated files, oldest first
+func (o *FileOutlet) backups() ([]string, error) {
+	dir, base := filepath.Split(o.path)
+	if dir == "" {
+		dir = "."
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		ts := strings.TrimSuffix(strings.TrimPrefix(name, base+"."), ".gz")
+		if _, err := time.Parse(fileOutletRotationTimeFormat, ts); err != nil {
+			continue // not created by us
+		}
+		backups = append(backups, filepath.Join(dir, name))
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+func (o *FileOutlet) removeOldBackups() error {
+	if o.maxBackups == 0 {
+		return nil
+	}
+	backups, err := o.backups()
+	if err != nil {
+		return errors.Wrap(err, "cannot list rotated log files")
+	}
+	for len(backups) > o.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return errors.Wrap(err, "cannot remove rotated log file")
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// gzipFile replaces path with path.gz
+func gzipFile(path string) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(out.Name())
+		}
+	}()
+	gz := gzip.NewWriter(out)
+	if _, err = io.Copy(gz, in); err != nil {
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// ReopenFileOutlets calls Reopen on all FileOutlets in outlets and returns the first error.
+func ReopenFileOutlets(outlets *logger.Outlets) error {
+	var firstErr error
+	// every outlet is registered for level Error, exactly once, see logger.Outlets.Add
+	for _, o := range outlets.Get(logger.Error) {
+		if fo, ok := unwrapOutlet(o).(*FileOutlet); ok {
+			if err := fo.Reopen(); err != nil && firstErr == nil {
+				firstErr = errors.Wrapf(err, "reopen %s", fo)
+			}
+		}
+	}
+	return firstErr
+}
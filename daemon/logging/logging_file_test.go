@@ -0,0 +1,137 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/logger"
+)
+
+func fileOutletTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "zrepl-logging-file-test")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func readFile(t *testing.T, path string) string {
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func readGzipFile(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func fileTestEntry(msg string, t time.Time) logger.Entry {
+	return logger.Entry{Level: logger.Info, Message: msg, Time: t}
+}
+
+func TestFileOutletRotatesBySize(t *testing.T) {
+	path := filepath.Join(fileOutletTestDir(t), "zrepl.log")
+	// each entry is 10 bytes including the newline, see NoFormatter
+	o, err := NewFileOutlet(NoFormatter{}, path, 25, 0, 2, true)
+	require.NoError(t, err)
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		msg := strings.Repeat(string(rune('a'+i)), 9)
+		require.NoError(t, o.WriteEntry(fileTestEntry(msg, now.Add(time.Duration(i)*time.Millisecond))))
+	}
+	o.maintenance.Wait()
+
+	assert.Equal(t, "iiiiiiiii\njjjjjjjjj\n", readFile(t, path))
+	backups, err := o.backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	for _, b := range backups {
+		assert.True(t, strings.HasSuffix(b, ".gz"), b)
+	}
+	assert.Equal(t, "eeeeeeeee\nfffffffff\n", readGzipFile(t, backups[0]))
+	assert.Equal(t, "ggggggggg\nhhhhhhhhh\n", readGzipFile(t, backups[1]))
+}
+
+func TestFileOutletRotatesByAge(t *testing.T) {
+	path := filepath.Join(fileOutletTestDir(t), "zrepl.log")
+	o, err := NewFileOutlet(NoFormatter{}, path, 0, time.Hour, 0, false)
+	require.NoError(t, err)
+
+	now := time.Now()
+	require.NoError(t, o.WriteEntry(fileTestEntry("first", now)))
+	require.NoError(t, o.WriteEntry(fileTestEntry("second", now.Add(time.Minute))))
+	require.NoError(t, o.WriteEntry(fileTestEntry("third", now.Add(time.Hour))))
+	o.maintenance.Wait()
+
+	assert.Equal(t, "third\n", readFile(t, path))
+	backups, err := o.backups()
+	require.NoError(t, err)
+	require.Len(t, backups, 1)
+	assert.Equal(t, "first\nsecond\n", readFile(t, backups[0]))
+}
+
+func TestFileOutletReopen(t *testing.T) {
+	dir := fileOutletTestDir(t)
+	path := filepath.Join(dir, "zrepl.log")
+	o, err := NewFileOutlet(NoFormatter{}, path, 0, 0, 0, false)
+	require.NoError(t, err)
+	outlets := logger.NewOutlets()
+	outlets.Add(o, logger.Info)
+
+	require.NoError(t, o.WriteEntry(fileTestEntry("before", time.Now())))
+	// what logrotate does without copytruncate
+	require.NoError(t, os.Rename(path, filepath.Join(dir, "zrepl.log.1")))
+	require.NoError(t, o.WriteEntry(fileTestEntry("moved", time.Now())))
+	require.NoError(t, ReopenFileOutlets(outlets))
+	require.NoError(t, o.WriteEntry(fileTestEntry("after", time.Now())))
+
+	assert.Equal(t, "before\nmoved\n", readFile(t, filepath.Join(dir, "zrepl.log.1")))
+	assert.Equal(t, "after\n", readFile(t, path))
+	backups, err := o.backups()
+	require.NoError(t, err)
+	assert.Empty(t, backups, "files rotated by external tools are not ours to remove")
+}
+
+func TestCheckOutletsFromConfigDoesNotCreateFiles(t *testing.T) {
+	dir := fileOutletTestDir(t)
+	path := filepath.Join(dir, "zrepl.log")
+	outlet := func(path string, maxBackups int) config.LoggingOutletEnumList {
+		return config.LoggingOutletEnumList{{Ret: &config.FileLoggingOutlet{
+			LoggingOutletCommon: config.LoggingOutletCommon{Level: "info", Format: "human"},
+			Path:                path,
+			MaxBackups:          maxBackups,
+		}}}
+	}
+
+	_, err := CheckOutletsFromConfig(outlet(path, 0))
+	require.NoError(t, err)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "configcheck must not create the log file")
+
+	_, err = CheckOutletsFromConfig(outlet("relative.log", 0))
+	assert.Error(t, err)
+	_, err = CheckOutletsFromConfig(outlet(path, -1))
+	assert.Error(t, err)
+
+	// the daemon creates the file
+	_, err = OutletsFromConfig(outlet(path, 0), nil)
+	require.NoError(t, err)
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+}
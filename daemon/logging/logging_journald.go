@@ -0,0 +1,181 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zrepl/zrepl/logger"
+)
+
+// JournaldOutlet writes entries to the systemd journal using its native protocol
+// (see https://systemd.io/JOURNAL_NATIVE_PROTOCOL/), without depending on libsystemd.
+//
+// In contrast to SyslogOutlet, each logger field becomes a separate journal field,
+// named by journaldFieldName, e.g., field `job` becomes `ZREPL_JOB`.
+// MESSAGE is the entry formatted by Formatter.
+type JournaldOutlet struct {
+	Formatter     EntryFormatter
+	Socket        string
+	RetryInterval time.Duration
+
+	mtx                sync.Mutex
+	conn               *net.UnixConn
+	lastConnectAttempt time.Time
+}
+
+var _ logger.Outlet = (*JournaldOutlet)(nil)
+
+func (o *JournaldOutlet) String() string { return "journald:" + o.Socket }
+
+// journaldFieldName maps a logger field name to a journal field name.
+// Journal field names consist of uppercase letters, digits and underscores,
+// and must not start with an underscore (those are reserved for trusted fields).
+func journaldFieldName(field string) string {
+	var b strings.Builder
+	b.WriteString("ZREPL_")
+	for _, r := range strings.ToUpper(field) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+func journaldPriority(l logger.Level) int {
+	switch l {
+	case logger.Debug:
+		return 7
+	case logger.Info:
+		return 6
+	case logger.Warn:
+		return 4
+	default:
+		return 3 // errors and unknown levels
+	}
+}
+
+func journaldAppendField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+	} else {
+		// values with newlines are length-prefixed
+		buf.WriteByte('\n')
+		_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+		buf.WriteString(value)
+	}
+	buf.WriteByte('\n')
+}
+
+func (o *JournaldOutlet) encode(e *logger.Entry) ([]byte, error) {
+	msg, err := o.Formatter.Format(e)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	journaldAppendField(&buf, "MESSAGE", string(msg))
+	journaldAppendField(&buf, "PRIORITY", fmt.Sprint(journaldPriority(e.Level)))
+	journaldAppendField(&buf, "SYSLOG_IDENTIFIER", "zrepl")
+
+	fields := make([]string, 0, len(e.Fields))
+	for field := range e.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		journaldAppendField(&buf, journaldFieldName(field), fmt.Sprint(e.Fields[field]))
+	}
+	return buf.Bytes(), nil
+}
+
+func (o *JournaldOutlet) WriteEntry(entry logger.Entry) error {
+	datagram, err := o.encode(&entry)
+	if err != nil {
+		return err
+	}
+
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	if o.conn == nil {
+		now := time.Now()
+		if now.Sub(o.lastConnectAttempt) < o.RetryInterval {
+			return nil // not an error toward logger
+		}
+		o.lastConnectAttempt = now
+		o.conn, err = net.DialUnix("unixgram", nil, &net.UnixAddr{Name: o.Socket, Net: "unixgram"})
+		if err != nil {
+			o.conn = nil
+			return errors.Wrap(err, "cannot connect to journal socket")
+		}
+	}
+
+	_, err = o.conn.Write(datagram)
+	if isJournaldDatagramTooLarge(err) {
+		err = o.writeViaFile(datagram)
+	}
+	if err != nil {
+		o.conn.Close()
+		o.conn = nil
+		return errors.Wrap(err, "cannot write to journal socket")
+	}
+	return nil
+}
+
+func isJournaldDatagramTooLarge(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == syscall.EMSGSIZE || err == syscall.ENOBUFS
+}
+
+// writeViaFile passes datagram as a file descriptor to an unlinked file,
+// which the native protocol requires for entries exceeding the maximum datagram size.
+func (o *JournaldOutlet) writeViaFile(datagram []byte) error {
+	dir := "/dev/shm"
+	if _, err := os.Stat(dir); err != nil {
+		dir = os.TempDir()
+	}
+	f, err := ioutil.TempFile(dir, "zrepl-journal-")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return err
+	}
+	if _, err := f.Write(datagram); err != nil {
+		return err
+	}
+	// net.UnixConn.WriteMsgUnix refuses to send without an address on a connected socket
+	rc, err := o.conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sendErr error
+	err = rc.Write(func(fd uintptr) bool {
+		sendErr = syscall.Sendmsg(int(fd), nil, syscall.UnixRights(int(f.Fd())), nil, 0)
+		return sendErr != syscall.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+	return sendErr
+}
@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/logger"
+)
+
+// fakeJournal receives datagrams like the native journal socket
+type fakeJournal struct {
+	t    *testing.T
+	conn *net.UnixConn
+	path string
+}
+
+func newFakeJournal(t *testing.T) *fakeJournal {
+	dir, err := ioutil.TempDir("", "zrepl-journald-test")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &fakeJournal{t, conn, path}
+}
+
+// receive returns the fields of the next entry, reading it from the passed file descriptor if necessary
+func (j *fakeJournal) receive() map[string]string {
+	buf := make([]byte, 1<<16)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	require.NoError(j.t, j.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, oobn, _, _, err := j.conn.ReadMsgUnix(buf, oob)
+	require.NoError(j.t, err)
+	datagram := buf[:n]
+	if oobn > 0 {
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		require.NoError(j.t, err)
+		require.Len(j.t, msgs, 1)
+		fds, err := syscall.ParseUnixRights(&msgs[0])
+		require.NoError(j.t, err)
+		require.Len(j.t, fds, 1)
+		f := os.NewFile(uintptr(fds[0]), "journal-fd")
+		defer f.Close()
+		_, err = f.Seek(0, 0)
+		require.NoError(j.t, err)
+		datagram, err = ioutil.ReadAll(f)
+		require.NoError(j.t, err)
+	}
+	fields, err := parseJournaldDatagram(datagram)
+	require.NoError(j.t, err)
+	return fields
+}
+
+func parseJournaldDatagram(datagram []byte) (map[string]string, error) {
+	fields := make(map[string]string)
+	for len(datagram) > 0 {
+		nl := bytes.IndexByte(datagram, '\n')
+		if nl == -1 {
+			return nil, errors.New("missing newline")
+		}
+		line := datagram[:nl]
+		datagram = datagram[nl+1:]
+		if eq := bytes.IndexByte(line, '='); eq != -1 {
+			fields[string(line[:eq])] = string(line[eq+1:])
+			continue
+		}
+		if len(datagram) < 8 {
+			return nil, errors.New("missing length")
+		}
+		l := binary.LittleEndian.Uint64(datagram)
+		datagram = datagram[8:]
+		if uint64(len(datagram)) < l+1 || datagram[l] != '\n' {
+			return nil, errors.New("invalid length")
+		}
+		fields[string(line)] = string(datagram[:l])
+		datagram = datagram[l+1:]
+	}
+	return fields, nil
+}
+
+func TestJournaldOutlet(t *testing.T) {
+	j := newFakeJournal(t)
+	o := &JournaldOutlet{Formatter: NoFormatter{}, Socket: j.path, RetryInterval: time.Second}
+
+	require.NoError(t, o.WriteEntry(logger.Entry{
+		Level:   logger.Warn,
+		Message: "something happened",
+		Time:    time.Now(),
+		Fields: logger.Fields{
+			JobField:     "prod",
+			SubsysField:  SubsysReplication,
+			"fs":         "pool/data",
+			"err":        errors.New("line 1\nline 2"),
+			"duration_s": 2.5,
+		},
+	}))
+	assert.Equal(t, map[string]string{
+		"MESSAGE":           "something happened",
+		"PRIORITY":          "4",
+		"SYSLOG_IDENTIFIER": "zrepl",
+		"ZREPL_JOB":         "prod",
+		"ZREPL_SUBSYSTEM":   "repl",
+		"ZREPL_FS":          "pool/data",
+		"ZREPL_ERR":         "line 1\nline 2",
+		"ZREPL_DURATION_S":  "2.5",
+	}, j.receive())
+
+	// exceeds the maximum datagram size and must be passed as a file descriptor
+	large := strings.Repeat("x", 4<<20)
+	require.NoError(t, o.WriteEntry(logger.Entry{Level: logger.Debug, Message: large, Time: time.Now()}))
+	fields := j.receive()
+	assert.Equal(t, "7", fields["PRIORITY"])
+	assert.Equal(t, large, fields["MESSAGE"])
+}
+
+func TestJournaldOutletRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-journald-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	o := &JournaldOutlet{Formatter: NoFormatter{}, Socket: filepath.Join(dir, "nonexistent"), RetryInterval: time.Hour}
+	e := logger.Entry{Level: logger.Info, Message: "msg", Time: time.Now()}
+	assert.Error(t, o.WriteEntry(e))
+	assert.NoError(t, o.WriteEntry(e), "must not retry before RetryInterval")
+}
+
+func TestJournaldFieldName(t *testing.T) {
+	assert.Equal(t, "ZREPL_JOB", journaldFieldName("job"))
+	assert.Equal(t, "ZREPL_DURATION_S", journaldFieldName("duration_s"))
+	assert.Equal(t, "ZREPL_TRACE_ID", journaldFieldName("trace.id"))
+}
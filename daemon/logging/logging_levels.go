@@ -0,0 +1,201 @@
+package logging
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zrepl/zrepl/logger"
+)
+
+// LevelOverride changes the minimum level of an outlet for the entries of a job and / or subsystem.
+// Empty Job or Subsystem match all jobs or subsystems.
+type LevelOverride struct {
+	Job       string
+	Subsystem Subsystem
+	Level     logger.Level
+}
+
+func ParseSubsystem(s string) (Subsystem, error) {
+	for _, subsys := range AllSubsystems {
+		if string(subsys) == s {
+			return subsys, nil
+		}
+	}
+	return "", errors.Errorf("unknown subsystem %q", s)
+}
+
+func (o *LevelOverride) matches(e *logger.Entry) bool {
+	if o.Job != "" && fmt.Sprint(e.Fields[JobField]) != o.Job {
+		return false
+	}
+	if o.Subsystem != "" && fmt.Sprint(e.Fields[SubsysField]) != string(o.Subsystem) {
+		return false
+	}
+	return true
+}
+
+// overrides for a job and a subsystem take precedence over those for a job,
+// which take precedence over those for a subsystem
+func (o *LevelOverride) specificity() int {
+	s := 0
+	if o.Job != "" {
+		s += 2
+	}
+	if o.Subsystem != "" {
+		s += 1
+	}
+	return s
+}
+
+func (o *LevelOverride) sameKey(other *LevelOverride) bool {
+	return o.Job == other.Job && o.Subsystem == other.Subsystem
+}
+
+// lookupLevelOverride returns the level of the most specific override in overrides that matches e.
+func lookupLevelOverride(overrides []LevelOverride, e *logger.Entry) (level logger.Level, ok bool) {
+	best := -1
+	for i := range overrides {
+		if s := overrides[i].specificity(); s > best && overrides[i].matches(e) {
+			best = s
+			level = overrides[i].Level
+		}
+	}
+	return level, best != -1
+}
+
+// RuntimeLevelOverride is a LevelOverride that is in effect until Until.
+type RuntimeLevelOverride struct {
+	LevelOverride
+	Until time.Time
+}
+
+// RuntimeLevels holds the level overrides set at runtime through the control socket.
+// They apply to all outlets from the config and take precedence over the overrides in the config.
+// Overrides revert automatically once they expire.
+type RuntimeLevels struct {
+	mtx       sync.RWMutex
+	overrides []RuntimeLevelOverride
+}
+
+func NewRuntimeLevels() *RuntimeLevels { return &RuntimeLevels{} }
+
+// Set adds o, replacing an existing override for the same job and subsystem.
+func (r *RuntimeLevels) Set(o LevelOverride, until time.Time) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.pruneLocked(time.Now())
+	for i := range r.overrides {
+		if r.overrides[i].sameKey(&o) {
+			r.overrides[i] = RuntimeLevelOverride{o, until}
+			return
+		}
+	}
+	r.overrides = append(r.overrides, RuntimeLevelOverride{o, until})
+}
+
+// Reset removes all overrides.
+func (r *RuntimeLevels) Reset() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.overrides = nil
+}
+
+// Active returns the overrides that are in effect at now, sorted by expiry.
+func (r *RuntimeLevels) Active(now time.Time) []RuntimeLevelOverride {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.pruneLocked(now)
+	active := append([]RuntimeLevelOverride(nil), r.overrides...)
+	sort.SliceStable(active, func(i, j int) bool { return active[i].Until.Before(active[j].Until) })
+	return active
+}
+
+func (r *RuntimeLevels) pruneLocked(now time.Time) {
+	active := r.overrides[:0]
+	for _, o := range r.overrides {
+		if now.Before(o.Until) {
+			active = append(active, o)
+		}
+	}
+	r.overrides = active
+}
+
+func (r *RuntimeLevels) lookup(e *logger.Entry) (level logger.Level, ok bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	best := -1
+	for i := range r.overrides {
+		o := &r.overrides[i]
+		// expired overrides are pruned lazily, see Set and Active
+		if s := o.specificity(); s > best && e.Time.Before(o.Until) && o.matches(e) {
+			best = s
+			level = o.Level
+		}
+	}
+	return level, best != -1
+}
+
+// levelFilterOutlet applies the level overrides to the entries written to outlet.
+// It must be registered with logger.Outlets.Add at the lowest level any override may select.
+type levelFilterOutlet struct {
+	outlet    logger.Outlet
+	level     logger.Level
+	overrides []LevelOverride
+	runtime   *RuntimeLevels // may be nil
+}
+
+func (o *levelFilterOutlet) minLevel(e *logger.Entry) logger.Level {
+	if o.runtime != nil {
+		if l, ok := o.runtime.lookup(e); ok {
+			return l
+		}
+	}
+	if l, ok := lookupLevelOverride(o.overrides, e); ok {
+		return l
+	}
+	return o.level
+}
+
+func (o *levelFilterOutlet) WriteEntry(e logger.Entry) error {
+	if e.Level < o.minLevel(&e) {
+		return nil
+	}
+	return o.outlet.WriteEntry(e)
+}
+
+// String identifies the wrapped outlet in outlet errors, see logger.
+func (o *levelFilterOutlet) String() string {
+	if s, ok := o.outlet.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", o.outlet)
+}
+
+// addOutletWithOverrides adds outlet to outlets such that overrides and runtime (may be nil) apply to it.
+func addOutletWithOverrides(outlets *logger.Outlets, outlet logger.Outlet, level logger.Level, overrides []LevelOverride, runtime *RuntimeLevels) {
+	if runtime == nil && len(overrides) == 0 {
+		outlets.Add(outlet, level)
+		return
+	}
+	registerAt := level
+	if runtime != nil {
+		registerAt = logger.Debug // runtime overrides may select any level
+	}
+	for _, o := range overrides {
+		if o.Level < registerAt {
+			registerAt = o.Level
+		}
+	}
+	outlets.Add(&levelFilterOutlet{outlet, level, overrides, runtime}, registerAt)
+}
+
+func unwrapOutlet(o logger.Outlet) logger.Outlet {
+	if f, ok := o.(*levelFilterOutlet); ok {
+		return f.outlet
+	}
+	return o
+}
@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/logger"
+)
+
+type recordingOutlet struct {
+	entries []logger.Entry
+}
+
+func (o *recordingOutlet) WriteEntry(e logger.Entry) error {
+	o.entries = append(o.entries, e)
+	return nil
+}
+
+func levelTestEntry(level logger.Level, job string, subsys Subsystem, t time.Time) logger.Entry {
+	fields := logger.Fields{}
+	if job != "" {
+		fields[JobField] = job
+	}
+	if subsys != "" {
+		fields[SubsysField] = subsys
+	}
+	return logger.Entry{Level: level, Message: "msg", Time: t, Fields: fields}
+}
+
+func TestLevelFilterOutlet(t *testing.T) {
+	now := time.Now()
+	outlet := &levelFilterOutlet{
+		level: logger.Warn,
+		overrides: []LevelOverride{
+			{Job: "prod", Level: logger.Debug},
+			{Subsystem: SubsysRPC, Level: logger.Error},
+			{Job: "prod", Subsystem: SubsysRPC, Level: logger.Info},
+		},
+	}
+
+	tcs := []struct {
+		job    string
+		subsys Subsystem
+		min    logger.Level
+	}{
+		{"", "", logger.Warn},
+		{"other", SubsysReplication, logger.Warn},
+		{"prod", SubsysReplication, logger.Debug},
+		{"other", SubsysRPC, logger.Error},
+		{"prod", SubsysRPC, logger.Info}, // job + subsystem is most specific
+	}
+	for _, tc := range tcs {
+		e := levelTestEntry(logger.Debug, tc.job, tc.subsys, now)
+		assert.Equal(t, tc.min, outlet.minLevel(&e), "job=%q subsystem=%q", tc.job, tc.subsys)
+	}
+
+	// runtime overrides take precedence, even if less specific
+	outlet.runtime = NewRuntimeLevels()
+	outlet.runtime.Set(LevelOverride{Subsystem: SubsysRPC, Level: logger.Debug}, now.Add(time.Minute))
+	e := levelTestEntry(logger.Debug, "prod", SubsysRPC, now)
+	assert.Equal(t, logger.Debug, outlet.minLevel(&e))
+	e.Time = now.Add(time.Minute)
+	assert.Equal(t, logger.Info, outlet.minLevel(&e), "runtime override must revert once expired")
+}
+
+func TestRuntimeLevels(t *testing.T) {
+	now := time.Now()
+	r := NewRuntimeLevels()
+	r.Set(LevelOverride{Job: "prod", Level: logger.Debug}, now.Add(time.Hour))
+	r.Set(LevelOverride{Subsystem: SubsysZFSCmd, Level: logger.Info}, now.Add(time.Minute))
+	r.Set(LevelOverride{Job: "prod", Level: logger.Info}, now.Add(2*time.Hour)) // replaces the first
+
+	active := r.Active(now)
+	require.Len(t, active, 2)
+	assert.Equal(t, SubsysZFSCmd, active[0].Subsystem)
+	assert.Equal(t, LevelOverride{Job: "prod", Level: logger.Info}, active[1].LevelOverride)
+
+	assert.Len(t, r.Active(now.Add(90*time.Minute)), 1)
+	assert.Len(t, r.Active(now.Add(3*time.Hour)), 0)
+
+	r.Set(LevelOverride{Level: logger.Debug}, time.Now().Add(time.Hour))
+	r.Reset()
+	assert.Empty(t, r.Active(now))
+}
+
+func TestAddOutletWithOverrides(t *testing.T) {
+	out := &recordingOutlet{}
+	outlets := logger.NewOutlets()
+	addOutletWithOverrides(outlets, out, logger.Warn, []LevelOverride{{Job: "prod", Level: logger.Info}}, nil)
+	log := logger.NewLogger(outlets, time.Second)
+
+	log.WithField(JobField, "prod").Debug("prod debug")
+	log.WithField(JobField, "prod").Info("prod info")
+	log.WithField(JobField, "other").Info("other info")
+	log.WithField(JobField, "other").Warn("other warn")
+	var msgs []string
+	for _, e := range out.entries {
+		msgs = append(msgs, e.Message)
+	}
+	assert.Equal(t, []string{"prod info", "other warn"}, msgs)
+
+	// unwrapped if there is nothing to override
+	outlets = logger.NewOutlets()
+	addOutletWithOverrides(outlets, out, logger.Warn, nil, nil)
+	assert.Equal(t, []logger.Outlet{out}, outlets.Get(logger.Error))
+	assert.Empty(t, outlets.Get(logger.Info))
+}
+
+func TestParseSubsystem(t *testing.T) {
+	s, err := ParseSubsystem("trace.data")
+	require.NoError(t, err)
+	assert.Equal(t, SubsysTraceData, s)
+	_, err = ParseSubsystem("nonexistent")
+	assert.Error(t, err)
+}
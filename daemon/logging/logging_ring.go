@@ -0,0 +1,199 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/util/circlog"
+	"github.com/zrepl/zrepl/util/envconst"
+)
+
+// RingOutlet keeps the most recent log entries in an in-memory ring buffer
+// and streams newly written entries to subscribers.
+// It backs the `zrepl logs` command.
+//
+// Entries are stored JSON-encoded, one per line, in a circlog.CircularLog,
+// i.e., the buffer size is bounded in bytes and the oldest entries are overwritten first.
+type RingOutlet struct {
+	mtx         sync.Mutex
+	buf         *circlog.CircularLog
+	subscribers map[*RingSubscription]struct{}
+}
+
+var _ logger.Outlet = (*RingOutlet)(nil)
+
+func NewRingOutlet(size int) (*RingOutlet, error) {
+	buf, err := circlog.NewCircularLog(size)
+	if err != nil {
+		return nil, err
+	}
+	return &RingOutlet{
+		buf:         buf,
+		subscribers: make(map[*RingSubscription]struct{}),
+	}, nil
+}
+
+// sanitizeEntry returns a copy of e whose fields can be JSON-encoded
+func sanitizeEntry(e logger.Entry) logger.Entry {
+	fields := make(logger.Fields, len(e.Fields))
+	for k, v := range e.Fields {
+		switch v := v.(type) {
+		case error:
+			fields[k] = v.Error()
+		case fmt.Stringer:
+			fields[k] = v.String()
+		default:
+			if _, err := json.Marshal(v); err != nil {
+				fields[k] = fmt.Sprintf("<%T>", v)
+			} else {
+				fields[k] = v
+			}
+		}
+	}
+	e.Fields = fields
+	return e
+}
+
+func (o *RingOutlet) WriteEntry(e logger.Entry) error {
+	e = sanitizeEntry(e)
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	if _, err := o.buf.Write(line); err != nil {
+		return err
+	}
+	for s := range o.subscribers {
+		s.deliver(e)
+	}
+	return nil
+}
+
+// Entries returns the entries in the buffer, oldest first.
+func (o *RingOutlet) Entries() []logger.Entry {
+	o.mtx.Lock()
+	buf, wrapped := o.snapshotLocked()
+	o.mtx.Unlock()
+	return parseRingBuffer(buf, wrapped)
+}
+
+func (o *RingOutlet) snapshotLocked() (buf []byte, wrapped bool) {
+	// copy because CircularLog.Bytes may alias its internal buffer
+	buf = append([]byte(nil), o.buf.Bytes()...)
+	return buf, o.buf.TotalWritten() > len(buf)
+}
+
+func parseRingBuffer(buf []byte, wrapped bool) []logger.Entry {
+	lines := bytes.Split(buf, []byte("\n"))
+	if wrapped && len(lines) > 0 {
+		// the oldest line was partially overwritten
+		lines = lines[1:]
+	}
+	entries := make([]logger.Entry, 0, len(lines))
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		var e logger.Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			continue // entry larger than the buffer, see circlog.CircularLog.Write
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+var ringSubscriptionQueueSize = envconst.Int("ZREPL_LOGGING_RING_SUBSCRIPTION_QUEUE_SIZE", 1<<12)
+
+// RingSubscription receives the entries written to a RingOutlet after Subscribe.
+type RingSubscription struct {
+	outlet  *RingOutlet
+	entries chan logger.Entry
+	dropped uint64 // atomic
+}
+
+// Subscribe returns the entries currently in the buffer and a subscription
+// that receives all entries written afterwards, without gaps or duplicates.
+// The subscription must be closed by the caller.
+func (o *RingOutlet) Subscribe() ([]logger.Entry, *RingSubscription) {
+	s := &RingSubscription{
+		outlet:  o,
+		entries: make(chan logger.Entry, ringSubscriptionQueueSize),
+	}
+	o.mtx.Lock()
+	buf, wrapped := o.snapshotLocked()
+	o.subscribers[s] = struct{}{}
+	o.mtx.Unlock()
+	return parseRingBuffer(buf, wrapped), s
+}
+
+// must not block, see logger.Outlet
+func (s *RingSubscription) deliver(e logger.Entry) {
+	select {
+	case s.entries <- e:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+func (s *RingSubscription) Entries() <-chan logger.Entry { return s.entries }
+
+// TakeDropped returns the number of entries that were dropped because the subscriber
+// did not keep up since the last call to TakeDropped.
+func (s *RingSubscription) TakeDropped() uint64 {
+	return atomic.SwapUint64(&s.dropped, 0)
+}
+
+func (s *RingSubscription) Close() {
+	s.outlet.mtx.Lock()
+	defer s.outlet.mtx.Unlock()
+	delete(s.outlet.subscribers, s)
+}
+
+// EntryFilter selects log entries by their level, time and the fields
+// set by this package (see JobField, SubsysField and SpanField).
+// The zero value matches all entries.
+type EntryFilter struct {
+	Job       string // exact match, empty matches all
+	Subsystem string // exact match, empty matches all
+	// entries whose span stack contains Span, i.e., the span's and its descendants' entries
+	Span     string
+	MinLevel logger.Level
+	Since    time.Time // zero matches all
+}
+
+func (f *EntryFilter) Matches(e *logger.Entry) bool {
+	if e.Level < f.MinLevel {
+		return false
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	field := func(name string) string {
+		v, ok := e.Fields[name]
+		if !ok {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+	if f.Job != "" && field(JobField) != f.Job {
+		return false
+	}
+	if f.Subsystem != "" && field(SubsysField) != f.Subsystem {
+		return false
+	}
+	if f.Span != "" && !strings.Contains(field(SpanField), f.Span) {
+		return false
+	}
+	return true
+}
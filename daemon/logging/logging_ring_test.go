@@ -0,0 +1,130 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/logger"
+)
+
+func ringTestEntry(i int, fields logger.Fields) logger.Entry {
+	return logger.Entry{
+		Level:   logger.Info,
+		Message: fmt.Sprintf("message %d", i),
+		Time:    time.Date(2020, 1, 1, 0, 0, i, 0, time.UTC),
+		Fields:  fields,
+	}
+}
+
+func messages(entries []logger.Entry) (msgs []string) {
+	for _, e := range entries {
+		msgs = append(msgs, e.Message)
+	}
+	return msgs
+}
+
+func TestRingOutlet(t *testing.T) {
+	o, err := NewRingOutlet(1 << 20)
+	require.NoError(t, err)
+
+	require.NoError(t, o.WriteEntry(ringTestEntry(0, logger.Fields{
+		JobField:      "prod",
+		SubsysField:   SubsysReplication,
+		"err":         errors.New("some error"),
+		"unencodable": func() {},
+	})))
+	entries := o.Entries()
+	require.Len(t, entries, 1)
+	e := entries[0]
+	assert.Equal(t, logger.Info, e.Level)
+	assert.Equal(t, "message 0", e.Message)
+	assert.True(t, e.Time.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, "prod", e.Fields[JobField])
+	assert.Equal(t, "repl", e.Fields[SubsysField])
+	assert.Equal(t, "some error", e.Fields["err"])
+	assert.Equal(t, "<func()>", e.Fields["unencodable"])
+
+	backlog, sub := o.Subscribe()
+	defer sub.Close()
+	assert.Equal(t, []string{"message 0"}, messages(backlog))
+	require.NoError(t, o.WriteEntry(ringTestEntry(1, nil)))
+	select {
+	case e := <-sub.Entries():
+		assert.Equal(t, "message 1", e.Message)
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription did not receive entry")
+	}
+
+	sub.Close()
+	require.NoError(t, o.WriteEntry(ringTestEntry(2, nil)))
+	assert.Len(t, sub.Entries(), 0)
+}
+
+func TestRingOutletWraps(t *testing.T) {
+	const n = 1000
+	// circlog.CircularLog starts with a 32KiB buffer, n entries of ~100 bytes make sure it wraps
+	o, err := NewRingOutlet(1 << 10)
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		require.NoError(t, o.WriteEntry(ringTestEntry(i, logger.Fields{"padding": strings.Repeat("x", 20)})))
+	}
+	entries := o.Entries()
+	require.NotEmpty(t, entries)
+	assert.True(t, len(entries) < n)
+	// the newest entries are retained, in order, without the partially overwritten one
+	for i, e := range entries {
+		assert.Equal(t, fmt.Sprintf("message %d", n-len(entries)+i), e.Message)
+	}
+}
+
+func TestRingSubscriptionDrops(t *testing.T) {
+	defer func(prev int) { ringSubscriptionQueueSize = prev }(ringSubscriptionQueueSize)
+	ringSubscriptionQueueSize = 2
+
+	o, err := NewRingOutlet(1 << 20)
+	require.NoError(t, err)
+	_, sub := o.Subscribe()
+	defer sub.Close()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, o.WriteEntry(ringTestEntry(i, nil)))
+	}
+	assert.Equal(t, uint64(3), sub.TakeDropped())
+	assert.Equal(t, uint64(0), sub.TakeDropped())
+	assert.Len(t, sub.Entries(), 2)
+}
+
+func TestEntryFilter(t *testing.T) {
+	e := ringTestEntry(30, logger.Fields{
+		JobField:    "prod",
+		SubsysField: SubsysReplication,
+		SpanField:   "abcd$efgh.ijkl",
+	})
+	e.Level = logger.Warn
+
+	tcs := []struct {
+		filter  EntryFilter
+		matches bool
+	}{
+		{EntryFilter{}, true},
+		{EntryFilter{Job: "prod"}, true},
+		{EntryFilter{Job: "other"}, false},
+		{EntryFilter{Subsystem: "repl"}, true},
+		{EntryFilter{Subsystem: "pruning"}, false},
+		{EntryFilter{Span: "efgh"}, true},
+		{EntryFilter{Span: "mnop"}, false},
+		{EntryFilter{MinLevel: logger.Warn}, true},
+		{EntryFilter{MinLevel: logger.Error}, false},
+		{EntryFilter{Since: e.Time}, true},
+		{EntryFilter{Since: e.Time.Add(time.Second)}, false},
+		{EntryFilter{Job: "prod", Subsystem: "repl", MinLevel: logger.Info}, true},
+	}
+	for _, tc := range tcs {
+		assert.Equal(t, tc.matches, tc.filter.Matches(&e), "%#v", tc.filter)
+	}
+}
@@ -117,6 +117,9 @@ func init() {
 
 func RegisterMetrics(r prometheus.Registerer) {
 	r.MustRegister(metrics.activeTasks)
+	r.MustRegister(otlpMetrics.exportedSpans)
+	r.MustRegister(otlpMetrics.droppedSpans)
+	r.MustRegister(otlpMetrics.exportErrors)
 }
 
 type traceNode struct {
@@ -132,6 +135,8 @@ type traceNode struct {
 
 	startedAt time.Time
 	endedAt   time.Time
+
+	otel otelSpanContext
 }
 
 func (s *traceNode) StartedAt() time.Time { return s.startedAt }
@@ -157,10 +162,11 @@ var ErrTaskStillHasActiveChildTasks = fmt.Errorf("end task: task still has activ
 // a unique suffix is appended to uniquely identify the task opened with this function.
 func WithTask(ctx context.Context, taskName string) (context.Context, DoneFunc) {
 
-	var parentTask *traceNode
+	var parentTask, otelParent *traceNode
 	nodeI := ctx.Value(contextKeyTraceNode)
 	if nodeI != nil {
 		node := nodeI.(*traceNode)
+		otelParent = node
 		if node.parentSpan != nil {
 			parentTask = node.parentTask
 		} else {
@@ -194,6 +200,8 @@ func WithTask(ctx context.Context, taskName string) (context.Context, DoneFunc)
 
 		startedAt: time.Now(),
 		endedAt:   time.Time{},
+
+		otel: newOtelSpanContext(ctx, otelParent),
 	}
 
 	if parentTask != nil {
@@ -240,6 +248,7 @@ func WithTask(ctx context.Context, taskName string) (context.Context, DoneFunc)
 		}
 
 		chrometraceEndTask(this)
+		otelEndNode(this)
 
 		metrics.activeTasks.Dec()
 
@@ -277,6 +286,8 @@ func WithSpan(ctx context.Context, annotation string) (context.Context, DoneFunc
 
 		startedAt: time.Now(),
 		endedAt:   time.Time{},
+
+		otel: newOtelSpanContext(ctx, parentSpan),
 	}
 
 	parentSpan.mtx.HoldWhile(func() {
@@ -310,6 +321,7 @@ func WithSpan(ctx context.Context, annotation string) (context.Context, DoneFunc
 		this.endedAt = time.Now()
 
 		chrometraceEndSpan(this)
+		otelEndNode(this)
 		callbackEndSpan(this)
 	}
 
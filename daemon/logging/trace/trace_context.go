@@ -6,6 +6,8 @@ type contextKey int
 
 const (
 	contextKeyTraceNode contextKey = 1 + iota
+	// not in contextKeys, it must not be inherited (see WithNewTrace)
+	contextKeyOtelRoot
 )
 
 var contextKeys = []contextKey{
@@ -0,0 +1,139 @@
+package trace
+
+// The functions in this file map tasks and spans to OpenTelemetry spans.
+//
+// Every task and every span is an OpenTelemetry span whose parent is the task or span
+// that was active in the context passed to WithTask or WithSpan.
+// The trace ID is inherited from the parent, except for
+//   - root tasks,
+//   - the first task or span created in a context returned by WithNewTrace, and
+//   - the first task or span created in a context returned by WithRemoteParent,
+//     which continues the trace of a span in another process.
+//
+// The span context is propagated to other processes in the W3C Trace Context
+// `traceparent` format, see Traceparent.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+type otelTraceID [16]byte
+type otelSpanID [8]byte
+
+func (id otelTraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id otelSpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+func (id otelTraceID) isZero() bool { return id == otelTraceID{} }
+func (id otelSpanID) isZero() bool  { return id == otelSpanID{} }
+
+type otelSpanContext struct {
+	traceID      otelTraceID
+	spanID       otelSpanID
+	parentSpanID otelSpanID // zero if the node is the root span of its trace
+}
+
+func otelRandom(b []byte) {
+	for {
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		// all-zero IDs are invalid
+		for _, x := range b {
+			if x != 0 {
+				return
+			}
+		}
+	}
+}
+
+// otelRoot is stored in the context by WithNewTrace and WithRemoteParent.
+// It applies to the first task or span created in that context.
+type otelRoot struct {
+	consumed     uint32      // atomic
+	traceID      otelTraceID // zero => start a new trace
+	parentSpanID otelSpanID
+}
+
+// WithNewTrace returns a child context of ctx such that the next task or span created
+// in it starts a new OpenTelemetry trace.
+// Use it to split long-lived tasks into traces of manageable size, e.g., per job invocation.
+func WithNewTrace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKeyOtelRoot, &otelRoot{})
+}
+
+// WithRemoteParent returns a child context of ctx such that the next task or span created in it
+// continues the trace of the remote span identified by traceparent (see Traceparent).
+// If traceparent is invalid, it is ignored.
+func WithRemoteParent(ctx context.Context, traceparent string) context.Context {
+	traceID, spanID, err := parseTraceparent(traceparent)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKeyOtelRoot, &otelRoot{traceID: traceID, parentSpanID: spanID})
+}
+
+// Traceparent returns the span context of the task or span active in ctx in the
+// W3C Trace Context `traceparent` header format, or "" if ctx has no active task.
+func Traceparent(ctx context.Context) string {
+	nI := ctx.Value(contextKeyTraceNode)
+	if nI == nil {
+		return ""
+	}
+	n := nI.(*traceNode)
+	return fmt.Sprintf("00-%s-%s-01", n.otel.traceID, n.otel.spanID)
+}
+
+func parseTraceparent(s string) (traceID otelTraceID, spanID otelSpanID, err error) {
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 {
+		return traceID, spanID, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return traceID, spanID, fmt.Errorf("invalid traceparent version %q", parts[0])
+	}
+	if len(parts[1]) != 2*len(traceID) || len(parts[2]) != 2*len(spanID) {
+		return traceID, spanID, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, spanID, fmt.Errorf("invalid traceparent trace-id: %s", err)
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parts[2])); err != nil {
+		return traceID, spanID, fmt.Errorf("invalid traceparent parent-id: %s", err)
+	}
+	if traceID.isZero() || spanID.isZero() {
+		return traceID, spanID, fmt.Errorf("invalid traceparent %q: all-zero id", s)
+	}
+	return traceID, spanID, nil
+}
+
+// newOtelSpanContext returns the span context for a task or span
+// created in ctx whose parent is the task or span parent (nil if none).
+func newOtelSpanContext(ctx context.Context, parent *traceNode) (sc otelSpanContext) {
+	otelRandom(sc.spanID[:])
+
+	if rI := ctx.Value(contextKeyOtelRoot); rI != nil {
+		r := rI.(*otelRoot)
+		if atomic.CompareAndSwapUint32(&r.consumed, 0, 1) {
+			if r.traceID.isZero() {
+				otelRandom(sc.traceID[:])
+			} else {
+				sc.traceID = r.traceID
+				sc.parentSpanID = r.parentSpanID
+			}
+			return sc
+		}
+	}
+
+	if parent == nil {
+		otelRandom(sc.traceID[:])
+		return sc
+	}
+	sc.traceID = parent.otel.traceID
+	sc.parentSpanID = parent.otel.spanID
+	return sc
+}
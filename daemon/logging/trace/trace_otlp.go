@@ -0,0 +1,298 @@
+package trace
+
+// The functions in this file export ended tasks and spans to an OpenTelemetry collector
+// using the OTLP/HTTP protocol with JSON encoding:
+//   https://github.com/open-telemetry/opentelemetry-specification/blob/main/specification/protocol/otlp.md
+//
+// At most one OTLPExporter is active at a time (see OTLPExporter.Run).
+// Tasks and spans that end while no exporter is active are not exported.
+// If the exporter cannot keep up, spans are dropped instead of blocking the traced code.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zrepl/zrepl/util/envconst"
+)
+
+var otlpMetrics struct {
+	exportedSpans prometheus.Counter
+	droppedSpans  prometheus.Counter
+	exportErrors  prometheus.Counter
+}
+
+func init() {
+	otlpMetrics.exportedSpans = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "zrepl",
+		Subsystem: "trace",
+		Name:      "otlp_exported_spans",
+		Help:      "number of spans exported to the OpenTelemetry collector",
+	})
+	otlpMetrics.droppedSpans = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "zrepl",
+		Subsystem: "trace",
+		Name:      "otlp_dropped_spans",
+		Help:      "number of spans dropped because the export queue was full or the export failed",
+	})
+	otlpMetrics.exportErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "zrepl",
+		Subsystem: "trace",
+		Name:      "otlp_export_errors",
+		Help:      "number of failed export requests to the OpenTelemetry collector",
+	})
+}
+
+var (
+	otlpQueueSize     = envconst.Int("ZREPL_TRACE_OTLP_QUEUE_SIZE", 4096)
+	otlpMaxBatchSize  = envconst.Int("ZREPL_TRACE_OTLP_MAX_BATCH_SIZE", 512)
+	otlpFlushInterval = envconst.Duration("ZREPL_TRACE_OTLP_FLUSH_INTERVAL", 5*time.Second)
+)
+
+type OTLPExporterConfig struct {
+	// Base URL of the collector's OTLP/HTTP receiver, e.g. http://localhost:4318.
+	// Spans are POSTed to Endpoint + "/v1/traces".
+	Endpoint    string
+	ServiceName string
+	// Additional HTTP headers, e.g. for authentication
+	Headers map[string]string
+	// Timeout of a single export request
+	Timeout time.Duration
+	// Called with errors that occur during export, may be nil
+	OnError func(err error)
+}
+
+type OTLPExporter struct {
+	url      string
+	config   OTLPExporterConfig
+	client   *http.Client
+	resource otlpResource
+	queue    chan *otlpSpan
+}
+
+func NewOTLPExporter(config OTLPExporterConfig) (*OTLPExporter, error) {
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid endpoint %q: scheme must be http or https", config.Endpoint)
+	}
+	if config.ServiceName == "" {
+		return nil, fmt.Errorf("service name must not be empty")
+	}
+	if config.Timeout <= 0 {
+		return nil, fmt.Errorf("timeout must be positive")
+	}
+	hostname, _ := os.Hostname()
+	return &OTLPExporter{
+		url:    strings.TrimSuffix(config.Endpoint, "/") + "/v1/traces",
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		resource: otlpResource{
+			Attributes: []otlpAttribute{
+				otlpStringAttribute("service.name", config.ServiceName),
+				otlpStringAttribute("host.name", hostname),
+			},
+		},
+		queue: make(chan *otlpSpan, otlpQueueSize),
+	}, nil
+}
+
+var otlpActiveExporter struct {
+	mtx sync.RWMutex
+	e   *OTLPExporter
+}
+
+// Run makes e the active exporter and exports ended tasks and spans in batches until ctx is done.
+// Spans that are queued when ctx is done are flushed before Run returns.
+func (e *OTLPExporter) Run(ctx context.Context) {
+	otlpActiveExporter.mtx.Lock()
+	if otlpActiveExporter.e != nil {
+		otlpActiveExporter.mtx.Unlock()
+		panic("only one OTLPExporter can be active at a time")
+	}
+	otlpActiveExporter.e = e
+	otlpActiveExporter.mtx.Unlock()
+	defer func() {
+		otlpActiveExporter.mtx.Lock()
+		otlpActiveExporter.e = nil
+		otlpActiveExporter.mtx.Unlock()
+	}()
+
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+	batch := make([]*otlpSpan, 0, otlpMaxBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			otlpMetrics.exportErrors.Inc()
+			otlpMetrics.droppedSpans.Add(float64(len(batch)))
+			if e.config.OnError != nil {
+				e.config.OnError(err)
+			}
+		} else {
+			otlpMetrics.exportedSpans.Add(float64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case s := <-e.queue:
+					batch = append(batch, s)
+					if len(batch) >= otlpMaxBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		case <-ticker.C:
+			flush()
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= otlpMaxBatchSize {
+				flush()
+			}
+		}
+	}
+}
+
+func (e *OTLPExporter) export(spans []*otlpSpan) error {
+	req := otlpExportTraceServiceRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: e.resource,
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/zrepl/zrepl/daemon/logging/trace"},
+				Spans: spans,
+			}},
+		}},
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(&req); err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, e.url, &buf)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	for k, v := range e.config.Headers {
+		httpReq.Header.Set(k, v)
+	}
+	res, err := e.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("collector responded with %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body) // allow connection reuse
+	return nil
+}
+
+// called when n ended, must not block
+func otelEndNode(n *traceNode) {
+	otlpActiveExporter.mtx.RLock()
+	e := otlpActiveExporter.e
+	otlpActiveExporter.mtx.RUnlock()
+	if e == nil {
+		return
+	}
+
+	kind := "span"
+	if n.parentSpan == nil {
+		kind = "task"
+	}
+	s := &otlpSpan{
+		TraceID:           n.otel.traceID.String(),
+		SpanID:            n.otel.spanID.String(),
+		Name:              n.annotation,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(n.startedAt.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(n.endedAt.UnixNano(), 10),
+		Attributes: []otlpAttribute{
+			otlpStringAttribute("zrepl.task", n.TaskName()),
+			otlpStringAttribute("zrepl.trace_node.kind", kind),
+			otlpStringAttribute("zrepl.trace_node.id", n.id),
+		},
+	}
+	if !n.otel.parentSpanID.isZero() {
+		s.ParentSpanID = n.otel.parentSpanID.String()
+	}
+	select {
+	case e.queue <- s:
+	default:
+		otlpMetrics.droppedSpans.Inc()
+	}
+}
+
+// The following types model the JSON encoding of the OTLP ExportTraceServiceRequest protobuf message.
+
+type otlpExportTraceServiceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+const otlpSpanKindInternal = 1
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string             `json:"key"`
+	Value otlpAttributeValue `json:"value"`
+}
+
+type otlpAttributeValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func otlpStringAttribute(key, value string) otlpAttribute {
+	return otlpAttribute{key, otlpAttributeValue{value}}
+}
@@ -0,0 +1,176 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceparentRoundtrip(t *testing.T) {
+	ctx, end := WithTask(context.Background(), "root")
+	defer end()
+
+	tp := Traceparent(ctx)
+	require.Regexp(t, `^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`, tp)
+
+	traceID, spanID, err := parseTraceparent(tp)
+	require.NoError(t, err)
+	n := ctx.Value(contextKeyTraceNode).(*traceNode)
+	assert.Equal(t, n.otel.traceID, traceID)
+	assert.Equal(t, n.otel.spanID, spanID)
+
+	assert.Equal(t, "", Traceparent(context.Background()))
+
+	for _, invalid := range []string{
+		"",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01",
+		"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319x-b7ad6b7169203331-01",
+	} {
+		_, _, err := parseTraceparent(invalid)
+		assert.Error(t, err, "%q", invalid)
+	}
+}
+
+func TestOtelSpanContextInheritance(t *testing.T) {
+	node := func(ctx context.Context) *traceNode { return ctx.Value(contextKeyTraceNode).(*traceNode) }
+
+	root, endRoot := WithTask(context.Background(), "root")
+	defer endRoot()
+	assert.True(t, node(root).otel.parentSpanID.isZero())
+
+	span, endSpan := WithSpan(root, "span")
+	defer endSpan()
+	assert.Equal(t, node(root).otel.traceID, node(span).otel.traceID)
+	assert.Equal(t, node(root).otel.spanID, node(span).otel.parentSpanID)
+
+	// child tasks are children of the span that was active when they were created
+	child, endChild := WithTask(span, "child")
+	assert.Equal(t, node(span).otel.spanID, node(child).otel.parentSpanID)
+	endChild()
+
+	// WithNewTrace only applies to the next task or span
+	newTrace, endNewTrace := WithSpan(WithNewTrace(span), "new-trace")
+	assert.NotEqual(t, node(root).otel.traceID, node(newTrace).otel.traceID)
+	assert.True(t, node(newTrace).otel.parentSpanID.isZero())
+	inNewTrace, endInNewTrace := WithSpan(newTrace, "in-new-trace")
+	assert.Equal(t, node(newTrace).otel.traceID, node(inNewTrace).otel.traceID)
+	assert.Equal(t, node(newTrace).otel.spanID, node(inNewTrace).otel.parentSpanID)
+	endInNewTrace()
+	endNewTrace()
+
+	// remote parent, e.g. the rpc client's span in another process
+	remote := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	handler, endHandler := WithTask(WithRemoteParent(span, remote), "handler")
+	defer endHandler()
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", node(handler).otel.traceID.String())
+	assert.Equal(t, "b7ad6b7169203331", node(handler).otel.parentSpanID.String())
+
+	// invalid remote parents are ignored
+	ignored, endIgnored := WithTask(WithRemoteParent(span, "garbage"), "ignored")
+	assert.Equal(t, node(span).otel.spanID, node(ignored).otel.parentSpanID)
+	endIgnored()
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var mtx sync.Mutex
+	var received []*otlpSpan
+	var receivedResource otlpResource
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var req otlpExportTraceServiceRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, rs := range req.ResourceSpans {
+			receivedResource = rs.Resource
+			for _, ss := range rs.ScopeSpans {
+				received = append(received, ss.Spans...)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer collector.Close()
+
+	var exportErrs []error
+	e, err := NewOTLPExporter(OTLPExporterConfig{
+		Endpoint:    collector.URL + "/",
+		ServiceName: "zrepl-test",
+		Headers:     map[string]string{"Authorization": "Bearer secret"},
+		Timeout:     10 * time.Second,
+		OnError:     func(err error) { exportErrs = append(exportErrs, err) },
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Run(ctx)
+	}()
+	// wait until e is the active exporter
+	require.Eventually(t, func() bool {
+		otlpActiveExporter.mtx.RLock()
+		defer otlpActiveExporter.mtx.RUnlock()
+		return otlpActiveExporter.e == e
+	}, 5*time.Second, time.Millisecond)
+
+	root, endRoot := WithTask(context.Background(), "otlp-test-root")
+	span, endSpan := WithSpan(root, "otlp-test-span")
+	endSpan()
+	endRoot()
+
+	cancel()
+	<-done
+	assert.Empty(t, exportErrs)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.Contains(t, receivedResource.Attributes, otlpStringAttribute("service.name", "zrepl-test"))
+	byName := make(map[string]*otlpSpan)
+	for _, s := range received {
+		byName[s.Name] = s
+	}
+	// task names have a unique suffix, see uniqueConcurrentTaskNamer
+	require.Contains(t, byName, "otlp-test-root#0")
+	require.Contains(t, byName, "otlp-test-span")
+	rootSpan, childSpan := byName["otlp-test-root#0"], byName["otlp-test-span"]
+	assert.Equal(t, rootSpan.TraceID, childSpan.TraceID)
+	assert.Equal(t, rootSpan.SpanID, childSpan.ParentSpanID)
+	assert.Empty(t, rootSpan.ParentSpanID)
+	assert.Equal(t, span.Value(contextKeyTraceNode).(*traceNode).otel.spanID.String(), childSpan.SpanID)
+	assert.Contains(t, childSpan.Attributes, otlpStringAttribute("zrepl.task", "otlp-test-root#0"))
+	assert.Contains(t, childSpan.Attributes, otlpStringAttribute("zrepl.trace_node.kind", "span"))
+}
+
+func TestNewOTLPExporterValidation(t *testing.T) {
+	valid := OTLPExporterConfig{Endpoint: "http://localhost:4318", ServiceName: "zrepl", Timeout: time.Second}
+	_, err := NewOTLPExporter(valid)
+	assert.NoError(t, err)
+
+	c := valid
+	c.Endpoint = "localhost:4318"
+	_, err = NewOTLPExporter(c)
+	assert.Error(t, err)
+
+	c = valid
+	c.ServiceName = ""
+	_, err = NewOTLPExporter(c)
+	assert.Error(t, err)
+}
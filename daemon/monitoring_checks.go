@@ -0,0 +1,43 @@
+package daemon
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/checks"
+	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/endpoint"
+	"github.com/zrepl/zrepl/zfs"
+)
+
+// checksJob exports the results of the configured monitoring checks as Prometheus metrics.
+// The checks themselves are evaluated on every scrape.
+type checksJob struct {
+	collector *checks.Collector
+}
+
+func newChecksJobFromConfig(in *config.ChecksMonitoring, confJobs []config.JobEnum, jobs *jobs) (*checksJob, error) {
+	cs, err := checks.FromConfig(in, confJobs)
+	if err != nil {
+		return nil, err
+	}
+	return &checksJob{checks.NewCollector(cs, jobs.status)}, nil
+}
+
+func (j *checksJob) Name() string { return jobNameChecks }
+
+func (j *checksJob) Status() *job.Status { return &job.Status{Type: job.TypeInternal} }
+
+func (j *checksJob) OwnedDatasetSubtreeRoot() (p *zfs.DatasetPath, ok bool) { return nil, false }
+
+func (j *checksJob) SenderConfig() *endpoint.SenderConfig { return nil }
+
+func (j *checksJob) RegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(j.collector)
+}
+
+func (j *checksJob) Run(ctx context.Context) {
+	<-ctx.Done()
+}
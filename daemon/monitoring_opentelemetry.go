@@ -0,0 +1,57 @@
+package daemon
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/daemon/logging/trace"
+	"github.com/zrepl/zrepl/endpoint"
+	"github.com/zrepl/zrepl/zfs"
+)
+
+// openTelemetryJob exports the daemon's tasks and spans to an OpenTelemetry collector.
+type openTelemetryJob struct {
+	config trace.OTLPExporterConfig
+}
+
+func newOpenTelemetryJobFromConfig(in *config.OpenTelemetryMonitoring) (*openTelemetryJob, error) {
+	c := trace.OTLPExporterConfig{
+		Endpoint:    in.Endpoint,
+		ServiceName: in.ServiceName,
+		Headers:     in.Headers,
+		Timeout:     in.Timeout,
+	}
+	// validate early, the exporter is re-created in Run with the job's logger
+	if _, err := trace.NewOTLPExporter(c); err != nil {
+		return nil, err
+	}
+	return &openTelemetryJob{c}, nil
+}
+
+func (j *openTelemetryJob) Name() string { return jobNameOpenTelemetry }
+
+func (j *openTelemetryJob) Status() *job.Status { return &job.Status{Type: job.TypeInternal} }
+
+func (j *openTelemetryJob) OwnedDatasetSubtreeRoot() (p *zfs.DatasetPath, ok bool) { return nil, false }
+
+func (j *openTelemetryJob) SenderConfig() *endpoint.SenderConfig { return nil }
+
+func (j *openTelemetryJob) RegisterMetrics(registerer prometheus.Registerer) {}
+
+func (j *openTelemetryJob) Run(ctx context.Context) {
+	log := job.GetLogger(ctx)
+	c := j.config
+	c.OnError = func(err error) {
+		log.WithError(err).Error("cannot export spans to OpenTelemetry collector")
+	}
+	e, err := trace.NewOTLPExporter(c)
+	if err != nil {
+		log.WithError(err).Error("cannot create OpenTelemetry exporter")
+		return
+	}
+	log.WithField("endpoint", c.Endpoint).Info("exporting tasks and spans to OpenTelemetry collector")
+	e.Run(ctx)
+}
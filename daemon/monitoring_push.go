@@ -0,0 +1,55 @@
+package daemon
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/job"
+	"github.com/zrepl/zrepl/daemon/pushmetrics"
+	"github.com/zrepl/zrepl/endpoint"
+	"github.com/zrepl/zrepl/zfs"
+)
+
+// pushJob periodically pushes the daemon's metrics for hosts that cannot be scraped by Prometheus.
+type pushJob struct {
+	name     string
+	pusher   pushmetrics.Pusher
+	interval time.Duration
+}
+
+func newPushgatewayJobFromConfig(in *config.PushgatewayMonitoring) (*pushJob, error) {
+	p, err := pushmetrics.NewPushgatewayFromConfig(in, prometheus.DefaultGatherer)
+	if err != nil {
+		return nil, err
+	}
+	return &pushJob{jobNamePushgateway, p, in.Interval}, nil
+}
+
+func newRemoteWriteJobFromConfig(in *config.RemoteWriteMonitoring) (*pushJob, error) {
+	p, err := pushmetrics.NewRemoteWriteFromConfig(in, prometheus.DefaultGatherer)
+	if err != nil {
+		return nil, err
+	}
+	return &pushJob{jobNameRemoteWrite, p, in.Interval}, nil
+}
+
+func (j *pushJob) Name() string { return j.name }
+
+func (j *pushJob) Status() *job.Status { return &job.Status{Type: job.TypeInternal} }
+
+func (j *pushJob) OwnedDatasetSubtreeRoot() (p *zfs.DatasetPath, ok bool) { return nil, false }
+
+func (j *pushJob) SenderConfig() *endpoint.SenderConfig { return nil }
+
+func (j *pushJob) RegisterMetrics(registerer prometheus.Registerer) {}
+
+func (j *pushJob) Run(ctx context.Context) {
+	registerExportedMetrics()
+	log := job.GetLogger(ctx)
+	pushmetrics.Run(ctx, j.pusher, j.interval, func(err error) {
+		log.WithError(err).Error("cannot push metrics")
+	})
+}
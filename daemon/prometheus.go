@@ -4,6 +4,7 @@ import (
 	"context"
 	"net"
 	"net/http"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -14,6 +15,8 @@ import (
 	"github.com/zrepl/zrepl/endpoint"
 	"github.com/zrepl/zrepl/logger"
 	"github.com/zrepl/zrepl/rpc/dataconn/frameconn"
+	"github.com/zrepl/zrepl/rpc/dataconn/stream"
+	"github.com/zrepl/zrepl/tlsconf"
 	"github.com/zrepl/zrepl/util/tcpsock"
 	"github.com/zrepl/zrepl/zfs"
 )
@@ -54,15 +57,34 @@ func (j *prometheusJob) SenderConfig() *endpoint.SenderConfig { return nil }
 
 func (j *prometheusJob) RegisterMetrics(registerer prometheus.Registerer) {}
 
-func (j *prometheusJob) Run(ctx context.Context) {
-
-	if err := zfs.PrometheusRegister(prometheus.DefaultRegisterer); err != nil {
-		panic(err)
+// registerExportedMetrics registers the metrics that are only collected
+// if they are exported by a monitoring job, i.e., scraped or pushed.
+var registerExportedMetrics = func() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if err := zfs.PrometheusRegister(prometheus.DefaultRegisterer); err != nil {
+				panic(err)
+			}
+
+			if err := frameconn.PrometheusRegister(prometheus.DefaultRegisterer); err != nil {
+				panic(err)
+			}
+
+			if err := stream.PrometheusRegister(prometheus.DefaultRegisterer); err != nil {
+				panic(err)
+			}
+
+			if err := tlsconf.PrometheusRegister(prometheus.DefaultRegisterer); err != nil {
+				panic(err)
+			}
+		})
 	}
+}()
 
-	if err := frameconn.PrometheusRegister(prometheus.DefaultRegisterer); err != nil {
-		panic(err)
-	}
+func (j *prometheusJob) Run(ctx context.Context) {
+
+	registerExportedMetrics()
 
 	log := job.GetLogger(ctx)
 
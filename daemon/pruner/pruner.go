@@ -52,7 +52,16 @@ type args struct {
 	rules                          []pruning.KeepRule
 	retryWait                      time.Duration
 	considerSnapAtCursorReplicated bool
+	keepSnapAtCursor               bool
 	promPruneSecs                  prometheus.Observer
+	snapshotCounts                 SnapshotCountObserver // may be nil
+}
+
+// SnapshotCountObserver is notified of the number of snapshots that remain
+// in a filesystem after pruning it.
+// side is the prune side, i.e., sender or receiver.
+type SnapshotCountObserver interface {
+	ObserveSnapshotCount(fs, side string, count int)
 }
 
 type Pruner struct {
@@ -75,6 +84,7 @@ type PrunerFactory struct {
 	retryWait                      time.Duration
 	considerSnapAtCursorReplicated bool
 	promPruneSecs                  *prometheus.HistogramVec
+	snapshotCounts                 SnapshotCountObserver
 }
 
 type LocalPrunerFactory struct {
@@ -103,7 +113,8 @@ func NewLocalPrunerFactory(in config.PruningLocal, promPruneSecs *prometheus.His
 	return f, nil
 }
 
-func NewPrunerFactory(in config.PruningSenderReceiver, promPruneSecs *prometheus.HistogramVec) (*PrunerFactory, error) {
+// snapshotCounts may be nil.
+func NewPrunerFactory(in config.PruningSenderReceiver, promPruneSecs *prometheus.HistogramVec, snapshotCounts SnapshotCountObserver) (*PrunerFactory, error) {
 	keepRulesReceiver, err := pruning.RulesFromConfig(in.KeepReceiver)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot build receiver pruning rules")
@@ -128,6 +139,7 @@ func NewPrunerFactory(in config.PruningSenderReceiver, promPruneSecs *prometheus
 		retryWait:                      envconst.Duration("ZREPL_PRUNER_RETRY_INTERVAL", 10*time.Second),
 		considerSnapAtCursorReplicated: considerSnapAtCursorReplicated,
 		promPruneSecs:                  promPruneSecs,
+		snapshotCounts:                 snapshotCounts,
 	}
 	return f, nil
 }
@@ -141,7 +153,9 @@ func (f *PrunerFactory) BuildSenderPruner(ctx context.Context, target Target, re
 			f.senderRules,
 			f.retryWait,
 			f.considerSnapAtCursorReplicated,
+			false, // not_replicated rule takes care of it if desired
 			f.promPruneSecs.WithLabelValues("sender"),
+			f.snapshotCounts,
 		},
 		state: Plan,
 	}
@@ -157,7 +171,9 @@ func (f *PrunerFactory) BuildReceiverPruner(ctx context.Context, target Target,
 			f.receiverRules,
 			f.retryWait,
 			false, // senseless here anyways
+			false, // the receiver's last-received-hold prevents destruction
 			f.promPruneSecs.WithLabelValues("receiver"),
+			f.snapshotCounts,
 		},
 		state: Plan,
 	}
@@ -173,7 +189,30 @@ func (f *LocalPrunerFactory) BuildLocalPruner(ctx context.Context, target Target
 			f.keepRules,
 			f.retryWait,
 			false, // considerSnapAtCursorReplicated is not relevant for local pruning
+			false,
 			f.promPruneSecs.WithLabelValues("local"),
+			nil,
+		},
+		state: Plan,
+	}
+	return p
+}
+
+// BuildSinkPruner builds a pruner for the filesystems that a sink job received from a single client.
+// Unlike the pruner built by BuildLocalPruner, it never destroys the snapshot at the replication cursor
+// reported by receiver, which is expected to be the snapshot protected by the last-received-hold.
+func (f *LocalPrunerFactory) BuildSinkPruner(ctx context.Context, target Target, receiver History) *Pruner {
+	p := &Pruner{
+		args: args{
+			context.WithValue(ctx, contextKeyPruneSide, "sink"),
+			target,
+			receiver,
+			f.keepRules,
+			f.retryWait,
+			false, // considerSnapAtCursorReplicated is not relevant for local pruning
+			true,
+			f.promPruneSecs.WithLabelValues("sink"),
+			nil,
 		},
 		state: Plan,
 	}
@@ -462,6 +501,18 @@ tfss_loop:
 
 		// Apply prune rules
 		pfs.destroyList = pruning.PruneSnapshots(pfs.snaps, a.rules)
+
+		if a.keepSnapAtCursor {
+			destroyList := pfs.destroyList[:0]
+			for _, s := range pfs.destroyList {
+				if s.(snapshot).fsv.Guid == rc.GetGuid() {
+					l.WithField("snap", s.Name()).Debug("not destroying snapshot at replication cursor")
+					continue
+				}
+				destroyList = append(destroyList, s)
+			}
+			pfs.destroyList = destroyList
+		}
 	}
 
 	u(func(pruner *Pruner) {
@@ -573,4 +624,8 @@ func doOneAttemptExec(a *args, u updater, pfs *fs) {
 		GetLogger(a.ctx).WithError(err).Error("target could not destroy snapshots")
 		return
 	}
+	if a.snapshotCounts != nil && pfs.skipReason.NotSkipped() && pfs.planErr == nil {
+		side, _ := a.ctx.Value(contextKeyPruneSide).(string)
+		a.snapshotCounts.ObserveSnapshotCount(pfs.path, side, len(pfs.snaps)-len(pfs.destroyList))
+	}
 }
@@ -0,0 +1,137 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/replication/logic/pdu"
+)
+
+// fakeTarget serves a single filesystem and records the snapshots it is asked to destroy
+type fakeTarget struct {
+	fs        string
+	versions  []*pdu.FilesystemVersion
+	destroyed []string
+}
+
+func newFakeTarget(fs string, snapNames ...string) *fakeTarget {
+	t := &fakeTarget{fs: fs}
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range snapNames {
+		t.versions = append(t.versions, &pdu.FilesystemVersion{
+			Type:      pdu.FilesystemVersion_Snapshot,
+			Name:      name,
+			Guid:      uint64(100 + i),
+			CreateTXG: uint64(i + 1),
+			Creation:  pdu.FilesystemVersionCreation(t0.Add(time.Duration(i) * time.Hour)),
+		})
+	}
+	return t
+}
+
+func (t *fakeTarget) guid(name string) uint64 {
+	for _, v := range t.versions {
+		if v.Name == name {
+			return v.Guid
+		}
+	}
+	panic(name)
+}
+
+func (t *fakeTarget) ListFilesystems(ctx context.Context, req *pdu.ListFilesystemReq) (*pdu.ListFilesystemRes, error) {
+	return &pdu.ListFilesystemRes{Filesystems: []*pdu.Filesystem{{Path: t.fs}}}, nil
+}
+
+func (t *fakeTarget) ListFilesystemVersions(ctx context.Context, req *pdu.ListFilesystemVersionsReq) (*pdu.ListFilesystemVersionsRes, error) {
+	if req.GetFilesystem() != t.fs {
+		return nil, fmt.Errorf("unknown filesystem %q", req.GetFilesystem())
+	}
+	return &pdu.ListFilesystemVersionsRes{Versions: t.versions}, nil
+}
+
+func (t *fakeTarget) DestroySnapshots(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsRes, error) {
+	res := &pdu.DestroySnapshotsRes{}
+	for _, s := range req.GetSnapshots() {
+		t.destroyed = append(t.destroyed, s.GetName())
+		res.Results = append(res.Results, &pdu.DestroySnapshotRes{Snapshot: s})
+	}
+	sort.Strings(t.destroyed)
+	return res, nil
+}
+
+// fakeHistory reports cursorGUID as the replication cursor, or that there is none if it is 0
+type fakeHistory struct {
+	target     *fakeTarget
+	cursorGUID uint64
+}
+
+func (h fakeHistory) ReplicationCursor(ctx context.Context, req *pdu.ReplicationCursorReq) (*pdu.ReplicationCursorRes, error) {
+	if h.cursorGUID == 0 {
+		return &pdu.ReplicationCursorRes{Result: &pdu.ReplicationCursorRes_Notexist{Notexist: true}}, nil
+	}
+	return &pdu.ReplicationCursorRes{Result: &pdu.ReplicationCursorRes_Guid{Guid: h.cursorGUID}}, nil
+}
+
+func (h fakeHistory) ListFilesystems(ctx context.Context, req *pdu.ListFilesystemReq) (*pdu.ListFilesystemRes, error) {
+	return h.target.ListFilesystems(ctx, req)
+}
+
+func newTestLocalPrunerFactory(t *testing.T, keep interface{}) *LocalPrunerFactory {
+	f, err := NewLocalPrunerFactory(config.PruningLocal{
+		Keep: []config.PruningEnum{{Ret: keep}},
+	}, prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "test"}, []string{"prune_side"}))
+	require.NoError(t, err)
+	return f
+}
+
+var keepNothing = &config.PruneKeepRegex{Type: "regex", Regex: "^$"}
+
+func TestSinkPrunerKeepsSnapshotAtCursor(t *testing.T) {
+	f := newTestLocalPrunerFactory(t, &config.PruneKeepLastN{Type: "last_n", Count: 1})
+
+	// the held snapshot @a matches the destroy rules
+	target := newFakeTarget("pool/sink/client/fs", "a", "b", "c")
+	p := f.BuildSinkPruner(context.Background(), target, fakeHistory{target, target.guid("a")})
+	assert.True(t, p.args.keepSnapAtCursor)
+	p.Prune()
+	assert.Equal(t, Done, p.State())
+	assert.Equal(t, []string{"b"}, target.destroyed)
+
+	// in contrast, the local pruner only follows the rules
+	target = newFakeTarget("pool/sink/client/fs", "a", "b", "c")
+	p = f.BuildLocalPruner(context.Background(), target, fakeHistory{target, target.guid("a")})
+	assert.False(t, p.args.keepSnapAtCursor)
+	p.Prune()
+	assert.Equal(t, []string{"a", "b"}, target.destroyed)
+}
+
+func TestSinkPrunerKeepsMostRecentSnapshotWithoutHold(t *testing.T) {
+	f := newTestLocalPrunerFactory(t, keepNothing)
+
+	// without a last-received-hold, the history reports the most recent snapshot as the cursor
+	target := newFakeTarget("pool/sink/client/fs", "a", "b", "c")
+	p := f.BuildSinkPruner(context.Background(), target, fakeHistory{target, target.guid("c")})
+	p.Prune()
+	assert.Equal(t, Done, p.State())
+	assert.Equal(t, []string{"a", "b"}, target.destroyed)
+}
+
+func TestSinkPrunerDoesNotDestroyWithoutCursor(t *testing.T) {
+	f := newTestLocalPrunerFactory(t, keepNothing)
+
+	target := newFakeTarget("pool/sink/client/fs", "a", "b", "c")
+	p := f.BuildSinkPruner(context.Background(), target, fakeHistory{target, 0})
+	p.Prune()
+	assert.Empty(t, target.destroyed)
+	rep := p.Report()
+	require.Len(t, rep.Completed, 1)
+	assert.Contains(t, rep.Completed[0].LastError, "replication cursor bookmark does not exist")
+}
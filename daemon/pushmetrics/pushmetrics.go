@@ -0,0 +1,141 @@
+// Package pushmetrics periodically pushes the metrics of a prometheus.Gatherer
+// to a Prometheus Pushgateway or a Prometheus remote write endpoint.
+//
+// It is used by hosts that cannot be scraped by Prometheus, e.g., because they are behind NAT.
+package pushmetrics
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/tlsconf"
+)
+
+// Pusher pushes a snapshot of the metrics of a prometheus.Gatherer.
+type Pusher interface {
+	Push(ctx context.Context) error
+}
+
+type basicAuth struct {
+	username, password string
+}
+
+// endpoint is the url and HTTP client configuration shared by all Pushers.
+type endpoint struct {
+	url       string
+	client    *http.Client
+	basicAuth *basicAuth // nil if not configured
+}
+
+func endpointFromConfig(in *config.MonitoringPushCommon) (*endpoint, error) {
+	u, err := url.Parse(in.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.Errorf("invalid url %q: scheme must be http or https", in.URL)
+	}
+
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if in.TLS != nil {
+		if u.Scheme != "https" {
+			return nil, errors.Errorf("tls is configured but url %q does not use https", in.URL)
+		}
+		transport.TLSClientConfig, err = tlsConfigFromConfig(in.TLS)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot build TLS config")
+		}
+	}
+
+	e := &endpoint{
+		url:    in.URL,
+		client: &http.Client{Transport: transport, Timeout: in.Timeout},
+	}
+	if in.BasicAuth != nil {
+		password, err := ioutil.ReadFile(in.BasicAuth.PasswordFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read basic auth password file")
+		}
+		e.basicAuth = &basicAuth{
+			username: in.BasicAuth.Username,
+			password: strings.TrimRight(string(password), "\r\n"),
+		}
+	}
+	return e, nil
+}
+
+func tlsConfigFromConfig(in *config.MonitoringPushTLS) (*tls.Config, error) {
+	c := &tls.Config{
+		ServerName: in.ServerName,
+	}
+	var err error
+	if in.CA == "" {
+		if c.RootCAs, err = x509.SystemCertPool(); err != nil {
+			return nil, errors.Wrap(err, "cannot open system cert pool")
+		}
+	} else {
+		if c.RootCAs, err = tlsconf.ParseCAFile(in.CA); err != nil {
+			return nil, errors.Wrap(err, "cannot parse CA cert")
+		}
+	}
+	if (in.Cert == "") != (in.Key == "") {
+		return nil, errors.New("client cert and key must be specified together")
+	}
+	if in.Cert != "" {
+		clientCert, err := tls.LoadX509KeyPair(in.Cert, in.Key)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot load client cert")
+		}
+		c.Certificates = []tls.Certificate{clientCert}
+	}
+	return c, nil
+}
+
+func validateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !model.LabelName(name).IsValid() {
+			return errors.Errorf("invalid label name %q", name)
+		}
+	}
+	return nil
+}
+
+// defaultInstance returns the value of the instance label if none is configured.
+func defaultInstance(configured string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", errors.Wrap(err, "cannot determine hostname for instance label")
+	}
+	return hostname, nil
+}
+
+// Run pushes using p immediately and then every interval until ctx is done.
+// Push errors are reported to onError, the next push is attempted regardless.
+func Run(ctx context.Context, p Pusher, interval time.Duration, onError func(err error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := p.Push(ctx); err != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package pushmetrics
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+
+	"github.com/zrepl/zrepl/config"
+)
+
+// Pushgateway pushes to a Prometheus Pushgateway.
+// Each push replaces all metrics of the grouping key (job, instance and the configured grouping labels).
+type Pushgateway struct {
+	pusher *push.Pusher
+}
+
+func NewPushgatewayFromConfig(in *config.PushgatewayMonitoring, g prometheus.Gatherer) (*Pushgateway, error) {
+	e, err := endpointFromConfig(&in.MonitoringPushCommon)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateLabels(in.Grouping); err != nil {
+		return nil, errors.Wrap(err, "invalid grouping")
+	}
+	for _, reserved := range []string{"job", "instance"} {
+		if _, ok := in.Grouping[reserved]; ok {
+			return nil, errors.Errorf("invalid grouping: use field '%s' to set the %s label", reserved, reserved)
+		}
+	}
+	instance, err := defaultInstance(in.Instance)
+	if err != nil {
+		return nil, err
+	}
+
+	p := push.New(e.url, in.Job).
+		Gatherer(g).
+		Client(e.client).
+		Grouping("instance", instance)
+	for name, value := range in.Grouping {
+		p = p.Grouping(name, value)
+	}
+	if e.basicAuth != nil {
+		p = p.BasicAuth(e.basicAuth.username, e.basicAuth.password)
+	}
+	return &Pushgateway{p}, nil
+}
+
+// Push implements Pusher.
+// The request is bounded by the configured timeout, ctx is not used.
+func (p *Pushgateway) Push(ctx context.Context) error {
+	return p.pusher.Push()
+}
@@ -0,0 +1,223 @@
+package pushmetrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/klauspost/compress/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/version"
+)
+
+// RemoteWrite pushes to an endpoint that implements the Prometheus remote write protocol (version 0.1.0):
+//
+//	https://prometheus.io/docs/concepts/remote_write_spec/
+//
+// Each push sends one sample per time series, timestamped with the time of the push.
+type RemoteWrite struct {
+	endpoint *endpoint
+	gatherer prometheus.Gatherer
+	labels   []label // attached to every time series, sorted by name
+}
+
+func NewRemoteWriteFromConfig(in *config.RemoteWriteMonitoring, g prometheus.Gatherer) (*RemoteWrite, error) {
+	e, err := endpointFromConfig(&in.MonitoringPushCommon)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateLabels(in.Labels); err != nil {
+		return nil, errors.Wrap(err, "invalid labels")
+	}
+	for _, reserved := range []string{"job", "instance"} {
+		if _, ok := in.Labels[reserved]; ok {
+			return nil, errors.Errorf("invalid labels: use field '%s' to set the %s label", reserved, reserved)
+		}
+	}
+	instance, err := defaultInstance(in.Instance)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := []label{{"job", in.Job}, {"instance", instance}}
+	for name, value := range in.Labels {
+		labels = append(labels, label{name, value})
+	}
+	sortLabels(labels)
+	return &RemoteWrite{e, g, labels}, nil
+}
+
+// Push implements Pusher.
+func (w *RemoteWrite) Push(ctx context.Context) error {
+	mfs, err := w.gatherer.Gather()
+	if err != nil {
+		return errors.Wrap(err, "cannot gather metrics")
+	}
+	series := timeSeriesFromMetricFamilies(mfs, w.labels, time.Now())
+	body := snappy.Encode(nil, marshalWriteRequest(series))
+
+	req, err := http.NewRequest(http.MethodPost, w.endpoint.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", fmt.Sprintf("zrepl/%s", version.NewZreplVersionInformation().Version))
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if w.endpoint.basicAuth != nil {
+		req.SetBasicAuth(w.endpoint.basicAuth.username, w.endpoint.basicAuth.password)
+	}
+
+	res, err := w.endpoint.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return errors.Errorf("remote write endpoint responded with %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(ioutil.Discard, res.Body) // allow connection reuse
+	return nil
+}
+
+type label struct {
+	name, value string
+}
+
+func sortLabels(ls []label) {
+	sort.Slice(ls, func(i, j int) bool { return ls[i].name < ls[j].name })
+}
+
+type timeSeries struct {
+	labels      []label // sorted by name, including __name__
+	value       float64
+	timestampMs int64
+}
+
+// timeSeriesFromMetricFamilies flattens mfs into time series the same way the
+// Prometheus server does when scraping, i.e., histograms and summaries
+// are split into their _bucket / quantile, _sum and _count series.
+//
+// extraLabels are added to each time series unless the metric has a label of the same name.
+func timeSeriesFromMetricFamilies(mfs []*dto.MetricFamily, extraLabels []label, now time.Time) []timeSeries {
+	var ret []timeSeries
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			ts := now.UnixNano() / int64(time.Millisecond)
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(name string, value float64, extra ...label) {
+				ls := make([]label, 0, 1+len(m.GetLabel())+len(extra)+len(extraLabels))
+				ls = append(ls, label{"__name__", name})
+				seen := make(map[string]bool, len(m.GetLabel()))
+				for _, lp := range m.GetLabel() {
+					ls = append(ls, label{lp.GetName(), lp.GetValue()})
+					seen[lp.GetName()] = true
+				}
+				ls = append(ls, extra...)
+				for _, l := range extraLabels {
+					if !seen[l.name] {
+						ls = append(ls, l)
+					}
+				}
+				sortLabels(ls)
+				ret = append(ret, timeSeries{ls, value, ts})
+			}
+
+			name := mf.GetName()
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, q.GetValue(), label{"quantile", formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", s.GetSampleSum())
+				add(name+"_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				hasInf := false
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						hasInf = true
+					}
+					add(name+"_bucket", float64(b.GetCumulativeCount()), label{"le", formatFloat(b.GetUpperBound())})
+				}
+				if !hasInf {
+					add(name+"_bucket", float64(h.GetSampleCount()), label{"le", "+Inf"})
+				}
+				add(name+"_sum", h.GetSampleSum())
+				add(name+"_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return ret
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// marshalWriteRequest encodes series as a prometheus.WriteRequest protobuf message:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+//
+// The message is simple enough that we do not need the generated code of the Prometheus repository.
+func marshalWriteRequest(series []timeSeries) []byte {
+	const (
+		wireVarint  = 0
+		wireFixed64 = 1
+		wireBytes   = 2
+	)
+	key := func(field, wireType uint64) uint64 { return field<<3 | wireType }
+
+	// The Encode* methods of proto.Buffer only fail for oversized inputs, which cannot happen here.
+	var req, ts, sub proto.Buffer
+	for _, s := range series {
+		ts.Reset()
+		for _, l := range s.labels {
+			sub.Reset()
+			_ = sub.EncodeVarint(key(1, wireBytes))
+			_ = sub.EncodeStringBytes(l.name)
+			_ = sub.EncodeVarint(key(2, wireBytes))
+			_ = sub.EncodeStringBytes(l.value)
+			_ = ts.EncodeVarint(key(1, wireBytes))
+			_ = ts.EncodeRawBytes(sub.Bytes())
+		}
+		sub.Reset()
+		_ = sub.EncodeVarint(key(1, wireFixed64))
+		_ = sub.EncodeFixed64(math.Float64bits(s.value))
+		_ = sub.EncodeVarint(key(2, wireVarint))
+		_ = sub.EncodeVarint(uint64(s.timestampMs))
+		_ = ts.EncodeVarint(key(2, wireBytes))
+		_ = ts.EncodeRawBytes(sub.Bytes())
+
+		_ = req.EncodeVarint(key(1, wireBytes))
+		_ = req.EncodeRawBytes(ts.Bytes())
+	}
+	return req.Bytes()
+}
@@ -0,0 +1,250 @@
+package pushmetrics
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/config"
+)
+
+func testRegistry(t *testing.T) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "zrepl_test_total", Help: "test"}, []string{"zrepl_job"})
+	c.WithLabelValues("prod").Add(3)
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "zrepl_test_seconds", Help: "test", Buckets: []float64{1, 10}})
+	h.Observe(0.5)
+	h.Observe(5)
+	reg.MustRegister(c, h)
+	return reg
+}
+
+func writePasswordFile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "zrepl-pushmetrics-test")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "password")
+	require.NoError(t, ioutil.WriteFile(path, []byte("secret\n"), 0600))
+	return path
+}
+
+func TestPushgateway(t *testing.T) {
+	type request struct {
+		method, path, body string
+		user, password     string
+	}
+	reqs := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		user, password, _ := r.BasicAuth()
+		reqs <- request{r.Method, r.URL.Path, string(body), user, password}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	p, err := NewPushgatewayFromConfig(&config.PushgatewayMonitoring{
+		MonitoringPushCommon: config.MonitoringPushCommon{
+			URL:       srv.URL,
+			Timeout:   10 * time.Second,
+			BasicAuth: &config.MonitoringPushBasicAuth{Username: "zrepl", PasswordFile: writePasswordFile(t)},
+		},
+		Job:      "zrepl",
+		Instance: "laptop",
+		Grouping: map[string]string{"site": "home"},
+	}, testRegistry(t))
+	require.NoError(t, err)
+	require.NoError(t, p.Push(context.Background()))
+
+	r := <-reqs
+	assert.Equal(t, http.MethodPut, r.method)
+	assert.Equal(t, "/metrics/job/zrepl/instance/laptop/site/home", r.path)
+	assert.Equal(t, "zrepl", r.user)
+	assert.Equal(t, "secret", r.password)
+	// the body is in the protobuf exposition format, the metric name is contained verbatim
+	assert.Contains(t, r.body, "zrepl_test_total")
+
+	for _, reserved := range []string{"job", "instance"} {
+		_, err = NewPushgatewayFromConfig(&config.PushgatewayMonitoring{
+			MonitoringPushCommon: config.MonitoringPushCommon{URL: srv.URL, Timeout: time.Second},
+			Job:                  "zrepl",
+			Grouping:             map[string]string{reserved: "x"},
+		}, testRegistry(t))
+		assert.Error(t, err, reserved)
+	}
+}
+
+type decodedSeries struct {
+	labels      map[string]string
+	value       float64
+	timestampMs int64
+}
+
+// decodeWriteRequest is the inverse of marshalWriteRequest
+func decodeWriteRequest(t *testing.T, b []byte) (ret []decodedSeries) {
+	// fields calls f for each field of the message b with the field's raw value
+	fields := func(b []byte, f func(field uint64, value []byte)) {
+		for len(b) > 0 {
+			key, n := binary.Uvarint(b)
+			require.True(t, n > 0)
+			b = b[n:]
+			var value []byte
+			switch key & 7 {
+			case 0:
+				_, n = binary.Uvarint(b)
+				require.True(t, n > 0)
+				value, b = b[:n], b[n:]
+			case 1:
+				value, b = b[:8], b[8:]
+			case 2:
+				l, n := binary.Uvarint(b)
+				require.True(t, n > 0)
+				value, b = b[n:n+int(l)], b[n+int(l):]
+			default:
+				t.Fatalf("unexpected wire type %d", key&7)
+			}
+			f(key>>3, value)
+		}
+	}
+	fields(b, func(field uint64, value []byte) {
+		require.Equal(t, uint64(1), field)
+		s := decodedSeries{labels: make(map[string]string)}
+		var labelOrder []string
+		fields(value, func(field uint64, value []byte) {
+			switch field {
+			case 1:
+				var name, lvalue string
+				fields(value, func(field uint64, value []byte) {
+					if field == 1 {
+						name = string(value)
+					} else {
+						lvalue = string(value)
+					}
+				})
+				s.labels[name] = lvalue
+				labelOrder = append(labelOrder, name)
+			case 2:
+				fields(value, func(field uint64, value []byte) {
+					if field == 1 {
+						s.value = math.Float64frombits(binary.LittleEndian.Uint64(value))
+					} else {
+						ts, _ := binary.Uvarint(value)
+						s.timestampMs = int64(ts)
+					}
+				})
+			default:
+				t.Fatalf("unexpected field %d", field)
+			}
+		})
+		require.True(t, sort.StringsAreSorted(labelOrder), "labels must be sorted: %v", labelOrder)
+		ret = append(ret, s)
+	})
+	return ret
+}
+
+func TestRemoteWrite(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Encoding") != "snappy" ||
+			r.Header.Get("Content-Type") != "application/x-protobuf" ||
+			r.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		compressed, _ := ioutil.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bodies <- body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	p, err := NewRemoteWriteFromConfig(&config.RemoteWriteMonitoring{
+		MonitoringPushCommon: config.MonitoringPushCommon{URL: srv.URL + "/api/v1/write", Timeout: 10 * time.Second},
+		Job:                  "zrepl",
+		Instance:             "laptop",
+		Labels:               map[string]string{"site": "home"},
+	}, testRegistry(t))
+	require.NoError(t, err)
+	before := time.Now()
+	require.NoError(t, p.Push(context.Background()))
+
+	series := decodeWriteRequest(t, <-bodies)
+	byName := make(map[string]decodedSeries)
+	for _, s := range series {
+		key := s.labels["__name__"]
+		if le, ok := s.labels["le"]; ok {
+			key += "{le=" + le + "}"
+		}
+		byName[key] = s
+		assert.Equal(t, "zrepl", s.labels["job"])
+		assert.Equal(t, "laptop", s.labels["instance"])
+		assert.Equal(t, "home", s.labels["site"])
+		assert.True(t, s.timestampMs >= before.UnixNano()/int64(time.Millisecond))
+	}
+	expect := map[string]float64{
+		"zrepl_test_total":                   3,
+		"zrepl_test_seconds_bucket{le=1}":    1,
+		"zrepl_test_seconds_bucket{le=10}":   2,
+		"zrepl_test_seconds_bucket{le=+Inf}": 2,
+		"zrepl_test_seconds_sum":             5.5,
+		"zrepl_test_seconds_count":           2,
+	}
+	for name, value := range expect {
+		require.Contains(t, byName, name)
+		assert.Equal(t, value, byName[name].value, name)
+	}
+	assert.Equal(t, "prod", byName["zrepl_test_total"].labels["zrepl_job"])
+}
+
+func TestRemoteWriteErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	p, err := NewRemoteWriteFromConfig(&config.RemoteWriteMonitoring{
+		MonitoringPushCommon: config.MonitoringPushCommon{URL: srv.URL, Timeout: 10 * time.Second},
+		Job:                  "zrepl",
+		Instance:             "laptop",
+	}, testRegistry(t))
+	require.NoError(t, err)
+	err = p.Push(context.Background())
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "out of order sample"), err.Error())
+}
+
+func TestEndpointFromConfigValidation(t *testing.T) {
+	_, err := endpointFromConfig(&config.MonitoringPushCommon{URL: "localhost:9091", Timeout: time.Second})
+	assert.Error(t, err)
+
+	_, err = endpointFromConfig(&config.MonitoringPushCommon{
+		URL:     "http://localhost:9091",
+		Timeout: time.Second,
+		TLS:     &config.MonitoringPushTLS{},
+	})
+	assert.Error(t, err, "tls requires https")
+
+	_, err = endpointFromConfig(&config.MonitoringPushCommon{
+		URL:     "https://localhost:9091",
+		Timeout: time.Second,
+		TLS:     &config.MonitoringPushTLS{Cert: "/some/cert"},
+	})
+	assert.Error(t, err, "cert without key")
+}
@@ -236,6 +236,11 @@ func plan(a args, u updater) state {
 
 func snapshot(a args, u updater) state {
 
+	// each snapshotting round is a separate trace
+	ctx, endSpan := trace.WithSpan(trace.WithNewTrace(a.ctx), "snapshot")
+	defer endSpan()
+	a.ctx = ctx
+
 	var plan map[*zfs.DatasetPath]*snapProgress
 	u(func(snapper *Snapper) {
 		plan = snapper.plan
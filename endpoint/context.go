@@ -11,8 +11,39 @@ type contextKey int
 
 const (
 	ClientIdentityKey contextKey = iota
+	capabilitiesKey
 )
 
+// WithCapabilities returns a context for handling requests of a peer
+// with which the given capabilities were negotiated.
+func WithCapabilities(ctx context.Context, capabilities []string) context.Context {
+	if capabilities == nil {
+		capabilities = []string{}
+	}
+	return context.WithValue(ctx, capabilitiesKey, capabilities)
+}
+
+// CapabilitiesFromContext returns the capabilities negotiated with the peer whose request
+// is handled under ctx (see WithCapabilities).
+// ok is false if ctx carries no capabilities, e.g. for local method invocations.
+func CapabilitiesFromContext(ctx context.Context) (capabilities []string, ok bool) {
+	capabilities, ok = ctx.Value(capabilitiesKey).([]string)
+	return capabilities, ok
+}
+
+// HasCapability returns true iff capability was negotiated with the peer whose request
+// is handled under ctx.
+// Handlers use it to enable optional behavior that older peers do not support.
+func HasCapability(ctx context.Context, capability string) bool {
+	capabilities, _ := CapabilitiesFromContext(ctx)
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
 type Logger = logger.Logger
 
 func getLogger(ctx context.Context) Logger {
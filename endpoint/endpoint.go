@@ -13,6 +13,7 @@ import (
 	"github.com/zrepl/zrepl/daemon/logging/trace"
 
 	"github.com/zrepl/zrepl/replication/logic/pdu"
+	"github.com/zrepl/zrepl/util/admission"
 	"github.com/zrepl/zrepl/util/chainedio"
 	"github.com/zrepl/zrepl/util/chainlock"
 	"github.com/zrepl/zrepl/util/envconst"
@@ -24,6 +25,9 @@ type SenderConfig struct {
 	FSF     zfs.DatasetFilter
 	Encrypt *zfs.NilBool
 	JobID   JobID
+
+	// Limits the concurrent sends per client identity, may be nil.
+	Limiter *admission.Limiter
 }
 
 func (c *SenderConfig) Validate() error {
@@ -42,6 +46,7 @@ type Sender struct {
 	FSFilter zfs.DatasetFilter
 	encrypt  *zfs.NilBool
 	jobId    JobID
+	limiter  *admission.Limiter
 }
 
 func NewSender(conf SenderConfig) *Sender {
@@ -52,6 +57,7 @@ func NewSender(conf SenderConfig) *Sender {
 		FSFilter: conf.FSF,
 		encrypt:  conf.Encrypt,
 		jobId:    conf.JobID,
+		limiter:  conf.Limiter,
 	}
 }
 
@@ -178,14 +184,18 @@ func (s *Sender) Send(ctx context.Context, r *pdu.SendReq) (*pdu.SendRes, io.Rea
 	}
 
 	getLogger(ctx).Debug("acquire concurrent send semaphore")
-	// TODO use try-acquire and fail with resource-exhaustion rpc status
-	// => would require handling on the client-side
-	// => this is a dataconn endpoint, doesn't have the status code semantics of gRPC
-	guard, err := maxConcurrentZFSSendSemaphore.Acquire(ctx)
+	releaseSem, releaseClient, err := admit(ctx, maxConcurrentZFSSendSemaphore, "concurrent zfs sends of this daemon", s.limiter)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer guard.Release()
+	defer releaseSem()
+	// the per-client limit covers the entire transfer => released when the send stream is closed
+	streamReturned := false
+	defer func() {
+		if !streamReturned {
+			releaseClient()
+		}
+	}()
 
 	si, err := zfs.ZFSSendDry(ctx, sendArgs)
 	if err != nil {
@@ -299,7 +309,8 @@ func (s *Sender) Send(ctx context.Context, r *pdu.SendReq) (*pdu.SendRes, io.Rea
 		return nil, nil, errors.Wrap(err, "zfs send failed")
 	}
 
-	return res, sendStream, nil
+	streamReturned = true
+	return res, &releaseOnCloseReadCloser{sendStream, releaseClient}, nil
 }
 
 func (p *Sender) SendCompleted(ctx context.Context, r *pdu.SendCompletedReq) (*pdu.SendCompletedRes, error) {
@@ -426,6 +437,9 @@ type ReceiverConfig struct {
 
 	RootWithoutClientComponent *zfs.DatasetPath // TODO use
 	AppendClientIdentity       bool
+
+	// Limits the concurrent receives per client identity, may be nil.
+	Limiter *admission.Limiter
 }
 
 func (c *ReceiverConfig) copyIn() {
@@ -746,14 +760,12 @@ func (s *Receiver) Receive(ctx context.Context, req *pdu.ReceiveReq, receive io.
 	}
 
 	log.Debug("acquire concurrent recv semaphore")
-	// TODO use try-acquire and fail with resource-exhaustion rpc status
-	// => would require handling on the client-side
-	// => this is a dataconn endpoint, doesn't have the status code semantics of gRPC
-	guard, err := maxConcurrentZFSRecvSemaphore.Acquire(ctx)
+	releaseSem, releaseClient, err := admit(ctx, maxConcurrentZFSRecvSemaphore, "concurrent zfs receives of this daemon", s.conf.Limiter)
 	if err != nil {
 		return nil, err
 	}
-	defer guard.Release()
+	defer releaseSem()
+	defer releaseClient()
 
 	var peek bytes.Buffer
 	var MaxPeek = envconst.Int64("ZREPL_ENDPOINT_RECV_PEEK_SIZE", 1<<20)
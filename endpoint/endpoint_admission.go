@@ -0,0 +1,70 @@
+package endpoint
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/zrepl/zrepl/util/admission"
+	"github.com/zrepl/zrepl/util/semaphore"
+)
+
+// admit acquires a slot of the daemon-wide semaphore sem and a slot of the
+// per-client limiter l (which may be nil) for the client whose request is handled under ctx.
+//
+// If the client negotiated admission.Capability, admit does not block but fails with an
+// *admission.ResourceExhaustedError, which dataconn transmits to the client in a dedicated response header.
+// Otherwise, admit blocks until both slots are available, because older clients
+// (and local method invocations of active-side jobs) treat any error as permanent.
+//
+// The returned funcs release the respective slot and are idempotent.
+func admit(ctx context.Context, sem *semaphore.S, semResource string, l *admission.Limiter) (releaseSem, releaseClient func(), err error) {
+	clientIdentity, _ := ctx.Value(ClientIdentityKey).(string)
+
+	var clientGuard, guard *semaphore.AcquireGuard
+	if !HasCapability(ctx, admission.Capability) {
+		clientGuard, err = l.Acquire(ctx, clientIdentity)
+		if err != nil {
+			return nil, nil, err
+		}
+		guard, err = sem.Acquire(ctx)
+		if err != nil {
+			clientGuard.Release()
+			return nil, nil, err
+		}
+	} else {
+		clientGuard, err = l.TryAcquire(clientIdentity)
+		if err != nil {
+			return nil, nil, err
+		}
+		var ok bool
+		guard, ok = sem.TryAcquire()
+		if !ok {
+			clientGuard.Release()
+			return nil, nil, &admission.ResourceExhaustedError{
+				Resource:   semResource,
+				RetryAfter: l.RetryAfter(),
+			}
+		}
+	}
+	// AcquireGuard is not goroutine-safe, but releaseClient may be called from the
+	// goroutine that closes the send stream
+	var clientMtx sync.Mutex
+	releaseClient = func() {
+		clientMtx.Lock()
+		defer clientMtx.Unlock()
+		clientGuard.Release()
+	}
+	return guard.Release, releaseClient, nil
+}
+
+// releaseOnCloseReadCloser calls release after the wrapped io.ReadCloser has been closed.
+type releaseOnCloseReadCloser struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseOnCloseReadCloser) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,52 @@
+package endpoint
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/daemon/logging/trace"
+	"github.com/zrepl/zrepl/util/admission"
+	"github.com/zrepl/zrepl/util/semaphore"
+)
+
+func TestAdmit(t *testing.T) {
+	sem := semaphore.New(1)
+	l := admission.NewLimiter("concurrent transfers", 1, nil, time.Minute)
+
+	ctx := context.Background()
+	defer trace.WithTaskFromStackUpdateCtx(&ctx)()
+	ctx = context.WithValue(ctx, ClientIdentityKey, "client1")
+	capableCtx := WithCapabilities(ctx, []string{admission.Capability})
+
+	releaseSem, releaseClient, err := admit(capableCtx, sem, "concurrent zfs sends", l)
+	require.NoError(t, err)
+
+	// the per-client limit is exhausted
+	_, _, err = admit(capableCtx, sem, "concurrent zfs sends", l)
+	require.IsType(t, &admission.ResourceExhaustedError{}, err)
+	assert.Contains(t, err.Error(), "client1")
+
+	// the daemon-wide limit is exhausted
+	releaseClient()
+	_, _, err = admit(capableCtx, sem, "concurrent zfs sends", l)
+	require.IsType(t, &admission.ResourceExhaustedError{}, err)
+	assert.Contains(t, err.Error(), "concurrent zfs sends")
+
+	// clients without the capability block
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, _, err = admit(timeoutCtx, sem, "concurrent zfs sends", l)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// the failed attempts did not leak slots
+	releaseSem()
+	releaseSem()
+	releaseSem, releaseClient, err = admit(capableCtx, sem, "concurrent zfs sends", l)
+	require.NoError(t, err)
+	releaseSem()
+	releaseClient()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"sort"
 
 	"github.com/pkg/errors"
 	"github.com/zrepl/zrepl/zfs"
@@ -89,3 +90,35 @@ func CreateLastReceivedHold(ctx context.Context, fs string, to zfs.FilesystemVer
 		Tag:               tag,
 	}, nil
 }
+
+// GetMostRecentLastReceivedHoldOfJob returns the snapshot that is held by jobID's last-received-hold on fs.
+// If there is no such snapshot, returns (nil, nil).
+// If there are multiple such snapshots (e.g. due to a crash between the creation of a new hold
+// and the release of the old one), the one with the highest CreateTXG is returned.
+func GetMostRecentLastReceivedHoldOfJob(ctx context.Context, fs string, jobID JobID) (*zfs.FilesystemVersion, error) {
+	q := ListZFSHoldsAndBookmarksQuery{
+		FS: ListZFSHoldsAndBookmarksQueryFilesystemFilter{FS: &fs},
+		What: map[AbstractionType]bool{
+			AbstractionLastReceivedHold: true,
+		},
+		JobID:       &jobID,
+		CreateTXG:   CreateTXGRange{},
+		Concurrency: 1,
+	}
+	abs, absErr, err := ListAbstractions(ctx, q)
+	if err != nil {
+		return nil, errors.Wrap(err, "get last-received-hold: list bookmarks and holds")
+	}
+	if len(absErr) > 0 {
+		return nil, ListAbstractionsErrors(absErr)
+	}
+	if len(abs) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(abs, func(i, j int) bool {
+		return abs[i].GetCreateTXG() < abs[j].GetCreateTXG()
+	})
+	mostRecent := abs[len(abs)-1].GetFilesystemVersion()
+	return &mostRecent, nil
+}
@@ -2,6 +2,8 @@
 package main
 
 import (
+	"os"
+
 	"github.com/zrepl/zrepl/cli"
 	"github.com/zrepl/zrepl/client"
 	"github.com/zrepl/zrepl/daemon"
@@ -18,8 +20,11 @@ func init() {
 	cli.AddSubcommand(client.TestCmd)
 	cli.AddSubcommand(client.MigrateCmd)
 	cli.AddSubcommand(client.ZFSAbstractionsCmd)
+	cli.AddSubcommand(client.MonitorCmd)
+	cli.AddSubcommand(client.LogsCmd)
+	cli.AddSubcommand(client.LoglevelCmd)
 }
 
 func main() {
-	cli.Run()
+	os.Exit(cli.Run())
 }
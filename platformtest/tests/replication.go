@@ -14,6 +14,7 @@ import (
 	"github.com/zrepl/zrepl/endpoint"
 	"github.com/zrepl/zrepl/platformtest"
 	"github.com/zrepl/zrepl/replication"
+	"github.com/zrepl/zrepl/replication/driver"
 	"github.com/zrepl/zrepl/replication/logic"
 	"github.com/zrepl/zrepl/replication/logic/pdu"
 	"github.com/zrepl/zrepl/replication/report"
@@ -73,7 +74,8 @@ func (i replicationInvocation) Do(ctx *platformtest.Context) *report.Report {
 
 	report, wait := replication.Do(
 		ctx,
-		logic.NewPlanner(nil, nil, sender, receiver, plannerPolicy),
+		logic.NewPlanner(nil, nil, nil, sender, receiver, plannerPolicy),
+		driver.Config{Retry: driver.RetryPolicy{MaxAttempts: 3, RetryConnectivityErrors: true}},
 	)
 	wait(true)
 	return report()
@@ -3,6 +3,7 @@ package driver
 import (
 	"context"
 	"fmt"
+	"math/rand"
 	"net"
 	"sort"
 	"strings"
@@ -91,6 +92,14 @@ type attempt struct {
 	// if both are nil, it must be assumed that Planner.Plan is active
 	planErr *timedError
 	fss     []*fs
+
+	stallPolicy StallPolicy
+
+	// retry bookkeeping, see report.AttemptReport
+	retryCount  int
+	maxAttempts int
+	errorClass  string // ReportString of the most recent error's class, empty unless the attempt failed
+	nextRetryAt time.Time
 }
 
 type timedError struct {
@@ -174,16 +183,16 @@ type fs struct {
 type step struct {
 	l    *chainlock.L
 	step Step
+	// non-nil iff the step was aborted by stall detection
+	stalled *timedError
 }
 
 type ReportFunc func() *report.Report
 type WaitFunc func(block bool) (done bool)
 
-var maxAttempts = envconst.Int64("ZREPL_REPLICATION_MAX_ATTEMPTS", 3)
-var reconnectHardFailTimeout = envconst.Duration("ZREPL_REPLICATION_RECONNECT_HARD_FAIL_TIMEOUT", 10*time.Minute)
-
-func Do(ctx context.Context, planner Planner) (ReportFunc, WaitFunc) {
+func Do(ctx context.Context, planner Planner, config Config) (ReportFunc, WaitFunc) {
 	log := getLog(ctx)
+	retryPolicy := config.Retry
 	l := chainlock.New()
 	run := &run{
 		l:         l,
@@ -199,7 +208,7 @@ func Do(ctx context.Context, planner Planner) (ReportFunc, WaitFunc) {
 		defer log.Debug("run ended")
 		var prev *attempt
 		mainLog := log
-		for ano := 0; ano < int(maxAttempts) || maxAttempts == 0; ano++ {
+		for ano := 0; ano < retryPolicy.MaxAttempts || retryPolicy.MaxAttempts == 0; ano++ {
 			log := mainLog.WithField("attempt_number", ano)
 			log.Debug("start attempt")
 
@@ -208,9 +217,12 @@ func Do(ctx context.Context, planner Planner) (ReportFunc, WaitFunc) {
 
 			// do current attempt
 			cur := &attempt{
-				l:         l,
-				startedAt: time.Now(),
-				planner:   planner,
+				l:           l,
+				startedAt:   time.Now(),
+				planner:     planner,
+				stallPolicy: config.Stall,
+				retryCount:  ano,
+				maxAttempts: retryPolicy.MaxAttempts,
 			}
 			run.attempts = append(run.attempts, cur)
 			run.l.DropWhile(func() {
@@ -233,17 +245,49 @@ func Do(ctx context.Context, planner Planner) (ReportFunc, WaitFunc) {
 			}
 
 			mostRecentErr, mostRecentErrClass := errRep.MostRecent()
-			log.WithField("most_recent_err", mostRecentErr).WithField("most_recent_err_class", mostRecentErrClass).Debug("most recent error used for re-connect decision")
+			log.WithField("most_recent_err", mostRecentErr).WithField("most_recent_err_class", mostRecentErrClass).Debug("most recent error used for retry decision")
 			if mostRecentErr == nil {
 				// inconsistent reporting, let's bail out
 				log.Warn("attempt does not report done but error report does not report errors, aborting run")
 				break
 			}
+			cur.errorClass = mostRecentErrClass.ReportString()
 			log.WithError(mostRecentErr.Err).Error("most recent error in this attempt")
-			shouldReconnect := mostRecentErrClass == errorClassTemporaryConnectivityRelated
-			log.WithField("reconnect_decision", shouldReconnect).Debug("reconnect decision made")
-			if shouldReconnect {
-				run.waitReconnect.Set(time.Now(), reconnectHardFailTimeout)
+			shouldRetry := retryPolicy.shouldRetry(mostRecentErrClass)
+			log.WithField("retry_decision", shouldRetry).Debug("retry decision made")
+			if !shouldRetry {
+				log.WithField("error_class", mostRecentErrClass.ReportString()).Error("retry policy does not allow retrying the most recent error, aborting run")
+				return
+			}
+			if retryPolicy.MaxAttempts != 0 && ano+1 >= retryPolicy.MaxAttempts {
+				log.Error("maximum number of attempts reached, aborting run")
+				return
+			}
+
+			delay := retryPolicy.Backoff.Delay(ano, rand.Float64)
+			if hint := errRep.RetryAfterHint(); hint > delay {
+				log.WithField("retry_after_hint", hint).Info("remote asked to retry later than backoff")
+				delay = hint
+			}
+			cur.nextRetryAt = time.Now().Add(delay)
+			log.WithField("next_retry_at", cur.nextRetryAt).Warn("retrying after backoff")
+			var backoffErr error
+			run.l.DropWhile(func() {
+				t := time.NewTimer(time.Until(cur.nextRetryAt))
+				defer t.Stop()
+				select {
+				case <-ctx.Done():
+					backoffErr = ctx.Err()
+				case <-t.C:
+				}
+			})
+			if backoffErr != nil {
+				log.WithError(backoffErr).Info("context error")
+				return
+			}
+
+			if mostRecentErrClass == errorClassTemporaryConnectivityRelated {
+				run.waitReconnect.Set(time.Now(), retryPolicy.ReconnectTimeout)
 				log.WithField("deadline", run.waitReconnect.End()).Error("temporary connectivity-related error identified, start waiting for reconnect")
 				var connectErr error
 				var connectErrTime time.Time
@@ -261,11 +305,7 @@ func Do(ctx context.Context, planner Planner) (ReportFunc, WaitFunc) {
 					log.WithError(connectErr).Error("reconnecting failed, aborting run")
 					break
 				}
-			} else {
-				log.Error("most recent error cannot be solved by reconnecting, aborting run")
-				return
 			}
-
 		}
 
 	}()
@@ -422,7 +462,7 @@ func (a *attempt) doFilesystems(ctx context.Context, prevs map[*fs]*fs) {
 			// avoid explosion of tasks with name f.report().Info.Name
 			ctx, endTask := trace.WithTaskAndSpan(ctx, "repl-fs", f.report().Info.Name)
 			defer endTask()
-			f.do(ctx, stepQueue, prevs[f])
+			f.do(ctx, stepQueue, prevs[f], a.stallPolicy)
 		}(f)
 	}
 	a.l.DropWhile(func() {
@@ -452,11 +492,24 @@ func (f *fs) initialRepOrdWakeupChildren() {
 	}
 }
 
-func (f *fs) do(ctx context.Context, pq *stepQueue, prev *fs) {
+func (f *fs) do(ctx context.Context, pq *stepQueue, prev *fs, stallPolicy StallPolicy) {
 
 	defer f.l.Lock().Unlock()
 	defer f.initialRepOrdWakeupChildren()
 
+	// only retry filesystems that failed in the previous attempt:
+	// filesystems that completed all their steps are carried over without re-planning
+	if prev != nil && prev.planning.done && prev.planning.err == nil &&
+		prev.planned.stepErr == nil && prev.planned.step >= len(prev.planned.steps) {
+		f.debug("previous attempt completed all steps, not re-planning")
+		for _, s := range prev.planned.steps {
+			f.planned.steps = append(f.planned.steps, &step{l: f.l, step: s.step})
+		}
+		f.planned.step = len(f.planned.steps)
+		f.planning.done = true
+		return
+	}
+
 	// get planned steps from replication logic
 	var psteps []Step
 	var errTime time.Time
@@ -630,9 +683,18 @@ func (f *fs) do(ctx context.Context, pq *stepQueue, prev *fs) {
 			// do the step
 			ctx, endSpan := trace.WithSpan(ctx, fmt.Sprintf("%#v", s.step.ReportInfo()))
 			defer endSpan()
+			ctx, watchdog := stallPolicy.watchStep(ctx, s.step)
 			err, errTime = s.step.Step(ctx), time.Now() // no shadow
+			if stalled := watchdog.Stop(); stalled != nil {
+				// the step's error is a consequence of the watchdog cancelling its context
+				err = stalled
+			}
 		})
 
+		if stalled, ok := err.(*StepStalledError); ok {
+			f.debug("step stalled: %s", stalled)
+			s.stalled = newTimedError(stalled, errTime)
+		}
 		if err != nil {
 			f.planned.stepErr = newTimedError(err, errTime)
 			break
@@ -669,6 +731,10 @@ func (a *attempt) report() *report.AttemptReport {
 		StartAt:     a.startedAt,
 		FinishAt:    a.finishedAt,
 		PlanError:   a.planErr.IntoReportError(),
+		RetryCount:  a.retryCount,
+		MaxAttempts: a.maxAttempts,
+		ErrorClass:  a.errorClass,
+		NextRetryAt: a.nextRetryAt,
 	}
 
 	for i := range r.Filesystems {
@@ -730,7 +796,8 @@ func (f *fs) report() *report.FilesystemReport {
 // caller must hold lock l
 func (s *step) report() *report.StepReport {
 	r := &report.StepReport{
-		Info: s.step.ReportInfo(),
+		Info:    s.step.ReportInfo(),
+		Stalled: s.stalled.IntoReportError(),
 	}
 	return r
 }
@@ -743,6 +810,19 @@ const (
 	errorClassTemporaryConnectivityRelated
 )
 
+// ReportString returns the name of the error class as used in the
+// replication.retry.on_error config and in report.AttemptReport.
+func (c errorClass) ReportString() string {
+	switch c {
+	case errorClassPermanent:
+		return "permanent"
+	case errorClassTemporaryConnectivityRelated:
+		return "network"
+	default:
+		return c.String()
+	}
+}
+
 type errorReport struct {
 	flattened []*timedError
 	// sorted DESCending by err time
@@ -772,6 +852,11 @@ func (a *attempt) errorReport() *errorReport {
 			r.byClass[class] = errs
 		}
 		for _, err := range r.flattened {
+			if _, ok := err.Err.(*StepStalledError); ok {
+				// resuming the step in a new attempt might get the transfer going again
+				putClass(err, errorClassTemporaryConnectivityRelated)
+				continue
+			}
 			if neterr, ok := err.Err.(net.Error); ok && neterr.Temporary() {
 				putClass(err, errorClassTemporaryConnectivityRelated)
 				continue
@@ -804,6 +889,23 @@ func (r *errorReport) AnyError() *timedError {
 	return nil
 }
 
+// retryAfterHinter is implemented by errors that indicate when the failed
+// operation should be retried at the earliest, e.g. *admission.ResourceExhaustedError.
+type retryAfterHinter interface {
+	RetryAfterHint() time.Duration
+}
+
+// RetryAfterHint returns the maximum retry-after hint of all errors in the report,
+// or 0 if no error carries one.
+func (r *errorReport) RetryAfterHint() (hint time.Duration) {
+	for _, err := range r.flattened {
+		if h, ok := err.Err.(retryAfterHinter); ok && h.RetryAfterHint() > hint {
+			hint = h.RetryAfterHint()
+		}
+	}
+	return hint
+}
+
 func (r *errorReport) MostRecent() (err *timedError, errClass errorClass) {
 	for class, errs := range r.byClass {
 		// errs are sorted descendingly during construction
@@ -0,0 +1,109 @@
+package driver
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zrepl/zrepl/config"
+)
+
+// RetryPolicy determines whether and when a run starts another attempt
+// after an attempt failed.
+type RetryPolicy struct {
+	// Maximum number of attempts per run, 0 means unlimited.
+	MaxAttempts int
+	// Maximum time to wait for connectivity to be restored after a
+	// connectivity-related error, 0 means wait indefinitely.
+	ReconnectTimeout time.Duration
+	// Delay between attempts.
+	Backoff Backoff
+	// Whether to retry if the most recent error of an attempt is connectivity-related.
+	RetryConnectivityErrors bool
+	// Whether to retry if the most recent error of an attempt is permanent (e.g. a ZFS error).
+	RetryPermanentErrors bool
+}
+
+func (p RetryPolicy) shouldRetry(class errorClass) bool {
+	switch class {
+	case errorClassTemporaryConnectivityRelated:
+		return p.RetryConnectivityErrors
+	case errorClassPermanent:
+		return p.RetryPermanentErrors
+	default:
+		panic(class)
+	}
+}
+
+// Backoff computes the delay before the n-th retry as
+// min(Initial * Multiplier^n, Max), randomly varied by +/- Jitter * delay.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration // 0 means no upper bound
+	Multiplier float64
+	Jitter     float64 // in [0, 1]
+}
+
+// Delay returns the delay before retry number retry (starting at 0).
+// randFloat must return values in [0, 1), e.g. rand.Float64.
+func (b Backoff) Delay(retry int, randFloat func() float64) time.Duration {
+	d := float64(b.Initial) * math.Pow(b.Multiplier, float64(retry))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	d += d * b.Jitter * (2*randFloat() - 1)
+	if d < 0 || math.IsNaN(d) {
+		return 0
+	}
+	if d > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+func RetryPolicyFromConfig(in *config.ReplicationOptionsRetry) (*RetryPolicy, error) {
+	if in.MaxAttempts < 0 {
+		return nil, errors.New("field 'max_attempts' must not be negative")
+	}
+	if in.Backoff.Multiplier < 1 {
+		return nil, errors.New("field 'backoff.multiplier' must be >= 1")
+	}
+	if in.Backoff.Jitter < 0 || in.Backoff.Jitter > 1 {
+		return nil, errors.New("field 'backoff.jitter' must be in [0, 1]")
+	}
+	if in.Backoff.Max != 0 && in.Backoff.Max < in.Backoff.Initial {
+		return nil, errors.New("field 'backoff.max' must not be less than 'backoff.initial'")
+	}
+	network, err := retryDecisionFromConfig(in.OnError.Network)
+	if err != nil {
+		return nil, errors.Wrap(err, "field 'on_error.network'")
+	}
+	permanent, err := retryDecisionFromConfig(in.OnError.Permanent)
+	if err != nil {
+		return nil, errors.Wrap(err, "field 'on_error.permanent'")
+	}
+	return &RetryPolicy{
+		MaxAttempts:      in.MaxAttempts,
+		ReconnectTimeout: in.ReconnectTimeout,
+		Backoff: Backoff{
+			Initial:    in.Backoff.Initial,
+			Max:        in.Backoff.Max,
+			Multiplier: in.Backoff.Multiplier,
+			Jitter:     in.Backoff.Jitter,
+		},
+		RetryConnectivityErrors: network,
+		RetryPermanentErrors:    permanent,
+	}, nil
+}
+
+func retryDecisionFromConfig(in string) (retry bool, _ error) {
+	switch in {
+	case "retry":
+		return true, nil
+	case "fail":
+		return false, nil
+	default:
+		return false, errors.Errorf("%q is not in {retry,fail}", in)
+	}
+}
@@ -0,0 +1,199 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/daemon/logging/trace"
+	"github.com/zrepl/zrepl/replication/report"
+	"github.com/zrepl/zrepl/util/admission"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{
+		Initial:    10 * time.Second,
+		Max:        time.Minute,
+		Multiplier: 2,
+	}
+	noJitter := func() float64 { return 0.5 }
+	assert.Equal(t, 10*time.Second, b.Delay(0, noJitter))
+	assert.Equal(t, 20*time.Second, b.Delay(1, noJitter))
+	assert.Equal(t, 40*time.Second, b.Delay(2, noJitter))
+	assert.Equal(t, time.Minute, b.Delay(3, noJitter))
+	assert.Equal(t, time.Minute, b.Delay(1000, noJitter))
+
+	b.Jitter = 0.5
+	assert.Equal(t, 5*time.Second, b.Delay(0, func() float64 { return 0 }))
+	assert.Equal(t, 10*time.Second, b.Delay(0, noJitter))
+	assert.Equal(t, 15*time.Second, b.Delay(0, func() float64 { return 1 }))
+
+	b.Max = 0
+	assert.Equal(t, 80*time.Second, b.Delay(3, noJitter))
+
+	assert.Equal(t, time.Duration(0), Backoff{}.Delay(3, noJitter))
+}
+
+func TestResourceExhaustedIsTemporaryWithRetryAfterHint(t *testing.T) {
+	busy := &admission.ResourceExhaustedError{Resource: "concurrent zfs sends", RetryAfter: time.Minute}
+	a := &attempt{planErr: newTimedError(busy, time.Now())}
+	rep := a.errorReport()
+	_, class := rep.MostRecent()
+	assert.Equal(t, errorClassTemporaryConnectivityRelated, class)
+	assert.Equal(t, time.Minute, rep.RetryAfterHint())
+
+	a = &attempt{planErr: newTimedError(fmt.Errorf("some error"), time.Now())}
+	assert.Equal(t, time.Duration(0), a.errorReport().RetryAfterHint())
+}
+
+type temporaryNetError struct{}
+
+func (temporaryNetError) Error() string   { return "connection reset" }
+func (temporaryNetError) Timeout() bool   { return false }
+func (temporaryNetError) Temporary() bool { return true }
+
+var _ net.Error = temporaryNetError{}
+
+// retryTestPlanner plans the same filesystems in each attempt, each with a single step.
+// The step of a filesystem fails with stepErrs[fs][n] in the n-th attempt,
+// and succeeds if there is no such entry.
+type retryTestPlanner struct {
+	fss      []string
+	stepErrs map[string][]error
+
+	mtx      sync.Mutex
+	attempts int
+	planned  map[string]int // number of PlanFS calls by filesystem
+}
+
+func (p *retryTestPlanner) Plan(ctx context.Context) ([]FS, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	attempt := p.attempts
+	p.attempts++
+	fss := make([]FS, len(p.fss))
+	for i, name := range p.fss {
+		fss[i] = &retryTestFS{p, name, attempt}
+	}
+	return fss, nil
+}
+
+func (p *retryTestPlanner) WaitForConnectivity(context.Context) error { return nil }
+
+type retryTestFS struct {
+	p       *retryTestPlanner
+	name    string
+	attempt int
+}
+
+func (f *retryTestFS) EqualToPreviousAttempt(other FS) bool {
+	return f.name == other.(*retryTestFS).name
+}
+
+func (f *retryTestFS) PlanFS(ctx context.Context) ([]Step, error) {
+	f.p.mtx.Lock()
+	defer f.p.mtx.Unlock()
+	if f.p.planned == nil {
+		f.p.planned = make(map[string]int)
+	}
+	f.p.planned[f.name]++
+	var err error
+	if errs := f.p.stepErrs[f.name]; f.attempt < len(errs) {
+		err = errs[f.attempt]
+	}
+	return []Step{&retryTestStep{err}}, nil
+}
+
+func (f *retryTestFS) ReportInfo() *report.FilesystemInfo {
+	return &report.FilesystemInfo{Name: f.name}
+}
+
+type retryTestStep struct {
+	err error
+}
+
+func (s *retryTestStep) TargetEquals(other Step) bool   { return true }
+func (s *retryTestStep) TargetDate() time.Time          { return time.Unix(1, 0) }
+func (s *retryTestStep) Step(ctx context.Context) error { return s.err }
+func (s *retryTestStep) ReportInfo() *report.StepInfo {
+	return &report.StepInfo{To: "@1"}
+}
+
+func TestRetryDecisionByErrorClass(t *testing.T) {
+	ctx := context.Background()
+	defer trace.WithTaskFromStackUpdateCtx(&ctx)()
+
+	tcs := []struct {
+		name           string
+		err            error
+		policy         RetryPolicy
+		expectAttempts int
+		expectClass    string
+	}{
+		{"network_retry", temporaryNetError{}, RetryPolicy{RetryConnectivityErrors: true}, 2, "network"},
+		{"network_fail", temporaryNetError{}, RetryPolicy{RetryPermanentErrors: true}, 1, "network"},
+		{"permanent_retry", fmt.Errorf("zfs error"), RetryPolicy{RetryPermanentErrors: true}, 2, "permanent"},
+		{"permanent_fail", fmt.Errorf("zfs error"), RetryPolicy{RetryConnectivityErrors: true}, 1, "permanent"},
+		{"stalled_is_network", &StepStalledError{}, RetryPolicy{RetryConnectivityErrors: true}, 2, "network"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &retryTestPlanner{
+				fss:      []string{"pool/a"},
+				stepErrs: map[string][]error{"pool/a": {tc.err}},
+			}
+			tc.policy.MaxAttempts = 3
+			getReport, wait := Do(ctx, p, Config{Retry: tc.policy})
+			wait(true)
+			rep := getReport()
+			require.Len(t, rep.Attempts, tc.expectAttempts)
+			assert.Equal(t, tc.expectClass, rep.Attempts[0].ErrorClass)
+			last := rep.Attempts[len(rep.Attempts)-1]
+			if tc.expectAttempts > 1 {
+				assert.Equal(t, report.AttemptDone, last.State)
+			} else {
+				assert.NotEqual(t, report.AttemptDone, last.State)
+			}
+		})
+	}
+
+	t.Run("max_attempts", func(t *testing.T) {
+		p := &retryTestPlanner{
+			fss:      []string{"pool/a"},
+			stepErrs: map[string][]error{"pool/a": {fmt.Errorf("1"), fmt.Errorf("2"), fmt.Errorf("3")}},
+		}
+		getReport, wait := Do(ctx, p, Config{Retry: RetryPolicy{MaxAttempts: 2, RetryPermanentErrors: true}})
+		wait(true)
+		assert.Len(t, getReport().Attempts, 2)
+	})
+}
+
+func TestRetryCarriesOverCompletedFilesystems(t *testing.T) {
+	ctx := context.Background()
+	defer trace.WithTaskFromStackUpdateCtx(&ctx)()
+
+	p := &retryTestPlanner{
+		fss: []string{"pool/a", "pool/b"},
+		stepErrs: map[string][]error{
+			"pool/b": {fmt.Errorf("zfs error"), nil},
+		},
+	}
+	getReport, wait := Do(ctx, p, Config{Retry: RetryPolicy{MaxAttempts: 3, RetryPermanentErrors: true}})
+	wait(true)
+
+	rep := getReport()
+	require.Len(t, rep.Attempts, 2)
+	assert.Equal(t, report.AttemptDone, rep.Attempts[1].State)
+	// pool/a completed in the first attempt and is not planned again
+	assert.Equal(t, map[string]int{"pool/a": 1, "pool/b": 2}, p.planned)
+	for _, fs := range rep.Attempts[1].Filesystems {
+		assert.Equal(t, report.FilesystemDone, fs.State, fs.Info.Name)
+		require.Len(t, fs.Steps, 1, fs.Info.Name)
+	}
+}
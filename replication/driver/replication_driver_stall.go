@@ -0,0 +1,153 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zrepl/zrepl/config"
+)
+
+// Config bundles the policies that the driver applies to a replication run.
+type Config struct {
+	Retry RetryPolicy
+	Stall StallPolicy
+}
+
+func ConfigFromConfig(in *config.Replication) (*Config, error) {
+	retry, err := RetryPolicyFromConfig(in.Retry)
+	if err != nil {
+		return nil, errors.Wrap(err, "field 'retry'")
+	}
+	stall, err := StallPolicyFromConfig(in)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{Retry: *retry, Stall: *stall}, nil
+}
+
+// StallPolicy determines when a step is considered stalled.
+//
+// A step is stalled if it replicates less than max(1, MinThroughput * Window)
+// bytes during any window of length Window, as reported by
+// Step.ReportInfo().BytesReplicated.
+type StallPolicy struct {
+	Window        time.Duration // 0 disables stall detection
+	MinThroughput int64         // bytes per second
+
+	// newTicker returns a channel that delivers a tick every Window, replaceable for tests
+	newTicker func(d time.Duration) (ticks <-chan time.Time, stop func())
+}
+
+func newTimeTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+func StallPolicyFromConfig(in *config.Replication) (*StallPolicy, error) {
+	if in.MinThroughput < 0 {
+		return nil, errors.New("field 'min_throughput' must not be negative")
+	}
+	if in.MinThroughput > 0 && in.StepTimeout == 0 {
+		return nil, errors.New("field 'min_throughput' requires field 'step_timeout' to be set")
+	}
+	return &StallPolicy{
+		Window:        in.StepTimeout,
+		MinThroughput: in.MinThroughput,
+	}, nil
+}
+
+func (p StallPolicy) minBytesPerWindow() int64 {
+	min := int64(p.Window.Seconds() * float64(p.MinThroughput))
+	if min < 1 {
+		min = 1
+	}
+	return min
+}
+
+// StepStalledError is the error of a step that was aborted
+// because it did not make sufficient progress.
+type StepStalledError struct {
+	Window            time.Duration
+	BytesReplicated   int64 // during the window
+	MinBytesPerWindow int64
+}
+
+func (e *StepStalledError) Error() string {
+	return fmt.Sprintf("step stalled: replicated %d bytes in %s, expected at least %d bytes",
+		e.BytesReplicated, e.Window, e.MinBytesPerWindow)
+}
+
+// stallWatchdog observes the progress of a single step.
+type stallWatchdog struct {
+	mtx     sync.Mutex
+	stalled *StepStalledError
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// watchStep returns a context derived from ctx that is cancelled if step stalls.
+// Cancelling the context aborts the step's RPCs and kills the zfs processes that
+// were started with it (see zfscmd.CommandContext).
+// The caller must call the returned watchdog's Stop method after the step returned.
+func (p StallPolicy) watchStep(ctx context.Context, step Step) (context.Context, *stallWatchdog) {
+	w := &stallWatchdog{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if p.Window == 0 {
+		close(w.done)
+		return ctx, w
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	last := step.ReportInfo().BytesReplicated
+	go func() {
+		defer close(w.done)
+		defer cancel()
+		newTicker := p.newTicker
+		if newTicker == nil {
+			newTicker = newTimeTicker
+		}
+		ticks, stopTicker := newTicker(p.Window)
+		defer stopTicker()
+		min := p.minBytesPerWindow()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticks:
+				cur := step.ReportInfo().BytesReplicated
+				if cur-last < min {
+					w.mtx.Lock()
+					w.stalled = &StepStalledError{
+						Window:            p.Window,
+						BytesReplicated:   cur - last,
+						MinBytesPerWindow: min,
+					}
+					w.mtx.Unlock()
+					return
+				}
+				last = cur
+			}
+		}
+	}()
+	return ctx, w
+}
+
+// Stop stops the watchdog and returns a non-nil error iff it detected a stall.
+func (w *stallWatchdog) Stop() *StepStalledError {
+	select {
+	case <-w.done:
+	default:
+		close(w.stop)
+		<-w.done
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.stalled
+}
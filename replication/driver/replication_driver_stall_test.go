@@ -0,0 +1,108 @@
+package driver
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/replication/report"
+)
+
+type stallTestStep struct {
+	replicated int64
+	sampled    chan struct{} // receives when the watchdog sampled the progress
+}
+
+func newStallTestStep() *stallTestStep {
+	return &stallTestStep{sampled: make(chan struct{}, 1)}
+}
+
+func (s *stallTestStep) TargetEquals(other Step) bool { return s == other }
+func (s *stallTestStep) TargetDate() time.Time        { return time.Time{} }
+func (s *stallTestStep) Step(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+func (s *stallTestStep) ReportInfo() *report.StepInfo {
+	info := &report.StepInfo{BytesReplicated: atomic.LoadInt64(&s.replicated)}
+	select {
+	case s.sampled <- struct{}{}:
+	default:
+	}
+	return info
+}
+
+// manualTicker delivers a tick whenever tick is called.
+type manualTicker chan time.Time
+
+func (m manualTicker) newTicker(d time.Duration) (<-chan time.Time, func()) {
+	return m, func() {}
+}
+
+// tick returns once the watchdog sampled the progress of s for this tick.
+// The caller must have drained the sample taken by watchStep.
+func (m manualTicker) tick(s *stallTestStep) {
+	m <- time.Time{}
+	<-s.sampled
+}
+
+func TestStallWatchdog(t *testing.T) {
+
+	t.Run("disabled", func(t *testing.T) {
+		ctx, w := StallPolicy{}.watchStep(context.Background(), newStallTestStep())
+		assert.Nil(t, ctx.Done())
+		assert.Nil(t, w.Stop())
+	})
+
+	t.Run("no_progress", func(t *testing.T) {
+		s := newStallTestStep()
+		ticker := make(manualTicker)
+		ctx, w := StallPolicy{Window: time.Minute, newTicker: ticker.newTicker}.watchStep(context.Background(), s)
+		<-s.sampled
+		ticker.tick(s)
+		err := s.Step(ctx)
+		assert.Equal(t, context.Canceled, err)
+		stalled := w.Stop()
+		require.NotNil(t, stalled)
+		assert.Equal(t, int64(0), stalled.BytesReplicated)
+		assert.Equal(t, int64(1), stalled.MinBytesPerWindow)
+		assert.Equal(t, time.Minute, stalled.Window)
+	})
+
+	t.Run("below_min_throughput", func(t *testing.T) {
+		s := newStallTestStep()
+		ticker := make(manualTicker)
+		p := StallPolicy{Window: 10 * time.Second, MinThroughput: 1 << 20, newTicker: ticker.newTicker}
+		ctx, w := p.watchStep(context.Background(), s)
+		<-s.sampled
+		atomic.AddInt64(&s.replicated, 10<<20) // sufficient progress in the first window
+		ticker.tick(s)
+		atomic.AddInt64(&s.replicated, 1<<10)
+		ticker.tick(s)
+		<-ctx.Done()
+		stalled := w.Stop()
+		require.NotNil(t, stalled)
+		assert.Equal(t, int64(1<<10), stalled.BytesReplicated)
+		assert.Equal(t, int64(10<<20), stalled.MinBytesPerWindow)
+	})
+
+	t.Run("progress", func(t *testing.T) {
+		s := newStallTestStep()
+		ticker := make(manualTicker)
+		ctx, w := StallPolicy{Window: time.Minute, newTicker: ticker.newTicker}.watchStep(context.Background(), s)
+		<-s.sampled
+		for i := 0; i < 10; i++ {
+			atomic.AddInt64(&s.replicated, 1)
+			ticker.tick(s)
+		}
+		atomic.AddInt64(&s.replicated, 1)
+		ticker.tick(s)
+		assert.NoError(t, ctx.Err())
+		assert.Nil(t, w.Stop())
+		assert.Error(t, ctx.Err(), "watchdog must release its context on Stop")
+	})
+}
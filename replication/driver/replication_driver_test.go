@@ -153,7 +153,7 @@ func TestReplication(t *testing.T) {
 	defer trace.WithTaskFromStackUpdateCtx(&ctx)()
 
 	mp := &mockPlanner{}
-	getReport, wait := Do(ctx, mp)
+	getReport, wait := Do(ctx, mp, Config{Retry: RetryPolicy{MaxAttempts: 3, RetryConnectivityErrors: true}})
 	begin := time.Now()
 	fireAt := []time.Duration{
 		// the following values are relative to the start
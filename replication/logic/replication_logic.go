@@ -18,6 +18,7 @@ import (
 	"github.com/zrepl/zrepl/replication/report"
 	"github.com/zrepl/zrepl/util/bytecounter"
 	"github.com/zrepl/zrepl/util/chainlock"
+	"github.com/zrepl/zrepl/util/compressionstats"
 	"github.com/zrepl/zrepl/util/envconst"
 	"github.com/zrepl/zrepl/util/semaphore"
 	"github.com/zrepl/zrepl/zfs"
@@ -59,6 +60,7 @@ type Planner struct {
 
 	promSecsPerState    *prometheus.HistogramVec // labels: state
 	promBytesReplicated *prometheus.CounterVec   // labels: filesystem
+	freshness           *FreshnessMetrics        // may be nil
 }
 
 func (p *Planner) Plan(ctx context.Context) ([]driver.FS, error) {
@@ -121,6 +123,7 @@ type Filesystem struct {
 	Path                 string             // compat
 	receiverFS, senderFS *pdu.Filesystem    // receiverFS may be nil, senderFS never nil
 	promBytesReplicated  prometheus.Counter // compat
+	freshness            *FreshnessMetrics  // may be nil
 
 	sizeEstimateRequestSem *semaphore.S
 }
@@ -135,7 +138,7 @@ func (f *Filesystem) EqualToPreviousAttempt(other driver.FS) bool {
 }
 
 func (f *Filesystem) PlanFS(ctx context.Context) ([]driver.Step, error) {
-	steps, err := f.doPlanning(ctx)
+	steps, err := f.doPlanning(ctx, &fsPlanningInfo{})
 	if err != nil {
 		return nil, err
 	}
@@ -164,6 +167,9 @@ type Step struct {
 	// => concurrent read of that pointer from Step.ReportInfo must be protected
 	byteCounter    bytecounter.ReadCloser
 	byteCounterMtx chainlock.L
+
+	// filled by the rpc layer if the stream is compressed on the wire
+	compressionStats compressionstats.Stats
 }
 
 func (s *Step) TargetEquals(other driver.Step) bool {
@@ -218,16 +224,20 @@ func (s *Step) ReportInfo() *report.StepInfo {
 		Encrypted:       encrypted,
 		BytesExpected:   s.expectedSize,
 		BytesReplicated: byteCounter,
+		Compression:     s.compressionStats.Algorithm(),
+		BytesOnWire:     s.compressionStats.Compressed(),
 	}
 }
 
-func NewPlanner(secsPerState *prometheus.HistogramVec, bytesReplicated *prometheus.CounterVec, sender Sender, receiver Receiver, policy PlannerPolicy) *Planner {
+// freshness may be nil.
+func NewPlanner(secsPerState *prometheus.HistogramVec, bytesReplicated *prometheus.CounterVec, freshness *FreshnessMetrics, sender Sender, receiver Receiver, policy PlannerPolicy) *Planner {
 	return &Planner{
 		sender:              sender,
 		receiver:            receiver,
 		policy:              policy,
 		promSecsPerState:    secsPerState,
 		promBytesReplicated: bytesReplicated,
+		freshness:           freshness,
 	}
 }
 func resolveConflict(conflict error) (path []*pdu.FilesystemVersion, msg string) {
@@ -296,14 +306,30 @@ func (p *Planner) doPlanning(ctx context.Context) ([]*Filesystem, error) {
 			senderFS:               fs,
 			receiverFS:             receiverFS,
 			promBytesReplicated:    ctr,
+			freshness:              p.freshness,
 			sizeEstimateRequestSem: sizeEstimateRequestSem,
 		})
 	}
 
+	paths := make([]string, len(q))
+	for i := range q {
+		paths[i] = q[i].Path
+	}
+	p.freshness.retainFilesystems(paths)
+
 	return q, nil
 }
 
-func (fs *Filesystem) doPlanning(ctx context.Context) ([]*Step, error) {
+// fsPlanningInfo captures intermediate results of Filesystem.doPlanning
+// that are not represented in the returned steps.
+type fsPlanningInfo struct {
+	commonAncestor     *pdu.FilesystemVersion // nil if there is none or it could not be determined
+	resumeToken        string                 // empty if no resume token is used
+	conflict           error                  // nil if there was no conflict
+	conflictResolution string                 // explanation of the resolution or why there is none
+}
+
+func (fs *Filesystem) doPlanning(ctx context.Context, info *fsPlanningInfo) ([]*Step, error) {
 
 	log := func(ctx context.Context) logger.Logger {
 		return getLogger(ctx).WithField("filesystem", fs.Path)
@@ -322,6 +348,8 @@ func (fs *Filesystem) doPlanning(ctx context.Context) ([]*Step, error) {
 	}
 	sfsvs := sfsvsres.GetVersions()
 
+	fs.freshness.observeVersions(fs.Path, FreshnessSideSender, sfsvs)
+
 	if len(sfsvs) < 1 {
 		err := errors.New("sender does not have any versions")
 		log(ctx).Error(err.Error())
@@ -339,6 +367,7 @@ func (fs *Filesystem) doPlanning(ctx context.Context) ([]*Step, error) {
 	} else {
 		rfsvs = []*pdu.FilesystemVersion{}
 	}
+	fs.freshness.observeVersions(fs.Path, FreshnessSideReceiver, rfsvs)
 
 	var resumeToken *zfs.ResumeToken
 	var resumeTokenRaw string
@@ -410,6 +439,8 @@ func (fs *Filesystem) doPlanning(ctx context.Context) ([]*Step, error) {
 		} else if fromVersion == toVersion {
 			return nil, fmt.Errorf("resume token `fromguid` and `toguid` match same version on sener")
 		}
+		info.commonAncestor = fromVersion
+		info.resumeToken = resumeTokenRaw
 		// fromVersion may be nil, toVersion is no nil, encryption matches
 		// good to go this one step!
 		resumeStep := &Step{
@@ -450,9 +481,21 @@ func (fs *Filesystem) doPlanning(ctx context.Context) ([]*Step, error) {
 		}
 	} else { // resumeToken == nil
 		path, conflict := IncrementalPath(rfsvs, sfsvs)
+		if conflict == nil {
+			if len(path) > 0 {
+				info.commonAncestor = path[0]
+			} else if sorted := SortVersionListByCreateTXGThenBookmarkLTSnapshot(rfsvs); len(sorted) > 0 {
+				// up to date, the receiver's most recent version is the common ancestor
+				info.commonAncestor = sorted[len(sorted)-1]
+			}
+		} else if diverged, ok := conflict.(*ConflictDiverged); ok {
+			info.commonAncestor = diverged.CommonAncestor
+		}
 		if conflict != nil {
 			var msg string
 			path, msg = resolveConflict(conflict) // no shadowing allowed!
+			info.conflict = conflict
+			info.conflictResolution = msg
 			if path != nil {
 				log(ctx).WithField("conflict", conflict).Info("conflict")
 				log(ctx).WithField("resolution", msg).Info("automatically resolved")
@@ -580,6 +623,8 @@ func (s *Step) doReplication(ctx context.Context) error {
 	log := getLogger(ctx).WithField("filesystem", fs)
 	sr := s.buildSendRequest(false)
 
+	ctx = compressionstats.WithStats(ctx, &s.compressionStats)
+
 	log.Debug("initiate send request")
 	sres, stream, err := s.sender.Send(ctx, sr)
 	if err != nil {
@@ -639,6 +684,7 @@ func (s *Step) doReplication(ctx context.Context) error {
 		log.WithError(err).Error("error telling sender that replication completed successfully")
 		return err
 	}
+	s.parent.freshness.observeStepCompleted(fs, s.to, time.Now())
 
 	return err
 }
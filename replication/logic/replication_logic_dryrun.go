@@ -0,0 +1,67 @@
+package logic
+
+import (
+	"context"
+
+	"github.com/zrepl/zrepl/replication/report"
+)
+
+// FilesystemDryRunReport describes what replication would do for a single filesystem.
+type FilesystemDryRunReport struct {
+	Filesystem string
+	// RelName of the most recent common version of sender and receiver, empty if there is none
+	CommonAncestor string
+	// Receive resume token that the first step would use, empty if none
+	ResumeToken string
+	// Conflict between sender and receiver, empty if there is none
+	Conflict string
+	// Only valid if Conflict != "": how the conflict would be resolved
+	// or why it cannot be resolved automatically (in which case Steps is empty)
+	ConflictResolution string
+	// Steps that would be replicated, including size estimates
+	Steps []*report.StepInfo
+	// Error that aborted planning for this filesystem, empty on success
+	PlanError string
+}
+
+// DryRun performs the same planning as Plan and (*Filesystem).PlanFS,
+// including the size estimation via dry-run send requests,
+// but does not replicate.
+//
+// Planning does not create holds, bookmarks or placeholder filesystems
+// on either side, which makes DryRun safe to use against production setups.
+//
+// The returned error is non-nil if the list of filesystems cannot be determined.
+// Errors that occur while planning an individual filesystem are reported
+// in its FilesystemDryRunReport.
+func (p *Planner) DryRun(ctx context.Context) ([]*FilesystemDryRunReport, error) {
+	fss, err := p.doPlanning(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]*FilesystemDryRunReport, len(fss))
+	for i, fs := range fss {
+		var info fsPlanningInfo
+		steps, err := fs.doPlanning(ctx, &info)
+		r := &FilesystemDryRunReport{
+			Filesystem:  fs.Path,
+			ResumeToken: info.resumeToken,
+		}
+		if info.commonAncestor != nil {
+			r.CommonAncestor = info.commonAncestor.RelName()
+		}
+		if info.conflict != nil {
+			r.Conflict = info.conflict.Error()
+			r.ConflictResolution = info.conflictResolution
+		}
+		if err != nil {
+			r.PlanError = err.Error()
+		}
+		r.Steps = make([]*report.StepInfo, len(steps))
+		for i := range steps {
+			r.Steps[i] = steps[i].ReportInfo()
+		}
+		reports[i] = r
+	}
+	return reports, nil
+}
@@ -0,0 +1,147 @@
+package logic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/daemon/logging/trace"
+	"github.com/zrepl/zrepl/replication/logic/pdu"
+	"github.com/zrepl/zrepl/replication/report"
+)
+
+// dryRunTestEndpoint implements Sender and Receiver.
+// It serves a fixed set of filesystems and versions and records all requests that would modify state.
+type dryRunTestEndpoint struct {
+	versions map[string][]*pdu.FilesystemVersion // by filesystem
+
+	mtx      sync.Mutex
+	sends    []*pdu.SendReq
+	modified []string // requests other than dry-run sends that modify state
+}
+
+func (e *dryRunTestEndpoint) recordModification(format string, args ...interface{}) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.modified = append(e.modified, fmt.Sprintf(format, args...))
+}
+
+func (e *dryRunTestEndpoint) ListFilesystems(ctx context.Context, req *pdu.ListFilesystemReq) (*pdu.ListFilesystemRes, error) {
+	res := &pdu.ListFilesystemRes{}
+	for _, fs := range []string{"pool/a", "pool/b", "pool/c"} {
+		if _, ok := e.versions[fs]; ok {
+			res.Filesystems = append(res.Filesystems, &pdu.Filesystem{Path: fs})
+		}
+	}
+	return res, nil
+}
+
+func (e *dryRunTestEndpoint) ListFilesystemVersions(ctx context.Context, req *pdu.ListFilesystemVersionsReq) (*pdu.ListFilesystemVersionsRes, error) {
+	return &pdu.ListFilesystemVersionsRes{Versions: e.versions[req.GetFilesystem()]}, nil
+}
+
+func (e *dryRunTestEndpoint) DestroySnapshots(ctx context.Context, req *pdu.DestroySnapshotsReq) (*pdu.DestroySnapshotsRes, error) {
+	e.recordModification("destroy %s", req.GetFilesystem())
+	return nil, fmt.Errorf("not allowed in dry run")
+}
+
+func (e *dryRunTestEndpoint) WaitForConnectivity(ctx context.Context) error { return nil }
+
+func (e *dryRunTestEndpoint) Send(ctx context.Context, r *pdu.SendReq) (*pdu.SendRes, io.ReadCloser, error) {
+	e.mtx.Lock()
+	e.sends = append(e.sends, r)
+	e.mtx.Unlock()
+	if !r.GetDryRun() {
+		e.recordModification("send %s", r.GetFilesystem())
+		return nil, nil, fmt.Errorf("not allowed in dry run")
+	}
+	return &pdu.SendRes{ExpectedSize: 1000 * int64(r.GetTo().GetCreateTXG())}, nil, nil
+}
+
+func (e *dryRunTestEndpoint) SendCompleted(ctx context.Context, r *pdu.SendCompletedReq) (*pdu.SendCompletedRes, error) {
+	e.recordModification("send completed %s", r.GetOriginalReq().GetFilesystem())
+	return nil, fmt.Errorf("not allowed in dry run")
+}
+
+func (e *dryRunTestEndpoint) ReplicationCursor(ctx context.Context, req *pdu.ReplicationCursorReq) (*pdu.ReplicationCursorRes, error) {
+	return &pdu.ReplicationCursorRes{Result: &pdu.ReplicationCursorRes_Notexist{Notexist: true}}, nil
+}
+
+func (e *dryRunTestEndpoint) Receive(ctx context.Context, req *pdu.ReceiveReq, receive io.ReadCloser) (*pdu.ReceiveRes, error) {
+	e.recordModification("receive %s", req.GetFilesystem())
+	return nil, fmt.Errorf("not allowed in dry run")
+}
+
+func dryRunTestSnap(name string, txg uint64) *pdu.FilesystemVersion {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &pdu.FilesystemVersion{
+		Type:      pdu.FilesystemVersion_Snapshot,
+		Name:      name,
+		Guid:      txg,
+		CreateTXG: txg,
+		Creation:  pdu.FilesystemVersionCreation(t0.Add(time.Duration(txg) * time.Hour)),
+	}
+}
+
+func TestPlannerDryRun(t *testing.T) {
+	ctx, end := trace.WithTask(context.Background(), "test")
+	defer end()
+
+	s1, s2, s3 := dryRunTestSnap("1", 1), dryRunTestSnap("2", 2), dryRunTestSnap("3", 3)
+	sender := &dryRunTestEndpoint{versions: map[string][]*pdu.FilesystemVersion{
+		"pool/a": {s1, s2, s3}, // incremental from @1
+		"pool/b": {s1, s2, s3}, // does not exist on the receiver
+		"pool/c": {s1, s2},     // diverged
+	}}
+	receiver := &dryRunTestEndpoint{versions: map[string][]*pdu.FilesystemVersion{
+		"pool/a": {s1},
+		"pool/c": {s1, dryRunTestSnap("x", 10)},
+	}}
+
+	p := NewPlanner(nil, nil, nil, sender, receiver, PlannerPolicy{EncryptedSend: DontCare})
+	reports, err := p.DryRun(ctx)
+	require.NoError(t, err)
+	require.Len(t, reports, 3)
+
+	a := reports[0]
+	assert.Equal(t, "pool/a", a.Filesystem)
+	assert.Equal(t, "@1", a.CommonAncestor)
+	assert.Empty(t, a.Conflict)
+	assert.Empty(t, a.PlanError)
+	assert.Equal(t, []*report.StepInfo{
+		{From: "@1", To: "@2", BytesExpected: 2000, Encrypted: report.EncryptedSenderDependent},
+		{From: "@2", To: "@3", BytesExpected: 3000, Encrypted: report.EncryptedSenderDependent},
+	}, a.Steps)
+
+	b := reports[1]
+	assert.Equal(t, "pool/b", b.Filesystem)
+	assert.Empty(t, b.CommonAncestor)
+	assert.NotEmpty(t, b.Conflict)
+	assert.Contains(t, b.ConflictResolution, "most recent snapshot @3")
+	assert.Empty(t, b.PlanError)
+	assert.Equal(t, []*report.StepInfo{
+		{From: "", To: "@3", BytesExpected: 3000, Encrypted: report.EncryptedSenderDependent},
+	}, b.Steps)
+
+	c := reports[2]
+	assert.Equal(t, "pool/c", c.Filesystem)
+	assert.Equal(t, "@1", c.CommonAncestor)
+	assert.NotEmpty(t, c.Conflict)
+	assert.NotEmpty(t, c.PlanError)
+	assert.Empty(t, c.Steps)
+
+	// only dry-run sends for size estimation, nothing is received
+	require.Len(t, sender.sends, 3)
+	for _, s := range sender.sends {
+		assert.True(t, s.GetDryRun(), "send request for %s must be a dry run", s.GetFilesystem())
+	}
+	assert.Empty(t, sender.modified)
+	assert.Empty(t, receiver.sends)
+	assert.Empty(t, receiver.modified)
+}
@@ -0,0 +1,295 @@
+package logic
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/zrepl/zrepl/replication/logic/pdu"
+	"github.com/zrepl/zrepl/zfs"
+)
+
+const (
+	FreshnessSideSender   = "sender"
+	FreshnessSideReceiver = "receiver"
+)
+
+// FreshnessMetrics exports how up to date the receiver's copy of each filesystem is,
+// i.e., the numbers required for recovery point objective (RPO) alerting.
+//
+// The per-filesystem series are limited to the filesystems that pass filter,
+// and to at most maxFilesystems filesystems (in the order in which they are first observed).
+// The job-wide maximum lag covers all filesystems, regardless of these limits.
+//
+// All methods are safe for concurrent use and may be called on a nil *FreshnessMetrics.
+type FreshnessMetrics struct {
+	filter         zfs.DatasetFilter // nil means all filesystems
+	maxFilesystems int
+
+	newestSnapshot     *prometheus.GaugeVec // labels: filesystem, side
+	snapshots          *prometheus.GaugeVec // labels: filesystem, side
+	lag                *prometheus.GaugeVec // labels: filesystem
+	lastSuccessfulStep *prometheus.GaugeVec // labels: filesystem
+	maxLag             prometheus.Gauge
+	untracked          prometheus.Gauge
+
+	mtx      sync.Mutex
+	fss      map[string]*fsFreshness
+	exported int
+}
+
+type fsFreshness struct {
+	exported bool
+	newest   map[string]time.Time // by side, missing if the side has no snapshots
+	lag      *time.Duration       // nil if unknown
+}
+
+func NewFreshnessMetrics(jobName string, filter zfs.DatasetFilter, maxFilesystems int) *FreshnessMetrics {
+	constLabels := prometheus.Labels{"zrepl_job": jobName}
+	return &FreshnessMetrics{
+		filter:         filter,
+		maxFilesystems: maxFilesystems,
+		newestSnapshot: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   "zrepl",
+			Subsystem:   "replication",
+			Name:        "newest_snapshot_timestamp_seconds",
+			Help:        "creation time of the newest snapshot of the filesystem on the sender or receiver side",
+			ConstLabels: constLabels,
+		}, []string{"filesystem", "side"}),
+		snapshots: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   "zrepl",
+			Subsystem:   "replication",
+			Name:        "snapshots",
+			Help:        "number of snapshots of the filesystem on the sender or receiver side",
+			ConstLabels: constLabels,
+		}, []string{"filesystem", "side"}),
+		lag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   "zrepl",
+			Subsystem:   "replication",
+			Name:        "lag_seconds",
+			Help:        "creation time difference between the newest snapshot on the sender and on the receiver",
+			ConstLabels: constLabels,
+		}, []string{"filesystem"}),
+		lastSuccessfulStep: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   "zrepl",
+			Subsystem:   "replication",
+			Name:        "last_successful_step_timestamp_seconds",
+			Help:        "time at which the most recent replication step of the filesystem completed",
+			ConstLabels: constLabels,
+		}, []string{"filesystem"}),
+		maxLag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   "zrepl",
+			Subsystem:   "replication",
+			Name:        "max_lag_seconds",
+			Help:        "maximum of lag_seconds over all filesystems of the job, including those without per-filesystem metrics",
+			ConstLabels: constLabels,
+		}),
+		untracked: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   "zrepl",
+			Subsystem:   "replication",
+			Name:        "freshness_untracked_filesystems",
+			Help:        "number of filesystems without per-filesystem freshness metrics because of the filesystems filter or max_filesystems",
+			ConstLabels: constLabels,
+		}),
+		fss: make(map[string]*fsFreshness),
+	}
+}
+
+func (m *FreshnessMetrics) RegisterMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(m.newestSnapshot)
+	registerer.MustRegister(m.snapshots)
+	registerer.MustRegister(m.lag)
+	registerer.MustRegister(m.lastSuccessfulStep)
+	registerer.MustRegister(m.maxLag)
+	registerer.MustRegister(m.untracked)
+}
+
+// caller must hold m.mtx
+func (m *FreshnessMetrics) state(fs string) *fsFreshness {
+	s, ok := m.fss[fs]
+	if ok {
+		return s
+	}
+	s = &fsFreshness{newest: make(map[string]time.Time)}
+	m.fss[fs] = s
+	if m.exported < m.maxFilesystems && m.passesFilter(fs) {
+		s.exported = true
+		m.exported++
+	} else {
+		m.untracked.Inc()
+	}
+	return s
+}
+
+func (m *FreshnessMetrics) passesFilter(fs string) bool {
+	if m.filter == nil {
+		return true
+	}
+	dp, err := zfs.NewDatasetPath(fs)
+	if err != nil {
+		return false
+	}
+	pass, err := m.filter.Filter(dp)
+	return err == nil && pass
+}
+
+// caller must hold m.mtx
+func (m *FreshnessMetrics) updateLag(fs string, s *fsFreshness) {
+	sender, senderOk := s.newest[FreshnessSideSender]
+	receiver, receiverOk := s.newest[FreshnessSideReceiver]
+	if senderOk && receiverOk {
+		lag := sender.Sub(receiver)
+		if lag < 0 {
+			lag = 0
+		}
+		s.lag = &lag
+		if s.exported {
+			m.lag.WithLabelValues(fs).Set(lag.Seconds())
+		}
+	} else {
+		s.lag = nil
+		if s.exported {
+			m.lag.DeleteLabelValues(fs)
+		}
+	}
+
+	m.updateMaxLag()
+}
+
+// caller must hold m.mtx
+func (m *FreshnessMetrics) updateMaxLag() {
+	var maxLag time.Duration
+	for _, s := range m.fss {
+		if s.lag != nil && *s.lag > maxLag {
+			maxLag = *s.lag
+		}
+	}
+	m.maxLag.Set(maxLag.Seconds())
+}
+
+// retainFilesystems drops the state and series of all filesystems not in fss,
+// e.g. because they were destroyed or no longer match the job's filesystem filter.
+func (m *FreshnessMetrics) retainFilesystems(fss []string) {
+	if m == nil {
+		return
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	keep := make(map[string]bool, len(fss))
+	for _, fs := range fss {
+		keep[fs] = true
+	}
+	for fs, s := range m.fss {
+		if keep[fs] {
+			continue
+		}
+		delete(m.fss, fs)
+		if s.exported {
+			m.exported--
+			for _, side := range []string{FreshnessSideSender, FreshnessSideReceiver} {
+				m.newestSnapshot.DeleteLabelValues(fs, side)
+				m.snapshots.DeleteLabelValues(fs, side)
+			}
+			m.lag.DeleteLabelValues(fs)
+			m.lastSuccessfulStep.DeleteLabelValues(fs)
+		} else {
+			m.untracked.Dec()
+		}
+	}
+	m.updateMaxLag()
+}
+
+// observeVersions updates the metrics of side from the complete list of versions of fs on that side.
+func (m *FreshnessMetrics) observeVersions(fs, side string, versions []*pdu.FilesystemVersion) {
+	if m == nil {
+		return
+	}
+	var count int
+	var newest time.Time
+	for _, v := range versions {
+		if v.GetType() != pdu.FilesystemVersion_Snapshot {
+			continue
+		}
+		count++
+		if creation, err := v.CreationAsTime(); err == nil && creation.After(newest) {
+			newest = creation
+		}
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	s := m.state(fs)
+	if newest.IsZero() {
+		delete(s.newest, side)
+	} else {
+		s.newest[side] = newest
+	}
+	if s.exported {
+		m.snapshots.WithLabelValues(fs, side).Set(float64(count))
+		if newest.IsZero() {
+			m.newestSnapshot.DeleteLabelValues(fs, side)
+		} else {
+			m.newestSnapshot.WithLabelValues(fs, side).Set(float64(newest.Unix()))
+		}
+	}
+	m.updateLag(fs, s)
+}
+
+// observeStepCompleted updates the metrics after snapshot `to` of fs was received at time `at`.
+func (m *FreshnessMetrics) observeStepCompleted(fs string, to *pdu.FilesystemVersion, at time.Time) {
+	if m == nil {
+		return
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	s := m.state(fs)
+	if s.exported {
+		m.lastSuccessfulStep.WithLabelValues(fs).Set(float64(at.Unix()))
+		m.snapshots.WithLabelValues(fs, FreshnessSideReceiver).Inc()
+	}
+	creation, err := to.CreationAsTime()
+	if err != nil || !creation.After(s.newest[FreshnessSideReceiver]) {
+		return
+	}
+	s.newest[FreshnessSideReceiver] = creation
+	if s.exported {
+		m.newestSnapshot.WithLabelValues(fs, FreshnessSideReceiver).Set(float64(creation.Unix()))
+	}
+	m.updateLag(fs, s)
+}
+
+// ObserveSnapshotCount updates the number of snapshots of fs on side, e.g. after pruning.
+func (m *FreshnessMetrics) ObserveSnapshotCount(fs, side string, count int) {
+	if m == nil {
+		return
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if s := m.state(fs); s.exported {
+		m.snapshots.WithLabelValues(fs, side).Set(float64(count))
+	}
+}
+
+type FilesystemFreshness struct {
+	// zero if the side has no snapshots
+	NewestSenderSnapshot, NewestReceiverSnapshot time.Time
+}
+
+// Report returns the freshness of all filesystems, by filesystem name,
+// regardless of the label cardinality limits.
+func (m *FreshnessMetrics) Report() map[string]*FilesystemFreshness {
+	if m == nil {
+		return nil
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	r := make(map[string]*FilesystemFreshness, len(m.fss))
+	for fs, s := range m.fss {
+		r[fs] = &FilesystemFreshness{
+			NewestSenderSnapshot:   s.newest[FreshnessSideSender],
+			NewestReceiverSnapshot: s.newest[FreshnessSideReceiver],
+		}
+	}
+	return r
+}
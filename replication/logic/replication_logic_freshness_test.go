@@ -0,0 +1,83 @@
+package logic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zrepl/zrepl/replication/logic/pdu"
+)
+
+func freshnessTestSnap(name string, creation time.Time) *pdu.FilesystemVersion {
+	return &pdu.FilesystemVersion{
+		Type:     pdu.FilesystemVersion_Snapshot,
+		Name:     name,
+		Creation: creation.Format(time.RFC3339),
+	}
+}
+
+func collectAndCount(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	n := 0
+	for range ch {
+		n++
+	}
+	return n
+}
+
+func TestFreshnessMetrics(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewFreshnessMetrics("job", nil, 1)
+
+	m.observeVersions("pool/a", FreshnessSideSender, []*pdu.FilesystemVersion{
+		freshnessTestSnap("1", t0),
+		freshnessTestSnap("2", t0.Add(2*time.Hour)),
+		{Type: pdu.FilesystemVersion_Bookmark, Name: "3", Creation: t0.Add(3 * time.Hour).Format(time.RFC3339)},
+	})
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.snapshots.WithLabelValues("pool/a", FreshnessSideSender)))
+	assert.Equal(t, float64(t0.Add(2*time.Hour).Unix()), testutil.ToFloat64(m.newestSnapshot.WithLabelValues("pool/a", FreshnessSideSender)))
+	assert.Equal(t, 0, collectAndCount(m.lag), "lag is unknown while the receiver has not been observed")
+
+	m.observeVersions("pool/a", FreshnessSideReceiver, []*pdu.FilesystemVersion{freshnessTestSnap("1", t0)})
+	assert.Equal(t, (2 * time.Hour).Seconds(), testutil.ToFloat64(m.lag.WithLabelValues("pool/a")))
+	assert.Equal(t, (2 * time.Hour).Seconds(), testutil.ToFloat64(m.maxLag))
+
+	m.observeStepCompleted("pool/a", freshnessTestSnap("2", t0.Add(2*time.Hour)), t0.Add(3*time.Hour))
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.lag.WithLabelValues("pool/a")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.snapshots.WithLabelValues("pool/a", FreshnessSideReceiver)))
+	assert.Equal(t, float64(t0.Add(3*time.Hour).Unix()), testutil.ToFloat64(m.lastSuccessfulStep.WithLabelValues("pool/a")))
+
+	m.ObserveSnapshotCount("pool/a", FreshnessSideSender, 1)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.snapshots.WithLabelValues("pool/a", FreshnessSideSender)))
+
+	// max_filesystems exceeded: no per-filesystem series, but max lag covers pool/b
+	m.observeVersions("pool/b", FreshnessSideSender, []*pdu.FilesystemVersion{freshnessTestSnap("1", t0.Add(5*time.Hour))})
+	m.observeVersions("pool/b", FreshnessSideReceiver, []*pdu.FilesystemVersion{freshnessTestSnap("1", t0)})
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.untracked))
+	assert.Equal(t, 1, collectAndCount(m.lag))
+	assert.Equal(t, (5 * time.Hour).Seconds(), testutil.ToFloat64(m.maxLag))
+
+	// pool/a is gone, its series are deleted and pool/b no longer counts
+	m.retainFilesystems([]string{"pool/b"})
+	assert.Equal(t, 0, collectAndCount(m.lag))
+	assert.Equal(t, 0, collectAndCount(m.snapshots))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.untracked))
+	m.retainFilesystems(nil)
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.untracked))
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.maxLag))
+}
+
+func TestFreshnessMetricsNil(t *testing.T) {
+	var m *FreshnessMetrics
+	m.retainFilesystems([]string{"pool/a"})
+	m.observeVersions("pool/a", FreshnessSideSender, nil)
+	m.observeStepCompleted("pool/a", &pdu.FilesystemVersion{}, time.Now())
+	m.ObserveSnapshotCount("pool/a", FreshnessSideSender, 1)
+}
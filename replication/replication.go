@@ -8,6 +8,6 @@ import (
 	"github.com/zrepl/zrepl/replication/driver"
 )
 
-func Do(ctx context.Context, planner driver.Planner) (driver.ReportFunc, driver.WaitFunc) {
-	return driver.Do(ctx, planner)
+func Do(ctx context.Context, planner driver.Planner, config driver.Config) (driver.ReportFunc, driver.WaitFunc) {
+	return driver.Do(ctx, planner, config)
 }
@@ -40,6 +40,16 @@ type AttemptReport struct {
 	StartAt, FinishAt time.Time
 	PlanError         *TimedError
 	Filesystems       []*FilesystemReport
+
+	// Number of attempts in this run that preceded this one
+	RetryCount int
+	// Maximum number of attempts in this run, 0 if unlimited
+	MaxAttempts int
+	// Class of the error that determined the retry decision ("network" or "permanent"),
+	// empty unless the attempt failed
+	ErrorClass string
+	// If non-zero, the next attempt starts at (or, if reconnecting is necessary, after) this time
+	NextRetryAt time.Time
 }
 
 type AttemptState string
@@ -83,6 +93,8 @@ type FilesystemInfo struct {
 
 type StepReport struct {
 	Info *StepInfo
+	// non-nil if the step was aborted because it stalled (see replication.step_timeout)
+	Stalled *TimedError
 }
 
 type EncryptedEnum string
@@ -99,6 +111,19 @@ type StepInfo struct {
 	Encrypted       EncryptedEnum
 	BytesExpected   int64
 	BytesReplicated int64
+	// Compression algorithm used on the wire, empty if the stream is not compressed
+	Compression string
+	// Only valid if Compression != "": the number of compressed bytes transferred
+	BytesOnWire int64
+}
+
+// CompressionRatio returns BytesReplicated / BytesOnWire,
+// or 0 if the stream is not compressed or no bytes have been transferred yet.
+func (i *StepInfo) CompressionRatio() float64 {
+	if i.Compression == "" || i.BytesOnWire == 0 {
+		return 0
+	}
+	return float64(i.BytesReplicated) / float64(i.BytesOnWire)
 }
 
 func (a *AttemptReport) BytesSum() (expected, replicated int64, containsInvalidSizeEstimates bool) {
@@ -159,6 +184,16 @@ func (f *FilesystemReport) NextStep() *StepReport {
 	panic("unreachable")
 }
 
+// StalledStep returns the step that was aborted because it stalled, or nil.
+func (f *FilesystemReport) StalledStep() *StepReport {
+	for _, s := range f.Steps {
+		if s.Stalled != nil {
+			return s
+		}
+	}
+	return nil
+}
+
 func (f *StepReport) IsIncremental() bool {
 	return f.Info.From != ""
 }
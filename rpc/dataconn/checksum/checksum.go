@@ -0,0 +1,93 @@
+// Package checksum implements the optional checksumming of ZFS streams
+// transferred over dataconn.
+//
+// The algorithms that both peers support are negotiated as versionhandshake
+// extensions (see Extensions). The client then chooses the algorithm per request,
+// the peer that writes the stream sends a checksum after each frame
+// and the peer that reads the stream verifies it (see package stream).
+package checksum
+
+import (
+	"crypto/sha256"
+	"hash"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/pkg/errors"
+)
+
+type Algorithm string
+
+const (
+	None     Algorithm = ""
+	XXHash64 Algorithm = "xxhash64"
+	SHA256   Algorithm = "sha256"
+)
+
+// Algorithms lists all supported algorithms except None.
+var Algorithms = []Algorithm{XXHash64, SHA256}
+
+func AlgorithmFromString(s string) (Algorithm, error) {
+	switch s {
+	case "", "off":
+		return None, nil
+	case string(XXHash64):
+		return XXHash64, nil
+	case string(SHA256):
+		return SHA256, nil
+	default:
+		return None, errors.Errorf("unknown checksum algorithm %q", s)
+	}
+}
+
+func (a Algorithm) String() string {
+	if a == None {
+		return "off"
+	}
+	return string(a)
+}
+
+// New returns a new hash.Hash that computes checksums of algorithm a.
+// It panics if a is None or unknown.
+func (a Algorithm) New() hash.Hash {
+	switch a {
+	case XXHash64:
+		return xxhash.New()
+	case SHA256:
+		return sha256.New()
+	default:
+		panic(a)
+	}
+}
+
+const extensionPrefix = "dataconn-checksum-"
+
+// Extension returns the versionhandshake extension that advertises support for a.
+func (a Algorithm) Extension() string {
+	return extensionPrefix + string(a)
+}
+
+// Extensions returns the versionhandshake extensions for all supported algorithms.
+func Extensions() []string {
+	exts := make([]string, len(Algorithms))
+	for i, a := range Algorithms {
+		exts[i] = a.Extension()
+	}
+	return exts
+}
+
+// NegotiatedAlgorithms returns the algorithms whose extension is contained in negotiated.
+func NegotiatedAlgorithms(negotiated []string) []Algorithm {
+	var algs []Algorithm
+	for _, ext := range negotiated {
+		if !strings.HasPrefix(ext, extensionPrefix) {
+			continue
+		}
+		a, err := AlgorithmFromString(strings.TrimPrefix(ext, extensionPrefix))
+		if err != nil || a == None {
+			continue
+		}
+		algs = append(algs, a)
+	}
+	return algs
+}
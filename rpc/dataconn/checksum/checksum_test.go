@@ -0,0 +1,24 @@
+package checksum
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlgorithmFromString(t *testing.T) {
+	for _, a := range append([]Algorithm{None}, Algorithms...) {
+		parsed, err := AlgorithmFromString(a.String())
+		require.NoError(t, err)
+		assert.Equal(t, a, parsed)
+	}
+	_, err := AlgorithmFromString("crc32")
+	assert.Error(t, err)
+}
+
+func TestNegotiatedAlgorithms(t *testing.T) {
+	negotiated := []string{"foo", SHA256.Extension(), extensionPrefix + "crc32", extensionPrefix}
+	assert.Equal(t, []Algorithm{SHA256}, NegotiatedAlgorithms(negotiated))
+	assert.Equal(t, Algorithms, NegotiatedAlgorithms(Extensions()))
+}
@@ -0,0 +1,268 @@
+// Package compression implements the optional compression of ZFS streams
+// transferred over dataconn.
+//
+// The algorithms that both peers support are negotiated as versionhandshake
+// extensions (see Extensions). The client then chooses the algorithm and level
+// per request, and the peer that writes the stream compresses it.
+package compression
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/pkg/errors"
+
+	"github.com/zrepl/zrepl/util/compressionstats"
+)
+
+type Algorithm string
+
+const (
+	None Algorithm = ""
+	Zstd Algorithm = "zstd"
+	LZ4  Algorithm = "lz4"
+)
+
+// Algorithms lists all supported algorithms except None.
+var Algorithms = []Algorithm{Zstd, LZ4}
+
+func AlgorithmFromString(s string) (Algorithm, error) {
+	switch s {
+	case "", "off":
+		return None, nil
+	case string(Zstd):
+		return Zstd, nil
+	case string(LZ4):
+		return LZ4, nil
+	default:
+		return None, errors.Errorf("unknown compression algorithm %q", s)
+	}
+}
+
+const extensionPrefix = "dataconn-compression-"
+
+// Extension returns the versionhandshake extension that advertises support for a.
+func (a Algorithm) Extension() string {
+	return extensionPrefix + string(a)
+}
+
+// Extensions returns the versionhandshake extensions for all supported algorithms.
+func Extensions() []string {
+	exts := make([]string, len(Algorithms))
+	for i, a := range Algorithms {
+		exts[i] = a.Extension()
+	}
+	return exts
+}
+
+// NegotiatedAlgorithms returns the algorithms whose extension is contained in negotiated.
+func NegotiatedAlgorithms(negotiated []string) []Algorithm {
+	var algs []Algorithm
+	for _, ext := range negotiated {
+		if !strings.HasPrefix(ext, extensionPrefix) {
+			continue
+		}
+		a, err := AlgorithmFromString(strings.TrimPrefix(ext, extensionPrefix))
+		if err != nil || a == None {
+			continue
+		}
+		algs = append(algs, a)
+	}
+	return algs
+}
+
+// Config selects the algorithm and level used to compress a stream.
+type Config struct {
+	Algorithm Algorithm
+	// Level is algorithm-specific, 0 selects the algorithm's default level.
+	//   zstd: 1 (fastest) to 22 (best compression), mapped to the levels supported by the encoder
+	//   lz4:  1 to 9, higher values compress better
+	Level int
+}
+
+func (c Config) Validate() error {
+	switch c.Algorithm {
+	case None:
+		if c.Level != 0 {
+			return errors.New("compression level requires a compression algorithm")
+		}
+	case Zstd:
+		if c.Level < 0 || c.Level > 22 {
+			return errors.Errorf("zstd compression level must be in [1, 22]")
+		}
+	case LZ4:
+		if c.Level < 0 || c.Level > 9 {
+			return errors.Errorf("lz4 compression level must be in [1, 9]")
+		}
+	default:
+		return errors.Errorf("unknown compression algorithm %q", c.Algorithm)
+	}
+	return nil
+}
+
+func (c Config) String() string {
+	if c.Algorithm == None {
+		return "off"
+	}
+	return fmt.Sprintf("%s:%d", c.Algorithm, c.Level)
+}
+
+// ConfigFromString parses the format produced by Config.String.
+func ConfigFromString(s string) (c Config, err error) {
+	if s == "off" {
+		return Config{}, nil
+	}
+	var alg string
+	var level int
+	if n, err := fmt.Sscanf(strings.Replace(s, ":", " ", 1), "%s %d", &alg, &level); err != nil || n != 2 {
+		return c, errors.Errorf("invalid compression config %q", s)
+	}
+	c.Algorithm, err = AlgorithmFromString(alg)
+	if err != nil {
+		return c, err
+	}
+	c.Level = level
+	return c, c.Validate()
+}
+
+type countingReader struct {
+	r     io.Reader
+	count func(n int64)
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.count(int64(n))
+	return n, err
+}
+
+type compressingReader struct {
+	*io.PipeReader
+	src  io.ReadCloser
+	done <-chan struct{}
+}
+
+// Close returns after the goroutine that compresses src has exited,
+// i.e., Stats are final once Close returns.
+func (r *compressingReader) Close() error {
+	err := r.PipeReader.Close()
+	srcErr := r.src.Close()
+	<-r.done
+	if srcErr != nil {
+		return srcErr
+	}
+	return err
+}
+
+// Compress returns a reader that yields the compressed contents of src.
+// stats may be nil.
+// Closing the returned reader closes src.
+func Compress(c Config, src io.ReadCloser, stats *compressionstats.Stats) (io.ReadCloser, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if stats == nil {
+		stats = &compressionstats.Stats{}
+	}
+	stats.SetAlgorithm(string(c.Algorithm))
+	if c.Algorithm == None {
+		return src, nil
+	}
+
+	pr, pw := io.Pipe()
+	out := countingWriter{pw, stats.AddCompressed}
+	var enc io.WriteCloser
+	switch c.Algorithm {
+	case Zstd:
+		level := zstd.SpeedDefault
+		if c.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(c.Level)
+		}
+		zenc, err := zstd.NewWriter(out, zstd.WithEncoderLevel(level))
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot create zstd encoder")
+		}
+		enc = zenc
+	case LZ4:
+		lenc := lz4.NewWriter(out)
+		level := lz4.Fast
+		if c.Level != 0 {
+			level = lz4.CompressionLevel(1 << uint(8+c.Level))
+		}
+		if err := lenc.Apply(lz4.CompressionLevelOption(level)); err != nil {
+			return nil, errors.Wrap(err, "cannot create lz4 encoder")
+		}
+		enc = lenc
+	default:
+		panic(c.Algorithm)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := io.Copy(enc, countingReader{src, stats.AddUncompressed})
+		if closeErr := enc.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err) // nil err => EOF
+	}()
+	return &compressingReader{pr, src, done}, nil
+}
+
+type countingWriter struct {
+	w     io.Writer
+	count func(n int64)
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.count(int64(n))
+	return n, err
+}
+
+type decompressingReader struct {
+	io.Reader
+	src   io.ReadCloser
+	close func()
+}
+
+func (r *decompressingReader) Close() error {
+	if r.close != nil {
+		r.close()
+	}
+	return r.src.Close()
+}
+
+// Decompress returns a reader that yields the decompressed contents of src,
+// which must have been compressed with algorithm a.
+// stats may be nil.
+// Closing the returned reader closes src.
+func Decompress(a Algorithm, src io.ReadCloser, stats *compressionstats.Stats) (io.ReadCloser, error) {
+	if stats == nil {
+		stats = &compressionstats.Stats{}
+	}
+	stats.SetAlgorithm(string(a))
+	if a == None {
+		return src, nil
+	}
+	in := countingReader{src, stats.AddCompressed}
+	var dec io.Reader
+	var closeDec func()
+	switch a {
+	case Zstd:
+		zdec, err := zstd.NewReader(in)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot create zstd decoder")
+		}
+		dec = zdec
+		closeDec = zdec.Close
+	case LZ4:
+		dec = lz4.NewReader(in)
+	default:
+		return nil, errors.Errorf("unknown compression algorithm %q", a)
+	}
+	return &decompressingReader{countingReader{dec, stats.AddUncompressed}, src, closeDec}, nil
+}
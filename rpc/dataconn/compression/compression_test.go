@@ -0,0 +1,73 @@
+package compression
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/util/compressionstats"
+)
+
+func TestConfigFromString(t *testing.T) {
+	for _, c := range []Config{
+		{},
+		{Algorithm: Zstd},
+		{Algorithm: Zstd, Level: 19},
+		{Algorithm: LZ4, Level: 9},
+	} {
+		parsed, err := ConfigFromString(c.String())
+		require.NoError(t, err, c.String())
+		assert.Equal(t, c, parsed)
+	}
+
+	for _, invalid := range []string{"", "zstd", "zstd:", "gzip:1", "zstd:23", "lz4:-1", "off:1"} {
+		_, err := ConfigFromString(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestNegotiatedAlgorithms(t *testing.T) {
+	negotiated := []string{"foo", LZ4.Extension(), extensionPrefix + "gzip", extensionPrefix}
+	assert.Equal(t, []Algorithm{LZ4}, NegotiatedAlgorithms(negotiated))
+	assert.Equal(t, Algorithms, NegotiatedAlgorithms(Extensions()))
+}
+
+func TestCompressDecompressRoundtrip(t *testing.T) {
+	// half random, half compressible
+	input := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(input[:len(input)/2])
+
+	for _, c := range []Config{
+		{},
+		{Algorithm: Zstd},
+		{Algorithm: Zstd, Level: 22},
+		{Algorithm: LZ4},
+		{Algorithm: LZ4, Level: 9},
+	} {
+		t.Run(c.String(), func(t *testing.T) {
+			var compStats, decompStats compressionstats.Stats
+			compressed, err := Compress(c, ioutil.NopCloser(bytes.NewReader(input)), &compStats)
+			require.NoError(t, err)
+			decompressed, err := Decompress(c.Algorithm, compressed, &decompStats)
+			require.NoError(t, err)
+
+			output, err := ioutil.ReadAll(decompressed)
+			require.NoError(t, err)
+			require.NoError(t, decompressed.Close())
+			assert.True(t, bytes.Equal(input, output))
+
+			assert.Equal(t, string(c.Algorithm), compStats.Algorithm())
+			assert.Equal(t, string(c.Algorithm), decompStats.Algorithm())
+			if c.Algorithm != None {
+				assert.Equal(t, int64(len(input)), compStats.Uncompressed())
+				assert.Equal(t, int64(len(input)), decompStats.Uncompressed())
+				assert.Equal(t, compStats.Compressed(), decompStats.Compressed())
+				assert.True(t, compStats.Compressed() < compStats.Uncompressed())
+			}
+		})
+	}
+}
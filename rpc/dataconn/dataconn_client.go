@@ -5,31 +5,53 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 	"strings"
+	"sync"
 
 	"github.com/golang/protobuf/proto"
 
+	"github.com/zrepl/zrepl/daemon/logging/trace"
 	"github.com/zrepl/zrepl/replication/logic/pdu"
+	"github.com/zrepl/zrepl/rpc/dataconn/checksum"
+	"github.com/zrepl/zrepl/rpc/dataconn/compression"
 	"github.com/zrepl/zrepl/rpc/dataconn/stream"
+	"github.com/zrepl/zrepl/rpc/dataconn/stripe"
+	"github.com/zrepl/zrepl/rpc/versionhandshake"
 	"github.com/zrepl/zrepl/transport"
+	"github.com/zrepl/zrepl/util/compressionstats"
 )
 
 type Client struct {
-	log Logger
-	cn  transport.Connecter
+	log    Logger
+	cn     transport.Connecter
+	config ClientConfig
 }
 
-func NewClient(connecter transport.Connecter, log Logger) *Client {
+type ClientConfig struct {
+	// Compression of the ZFS stream in Send and Receive requests.
+	// Only used if the server supports the algorithm, see package compression.
+	Compression compression.Config
+	// Checksum protects the frames of the ZFS stream in Send and Receive requests.
+	// Only used if the server supports the algorithm, see package checksum.
+	Checksum checksum.Algorithm
+	// Stripes is the number of connections over which the ZFS stream of Send and Receive requests
+	// is striped. Striping is disabled if Stripes <= 1 or if the server does not support it.
+	Stripes int
+}
+
+func NewClient(connecter transport.Connecter, log Logger, config ClientConfig) *Client {
 	return &Client{
-		log: log,
-		cn:  connecter,
+		log:    log,
+		cn:     connecter,
+		config: config,
 	}
 }
 
-func (c *Client) send(ctx context.Context, conn *stream.Conn, endpoint string, req proto.Message, stream io.ReadCloser) error {
+func (c *Client) send(ctx context.Context, conn *stream.Conn, endpoint string, opts streamOptions, req proto.Message, stream io.ReadCloser) error {
 
 	var buf bytes.Buffer
-	_, memErr := buf.WriteString(endpoint)
+	_, memErr := buf.WriteString(newRequestHeader(endpoint, opts).encode())
 	if memErr != nil {
 		panic(memErr)
 	}
@@ -47,20 +69,39 @@ func (c *Client) send(ctx context.Context, conn *stream.Conn, endpoint string, r
 	}
 
 	if stream != nil {
-		return conn.SendStream(ctx, stream, ZFSStream)
+		return conn.SendStream(ctx, stream, ZFSStream, opts.checksum)
 	} else {
 		return nil
 	}
 }
 
+type RemoteHandlerErrorKind int
+
+const (
+	RemoteHandlerErrorKindGeneric RemoteHandlerErrorKind = iota
+	// the server failed to verify the checksum of the stream sent by us
+	RemoteHandlerErrorKindChecksumMismatch
+)
+
 type RemoteHandlerError struct {
-	msg string
+	Kind RemoteHandlerErrorKind
+	msg  string
 }
 
 func (e *RemoteHandlerError) Error() string {
 	return fmt.Sprintf("server error: %s", e.msg)
 }
 
+var _ net.Error = (*RemoteHandlerError)(nil)
+
+func (e *RemoteHandlerError) Timeout() bool { return false }
+
+// Temporary returns true if the server failed to verify the checksum of the
+// stream sent by us, i.e., the stream was corrupted in transit.
+func (e *RemoteHandlerError) Temporary() bool {
+	return e.Kind == RemoteHandlerErrorKindChecksumMismatch
+}
+
 type ProtocolError struct {
 	cause error
 }
@@ -76,9 +117,21 @@ func (c *Client) recv(ctx context.Context, conn *stream.Conn, res proto.Message)
 		return err
 	}
 	header := string(headerBuf)
+	if strings.HasPrefix(header, responseHeaderHandlerErrorChecksumMismatchPrefix) {
+		msg := strings.TrimPrefix(header, responseHeaderHandlerErrorChecksumMismatchPrefix)
+		return &RemoteHandlerError{Kind: RemoteHandlerErrorKindChecksumMismatch, msg: msg}
+	}
+	if strings.HasPrefix(header, responseHeaderHandlerErrorResourceExhaustedPrefix) {
+		busy, err := decodeResourceExhaustedHeader(header)
+		if err != nil {
+			return &ProtocolError{err}
+		}
+		return busy
+	}
 	if strings.HasPrefix(header, responseHeaderHandlerErrorPrefix) {
+		msg := strings.TrimPrefix(header, responseHeaderHandlerErrorPrefix)
 		// FIXME distinguishable error type
-		return &RemoteHandlerError{strings.TrimPrefix(header, responseHeaderHandlerErrorPrefix)}
+		return &RemoteHandlerError{Kind: RemoteHandlerErrorKindGeneric, msg: msg}
 	}
 	if !strings.HasPrefix(header, responseHeaderHandlerOk) {
 		return &ProtocolError{fmt.Errorf("invalid header: %q", header)}
@@ -94,13 +147,60 @@ func (c *Client) recv(ctx context.Context, conn *stream.Conn, res proto.Message)
 	return nil
 }
 
-func (c *Client) getWire(ctx context.Context) (*stream.Conn, error) {
+// getWire returns a new connection and the versionhandshake extensions negotiated on it.
+func (c *Client) getWire(ctx context.Context) (*stream.Conn, []string, error) {
 	nc, err := c.cn.Connect(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	conn := stream.Wrap(nc, HeartbeatInterval, HeartbeatPeerTimeout)
-	return conn, nil
+	return conn, versionhandshake.NegotiatedExtensions(nc), nil
+}
+
+// streamOptions returns the streamOptions to use on a connection
+// that negotiated the given versionhandshake extensions.
+func (c *Client) streamOptions(negotiated []string) (opts streamOptions) {
+	if c.config.Compression.Algorithm != compression.None {
+		for _, a := range compression.NegotiatedAlgorithms(negotiated) {
+			if a == c.config.Compression.Algorithm {
+				opts.compression = c.config.Compression
+			}
+		}
+		if opts.compression.Algorithm == compression.None {
+			c.log.WithField("compression", c.config.Compression.String()).
+				Debug("server does not support configured compression algorithm, sending uncompressed")
+		}
+	}
+	if c.config.Checksum != checksum.None {
+		for _, a := range checksum.NegotiatedAlgorithms(negotiated) {
+			if a == c.config.Checksum {
+				opts.checksum = c.config.Checksum
+			}
+		}
+		if opts.checksum == checksum.None {
+			c.log.WithField("checksum", c.config.Checksum.String()).
+				Warn("server does not support configured checksum algorithm, stream integrity is not verified")
+		}
+	}
+	if c.config.Stripes > 1 {
+		if versionhandshake.ContainsExtension(negotiated, stripe.Extension) {
+			opts.stripes = c.config.Stripes
+			opts.stripeSession = newStripeSessionID()
+		} else {
+			c.log.WithField("stripes", c.config.Stripes).
+				Debug("server does not support striped streams, using a single connection")
+		}
+	}
+	return opts
+}
+
+// withTraceparent returns opts with the trace context of ctx if the server supports it,
+// so that the server's handler task continues the client's trace.
+func withTraceparent(ctx context.Context, negotiated []string, opts streamOptions) streamOptions {
+	if versionhandshake.ContainsExtension(negotiated, TraceContextExtension) {
+		opts.traceparent = trace.Traceparent(ctx)
+	}
+	return opts
 }
 
 func (c *Client) putWire(conn *stream.Conn) {
@@ -110,7 +210,7 @@ func (c *Client) putWire(conn *stream.Conn) {
 }
 
 func (c *Client) ReqSend(ctx context.Context, req *pdu.SendReq) (*pdu.SendRes, io.ReadCloser, error) {
-	conn, err := c.getWire(ctx)
+	conn, negotiated, err := c.getWire(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -121,7 +221,21 @@ func (c *Client) ReqSend(ctx context.Context, req *pdu.SendReq) (*pdu.SendRes, i
 		}
 	}()
 
-	if err := c.send(ctx, conn, EndpointSend, req, nil); err != nil {
+	var opts streamOptions
+	if !req.DryRun {
+		opts = c.streamOptions(negotiated)
+	}
+	opts = withTraceparent(ctx, negotiated, opts)
+	stripeConns, err := c.getStripeWires(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if putWireOnReturn {
+			c.putStripeWires(stripeConns)
+		}
+	}()
+	if err := c.send(ctx, conn, EndpointSend, opts, req, nil); err != nil {
 		return nil, nil, err
 	}
 
@@ -133,10 +247,32 @@ func (c *Client) ReqSend(ctx context.Context, req *pdu.SendReq) (*pdu.SendRes, i
 	var stream io.ReadCloser
 	if !req.DryRun {
 		putWireOnReturn = false
-		stream, err = conn.ReadStream(ZFSStream, true) // no shadow
+		stream, err = conn.ReadStream(ZFSStream, opts.checksum, true) // no shadow
 		if err != nil {
+			c.putStripeWires(stripeConns)
 			return nil, nil, err
 		}
+		if len(stripeConns) > 0 {
+			stripes := []io.ReadCloser{stream}
+			for i, sc := range stripeConns {
+				stripeStream, err := sc.ReadStream(ZFSStream, opts.checksum, true)
+				if err != nil {
+					for _, s := range stripes {
+						s.Close() // closes the connection
+					}
+					c.putStripeWires(stripeConns[i:])
+					return nil, nil, err
+				}
+				stripes = append(stripes, stripeStream)
+			}
+			stream = joinStripes(stripes)
+		}
+		decompressed, err := compression.Decompress(opts.compression.Algorithm, stream, compressionstats.FromContext(ctx))
+		if err != nil {
+			stream.Close() // closes the connection(s)
+			return nil, nil, err
+		}
+		stream = decompressed
 	}
 
 	return &res, stream, nil
@@ -144,10 +280,34 @@ func (c *Client) ReqSend(ctx context.Context, req *pdu.SendReq) (*pdu.SendRes, i
 
 func (c *Client) ReqRecv(ctx context.Context, req *pdu.ReceiveReq, stream io.ReadCloser) (*pdu.ReceiveRes, error) {
 	defer c.log.Debug("ReqRecv returns")
-	conn, err := c.getWire(ctx)
+	conn, negotiated, err := c.getWire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := withTraceparent(ctx, negotiated, c.streamOptions(negotiated))
+	compressed, err := compression.Compress(opts.compression, stream, compressionstats.FromContext(ctx))
+	if err != nil {
+		c.putWire(conn)
+		return nil, err
+	}
+	if opts.compression.Algorithm != compression.None {
+		// Stops the goroutine that compresses stream if sending failed before reading it to the end.
+		// Deferred calls run after both the send and the recv goroutine below have returned.
+		defer compressed.Close()
+	}
+	stream = compressed
+
+	stripeConns, err := c.getStripeWires(ctx, opts)
 	if err != nil {
+		c.putWire(conn)
 		return nil, err
 	}
+	var closeStripeConnsOnce sync.Once
+	closeStripeConns := func() {
+		closeStripeConnsOnce.Do(func() { c.putStripeWires(stripeConns) })
+	}
+	defer closeStripeConns()
 
 	// send and recv response concurrently to catch early exists of remote handler
 	// (e.g. disk full, permission error, etc)
@@ -168,7 +328,11 @@ func (c *Client) ReqRecv(ctx context.Context, req *pdu.ReceiveReq, stream io.Rea
 
 	sendErrChan := make(chan error)
 	go func() {
-		if err := c.send(ctx, conn, EndpointRecv, req, stream); err != nil {
+		if len(stripeConns) > 0 {
+			sendErrChan <- c.sendStripedRecv(ctx, conn, stripeConns, closeStripeConns, opts, req, stream)
+			return
+		}
+		if err := c.send(ctx, conn, EndpointRecv, opts, req, stream); err != nil {
 			sendErrChan <- err
 		} else {
 			sendErrChan <- nil
@@ -194,6 +358,7 @@ func (c *Client) ReqRecv(ctx context.Context, req *pdu.ReceiveReq, stream io.Rea
 		}
 		if !didTryClose && (res.err != nil || sendErr != nil) {
 			didTryClose = true
+			closeStripeConns()
 			if err := conn.Close(); err != nil {
 				c.log.WithError(err).Error("ReqRecv: cannot close connection, will likely block indefinitely")
 			}
@@ -218,13 +383,13 @@ func (c *Client) ReqRecv(ctx context.Context, req *pdu.ReceiveReq, stream io.Rea
 }
 
 func (c *Client) ReqPing(ctx context.Context, req *pdu.PingReq) (*pdu.PingRes, error) {
-	conn, err := c.getWire(ctx)
+	conn, negotiated, err := c.getWire(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer c.putWire(conn)
 
-	if err := c.send(ctx, conn, EndpointPing, req, nil); err != nil {
+	if err := c.send(ctx, conn, EndpointPing, withTraceparent(ctx, negotiated, streamOptions{}), req, nil); err != nil {
 		return nil, err
 	}
 
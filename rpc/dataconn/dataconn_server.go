@@ -3,16 +3,20 @@ package dataconn
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
 
 	"github.com/golang/protobuf/proto"
 
+	"github.com/zrepl/zrepl/daemon/logging/trace"
 	"github.com/zrepl/zrepl/logger"
 	"github.com/zrepl/zrepl/replication/logic/pdu"
+	"github.com/zrepl/zrepl/rpc/dataconn/compression"
 	"github.com/zrepl/zrepl/rpc/dataconn/stream"
 	"github.com/zrepl/zrepl/transport"
+	"github.com/zrepl/zrepl/util/admission"
 )
 
 // WireInterceptor has a chance to exchange the context and connection on each client connection.
@@ -45,6 +49,8 @@ type Server struct {
 	wi  WireInterceptor
 	ci  ContextInterceptor
 	log Logger
+
+	stripeSessions stripeSessions
 }
 
 var noopContextInteceptor = func(ctx context.Context, _ ContextInterceptorData, handler func(context.Context)) {
@@ -135,23 +141,46 @@ func (s *Server) serveConn(nc *transport.AuthConn) {
 		}
 	}()
 
-	header, err := c.ReadStreamedMessage(ctx, RequestHeaderMaxSize, ReqHeader)
+	headerBytes, err := c.ReadStreamedMessage(ctx, RequestHeaderMaxSize, ReqHeader)
 	if err != nil {
 		s.log.WithError(err).Error("error reading structured part")
 		return
 	}
-	endpoint := string(header)
+	header, decodeErr := decodeRequestHeader(string(headerBytes))
+	if decodeErr != nil {
+		s.log.WithError(decodeErr).Error("cannot decode request header")
+		return
+	}
+
+	if header.endpoint == EndpointStripe {
+		// not a request, only carries a stream => no need for the context interceptor
+		if err := s.stripeSessions.serveStripeConn(nc.ClientIdentity(), header, c); err != nil {
+			s.log.WithError(err).Error("cannot serve stripe connection")
+		}
+		return
+	}
+
+	if tp, ok := header.options[reqHeaderOptionTraceparent]; ok {
+		ctx = trace.WithRemoteParent(ctx, tp)
+	}
 
 	data := contextInterceptorData{
-		fullMethod:     endpoint,
+		fullMethod:     header.endpoint,
 		clientIdentity: nc.ClientIdentity(),
 	}
 	s.ci(ctx, data, func(ctx context.Context) {
-		s.serveConnRequest(ctx, endpoint, c)
+		s.serveConnRequest(ctx, nc.ClientIdentity(), header, c)
 	})
 }
 
-func (s *Server) serveConnRequest(ctx context.Context, endpoint string, c *stream.Conn) {
+func (s *Server) serveConnRequest(ctx context.Context, clientIdentity string, header *requestHeader, c *stream.Conn) {
+	endpoint := header.endpoint
+
+	opts, optsErr := streamOptionsFromRequestHeader(header)
+	if optsErr != nil {
+		s.log.WithError(optsErr).Error("invalid stream options in request header")
+		return
+	}
 
 	reqStructured, err := c.ReadStreamedMessage(ctx, RequestStructuredMaxSize, ReqStructured)
 	if err != nil {
@@ -159,11 +188,25 @@ func (s *Server) serveConnRequest(ctx context.Context, endpoint string, c *strea
 		return
 	}
 
+	// the connections that carry stripes 1..n of the ZFSStream
+	var stripeConns []*stream.Conn
+	if opts.stripes > 1 {
+		var release func()
+		var claimErr error
+		stripeConns, release, claimErr = s.stripeSessions.claim(ctx, clientIdentity, opts)
+		if claimErr != nil {
+			s.log.WithError(claimErr).Error("cannot set up striped stream")
+			return
+		}
+		defer release()
+	}
+
 	s.log.WithField("endpoint", endpoint).Debug("calling handler")
 
 	var res proto.Message
 	var sendStream io.ReadCloser
 	var handlerErr error
+	var recvStreams []*stream.StreamReader // the client's ZFSStream, one per stripe
 	switch endpoint {
 	case EndpointSend:
 		var req pdu.SendReq
@@ -178,12 +221,33 @@ func (s *Server) serveConnRequest(ctx context.Context, endpoint string, c *strea
 			s.log.WithError(err).Error("cannot unmarshal receive request")
 			return
 		}
-		stream, err := c.ReadStream(ZFSStream, false)
+		stream, err := c.ReadStream(ZFSStream, opts.checksum, false)
 		if err != nil {
 			s.log.WithError(err).Error("cannot open stream in receive request")
 			return
 		}
-		res, handlerErr = s.h.Receive(ctx, &req, stream) // SHADOWING
+		recvStreams = append(recvStreams, stream)
+		var joinedStream io.ReadCloser = stream
+		if len(stripeConns) > 0 {
+			stripes := []io.ReadCloser{stream}
+			for _, sc := range stripeConns {
+				stripeStream, err := sc.ReadStream(ZFSStream, opts.checksum, false)
+				if err != nil {
+					s.log.WithError(err).Error("cannot open stripe stream in receive request")
+					return
+				}
+				recvStreams = append(recvStreams, stripeStream)
+				stripes = append(stripes, stripeStream)
+			}
+			joinedStream = joinStripes(stripes)
+			defer joinedStream.Close()
+		}
+		zfsStream, err := compression.Decompress(opts.compression.Algorithm, joinedStream, nil)
+		if err != nil {
+			s.log.WithError(err).Error("cannot decompress stream in receive request")
+			return
+		}
+		res, handlerErr = s.h.Receive(ctx, &req, zfsStream) // SHADOWING
 	case EndpointPing:
 		var req pdu.PingReq
 		if err := proto.Unmarshal(reqStructured, &req); err != nil {
@@ -201,6 +265,19 @@ func (s *Server) serveConnRequest(ctx context.Context, endpoint string, c *strea
 	// prepare protobuf now to return the protobuf error in the header
 	// if marshaling fails. We consider failed marshaling a handler error
 	var protobuf *bytes.Buffer
+	if handlerErr == nil && sendStream != nil {
+		compressed, err := compression.Compress(opts.compression, sendStream, nil)
+		if err != nil {
+			s.log.WithError(err).Error("cannot compress send stream")
+			if closeErr := sendStream.Close(); closeErr != nil {
+				s.log.WithError(closeErr).Error("cannot close send stream")
+			}
+			sendStream = nil
+			handlerErr = err
+		} else {
+			sendStream = compressed
+		}
+	}
 	if handlerErr == nil {
 		if res == nil {
 			handlerErr = fmt.Errorf("implementation error: handler for endpoint %q returns nil error and nil result", endpoint)
@@ -219,8 +296,7 @@ func (s *Server) serveConnRequest(ctx context.Context, endpoint string, c *strea
 	if handlerErr == nil {
 		resHeaderBuf.WriteString(responseHeaderHandlerOk)
 	} else {
-		resHeaderBuf.WriteString(responseHeaderHandlerErrorPrefix)
-		resHeaderBuf.WriteString(handlerErr.Error())
+		resHeaderBuf.WriteString(handlerErrorHeader(handlerErr, recvStreams))
 	}
 	if err := c.WriteStreamedMessage(ctx, &resHeaderBuf, ResHeader); err != nil {
 		s.log.WithError(err).Error("cannot write response header")
@@ -237,8 +313,12 @@ func (s *Server) serveConnRequest(ctx context.Context, endpoint string, c *strea
 		return
 	}
 
-	if sendStream != nil {
-		err := c.SendStream(ctx, sendStream, ZFSStream)
+	if sendStream != nil && len(stripeConns) > 0 {
+		if err := sendStriped(ctx, c, stripeConns, sendStream, opts); err != nil {
+			s.log.WithError(err).Error("cannot write striped send stream")
+		}
+	} else if sendStream != nil {
+		err := c.SendStream(ctx, sendStream, ZFSStream, opts.checksum)
 		closeErr := sendStream.Close()
 		if closeErr != nil {
 			s.log.WithError(err).Error("cannot close send stream")
@@ -248,3 +328,20 @@ func (s *Server) serveConnRequest(ctx context.Context, endpoint string, c *strea
 		}
 	}
 }
+
+// handlerErrorHeader returns the response header for handlerErr.
+// Errors that the client handles specifically are signaled with a distinct prefix:
+// a checksum mismatch on any of the streams received from the client, so that the client
+// can retry the request, and a rejected admission, so that the client retries it later.
+func handlerErrorHeader(handlerErr error, recvStreams []*stream.StreamReader) string {
+	var busy *admission.ResourceExhaustedError
+	if errors.As(handlerErr, &busy) {
+		return encodeResourceExhaustedHeader(busy)
+	}
+	for _, r := range recvStreams {
+		if err := r.ReadStreamError(); err != nil && err.Kind == stream.ReadStreamErrorKindChecksumMismatch {
+			return responseHeaderHandlerErrorChecksumMismatchPrefix + handlerErr.Error()
+		}
+	}
+	return responseHeaderHandlerErrorPrefix + handlerErr.Error()
+}
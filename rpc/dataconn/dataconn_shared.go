@@ -1,13 +1,24 @@
 package dataconn
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/zrepl/zrepl/rpc/dataconn/checksum"
+	"github.com/zrepl/zrepl/rpc/dataconn/compression"
+	"github.com/zrepl/zrepl/rpc/dataconn/stripe"
+	"github.com/zrepl/zrepl/util/admission"
 )
 
 const (
 	EndpointPing string = "/v1/ping"
 	EndpointSend string = "/v1/send"
 	EndpointRecv string = "/v1/recv"
+	// carries an additional stripe of the ZFSStream of a Send or Recv request, see package stripe
+	EndpointStripe string = "/v1/stripe"
 )
 
 const (
@@ -33,4 +44,138 @@ const (
 const (
 	responseHeaderHandlerOk          = "HANDLER OK\n"
 	responseHeaderHandlerErrorPrefix = "HANDLER ERROR:\n"
+	// the handler failed because the checksum of the stream sent by the client did not match
+	responseHeaderHandlerErrorChecksumMismatchPrefix = "HANDLER ERROR checksum mismatch:\n"
+	// the handler did not admit the request, see encodeResourceExhaustedHeader
+	responseHeaderHandlerErrorResourceExhaustedPrefix = "HANDLER ERROR resource exhausted:\n"
+)
+
+// encodeResourceExhaustedHeader encodes e as a response header:
+// the prefix, followed by the retry-after duration and the exhausted resource on separate lines.
+func encodeResourceExhaustedHeader(e *admission.ResourceExhaustedError) string {
+	return fmt.Sprintf("%s%s\n%s", responseHeaderHandlerErrorResourceExhaustedPrefix, e.RetryAfter, e.Resource)
+}
+
+func decodeResourceExhaustedHeader(header string) (*admission.ResourceExhaustedError, error) {
+	lines := strings.SplitN(strings.TrimPrefix(header, responseHeaderHandlerErrorResourceExhaustedPrefix), "\n", 2)
+	if len(lines) != 2 {
+		return nil, fmt.Errorf("resource exhausted header without resource: %q", header)
+	}
+	retryAfter, err := time.ParseDuration(lines[0])
+	if err != nil || retryAfter < 0 {
+		return nil, fmt.Errorf("invalid retry-after in resource exhausted header: %q", lines[0])
+	}
+	return &admission.ResourceExhaustedError{Resource: lines[1], RetryAfter: retryAfter}, nil
+}
+
+// The request header consists of the endpoint, optionally followed by
+// newline-separated key=value options.
+// Clients must only send options if the server supports them, which is
+// determined through the versionhandshake extensions negotiated on the connection.
+type requestHeader struct {
+	endpoint string
+	options  map[string]string
+}
+
+const (
+	// the compression.Config that the peer writing the ZFSStream uses
+	reqHeaderOptionCompression = "compression"
+	// the checksum.Algorithm that protects the frames of the ZFSStream
+	reqHeaderOptionChecksum = "checksum"
+	// the number of stripes of the ZFSStream, including the one on the request's connection
+	reqHeaderOptionStripes = "stripes"
+	// identifies the connections that carry the stripes of the ZFSStream
+	reqHeaderOptionStripeSession = "stripe-session"
+	// the index of the stripe carried by an EndpointStripe connection
+	reqHeaderOptionStripeIndex = "stripe-index"
+	// the W3C Trace Context traceparent of the client's span (see TraceContextExtension)
+	reqHeaderOptionTraceparent = "traceparent"
 )
+
+// TraceContextExtension is the versionhandshake extension that advertises support for
+// the traceparent request header option.
+const TraceContextExtension = "dataconn-tracecontext-v1"
+
+func (h *requestHeader) encode() string {
+	var b strings.Builder
+	b.WriteString(h.endpoint)
+	keys := make([]string, 0, len(h.options))
+	for k := range h.options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\n%s=%s", k, h.options[k])
+	}
+	return b.String()
+}
+
+func decodeRequestHeader(s string) (*requestHeader, error) {
+	lines := strings.Split(s, "\n")
+	h := &requestHeader{endpoint: lines[0], options: make(map[string]string, len(lines)-1)}
+	for _, l := range lines[1:] {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid request header option %q", l)
+		}
+		h.options[kv[0]] = kv[1]
+	}
+	return h, nil
+}
+
+// streamOptions determine how the ZFSStream of a request is transferred.
+// The client chooses them based on the negotiated versionhandshake extensions
+// and transmits them to the server as request header options.
+type streamOptions struct {
+	compression compression.Config
+	checksum    checksum.Algorithm
+	// the stream is split into stripes if stripes > 1
+	stripes       int
+	stripeSession string
+	// not a stream option in the narrow sense, but transmitted the same way
+	traceparent string
+}
+
+func newRequestHeader(endpoint string, opts streamOptions) *requestHeader {
+	h := &requestHeader{endpoint: endpoint, options: make(map[string]string)}
+	if opts.compression.Algorithm != compression.None {
+		h.options[reqHeaderOptionCompression] = opts.compression.String()
+	}
+	if opts.checksum != checksum.None {
+		h.options[reqHeaderOptionChecksum] = opts.checksum.String()
+	}
+	if opts.stripes > 1 {
+		h.options[reqHeaderOptionStripes] = strconv.Itoa(opts.stripes)
+		h.options[reqHeaderOptionStripeSession] = opts.stripeSession
+	}
+	if opts.traceparent != "" {
+		h.options[reqHeaderOptionTraceparent] = opts.traceparent
+	}
+	return h
+}
+
+func streamOptionsFromRequestHeader(h *requestHeader) (opts streamOptions, err error) {
+	if opt, ok := h.options[reqHeaderOptionCompression]; ok {
+		opts.compression, err = compression.ConfigFromString(opt)
+		if err != nil {
+			return opts, err
+		}
+	}
+	if opt, ok := h.options[reqHeaderOptionChecksum]; ok {
+		opts.checksum, err = checksum.AlgorithmFromString(opt)
+		if err != nil {
+			return opts, err
+		}
+	}
+	if opt, ok := h.options[reqHeaderOptionStripes]; ok {
+		opts.stripes, err = strconv.Atoi(opt)
+		if err != nil || opts.stripes < 2 || opts.stripes > stripe.MaxStripes {
+			return opts, fmt.Errorf("invalid stripe count %q", opt)
+		}
+		opts.stripeSession = h.options[reqHeaderOptionStripeSession]
+		if opts.stripeSession == "" {
+			return opts, fmt.Errorf("stripe count without stripe session")
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,263 @@
+package dataconn
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zrepl/zrepl/replication/logic/pdu"
+	"github.com/zrepl/zrepl/rpc/dataconn/stream"
+	"github.com/zrepl/zrepl/rpc/dataconn/stripe"
+	"github.com/zrepl/zrepl/util/envconst"
+)
+
+// A striped request consists of the request's own connection, which carries stripe 0,
+// and one EndpointStripe connection per additional stripe.
+// All connections of a request carry the same stripe session identifier.
+// The server matches them up in a stripeSession.
+//
+// The EndpointStripe connections do not carry a request or response, only the stripe.
+
+var (
+	// how long the server waits for all connections of a striped request
+	stripeSessionTimeout = envconst.Duration("ZREPL_DATACONN_STRIPE_SESSION_TIMEOUT", 30*time.Second)
+	// number of out-of-order chunks buffered per stripe by the reading side
+	stripeMaxPendingChunksPerStripe = envconst.Int("ZREPL_DATACONN_STRIPE_MAX_PENDING_CHUNKS_PER_STRIPE", 4)
+)
+
+func newStripeSessionID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id[:])
+}
+
+func joinStripes(stripes []io.ReadCloser) io.ReadCloser {
+	return stripe.Join(stripes, stripeMaxPendingChunksPerStripe*len(stripes))
+}
+
+type stripeSession struct {
+	clientIdentity string
+
+	mtx     sync.Mutex
+	conns   []*stream.Conn // conns[0] is unused, it's the request's own connection
+	missing int
+	claimed bool // by the request on the stripe 0 connection
+
+	ready      chan struct{} // closed once all conns have arrived
+	done       chan struct{} // closed once the session is over
+	finishOnce sync.Once
+}
+
+func (s *stripeSession) finish() {
+	s.finishOnce.Do(func() { close(s.done) })
+}
+
+type stripeSessions struct {
+	mtx      sync.Mutex
+	sessions map[string]*stripeSession
+}
+
+// lookup returns the session with the given id, creating it if it does not exist.
+func (s *stripeSessions) lookup(id, clientIdentity string, stripes int) (*stripeSession, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.sessions == nil {
+		s.sessions = make(map[string]*stripeSession)
+	}
+	sess, ok := s.sessions[id]
+	if !ok {
+		sess = &stripeSession{
+			clientIdentity: clientIdentity,
+			conns:          make([]*stream.Conn, stripes),
+			missing:        stripes - 1,
+			ready:          make(chan struct{}),
+			done:           make(chan struct{}),
+		}
+		s.sessions[id] = sess
+		time.AfterFunc(stripeSessionTimeout, func() { s.expire(id, sess) })
+	}
+	if sess.clientIdentity != clientIdentity {
+		return nil, fmt.Errorf("stripe session %q belongs to a different client", id)
+	}
+	if len(sess.conns) != stripes {
+		return nil, fmt.Errorf("stripe session %q has %d stripes, not %d", id, len(sess.conns), stripes)
+	}
+	return sess, nil
+}
+
+// expire ends sessions that are not complete or not claimed after stripeSessionTimeout
+func (s *stripeSessions) expire(id string, sess *stripeSession) {
+	sess.mtx.Lock()
+	complete := sess.claimed && sess.missing == 0
+	sess.mtx.Unlock()
+	if complete {
+		return // the request is in charge of ending the session
+	}
+	s.remove(id, sess)
+}
+
+func (s *stripeSessions) remove(id string, sess *stripeSession) {
+	s.mtx.Lock()
+	if s.sessions[id] == sess {
+		delete(s.sessions, id)
+	}
+	s.mtx.Unlock()
+	sess.finish()
+}
+
+// claim returns the stripe connections of the striped request with opts
+// once they have all arrived.
+// The caller must call the returned release function when it is done with the connections.
+func (s *stripeSessions) claim(ctx context.Context, clientIdentity string, opts streamOptions) (conns []*stream.Conn, release func(), err error) {
+	sess, err := s.lookup(opts.stripeSession, clientIdentity, opts.stripes)
+	if err != nil {
+		return nil, nil, err
+	}
+	release = func() { s.remove(opts.stripeSession, sess) }
+
+	sess.mtx.Lock()
+	if sess.claimed {
+		sess.mtx.Unlock()
+		return nil, nil, fmt.Errorf("stripe session %q claimed by multiple requests", opts.stripeSession)
+	}
+	sess.claimed = true
+	sess.mtx.Unlock()
+
+	select {
+	case <-sess.ready:
+		return sess.conns[1:], release, nil
+	case <-sess.done:
+		return nil, nil, fmt.Errorf("timeout waiting for stripe connections")
+	case <-ctx.Done():
+		release()
+		return nil, nil, ctx.Err()
+	}
+}
+
+// serveStripeConn handles an EndpointStripe connection.
+// It returns once the request that uses the connection is done with it.
+func (s *stripeSessions) serveStripeConn(clientIdentity string, header *requestHeader, c *stream.Conn) error {
+	id := header.options[reqHeaderOptionStripeSession]
+	stripes, err := strconv.Atoi(header.options[reqHeaderOptionStripes])
+	if err != nil || stripes < 2 || stripes > stripe.MaxStripes {
+		return fmt.Errorf("invalid stripe count %q", header.options[reqHeaderOptionStripes])
+	}
+	idx, err := strconv.Atoi(header.options[reqHeaderOptionStripeIndex])
+	if err != nil || idx < 1 || idx >= stripes {
+		return fmt.Errorf("invalid stripe index %q", header.options[reqHeaderOptionStripeIndex])
+	}
+	if id == "" {
+		return fmt.Errorf("missing stripe session")
+	}
+
+	sess, err := s.lookup(id, clientIdentity, stripes)
+	if err != nil {
+		return err
+	}
+	sess.mtx.Lock()
+	if sess.conns[idx] != nil {
+		sess.mtx.Unlock()
+		return fmt.Errorf("duplicate connection for stripe %d", idx)
+	}
+	sess.conns[idx] = c
+	sess.missing--
+	if sess.missing == 0 {
+		close(sess.ready)
+	}
+	sess.mtx.Unlock()
+
+	<-sess.done
+	return nil
+}
+
+// sendStriped splits sendStream into len(conns)+1 stripes and sends them
+// over c and conns. It closes sendStream.
+func sendStriped(ctx context.Context, c *stream.Conn, conns []*stream.Conn, sendStream io.ReadCloser, opts streamOptions) error {
+	parts := stripe.Split(sendStream, len(conns)+1)
+	errs := make(chan error, len(conns))
+	for i := range conns {
+		go func(i int) {
+			errs <- conns[i].SendStream(ctx, parts[i+1], ZFSStream, opts.checksum)
+		}(i)
+	}
+	err := c.SendStream(ctx, parts[0], ZFSStream, opts.checksum)
+	if err != nil {
+		// closing sendStream makes the other stripes fail
+		_ = parts[0].Close()
+	}
+	for range conns {
+		if stripeErr := <-errs; stripeErr != nil && err == nil {
+			err = stripeErr
+		}
+	}
+	if closeErr := parts[0].Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// sendStripedRecv sends an EndpointRecv request whose stream is striped across conn and stripeConns.
+// closeStripeConns is called as soon as one of the stripes fails, to unblock the others.
+// The caller remains responsible for closing zfsStream.
+func (c *Client) sendStripedRecv(ctx context.Context, conn *stream.Conn, stripeConns []*stream.Conn, closeStripeConns func(), opts streamOptions, req *pdu.ReceiveReq, zfsStream io.ReadCloser) error {
+	parts := stripe.Split(ioutil.NopCloser(zfsStream), len(stripeConns)+1)
+	errs := make(chan error, len(stripeConns))
+	for i := range stripeConns {
+		go func(i int) {
+			err := stripeConns[i].SendStream(ctx, parts[i+1], ZFSStream, opts.checksum)
+			if err != nil {
+				closeStripeConns()
+			}
+			errs <- err
+		}(i)
+	}
+	err := c.send(ctx, conn, EndpointRecv, opts, req, parts[0])
+	if err != nil {
+		closeStripeConns()
+	}
+	for range stripeConns {
+		if stripeErr := <-errs; stripeErr != nil && err == nil {
+			err = stripeErr
+		}
+	}
+	return err
+}
+
+// getStripeWires connects and announces the EndpointStripe connections of a striped request.
+// It returns no connections if opts is not striped.
+func (c *Client) getStripeWires(ctx context.Context, opts streamOptions) ([]*stream.Conn, error) {
+	if opts.stripes <= 1 {
+		return nil, nil
+	}
+	conns := make([]*stream.Conn, 0, opts.stripes-1)
+	for i := 1; i < opts.stripes; i++ {
+		conn, _, err := c.getWire(ctx)
+		if err != nil {
+			c.putStripeWires(conns)
+			return nil, err
+		}
+		conns = append(conns, conn)
+		h := newRequestHeader(EndpointStripe, opts)
+		h.options[reqHeaderOptionStripeIndex] = strconv.Itoa(i)
+		if err := conn.WriteStreamedMessage(ctx, bytes.NewBufferString(h.encode()), ReqHeader); err != nil {
+			c.putStripeWires(conns)
+			return nil, err
+		}
+	}
+	return conns, nil
+}
+
+func (c *Client) putStripeWires(conns []*stream.Conn) {
+	for _, conn := range conns {
+		c.putWire(conn)
+	}
+}
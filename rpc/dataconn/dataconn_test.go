@@ -1 +1,402 @@
 package dataconn
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	configpkg "github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/daemon/logging/trace"
+	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/replication/logic/pdu"
+	"github.com/zrepl/zrepl/rpc/dataconn/checksum"
+	"github.com/zrepl/zrepl/rpc/dataconn/compression"
+	"github.com/zrepl/zrepl/rpc/dataconn/stripe"
+	"github.com/zrepl/zrepl/rpc/versionhandshake"
+	"github.com/zrepl/zrepl/transport"
+	"github.com/zrepl/zrepl/transport/local"
+	"github.com/zrepl/zrepl/util/admission"
+)
+
+func TestRequestHeaderEncodeDecode(t *testing.T) {
+	// headers without options are what clients without extensions send
+	h, err := decodeRequestHeader(EndpointSend)
+	require.NoError(t, err)
+	assert.Equal(t, EndpointSend, h.endpoint)
+	assert.Empty(t, h.options)
+	assert.Equal(t, EndpointSend, h.encode())
+
+	in := &requestHeader{
+		endpoint: EndpointRecv,
+		options: map[string]string{
+			reqHeaderOptionCompression: "zstd:3",
+			"other":                    "a=b",
+		},
+	}
+	out, err := decodeRequestHeader(in.encode())
+	require.NoError(t, err)
+	assert.Equal(t, in, out)
+
+	_, err = decodeRequestHeader(EndpointSend + "\nnoequalsign")
+	assert.Error(t, err)
+}
+
+func TestStreamOptionsRequestHeader(t *testing.T) {
+	for _, opts := range []streamOptions{
+		{},
+		{compression: compression.Config{Algorithm: compression.LZ4, Level: 1}},
+		{checksum: checksum.SHA256},
+		{compression: compression.Config{Algorithm: compression.Zstd}, checksum: checksum.XXHash64},
+	} {
+		h, err := decodeRequestHeader(newRequestHeader(EndpointSend, opts).encode())
+		require.NoError(t, err)
+		decoded, err := streamOptionsFromRequestHeader(h)
+		require.NoError(t, err)
+		assert.Equal(t, opts, decoded)
+	}
+
+	_, err := streamOptionsFromRequestHeader(&requestHeader{
+		endpoint: EndpointSend,
+		options:  map[string]string{reqHeaderOptionChecksum: "crc32"},
+	})
+	assert.Error(t, err)
+}
+
+func TestResourceExhaustedHeader(t *testing.T) {
+	busy := &admission.ResourceExhaustedError{Resource: "concurrent transfers of client \"prod1\" (limit 2)", RetryAfter: 90 * time.Second}
+	decoded, err := decodeResourceExhaustedHeader(encodeResourceExhaustedHeader(busy))
+	require.NoError(t, err)
+	assert.Equal(t, busy, decoded)
+
+	for _, invalid := range []string{
+		responseHeaderHandlerErrorResourceExhaustedPrefix,
+		responseHeaderHandlerErrorResourceExhaustedPrefix + "90s",
+		responseHeaderHandlerErrorResourceExhaustedPrefix + "forever\nfoo",
+		responseHeaderHandlerErrorResourceExhaustedPrefix + "-1s\nfoo",
+	} {
+		_, err := decodeResourceExhaustedHeader(invalid)
+		assert.Error(t, err, "%q", invalid)
+	}
+}
+
+type testHandler struct {
+	t       *testing.T
+	data    []byte
+	mtx     sync.Mutex
+	recvd   [][]byte
+	recvErr error
+}
+
+func (h *testHandler) Send(ctx context.Context, r *pdu.SendReq) (*pdu.SendRes, io.ReadCloser, error) {
+	return &pdu.SendRes{}, ioutil.NopCloser(bytes.NewReader(h.data)), nil
+}
+
+func (h *testHandler) Receive(ctx context.Context, r *pdu.ReceiveReq, receive io.ReadCloser) (*pdu.ReceiveRes, error) {
+	data, err := ioutil.ReadAll(receive)
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.recvd = append(h.recvd, data)
+	if err != nil {
+		return nil, err
+	}
+	if h.recvErr != nil {
+		return nil, h.recvErr
+	}
+	return &pdu.ReceiveRes{}, nil
+}
+
+func (h *testHandler) PingDataconn(ctx context.Context, r *pdu.PingReq) (*pdu.PingRes, error) {
+	return &pdu.PingRes{Echo: r.GetMessage()}, nil
+}
+
+func TestStripedSendRecv(t *testing.T) {
+	data := make([]byte, 5*stripe.ChunkSize+42)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	for _, config := range []ClientConfig{
+		{},
+		{Stripes: 4},
+		{Stripes: 3, Checksum: checksum.XXHash64, Compression: compression.Config{Algorithm: compression.LZ4}},
+	} {
+		t.Run(fmt.Sprintf("%#v", config), func(t *testing.T) {
+			listenerName := fmt.Sprintf("dataconn-test-%p", &config)
+			exts := append(append([]string{stripe.Extension}, checksum.Extensions()...), compression.Extensions()...)
+			l := versionhandshake.Listener(local.GetLocalListener(listenerName), 10*time.Second, exts)
+			cn, err := local.LocalConnecterFromConfig(&configpkg.LocalConnect{
+				ListenerName:   listenerName,
+				ClientIdentity: "client",
+				DialTimeout:    2 * time.Second,
+			})
+			require.NoError(t, err)
+
+			log := logger.NewTestLogger(t)
+			h := &testHandler{t: t, data: data}
+			srv := NewServer(nil, nil, log, h)
+			ctx, cancel := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				srv.Serve(ctx, l)
+			}()
+			defer wg.Wait()
+			defer cancel()
+
+			client := NewClient(versionhandshake.Connecter(cn, 10*time.Second, exts), log, config)
+
+			_, stream, err := client.ReqSend(ctx, &pdu.SendReq{})
+			require.NoError(t, err)
+			sent, err := ioutil.ReadAll(stream)
+			require.NoError(t, err)
+			require.NoError(t, stream.Close())
+			assert.True(t, bytes.Equal(data, sent))
+
+			_, err = client.ReqRecv(ctx, &pdu.ReceiveReq{}, ioutil.NopCloser(bytes.NewReader(data)))
+			require.NoError(t, err)
+			h.mtx.Lock()
+			require.Len(t, h.recvd, 1)
+			assert.True(t, bytes.Equal(data, h.recvd[0]))
+			h.mtx.Unlock()
+		})
+	}
+}
+
+func TestTraceparentPropagation(t *testing.T) {
+	for _, negotiate := range []bool{true, false} {
+		t.Run(fmt.Sprintf("negotiate=%v", negotiate), func(t *testing.T) {
+			listenerName := fmt.Sprintf("dataconn-test-traceparent-%v", negotiate)
+			var exts []string
+			if negotiate {
+				exts = append(exts, TraceContextExtension)
+			}
+			l := versionhandshake.Listener(local.GetLocalListener(listenerName), 10*time.Second, exts)
+			cn, err := local.LocalConnecterFromConfig(&configpkg.LocalConnect{
+				ListenerName:   listenerName,
+				ClientIdentity: "client",
+				DialTimeout:    2 * time.Second,
+			})
+			require.NoError(t, err)
+
+			var mtx sync.Mutex
+			var handlerTraceparent string
+			ci := func(ctx context.Context, _ ContextInterceptorData, handler func(context.Context)) {
+				ctx, endTask := trace.WithTask(ctx, "handler")
+				defer endTask()
+				mtx.Lock()
+				handlerTraceparent = trace.Traceparent(ctx)
+				mtx.Unlock()
+				handler(ctx)
+			}
+
+			log := logger.NewTestLogger(t)
+			srv := NewServer(nil, ci, log, &testHandler{t: t})
+			ctx, cancel := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				srv.Serve(ctx, l)
+			}()
+			defer wg.Wait()
+			defer cancel()
+
+			client := NewClient(versionhandshake.Connecter(cn, 10*time.Second, exts), log, ClientConfig{})
+			clientCtx, endTask := trace.WithTask(ctx, "client")
+			defer endTask()
+			_, err = client.ReqPing(clientCtx, &pdu.PingReq{Message: "hello"})
+			require.NoError(t, err)
+
+			// format: 00-<trace-id>-<span-id>-01
+			clientTraceID := strings.Split(trace.Traceparent(clientCtx), "-")[1]
+			mtx.Lock()
+			defer mtx.Unlock()
+			require.NotEmpty(t, handlerTraceparent)
+			handlerTraceID := strings.Split(handlerTraceparent, "-")[1]
+			if negotiate {
+				assert.Equal(t, clientTraceID, handlerTraceID)
+			} else {
+				assert.NotEqual(t, clientTraceID, handlerTraceID)
+			}
+		})
+	}
+}
+
+// corruptingWire flips the first occurrence of corruptByte that it reads.
+type corruptingWire struct {
+	transport.Wire
+	corrupted bool
+}
+
+const corruptByte = 0xaa
+
+func (w *corruptingWire) Read(p []byte) (int, error) {
+	n, err := w.Wire.Read(p)
+	if !w.corrupted {
+		if i := bytes.IndexByte(p[:n], corruptByte); i >= 0 {
+			p[i] = ^p[i]
+			w.corrupted = true
+		}
+	}
+	return n, err
+}
+
+func TestRecvRemoteHandlerErrorKind(t *testing.T) {
+	data := bytes.Repeat([]byte{corruptByte}, 1<<16)
+
+	for _, tc := range []struct {
+		name      string
+		corrupt   bool
+		handleErr error
+		kind      RemoteHandlerErrorKind
+	}{
+		{name: "checksum-mismatch", corrupt: true, kind: RemoteHandlerErrorKindChecksumMismatch},
+		{name: "generic", handleErr: errors.New("handler failed"), kind: RemoteHandlerErrorKindGeneric},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			listenerName := fmt.Sprintf("dataconn-test-handler-error-%s", tc.name)
+			exts := checksum.Extensions()
+			l := versionhandshake.Listener(local.GetLocalListener(listenerName), 10*time.Second, exts)
+			cn, err := local.LocalConnecterFromConfig(&configpkg.LocalConnect{
+				ListenerName:   listenerName,
+				ClientIdentity: "client",
+				DialTimeout:    2 * time.Second,
+			})
+			require.NoError(t, err)
+
+			var wi WireInterceptor
+			if tc.corrupt {
+				wi = func(ctx context.Context, rawConn *transport.AuthConn) (context.Context, *transport.AuthConn) {
+					return ctx, transport.NewAuthConn(&corruptingWire{Wire: rawConn}, rawConn.ClientIdentity())
+				}
+			}
+
+			log := logger.NewTestLogger(t)
+			srv := NewServer(wi, nil, log, &testHandler{t: t, recvErr: tc.handleErr})
+			ctx, cancel := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				srv.Serve(ctx, l)
+			}()
+			defer wg.Wait()
+			defer cancel()
+
+			client := NewClient(versionhandshake.Connecter(cn, 10*time.Second, exts), log, ClientConfig{Checksum: checksum.XXHash64})
+			_, err = client.ReqRecv(ctx, &pdu.ReceiveReq{}, ioutil.NopCloser(bytes.NewReader(data)))
+			require.Error(t, err)
+			var handlerErr *RemoteHandlerError
+			require.True(t, errors.As(err, &handlerErr), "%T: %s", err, err)
+			assert.Equal(t, tc.kind, handlerErr.Kind)
+			assert.Equal(t, tc.corrupt, handlerErr.Temporary())
+		})
+	}
+}
+
+// failingRecvHandler fails Receive requests without reading the stream.
+type failingRecvHandler struct {
+	testHandler
+}
+
+func (h *failingRecvHandler) Receive(ctx context.Context, r *pdu.ReceiveReq, receive io.ReadCloser) (*pdu.ReceiveRes, error) {
+	return nil, errors.New("handler failed")
+}
+
+// endlessSource yields incompressible data until it is closed.
+type endlessSource struct {
+	mtx    sync.Mutex
+	rand   *rand.Rand
+	closed bool
+}
+
+func (s *endlessSource) Read(p []byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return 0, errors.New("read after close")
+	}
+	return s.rand.Read(p)
+}
+
+func (s *endlessSource) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestCompressedRecvStopsCompressionOnError(t *testing.T) {
+	listenerName := "dataconn-test-compressed-recv-error"
+	exts := compression.Extensions()
+	l := versionhandshake.Listener(local.GetLocalListener(listenerName), 10*time.Second, exts)
+	cn, err := local.LocalConnecterFromConfig(&configpkg.LocalConnect{
+		ListenerName:   listenerName,
+		ClientIdentity: "client",
+		DialTimeout:    2 * time.Second,
+	})
+	require.NoError(t, err)
+
+	log := logger.NewTestLogger(t)
+	srv := NewServer(nil, nil, log, &failingRecvHandler{testHandler{t: t}})
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		srv.Serve(ctx, l)
+	}()
+	defer wg.Wait()
+	defer cancel()
+
+	client := NewClient(versionhandshake.Connecter(cn, 10*time.Second, exts), log,
+		ClientConfig{Compression: compression.Config{Algorithm: compression.Zstd}})
+	src := &endlessSource{rand: rand.New(rand.NewSource(1))}
+	_, err = client.ReqRecv(ctx, &pdu.ReceiveReq{}, src)
+	require.Error(t, err)
+
+	// the compressing goroutine must have exited and closed the source
+	src.mtx.Lock()
+	defer src.mtx.Unlock()
+	assert.True(t, src.closed)
+}
+
+func TestRecvResourceExhausted(t *testing.T) {
+	listenerName := "dataconn-test-resource-exhausted"
+	l := versionhandshake.Listener(local.GetLocalListener(listenerName), 10*time.Second, nil)
+	cn, err := local.LocalConnecterFromConfig(&configpkg.LocalConnect{
+		ListenerName:   listenerName,
+		ClientIdentity: "client",
+		DialTimeout:    2 * time.Second,
+	})
+	require.NoError(t, err)
+
+	busy := &admission.ResourceExhaustedError{Resource: "concurrent zfs receives", RetryAfter: time.Minute}
+	log := logger.NewTestLogger(t)
+	// handlers may wrap the error
+	srv := NewServer(nil, nil, log, &testHandler{t: t, recvErr: fmt.Errorf("cannot receive: %w", busy)})
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		srv.Serve(ctx, l)
+	}()
+	defer wg.Wait()
+	defer cancel()
+
+	client := NewClient(versionhandshake.Connecter(cn, 10*time.Second, nil), log, ClientConfig{})
+	_, err = client.ReqRecv(ctx, &pdu.ReceiveReq{}, ioutil.NopCloser(bytes.NewReader([]byte("data"))))
+	require.Error(t, err)
+	assert.Equal(t, busy, err)
+}
@@ -124,7 +124,7 @@ func (c *Conn) readFrame() (Frame, error) {
 
 	if !c.readNextValid {
 		var buf [8]byte
-		if _, err := io.ReadFull(c.nc, buf[:]); err != nil {
+		if _, err := io.ReadFull(&c.nc, buf[:]); err != nil {
 			return Frame{}, err
 		}
 		c.readNext.Unmarshal(buf[:])
@@ -354,7 +354,7 @@ func (c *Conn) Shutdown(deadline time.Time) error {
 	// TODO DoS mitigation by reading limited number of bytes
 	// see discussion above why this is non-trivial
 	defer prometheus.NewTimer(prom.ShutdownDrainSeconds).ObserveDuration()
-	n, _ := io.Copy(ioutil.Discard, c.nc)
+	n, _ := io.Copy(ioutil.Discard, &c.nc)
 	prom.ShutdownDrainBytesRead.Observe(float64(n))
 
 	return closeWire("close")
@@ -150,7 +150,7 @@ func client() {
 	ctx := context.Background()
 
 	connecter := tcpConnecter{args.addr}
-	client := dataconn.NewClient(connecter, logger)
+	client := dataconn.NewClient(connecter, logger, dataconn.ClientConfig{})
 
 	switch args.direction {
 	case "send":
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"hash"
 	"io"
 	"net"
 	"strings"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/zrepl/zrepl/logger"
 	"github.com/zrepl/zrepl/rpc/dataconn/base2bufpool"
+	"github.com/zrepl/zrepl/rpc/dataconn/checksum"
 	"github.com/zrepl/zrepl/rpc/dataconn/frameconn"
 	"github.com/zrepl/zrepl/rpc/dataconn/heartbeatconn"
 )
@@ -43,6 +45,8 @@ func getLog(ctx context.Context) Logger {
 const (
 	StreamErrTrailer uint32 = 1 << (16 + iota)
 	End
+	// follows each data frame of a checksummed stream, payload is the checksum of the data frame
+	Checksum
 	// max 16
 )
 
@@ -61,7 +65,9 @@ var bufpool = base2bufpool.New(FramePayloadShift, FramePayloadShift, base2bufpoo
 
 // if sendStream returns an error, that error will be sent as a trailer to the client
 // ok will return nil, though.
-func writeStream(ctx context.Context, c *heartbeatconn.Conn, stream io.Reader, stype uint32) (errStream, errConn error) {
+//
+// If cs is not checksum.None, each frame of type stype is followed by a Checksum frame.
+func writeStream(ctx context.Context, c *heartbeatconn.Conn, stream io.Reader, stype uint32, cs checksum.Algorithm) (errStream, errConn error) {
 	debug("writeStream: enter stype=%v", stype)
 	defer debug("writeStream: return")
 	if stype == 0 {
@@ -70,10 +76,14 @@ func writeStream(ctx context.Context, c *heartbeatconn.Conn, stream io.Reader, s
 	if !IsPublicFrameType(stype) {
 		panic(fmt.Sprintf("stype %v is not public", stype))
 	}
-	return doWriteStream(ctx, c, stream, stype)
+	var h hash.Hash
+	if cs != checksum.None {
+		h = cs.New()
+	}
+	return doWriteStream(ctx, c, stream, stype, h)
 }
 
-func doWriteStream(ctx context.Context, c *heartbeatconn.Conn, stream io.Reader, stype uint32) (errStream, errConn error) {
+func doWriteStream(ctx context.Context, c *heartbeatconn.Conn, stream io.Reader, stype uint32, h hash.Hash) (errStream, errConn error) {
 
 	// RULE1 (buf == <zero>) XOR (err == nil)
 	type read struct {
@@ -122,12 +132,21 @@ func doWriteStream(ctx context.Context, c *heartbeatconn.Conn, stream io.Reader,
 	for read := range reads {
 		if read.err == nil {
 			// RULE 1: read.buf is valid
+			var sum []byte
+			if h != nil {
+				sum = frameChecksum(h, read.buf.Bytes())
+			}
 			// next line is the hot path...
 			writeErr := c.WriteFrame(read.buf.Bytes(), stype)
 			read.buf.Free()
 			if writeErr != nil {
 				return nil, writeErr
 			}
+			if h != nil {
+				if err := c.WriteFrame(sum, Checksum); err != nil {
+					return nil, err
+				}
+			}
 			continue
 		} else if read.err == io.EOF {
 			if err := c.WriteFrame([]byte{}, End); err != nil {
@@ -136,7 +155,7 @@ func doWriteStream(ctx context.Context, c *heartbeatconn.Conn, stream io.Reader,
 			break
 		} else {
 			errReader := strings.NewReader(read.err.Error())
-			errReadErrReader, errConnWrite := doWriteStream(ctx, c, errReader, StreamErrTrailer)
+			errReadErrReader, errConnWrite := doWriteStream(ctx, c, errReader, StreamErrTrailer, nil)
 			if errReadErrReader != nil {
 				panic(errReadErrReader) // in-memory, cannot happen
 			}
@@ -147,6 +166,12 @@ func doWriteStream(ctx context.Context, c *heartbeatconn.Conn, stream io.Reader,
 	return nil, nil
 }
 
+func frameChecksum(h hash.Hash, frame []byte) []byte {
+	h.Reset()
+	_, _ = h.Write(frame) // hash.Hash.Write never returns an error
+	return h.Sum(nil)
+}
+
 type ReadStreamErrorKind int
 
 const (
@@ -155,6 +180,7 @@ const (
 	ReadStreamErrorKindSource
 	ReadStreamErrorKindStreamErrTrailerEncoding
 	ReadStreamErrorKindUnexpectedFrameType
+	ReadStreamErrorKindChecksumMismatch
 )
 
 type ReadStreamError struct {
@@ -175,6 +201,8 @@ func (e *ReadStreamError) Error() string {
 		kindStr = " source implementation error: "
 	case ReadStreamErrorKindUnexpectedFrameType:
 		kindStr = " protocol error: "
+	case ReadStreamErrorKindChecksumMismatch:
+		kindStr = " checksum mismatch: "
 	}
 	return fmt.Sprintf("stream:%s%s", kindStr, e.Err)
 }
@@ -196,6 +224,10 @@ func (e ReadStreamError) Timeout() bool {
 }
 
 func (e ReadStreamError) Temporary() bool {
+	if e.Kind == ReadStreamErrorKindChecksumMismatch {
+		// the data was corrupted in transit, a retry will likely succeed
+		return true
+	}
 	if netErr := e.netErr(); netErr != nil {
 		return netErr.Temporary()
 	}
@@ -239,7 +271,15 @@ func readFrames(reads chan<- readFrameResult, noMoreReads chan<- struct{}, c *he
 //
 // readStream calls itself recursively to read multi-frame error trailers
 // Thus, the reads channel needs to be a parameter.
-func readStream(reads <-chan readFrameResult, c *heartbeatconn.Conn, receiver io.Writer, stype uint32) *ReadStreamError {
+//
+// If cs is not checksum.None, each frame of type stype must be followed by a Checksum frame.
+// The checksum is verified before the frame is written to receiver.
+func readStream(reads <-chan readFrameResult, c *heartbeatconn.Conn, receiver io.Writer, stype uint32, cs checksum.Algorithm) *ReadStreamError {
+
+	var h hash.Hash
+	if cs != checksum.None {
+		h = cs.New()
+	}
 
 	var f frameconn.Frame
 	for read := range reads {
@@ -252,6 +292,13 @@ func readStream(reads <-chan readFrameResult, c *heartbeatconn.Conn, receiver io
 			break
 		}
 
+		if h != nil {
+			if err := verifyFrameChecksum(reads, h, cs, f.Buffer.Bytes()); err != nil {
+				f.Buffer.Free()
+				return err
+			}
+		}
+
 		n, err := receiver.Write(f.Buffer.Bytes())
 		if err != nil {
 			f.Buffer.Free()
@@ -276,7 +323,7 @@ func readStream(reads <-chan readFrameResult, c *heartbeatconn.Conn, receiver io
 			panic(fmt.Sprintf("unexpected bytes.Buffer write error: %v %v", n, err))
 		}
 		// recursion ftw! we won't enter this if stmt because stype == StreamErrTrailer in the following call
-		rserr := readStream(reads, c, &errBuf, StreamErrTrailer)
+		rserr := readStream(reads, c, &errBuf, StreamErrTrailer, checksum.None)
 		if rserr != nil && rserr.Kind == ReadStreamErrorKindWrite {
 			panic(fmt.Sprintf("unexpected bytes.Buffer write error: %s", rserr))
 		} else if rserr != nil {
@@ -291,3 +338,26 @@ func readStream(reads <-chan readFrameResult, c *heartbeatconn.Conn, receiver io
 
 	return &ReadStreamError{ReadStreamErrorKindUnexpectedFrameType, fmt.Errorf("unexpected frame type %v (expected %v)", f.Header.Type, stype)}
 }
+
+// verifyFrameChecksum reads the Checksum frame that follows frame from reads
+// and compares it to the checksum of frame.
+func verifyFrameChecksum(reads <-chan readFrameResult, h hash.Hash, cs checksum.Algorithm, frame []byte) *ReadStreamError {
+	read, ok := <-reads
+	if !ok {
+		return &ReadStreamError{ReadStreamErrorKindConn, io.ErrUnexpectedEOF}
+	}
+	if read.err != nil {
+		return &ReadStreamError{ReadStreamErrorKindConn, read.err}
+	}
+	defer read.f.Buffer.Free()
+	if read.f.Header.Type != Checksum {
+		return &ReadStreamError{ReadStreamErrorKindUnexpectedFrameType, fmt.Errorf("unexpected frame type %v (expected checksum frame %v)", read.f.Header.Type, Checksum)}
+	}
+	expected := read.f.Buffer.Bytes()
+	actual := frameChecksum(h, frame)
+	if !bytes.Equal(expected, actual) {
+		prom.ChecksumMismatches.WithLabelValues(string(cs)).Inc()
+		return &ReadStreamError{ReadStreamErrorKindChecksumMismatch, fmt.Errorf("%s checksum of %d byte frame is %x, sender computed %x", cs, len(frame), actual, expected)}
+	}
+	return nil
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/zrepl/zrepl/rpc/dataconn/checksum"
 	"github.com/zrepl/zrepl/rpc/dataconn/heartbeatconn"
 	"github.com/zrepl/zrepl/rpc/dataconn/timeoutconn"
 )
@@ -103,7 +104,7 @@ func (c *Conn) ReadStreamedMessage(ctx context.Context, maxSize uint32, frameTyp
 			panic(err)
 		}
 	}()
-	err = readStream(c.frameReads, c.hc, w, frameType)
+	err = readStream(c.frameReads, c.hc, w, frameType, checksum.None)
 	c.readClean = isConnCleanAfterRead(err)
 	_ = w.CloseWithError(readMessageSentinel) // always returns nil
 	wg.Wait()
@@ -118,6 +119,17 @@ type StreamReader struct {
 	*io.PipeReader
 	conn             *Conn
 	closeConnOnClose bool
+
+	errMtx sync.Mutex
+	err    *ReadStreamError
+}
+
+// ReadStreamError returns the error that ended reading the stream from the connection,
+// or nil if the stream was read completely or reading has not ended yet.
+func (r *StreamReader) ReadStreamError() *ReadStreamError {
+	r.errMtx.Lock()
+	defer r.errMtx.Unlock()
+	return r.err
 }
 
 func (r *StreamReader) Close() error {
@@ -129,7 +141,8 @@ func (r *StreamReader) Close() error {
 }
 
 // WriteStreamTo reads a stream from Conn and writes it to w.
-func (c *Conn) ReadStream(frameType uint32, closeConnOnClose bool) (_ *StreamReader, err error) {
+// cs must match the checksum algorithm passed to SendStream by the peer.
+func (c *Conn) ReadStream(frameType uint32, cs checksum.Algorithm, closeConnOnClose bool) (_ *StreamReader, err error) {
 
 	// if we are closed while writing, return that as an error
 	if closeGuard, cse := c.closeState.RWEntry(); cse != nil {
@@ -148,10 +161,14 @@ func (c *Conn) ReadStream(frameType uint32, closeConnOnClose bool) (_ *StreamRea
 	}
 
 	r, w := io.Pipe()
+	sr := &StreamReader{PipeReader: r, conn: c, closeConnOnClose: closeConnOnClose}
 	go func() {
 		defer c.readMtx.Unlock()
-		var err *ReadStreamError = readStream(c.frameReads, c.hc, w, frameType)
+		var err *ReadStreamError = readStream(c.frameReads, c.hc, w, frameType, cs)
 		if err != nil {
+			sr.errMtx.Lock()
+			sr.err = err
+			sr.errMtx.Unlock()
 			_ = w.CloseWithError(err) // doc guarantees that error will always be nil
 		} else {
 			w.Close()
@@ -159,7 +176,7 @@ func (c *Conn) ReadStream(frameType uint32, closeConnOnClose bool) (_ *StreamRea
 		c.readClean = isConnCleanAfterRead(err)
 	}()
 
-	return &StreamReader{PipeReader: r, conn: c, closeConnOnClose: closeConnOnClose}, nil
+	return sr, nil
 }
 
 func (c *Conn) WriteStreamedMessage(ctx context.Context, buf io.Reader, frameType uint32) (err error) {
@@ -180,7 +197,7 @@ func (c *Conn) WriteStreamedMessage(ctx context.Context, buf io.Reader, frameTyp
 	if !c.writeClean {
 		return fmt.Errorf("dataconn write message: connection is in unknown state")
 	}
-	errBuf, errConn := writeStream(ctx, c.hc, buf, frameType)
+	errBuf, errConn := writeStream(ctx, c.hc, buf, frameType, checksum.None)
 	if errBuf != nil {
 		panic(errBuf)
 	}
@@ -188,7 +205,7 @@ func (c *Conn) WriteStreamedMessage(ctx context.Context, buf io.Reader, frameTyp
 	return errConn
 }
 
-func (c *Conn) SendStream(ctx context.Context, stream io.ReadCloser, frameType uint32) (err error) {
+func (c *Conn) SendStream(ctx context.Context, stream io.ReadCloser, frameType uint32, cs checksum.Algorithm) (err error) {
 
 	// if we are closed while reading, return that as an error
 	if closeGuard, cse := c.closeState.RWEntry(); cse != nil {
@@ -207,7 +224,7 @@ func (c *Conn) SendStream(ctx context.Context, stream io.ReadCloser, frameType u
 		return fmt.Errorf("dataconn send stream: connection is in unknown state")
 	}
 
-	errStream, errConn := writeStream(ctx, c.hc, stream, frameType)
+	errStream, errConn := writeStream(ctx, c.hc, stream, frameType, cs)
 
 	c.writeClean = isConnCleanAfterWrite(errConn) // TODO correct?
 
@@ -0,0 +1,23 @@
+package stream
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var prom struct {
+	ChecksumMismatches *prometheus.CounterVec
+}
+
+func init() {
+	prom.ChecksumMismatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "zrepl",
+		Subsystem: "stream",
+		Name:      "checksum_mismatches",
+		Help:      "Number of stream frames whose checksum did not match the checksum computed by the sender. Should alert on this",
+	}, []string{"algorithm"})
+}
+
+func PrometheusRegister(registry prometheus.Registerer) error {
+	if err := registry.Register(prom.ChecksumMismatches); err != nil {
+		return err
+	}
+	return nil
+}
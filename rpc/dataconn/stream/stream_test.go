@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/zrepl/zrepl/logger"
+	"github.com/zrepl/zrepl/rpc/dataconn/checksum"
 	"github.com/zrepl/zrepl/rpc/dataconn/heartbeatconn"
 	"github.com/zrepl/zrepl/util/socketpair"
 )
@@ -21,6 +22,7 @@ func TestFrameTypesOk(t *testing.T) {
 	t.Logf("%v", End)
 	assert.True(t, heartbeatconn.IsPublicFrameType(End))
 	assert.True(t, heartbeatconn.IsPublicFrameType(StreamErrTrailer))
+	assert.True(t, heartbeatconn.IsPublicFrameType(Checksum))
 }
 
 func TestStreamer(t *testing.T) {
@@ -45,7 +47,7 @@ func TestStreamer(t *testing.T) {
 		buf.Write(
 			bytes.Repeat([]byte{1, 2}, 1<<25),
 		)
-		writeStream(ctx, a, &buf, stype)
+		writeStream(ctx, a, &buf, stype, checksum.None)
 		log.Debug("WriteStream returned")
 		a.Shutdown()
 	}()
@@ -59,7 +61,7 @@ func TestStreamer(t *testing.T) {
 			defer wg.Done()
 			readFrames(ch, nil, b)
 		}()
-		err := readStream(ch, b, &buf, stype)
+		err := readStream(ch, b, &buf, stype, checksum.None)
 		log.WithField("errType", fmt.Sprintf("%T %v", err, err)).Debug("ReadStream returned")
 		assert.Nil(t, err)
 		expected := bytes.Repeat([]byte{1, 2}, 1<<25)
@@ -101,7 +103,7 @@ func TestMultiFrameStreamErrTraileror(t *testing.T) {
 	go func() {
 		defer wg.Done()
 		r := errReader{t, longErr}
-		writeStream(ctx, a, &r, stype)
+		writeStream(ctx, a, &r, stype, checksum.None)
 		a.Shutdown()
 	}()
 
@@ -115,7 +117,7 @@ func TestMultiFrameStreamErrTraileror(t *testing.T) {
 			defer wg.Done()
 			readFrames(ch, nil, b)
 		}()
-		err := readStream(ch, b, &buf, stype)
+		err := readStream(ch, b, &buf, stype, checksum.None)
 		t.Logf("%s", err)
 		require.NotNil(t, err)
 		assert.True(t, buf.Len() == 0)
@@ -130,3 +132,94 @@ func TestMultiFrameStreamErrTraileror(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestChecksummedStream(t *testing.T) {
+	for _, cs := range checksum.Algorithms {
+		t.Run(string(cs), func(t *testing.T) {
+			anc, bnc, err := socketpair.SocketPair()
+			require.NoError(t, err)
+
+			hto := 1 * time.Hour
+			a := heartbeatconn.Wrap(anc, hto, hto)
+			b := heartbeatconn.Wrap(bnc, hto, hto)
+
+			ctx := context.Background()
+			stype := uint32(0x23)
+			expected := bytes.Repeat([]byte{1, 2, 3}, 1<<21)
+
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				errStream, errConn := writeStream(ctx, a, bytes.NewReader(expected), stype, cs)
+				assert.NoError(t, errStream)
+				assert.NoError(t, errConn)
+				a.Shutdown()
+			}()
+
+			go func() {
+				defer wg.Done()
+				defer b.Shutdown()
+				var buf bytes.Buffer
+				ch := make(chan readFrameResult, 5)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					readFrames(ch, nil, b)
+				}()
+				err := readStream(ch, b, &buf, stype, cs)
+				assert.Nil(t, err)
+				assert.True(t, bytes.Equal(expected, buf.Bytes()))
+			}()
+
+			wg.Wait()
+		})
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	anc, bnc, err := socketpair.SocketPair()
+	require.NoError(t, err)
+
+	hto := 1 * time.Hour
+	a := heartbeatconn.Wrap(anc, hto, hto)
+	b := heartbeatconn.Wrap(bnc, hto, hto)
+
+	stype := uint32(0x23)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer a.Shutdown()
+		frame := []byte("some data")
+		sum := frameChecksum(checksum.XXHash64.New(), frame)
+		frame[0] ^= 1 // bit flip in transit
+		require.NoError(t, a.WriteFrame(frame, stype))
+		require.NoError(t, a.WriteFrame(sum, Checksum))
+		require.NoError(t, a.WriteFrame([]byte{}, End))
+	}()
+
+	go func() {
+		defer wg.Done()
+		defer b.Shutdown()
+		var buf bytes.Buffer
+		ch := make(chan readFrameResult, 5)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			readFrames(ch, nil, b)
+		}()
+		err := readStream(ch, b, &buf, stype, checksum.XXHash64)
+		require.NotNil(t, err)
+		assert.Equal(t, ReadStreamErrorKindChecksumMismatch, err.Kind)
+		assert.True(t, err.Temporary())
+		assert.Equal(t, 0, buf.Len(), "corrupted frame must not be passed on")
+		// drain so that readFrames can exit
+		for read := range ch {
+			read.f.Buffer.Free()
+		}
+	}()
+
+	wg.Wait()
+}
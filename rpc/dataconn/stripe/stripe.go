@@ -0,0 +1,265 @@
+// Package stripe distributes a byte stream across multiple streams (stripes)
+// and reassembles it on the other side.
+// dataconn uses it to transfer a single ZFS stream over multiple connections
+// in parallel, which helps to saturate links with a high bandwidth-delay product.
+//
+// Each stripe is a sequence of chunks, each prefixed by a header that contains
+// the chunk's sequence number and payload length (see util/chunking for the
+// byte order). Chunks are assigned to the stripe that asks for the next chunk first,
+// such that faster connections carry more chunks.
+// The chunks of a single stripe are in ascending order of sequence numbers.
+package stripe
+
+import (
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/zrepl/zrepl/util/chunking"
+)
+
+// Extension is the versionhandshake extension that advertises support for striped streams.
+const Extension = "dataconn-stripe"
+
+// MaxStripes is the maximum number of stripes of a single stream.
+const MaxStripes = 64
+
+// ChunkSize is the maximum payload size of a chunk.
+const ChunkSize = 1 << 20
+
+const (
+	seqLen    = 8
+	lenLen    = 4
+	headerLen = seqLen + lenLen
+)
+
+var byteOrder = chunking.ChunkHeaderByteOrder
+
+type splitter struct {
+	mtx     sync.Mutex
+	src     io.ReadCloser
+	nextSeq uint64
+	err     error // sticky, io.EOF after src is exhausted
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Split returns n stripes that together carry the contents of src.
+//
+// Closing any of the stripes closes src, which causes subsequent reads of the
+// other stripes to fail unless src has already been read to EOF.
+// Thus, the caller should close all stripes as soon as any of them fails.
+func Split(src io.ReadCloser, n int) []io.ReadCloser {
+	if n < 1 {
+		panic("stripe count must be positive")
+	}
+	s := &splitter{src: src}
+	stripes := make([]io.ReadCloser, n)
+	for i := range stripes {
+		stripes[i] = &stripeReader{s: s, chunk: make([]byte, 0, headerLen+ChunkSize)}
+	}
+	return stripes
+}
+
+// nextChunk reads the next chunk from src into buf and returns it, including the header.
+func (s *splitter) nextChunk(buf []byte) ([]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	n, err := io.ReadFull(s.src, buf[headerLen:])
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err != nil {
+		s.err = err
+		if n == 0 {
+			return nil, err
+		}
+		// return the chunk now, the error with the next call
+	}
+	byteOrder.PutUint64(buf[0:seqLen], s.nextSeq)
+	byteOrder.PutUint32(buf[seqLen:headerLen], uint32(n))
+	s.nextSeq++
+	return buf[:headerLen+n], nil
+}
+
+func (s *splitter) close() error {
+	s.closeOnce.Do(func() {
+		s.closeErr = s.src.Close()
+	})
+	return s.closeErr
+}
+
+type stripeReader struct {
+	s     *splitter
+	chunk []byte // header and payload of the current chunk
+	off   int    // number of bytes of chunk returned by Read
+}
+
+func (r *stripeReader) Read(p []byte) (int, error) {
+	if r.off == len(r.chunk) {
+		chunk, err := r.s.nextChunk(r.chunk[:cap(r.chunk)])
+		if err != nil {
+			return 0, err
+		}
+		r.chunk, r.off = chunk, 0
+	}
+	n := copy(p, r.chunk[r.off:])
+	r.off += n
+	return n, nil
+}
+
+func (r *stripeReader) Close() error { return r.s.close() }
+
+type joiner struct {
+	stripes    []io.ReadCloser
+	maxPending int
+	wg         sync.WaitGroup
+
+	mtx     sync.Mutex
+	cond    *sync.Cond
+	pending map[uint64][]byte // by sequence number
+	next    uint64            // sequence number of the next chunk returned by Read
+	running int               // number of stripes that have not reached EOF
+	err     error             // sticky
+	closed  bool
+
+	cur []byte // remainder of the chunk that is currently returned by Read
+}
+
+// Join returns a reader that reassembles the stream split into stripes by Split.
+// At most maxPending chunks that arrived out of order are buffered,
+// further reads from stripes that are ahead block until the gap is filled.
+//
+// Closing the returned reader closes all stripes.
+func Join(stripes []io.ReadCloser, maxPending int) io.ReadCloser {
+	if len(stripes) < 1 {
+		panic("stripe count must be positive")
+	}
+	if maxPending < 1 {
+		maxPending = 1
+	}
+	j := &joiner{
+		stripes:    stripes,
+		maxPending: maxPending,
+		pending:    make(map[uint64][]byte),
+		running:    len(stripes),
+	}
+	j.cond = sync.NewCond(&j.mtx)
+	j.wg.Add(len(stripes))
+	for i := range stripes {
+		go func(i int) {
+			defer j.wg.Done()
+			j.stripeDone(j.readStripe(stripes[i])) // do not wrap, callers check for net.Error
+		}(i)
+	}
+	return j
+}
+
+// readStripe returns nil iff r was read to EOF
+func (j *joiner) readStripe(r io.Reader) error {
+	var hdr [headerLen]byte
+	var lastSeq uint64
+	for first := true; ; first = false {
+		if _, err := io.ReadFull(r, hdr[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		seq := byteOrder.Uint64(hdr[0:seqLen])
+		l := byteOrder.Uint32(hdr[seqLen:headerLen])
+		if l == 0 || l > ChunkSize {
+			return errors.Errorf("invalid chunk length %d", l)
+		}
+		if !first && seq <= lastSeq {
+			return errors.Errorf("chunk sequence numbers not ascending (%d after %d)", seq, lastSeq)
+		}
+		lastSeq = seq
+		payload := make([]byte, l)
+		if _, err := io.ReadFull(r, payload); err == io.EOF {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+
+		j.mtx.Lock()
+		// the stripe that carries chunk j.next must never block, otherwise we would deadlock
+		for j.err == nil && !j.closed && seq != j.next && len(j.pending) >= j.maxPending {
+			j.cond.Wait()
+		}
+		if j.err != nil || j.closed {
+			j.mtx.Unlock()
+			return nil
+		}
+		if _, dup := j.pending[seq]; dup || seq < j.next {
+			j.mtx.Unlock()
+			return errors.Errorf("duplicate chunk %d", seq)
+		}
+		j.pending[seq] = payload
+		j.cond.Broadcast()
+		j.mtx.Unlock()
+	}
+}
+
+func (j *joiner) stripeDone(err error) {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	if err != nil && j.err == nil {
+		j.err = err
+	}
+	j.running--
+	j.cond.Broadcast()
+}
+
+func (j *joiner) Read(p []byte) (int, error) {
+	if len(j.cur) == 0 {
+		j.mtx.Lock()
+		for {
+			if chunk, ok := j.pending[j.next]; ok {
+				delete(j.pending, j.next)
+				j.next++
+				j.cur = chunk
+				j.cond.Broadcast()
+				break
+			}
+			if j.err != nil {
+				j.mtx.Unlock()
+				return 0, j.err
+			}
+			if j.running == 0 {
+				if len(j.pending) > 0 {
+					j.err = errors.Errorf("striped stream incomplete: chunk %d is missing", j.next)
+				} else {
+					j.err = io.EOF
+				}
+				j.mtx.Unlock()
+				return 0, j.err
+			}
+			j.cond.Wait()
+		}
+		j.mtx.Unlock()
+	}
+	n := copy(p, j.cur)
+	j.cur = j.cur[n:]
+	return n, nil
+}
+
+func (j *joiner) Close() error {
+	j.mtx.Lock()
+	j.closed = true
+	j.cond.Broadcast()
+	j.mtx.Unlock()
+
+	var err error
+	for _, s := range j.stripes {
+		if closeErr := s.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	j.wg.Wait()
+	return err
+}
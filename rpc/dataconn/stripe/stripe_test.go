@@ -0,0 +1,128 @@
+package stripe
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// transfer copies each stripe through a pipe with a random per-write delay
+// to simulate connections of different speeds.
+func transfer(t *testing.T, stripes []io.ReadCloser) []io.ReadCloser {
+	out := make([]io.ReadCloser, len(stripes))
+	for i, s := range stripes {
+		pr, pw := io.Pipe()
+		out[i] = pr
+		go func(i int, s io.ReadCloser) {
+			rng := rand.New(rand.NewSource(int64(i)))
+			buf := make([]byte, 1<<16)
+			for {
+				n, err := s.Read(buf)
+				if n > 0 {
+					time.Sleep(time.Duration(rng.Intn(200)) * time.Microsecond)
+					if _, werr := pw.Write(buf[:n]); werr != nil {
+						s.Close()
+						return
+					}
+				}
+				if err == io.EOF {
+					pw.Close()
+					return
+				} else if err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+		}(i, s)
+	}
+	return out
+}
+
+func TestSplitJoin(t *testing.T) {
+	input := make([]byte, 10*ChunkSize+23)
+	rand.New(rand.NewSource(1)).Read(input)
+
+	for _, n := range []int{1, 2, 7} {
+		for _, maxPending := range []int{1, 16} {
+			stripes := Split(ioutil.NopCloser(bytes.NewReader(input)), n)
+			require.Len(t, stripes, n)
+			joined := Join(transfer(t, stripes), maxPending)
+			output, err := ioutil.ReadAll(joined)
+			require.NoError(t, err, "n=%d maxPending=%d", n, maxPending)
+			assert.True(t, bytes.Equal(input, output), "n=%d maxPending=%d", n, maxPending)
+			assert.NoError(t, joined.Close())
+		}
+	}
+}
+
+func TestSplitJoinEmpty(t *testing.T) {
+	joined := Join(Split(ioutil.NopCloser(bytes.NewReader(nil)), 3), 4)
+	output, err := ioutil.ReadAll(joined)
+	require.NoError(t, err)
+	assert.Empty(t, output)
+}
+
+type errReader struct {
+	data []byte
+	err  error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestSplitJoinSourceError(t *testing.T) {
+	srcErr := io.ErrClosedPipe
+	src := &errReader{make([]byte, 3*ChunkSize), srcErr}
+	joined := Join(transfer(t, Split(ioutil.NopCloser(src), 2)), 4)
+	output, err := ioutil.ReadAll(joined)
+	assert.Equal(t, srcErr, err)
+	assert.True(t, len(output) <= 3*ChunkSize)
+}
+
+type closeRecorder struct {
+	io.Reader
+	mtx    sync.Mutex
+	closed int
+}
+
+func (c *closeRecorder) Close() error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.closed++
+	return nil
+}
+
+func TestSplitClosesSourceOnce(t *testing.T) {
+	src := &closeRecorder{Reader: bytes.NewReader(nil)}
+	for _, s := range Split(src, 3) {
+		assert.NoError(t, s.Close())
+	}
+	assert.Equal(t, 1, src.closed)
+}
+
+func TestJoinDetectsMissingChunk(t *testing.T) {
+	var stripe bytes.Buffer
+	hdr := make([]byte, headerLen)
+	byteOrder.PutUint64(hdr[0:seqLen], 1) // chunk 0 is missing
+	byteOrder.PutUint32(hdr[seqLen:], 3)
+	stripe.Write(hdr)
+	stripe.Write([]byte("foo"))
+
+	joined := Join([]io.ReadCloser{ioutil.NopCloser(&stripe)}, 4)
+	_, err := ioutil.ReadAll(joined)
+	assert.Error(t, err)
+	assert.NotEqual(t, io.EOF, err)
+}
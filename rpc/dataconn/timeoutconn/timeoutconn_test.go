@@ -103,7 +103,7 @@ func TestNoPartialReadsDueToDeadline(t *testing.T) {
 		beginRead := time.Now()
 		// io.Copy will encounter a partial read, then wait ~50ms until the other 5 bytes are written
 		// It is still going to fail with deadline err because it expects EOF
-		n, err := io.Copy(&buf, bc)
+		n, err := io.Copy(&buf, &bc)
 		readDuration := time.Since(beginRead)
 		t.Logf("read duration=%s", readDuration)
 		t.Logf("recv done n=%v err=%v", n, err)
@@ -45,7 +45,7 @@ func NewDialer(logger Logger, connecter transport.Connecter) GRPCDialFunction {
 }
 
 type authConnAuthType struct {
-	clientIdentity string
+	conn *transport.AuthConn
 }
 
 func (authConnAuthType) AuthType() string {
@@ -83,7 +83,7 @@ func (c *transportCredentials) ServerHandshake(rawConn net.Conn) (net.Conn, cred
 	if !ok {
 		panic(fmt.Sprintf("NewTransportCredentials must be used with a listener that returns *transport.AuthConn, got %T", rawConn))
 	}
-	return rawConn, &authConnAuthType{authConn.ClientIdentity()}, nil
+	return rawConn, &authConnAuthType{authConn}, nil
 }
 
 func (*transportCredentials) Info() credentials.ProtocolInfo {
@@ -102,15 +102,18 @@ func (*transportCredentials) OverrideServerName(string) error {
 type ContextInterceptorData interface {
 	FullMethod() string
 	ClientIdentity() string
+	// The connection on which the request was received.
+	AuthConn() *transport.AuthConn
 }
 
 type contextInterceptorData struct {
-	fullMethod     string
-	clientIdentity string
+	fullMethod string
+	conn       *transport.AuthConn
 }
 
-func (d contextInterceptorData) FullMethod() string     { return d.fullMethod }
-func (d contextInterceptorData) ClientIdentity() string { return d.clientIdentity }
+func (d contextInterceptorData) FullMethod() string            { return d.fullMethod }
+func (d contextInterceptorData) ClientIdentity() string        { return d.conn.ClientIdentity() }
+func (d contextInterceptorData) AuthConn() *transport.AuthConn { return d.conn }
 
 type Interceptor = func(ctx context.Context, data ContextInterceptorData, handler func(ctx context.Context))
 
@@ -126,11 +129,12 @@ func NewInterceptors(logger Logger, clientIdentityKey interface{}, interceptor I
 		if !ok {
 			panic(fmt.Sprintf("NewInterceptors must be used in combination with grpc.NewTransportCredentials, but got auth type %T", p.AuthInfo))
 		}
-		logger.WithField("peer_client_identity", a.clientIdentity).Debug("peer client identity")
-		ctx = context.WithValue(ctx, clientIdentityKey, a.clientIdentity)
+		clientIdentity := a.conn.ClientIdentity()
+		logger.WithField("peer_client_identity", clientIdentity).Debug("peer client identity")
+		ctx = context.WithValue(ctx, clientIdentityKey, clientIdentity)
 		data := contextInterceptorData{
-			fullMethod:     info.FullMethod,
-			clientIdentity: a.clientIdentity,
+			fullMethod: info.FullMethod,
+			conn:       a.conn,
 		}
 		var (
 			resp interface{}
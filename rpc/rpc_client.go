@@ -30,6 +30,7 @@ type Client struct {
 	dataClient    *dataconn.Client
 	controlClient pdu.ReplicationClient // this the grpc client instance, see constructor
 	controlConn   *grpc.ClientConn
+	capabilities  *capabilityRecordingConnecter
 	loggers       Loggers
 	closed        chan struct{}
 }
@@ -40,18 +41,24 @@ var _ logic.Receiver = &Client{}
 
 type DialContextFunc = func(ctx context.Context, network string, addr string) (net.Conn, error)
 
+type ClientConfig struct {
+	Data dataconn.ClientConfig
+}
+
 // config must be validated, NewClient will panic if it is not valid
-func NewClient(cn transport.Connecter, loggers Loggers) *Client {
+func NewClient(cn transport.Connecter, loggers Loggers, config ClientConfig) *Client {
 
-	cn = versionhandshake.Connecter(cn, envconst.Duration("ZREPL_RPC_CLIENT_VERSIONHANDSHAKE_TIMEOUT", 10*time.Second))
+	cn = versionhandshake.Connecter(cn, envconst.Duration("ZREPL_RPC_CLIENT_VERSIONHANDSHAKE_TIMEOUT", 10*time.Second), handshakeExtensions())
+	capabilities := &capabilityRecordingConnecter{Connecter: cn}
 
-	muxedConnecter := mux(cn)
+	muxedConnecter := mux(capabilities)
 
 	c := &Client{
-		loggers: loggers,
-		closed:  make(chan struct{}),
+		capabilities: capabilities,
+		loggers:      loggers,
+		closed:       make(chan struct{}),
 	}
-	grpcConn := grpchelper.ClientConn(muxedConnecter.control, loggers.Control)
+	grpcConn := grpchelper.ClientConn(muxedConnecter.control, loggers.Control, grpc.WithUnaryInterceptor(traceparentUnaryClientInterceptor))
 
 	go func() {
 		ctx, cancel := context.WithCancel(context.Background())
@@ -69,7 +76,7 @@ func NewClient(cn transport.Connecter, loggers Loggers) *Client {
 	c.controlClient = pdu.NewReplicationClient(grpcConn)
 	c.controlConn = grpcConn
 
-	c.dataClient = dataconn.NewClient(muxedConnecter.data, loggers.Data)
+	c.dataClient = dataconn.NewClient(muxedConnecter.data, loggers.Data, config.Data)
 	return c
 }
 
@@ -81,6 +88,14 @@ func (c *Client) Close() {
 	// TODO c.dataClient should have Close()
 }
 
+// NegotiatedCapabilities returns the capabilities negotiated with the server
+// on the most recently established connection.
+// ok is false if no connection has been established yet.
+func (c *Client) NegotiatedCapabilities() (capabilities []string, ok bool) {
+	capabilities = c.capabilities.lastNegotiated()
+	return capabilities, capabilities != nil
+}
+
 // callers must ensure that the returned io.ReadCloser is closed
 // TODO expose dataClient interface to the outside world
 func (c *Client) Send(ctx context.Context, r *pdu.SendReq) (*pdu.SendRes, io.ReadCloser, error) {
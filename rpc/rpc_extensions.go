@@ -0,0 +1,104 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/zrepl/zrepl/rpc/dataconn"
+	"github.com/zrepl/zrepl/rpc/dataconn/checksum"
+	"github.com/zrepl/zrepl/rpc/dataconn/compression"
+	"github.com/zrepl/zrepl/rpc/dataconn/stripe"
+	"github.com/zrepl/zrepl/rpc/versionhandshake"
+	"github.com/zrepl/zrepl/transport"
+	"github.com/zrepl/zrepl/util/admission"
+)
+
+// handshakeExtensions returns the versionhandshake extensions that
+// this implementation advertises as client and as server.
+//
+// Extensions are the capabilities of the rpc protocol: optional behavior
+// must only be used if the corresponding extension was negotiated with the peer,
+// so that peers running an older version of zrepl remain compatible.
+func handshakeExtensions() []string {
+	var exts []string
+	exts = append(exts, compression.Extensions()...)
+	exts = append(exts, checksum.Extensions()...)
+	exts = append(exts, stripe.Extension)
+	exts = append(exts, admission.Capability)
+	exts = append(exts, dataconn.TraceContextExtension)
+	return exts
+}
+
+// negotiatedCapabilities returns the capabilities negotiated on w, never nil.
+func negotiatedCapabilities(w transport.Wire) []string {
+	caps := versionhandshake.NegotiatedExtensions(w)
+	if caps == nil {
+		caps = []string{}
+	}
+	return caps
+}
+
+// capabilityRecordingConnecter remembers the capabilities negotiated on
+// the most recent connection established by a versionhandshake.Connecter.
+type capabilityRecordingConnecter struct {
+	transport.Connecter
+	mtx  sync.Mutex
+	last []string // nil until the first connection is established
+}
+
+func (c *capabilityRecordingConnecter) Connect(ctx context.Context) (transport.Wire, error) {
+	w, err := c.Connecter.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.last = negotiatedCapabilities(w)
+	return w, nil
+}
+
+func (c *capabilityRecordingConnecter) lastNegotiated() []string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.last
+}
+
+// capabilityRecordingListener remembers the capabilities negotiated on
+// the most recent connection of each client identity accepted by a versionhandshake.Listener.
+type capabilityRecordingListener struct {
+	transport.AuthenticatedListener
+	byClient *clientCapabilities
+}
+
+func (l capabilityRecordingListener) Accept(ctx context.Context) (*transport.AuthConn, error) {
+	conn, err := l.AuthenticatedListener.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l.byClient.record(conn.ClientIdentity(), negotiatedCapabilities(conn))
+	return conn, nil
+}
+
+type clientCapabilities struct {
+	mtx      sync.Mutex
+	byClient map[string][]string
+}
+
+func (c *clientCapabilities) record(clientIdentity string, caps []string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.byClient == nil {
+		c.byClient = make(map[string][]string)
+	}
+	c.byClient[clientIdentity] = caps
+}
+
+func (c *clientCapabilities) get() map[string][]string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	ret := make(map[string][]string, len(c.byClient))
+	for clientIdentity, caps := range c.byClient {
+		ret[clientIdentity] = caps
+	}
+	return ret
+}
@@ -29,6 +29,7 @@ type Server struct {
 	controlServerServe serveFunc
 	dataServer         *dataconn.Server
 	dataServerServe    serveFunc
+	capabilities       clientCapabilities
 }
 
 type HandlerContextInterceptorData interface {
@@ -53,6 +54,8 @@ func NewServer(handler Handler, loggers Loggers, ctxInterceptor HandlerContextIn
 	controlServerServe := func(ctx context.Context, controlListener transport.AuthenticatedListener, errOut chan<- error) {
 
 		var controlCtxInterceptor grpcclientidentity.Interceptor = func(ctx context.Context, data grpcclientidentity.ContextInterceptorData, handler func(ctx context.Context)) {
+			ctx = endpoint.WithCapabilities(ctx, negotiatedCapabilities(data.AuthConn()))
+			ctx = withRemoteParentFromMetadata(ctx)
 			ctxInterceptor(ctx, interceptorData{"control://", data}, handler)
 		}
 		controlServer, serve := grpchelper.NewServer(controlListener, endpoint.ClientIdentityKey, loggers.Control, controlCtxInterceptor)
@@ -76,6 +79,7 @@ func NewServer(handler Handler, loggers Loggers, ctxInterceptor HandlerContextIn
 	dataServerClientIdentitySetter := func(ctx context.Context, wire *transport.AuthConn) (context.Context, *transport.AuthConn) {
 		ci := wire.ClientIdentity()
 		ctx = context.WithValue(ctx, endpoint.ClientIdentityKey, ci)
+		ctx = endpoint.WithCapabilities(ctx, negotiatedCapabilities(wire))
 		return ctx, wire
 	}
 	var dataCtxInterceptor dataconn.ContextInterceptor = func(ctx context.Context, data dataconn.ContextInterceptorData, handler func(ctx context.Context)) {
@@ -105,7 +109,8 @@ func (s *Server) Serve(ctx context.Context, l transport.AuthenticatedListener) {
 	defer cancel()
 	defer s.logger.Debug("rpc.(*Server).Serve done")
 
-	l = versionhandshake.Listener(l, envconst.Duration("ZREPL_RPC_SERVER_VERSIONHANDSHAKE_TIMEOUT", 10*time.Second))
+	l = versionhandshake.Listener(l, envconst.Duration("ZREPL_RPC_SERVER_VERSIONHANDSHAKE_TIMEOUT", 10*time.Second), handshakeExtensions())
+	l = capabilityRecordingListener{l, &s.capabilities}
 
 	// it is important that demux's context is cancelled,
 	// it has background goroutines attached
@@ -130,3 +135,9 @@ func (s *Server) Serve(ctx context.Context, l transport.AuthenticatedListener) {
 		s.logger.Debug("control and data server shut down, returning from Serve")
 	}
 }
+
+// ClientCapabilities returns the capabilities negotiated on the most recent connection
+// of each client identity that connected to the server.
+func (s *Server) ClientCapabilities() map[string][]string {
+	return s.capabilities.get()
+}
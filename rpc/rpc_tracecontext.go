@@ -0,0 +1,36 @@
+package rpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/zrepl/zrepl/daemon/logging/trace"
+)
+
+// The trace context of the client's span is propagated to the server in the
+// W3C Trace Context `traceparent` gRPC metadata key, so that the server's handler
+// task continues the client's trace (see trace.WithRemoteParent).
+// Older servers ignore the metadata, hence no capability is required.
+const traceparentMetadataKey = "traceparent"
+
+func traceparentUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if tp := trace.Traceparent(ctx); tp != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, traceparentMetadataKey, tp)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// withRemoteParentFromMetadata returns ctx with the remote parent propagated by
+// traceparentUnaryClientInterceptor, if any.
+func withRemoteParentFromMetadata(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	if tps := md.Get(traceparentMetadataKey); len(tps) > 0 {
+		ctx = trace.WithRemoteParent(ctx, tps[0])
+	}
+	return ctx
+}
@@ -150,26 +150,48 @@ func (m *HandshakeMessage) DecodeReader(r io.Reader, maxLen int) error {
 	return nil
 }
 
+// ProtocolVersion is the protocol version of this implementation.
+// Peers must speak the same protocol version.
+//
+// Optional protocol features are negotiated as extensions (capabilities, see NegotiateExtensions).
+// Adding an extension does not require a new protocol version,
+// only incompatible changes of the base protocol do.
+const ProtocolVersion = 5
+
 func DoHandshakeCurrentVersion(conn net.Conn, deadline time.Time) *HandshakeError {
-	// current protocol version is hardcoded here
-	return DoHandshakeVersion(conn, deadline, 5)
+	_, err := DoHandshakeCurrentVersionWithExtensions(conn, deadline, nil)
+	return err
+}
+
+// DoHandshakeCurrentVersionWithExtensions is like DoHandshakeCurrentVersion but
+// additionally advertises extensions to the peer.
+// The returned list contains those of our extensions that the peer advertised as well
+// (see NegotiateExtensions).
+func DoHandshakeCurrentVersionWithExtensions(conn net.Conn, deadline time.Time, extensions []string) ([]string, *HandshakeError) {
+	return DoHandshakeVersionWithExtensions(conn, deadline, ProtocolVersion, extensions)
 }
 
 const HandshakeMessageMaxLen = 16 * 4096
 
 func DoHandshakeVersion(conn net.Conn, deadline time.Time, version int) (rErr *HandshakeError) {
+	_, err := DoHandshakeVersionWithExtensions(conn, deadline, version, nil)
+	return err
+}
+
+// DoHandshakeVersionWithExtensions is like DoHandshakeVersion but additionally negotiates extensions.
+func DoHandshakeVersionWithExtensions(conn net.Conn, deadline time.Time, version int, extensions []string) (negotiated []string, rErr *HandshakeError) {
 	ours := HandshakeMessage{
 		ProtocolVersion: version,
-		Extensions:      nil,
+		Extensions:      extensions,
 	}
 	hsb, err := ours.Encode()
 	if err != nil {
-		return hsErr("could not encode protocol banner: %s", err)
+		return nil, hsErr("could not encode protocol banner: %s", err)
 	}
 
 	err = conn.SetDeadline(deadline)
 	if err != nil {
-		return hsErr("could not set deadline for protocol banner handshake: %s", err)
+		return nil, hsErr("could not set deadline for protocol banner handshake: %s", err)
 	}
 	defer func() {
 		if rErr != nil {
@@ -177,24 +199,24 @@ func DoHandshakeVersion(conn net.Conn, deadline time.Time, version int) (rErr *H
 		}
 		err := conn.SetDeadline(time.Time{})
 		if err != nil {
+			negotiated = nil
 			rErr = hsErr("could not reset deadline after protocol banner handshake: %s", err)
 		}
 	}()
 	_, err = io.Copy(conn, bytes.NewBuffer(hsb))
 	if err != nil {
-		return hsErr("could not send protocol banner: %s", err)
+		return nil, hsErr("could not send protocol banner: %s", err)
 	}
 
 	theirs := HandshakeMessage{}
 	if err := theirs.DecodeReader(conn, HandshakeMessageMaxLen); err != nil {
-		return hsErr("could not decode protocol banner: %s", err)
+		return nil, hsErr("could not decode protocol banner: %s", err)
 	}
 
 	if theirs.ProtocolVersion != ours.ProtocolVersion {
-		return hsErr("protocol versions do not match: ours is %d, theirs is %d",
+		return nil, hsErr("protocol versions do not match: ours is %d, theirs is %d",
 			ours.ProtocolVersion, theirs.ProtocolVersion)
 	}
-	// ignore extensions, we don't use them
 
-	return nil
+	return NegotiateExtensions(extensions, theirs.Extensions), nil
 }
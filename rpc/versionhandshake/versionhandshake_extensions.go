@@ -0,0 +1,73 @@
+package versionhandshake
+
+import (
+	"syscall"
+
+	"github.com/zrepl/zrepl/rpc/dataconn/timeoutconn"
+	"github.com/zrepl/zrepl/transport"
+)
+
+// NegotiateExtensions returns those extensions in ours that are also in theirs,
+// in the order of ours.
+func NegotiateExtensions(ours, theirs []string) []string {
+	theirSet := make(map[string]bool, len(theirs))
+	for _, ext := range theirs {
+		theirSet[ext] = true
+	}
+	var negotiated []string
+	for _, ext := range ours {
+		if theirSet[ext] {
+			negotiated = append(negotiated, ext)
+		}
+	}
+	return negotiated
+}
+
+// Conn is the transport.Wire returned by the Connecter and Listener of this package.
+// It remembers the extensions negotiated during the handshake.
+type Conn struct {
+	transport.Wire
+	negotiated []string
+}
+
+var _ timeoutconn.SyscallConner = (*Conn)(nil)
+
+func (c *Conn) SyscallConn() (rawConn syscall.RawConn, err error) {
+	scc, ok := c.Wire.(timeoutconn.SyscallConner)
+	if !ok {
+		return nil, timeoutconn.SyscallConnNotSupported
+	}
+	return scc.SyscallConn()
+}
+
+// NegotiatedExtensions returns the extensions negotiated during the handshake.
+func (c *Conn) NegotiatedExtensions() []string { return c.negotiated }
+
+// NegotiatedExtensions returns the extensions negotiated on w,
+// which may be a *Conn or a *transport.AuthConn wrapping a *Conn.
+// If w was not established through this package, the result is nil.
+func NegotiatedExtensions(w transport.Wire) []string {
+	if ac, ok := w.(*transport.AuthConn); ok {
+		w = ac.Wire
+	}
+	c, ok := w.(*Conn)
+	if !ok {
+		return nil
+	}
+	return c.negotiated
+}
+
+// HasNegotiatedExtension returns true iff ext was negotiated on w (see NegotiatedExtensions).
+func HasNegotiatedExtension(w transport.Wire, ext string) bool {
+	return ContainsExtension(NegotiatedExtensions(w), ext)
+}
+
+// ContainsExtension returns true iff ext is in exts.
+func ContainsExtension(exts []string, ext string) bool {
+	for _, e := range exts {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
@@ -117,3 +117,55 @@ func TestDoHandshakeCurrentVersion(t *testing.T) {
 	assert.Nil(t, <-srvErrCh)
 
 }
+
+func TestDoHandshakeVersionWithExtensions(t *testing.T) {
+	srv, client, err := socketpair.SocketPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	defer client.Close()
+
+	type result struct {
+		negotiated []string
+		err        *HandshakeError
+	}
+	srvResCh := make(chan result)
+	go func() {
+		negotiated, err := DoHandshakeVersionWithExtensions(srv, time.Now().Add(2*time.Second), 1, []string{"foo", "bar", "baz"})
+		srvResCh <- result{negotiated, err}
+	}()
+	negotiated, hsErr := DoHandshakeVersionWithExtensions(client, time.Now().Add(2*time.Second), 1, []string{"baz", "qux", "foo"})
+	assert.Nil(t, hsErr)
+	assert.Equal(t, []string{"baz", "foo"}, negotiated)
+
+	srvRes := <-srvResCh
+	assert.Nil(t, srvRes.err)
+	assert.Equal(t, []string{"foo", "baz"}, srvRes.negotiated)
+}
+
+func TestNegotiateExtensions(t *testing.T) {
+	assert.Nil(t, NegotiateExtensions(nil, []string{"foo"}))
+	assert.Nil(t, NegotiateExtensions([]string{"foo"}, nil))
+	assert.Equal(t, []string{"b", "a"}, NegotiateExtensions([]string{"b", "c", "a"}, []string{"a", "b"}))
+}
+
+func TestDoHandshakeVersionMismatch(t *testing.T) {
+	srv, client, err := socketpair.SocketPair()
+	require.NoError(t, err)
+	defer srv.Close()
+	defer client.Close()
+
+	srvErrCh := make(chan *HandshakeError)
+	go func() {
+		_, err := DoHandshakeVersionWithExtensions(srv, time.Now().Add(2*time.Second), 6, []string{"foo"})
+		srvErrCh <- err
+	}()
+	negotiated, hsErr := DoHandshakeVersionWithExtensions(client, time.Now().Add(2*time.Second), 5, []string{"foo"})
+	require.NotNil(t, hsErr)
+	assert.Nil(t, negotiated)
+	assert.Contains(t, hsErr.Error(), "ours is 5, theirs is 6")
+	srvErr := <-srvErrCh
+	require.NotNil(t, srvErr)
+	assert.Contains(t, srvErr.Error(), "ours is 6, theirs is 5")
+}
@@ -9,8 +9,9 @@ import (
 )
 
 type HandshakeConnecter struct {
-	connecter transport.Connecter
-	timeout   time.Duration
+	connecter  transport.Connecter
+	timeout    time.Duration
+	extensions []string
 }
 
 func (c HandshakeConnecter) Connect(ctx context.Context) (transport.Wire, error) {
@@ -22,24 +23,29 @@ func (c HandshakeConnecter) Connect(ctx context.Context) (transport.Wire, error)
 	if !ok {
 		dl = time.Now().Add(c.timeout)
 	}
-	if err := DoHandshakeCurrentVersion(conn, dl); err != nil {
+	negotiated, hsErr := DoHandshakeCurrentVersionWithExtensions(conn, dl, c.extensions)
+	if hsErr != nil {
 		conn.Close()
-		return nil, err
+		return nil, hsErr
 	}
-	return conn, nil
+	return &Conn{conn, negotiated}, nil
 }
 
-func Connecter(connecter transport.Connecter, timeout time.Duration) HandshakeConnecter {
+// Connecter wraps connecter such that a handshake that advertises extensions
+// is performed on each connection. The returned connections are of type *Conn.
+func Connecter(connecter transport.Connecter, timeout time.Duration, extensions []string) HandshakeConnecter {
 	return HandshakeConnecter{
-		connecter: connecter,
-		timeout:   timeout,
+		connecter:  connecter,
+		timeout:    timeout,
+		extensions: extensions,
 	}
 }
 
 // wrapper type that performs a a protocol version handshake before returning the connection
 type HandshakeListener struct {
-	l       transport.AuthenticatedListener
-	timeout time.Duration
+	l          transport.AuthenticatedListener
+	timeout    time.Duration
+	extensions []string
 }
 
 func (l HandshakeListener) Addr() net.Addr { return l.l.Addr() }
@@ -55,14 +61,17 @@ func (l HandshakeListener) Accept(ctx context.Context) (*transport.AuthConn, err
 	if !ok {
 		dl = time.Now().Add(l.timeout) // shadowing
 	}
-	if err := DoHandshakeCurrentVersion(conn, dl); err != nil {
-		err.isAcceptError = true
+	negotiated, hsErr := DoHandshakeCurrentVersionWithExtensions(conn, dl, l.extensions)
+	if hsErr != nil {
+		hsErr.isAcceptError = true
 		conn.Close()
-		return nil, err
+		return nil, hsErr
 	}
-	return conn, nil
+	return transport.NewAuthConn(&Conn{conn.Wire, negotiated}, conn.ClientIdentity()), nil
 }
 
-func Listener(l transport.AuthenticatedListener, timeout time.Duration) transport.AuthenticatedListener {
-	return HandshakeListener{l, timeout}
+// Listener wraps l such that a handshake that advertises extensions is performed
+// on each accepted connection. The Wire of the returned connections is of type *Conn.
+func Listener(l transport.AuthenticatedListener, timeout time.Duration, extensions []string) transport.AuthenticatedListener {
+	return HandshakeListener{l, timeout, extensions}
 }
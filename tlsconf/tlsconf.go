@@ -26,52 +26,87 @@ func ParseCAFile(certfile string) (*x509.CertPool, error) {
 
 type ClientAuthListener struct {
 	l                *net.TCPListener
-	c                *tls.Config
+	store            *Store
+	identity         *IdentityExtractor
 	handshakeTimeout time.Duration
+	keyLog           io.Writer
 }
 
+// NewClientAuthListener returns a listener that uses the material in store for each handshake.
+// Clients whose certificates are revoked by the CRL in the store are rejected.
+// The client identity is extracted from the client certificate using identity,
+// which defaults to the certificate's CommonName if nil.
 func NewClientAuthListener(
-	l *net.TCPListener, ca *x509.CertPool, serverCert tls.Certificate,
+	l *net.TCPListener, store *Store, identity *IdentityExtractor,
 	handshakeTimeout time.Duration) *ClientAuthListener {
 
-	if ca == nil {
-		panic(ca)
+	if store == nil {
+		panic(store)
 	}
-	if serverCert.Certificate == nil || serverCert.PrivateKey == nil {
-		panic(serverCert)
+	if identity == nil {
+		identity = &IdentityExtractor{from: IdentityFromCN}
 	}
+	return &ClientAuthListener{
+		l,
+		store,
+		identity,
+		handshakeTimeout,
+		keylogFromEnv(),
+	}
+}
+
+func (l *ClientAuthListener) tlsConfig() *tls.Config {
+	return clientAuthServerConfig(l.store.Get(), l.keyLog)
+}
 
-	tlsConf := &tls.Config{
-		Certificates:             []tls.Certificate{serverCert},
-		ClientCAs:                ca,
+func clientAuthServerConfig(m *Material, keyLog io.Writer) *tls.Config {
+	return &tls.Config{
+		Certificates:             []tls.Certificate{m.Cert},
+		ClientCAs:                m.CA,
 		ClientAuth:               tls.RequireAndVerifyClientCert,
+		VerifyPeerCertificate:    m.verifyNotRevoked,
 		PreferServerCipherSuites: true,
-		KeyLogWriter:             keylogFromEnv(),
+		KeyLogWriter:             keyLog,
 	}
-	return &ClientAuthListener{
-		l,
-		tlsConf,
-		handshakeTimeout,
+}
+
+// ClientAuthServerConfig returns a tls.Config for servers that perform the handshake
+// on the connection's first read or write, e.g. when using tls.NewListener with http.Server.
+// Like ClientAuthListener, it takes the material from store for every handshake
+// and rejects clients whose certificates are revoked by the CRL in the store.
+// The client identity must be extracted from the verified peer certificates after the handshake.
+func ClientAuthServerConfig(store *Store) *tls.Config {
+	if store == nil {
+		panic(store)
+	}
+	keyLog := keylogFromEnv()
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return clientAuthServerConfig(store.Get(), keyLog), nil
+		},
 	}
 }
 
 // Accept() accepts a connection from the *net.TCPListener passed to the constructor
-// and sets up the TLS connection, including handshake and peer CommonName validation
-// within the specified handshakeTimeout.
+// and sets up the TLS connection, including handshake and extraction of the client identity
+// from the client certificate within the specified handshakeTimeout.
+//
+// The TLS material is taken from the listener's Store for every connection,
+// see (*Store).Get for when changes to the files are picked up.
 //
 // It returns both the raw TCP connection (tcpConn) and the TLS connection (tlsConn) on top of it.
 // Access to the raw tcpConn might be necessary if CloseWrite semantics are desired:
 // tlsConn.CloseWrite does NOT call tcpConn.CloseWrite, hence we provide access to tcpConn to
 // allow the caller to do this by themselves.
-func (l *ClientAuthListener) Accept() (tcpConn *net.TCPConn, tlsConn *tls.Conn, clientCN string, err error) {
+func (l *ClientAuthListener) Accept() (tcpConn *net.TCPConn, tlsConn *tls.Conn, clientIdentity string, err error) {
 	tcpConn, err = l.l.AcceptTCP()
 	if err != nil {
 		return nil, nil, "", err
 	}
 
-	tlsConn = tls.Server(tcpConn, l.c)
+	tlsConn = tls.Server(tcpConn, l.tlsConfig())
 	var (
-		cn        string
+		identity  string
 		peerCerts []*x509.Certificate
 	)
 	if err = tlsConn.SetDeadline(time.Now().Add(l.handshakeTimeout)); err != nil {
@@ -89,8 +124,12 @@ func (l *ClientAuthListener) Accept() (tcpConn *net.TCPConn, tlsConn *tls.Conn,
 		err = errors.New("client must present full RFC5246:7.4.2 TLS client certificate chain")
 		goto CloseAndErr
 	}
-	cn = peerCerts[0].Subject.CommonName
-	return tcpConn, tlsConn, cn, nil
+	identity, err = l.identity.Extract(peerCerts[0])
+	if err != nil {
+		err = fmt.Errorf("cannot determine client identity from certificate: %s", err)
+		goto CloseAndErr
+	}
+	return tcpConn, tlsConn, identity, nil
 CloseAndErr:
 	// unlike CloseWrite, Close on *tls.Conn actually closes the underlying connection
 	tlsConn.Close() // TODO log error
@@ -0,0 +1,132 @@
+package tlsconf
+
+import (
+	"crypto/x509"
+	"fmt"
+	"regexp"
+)
+
+// Certificate fields from which an IdentityExtractor extracts the identity.
+const (
+	IdentityFromCN     = "cn"
+	IdentityFromDNSSAN = "dns_san"
+	IdentityFromURISAN = "uri_san"
+)
+
+// An IdentityExtractor determines the identity of a peer from its certificate.
+type IdentityExtractor struct {
+	from  string
+	regex *regexp.Regexp // may be nil
+}
+
+// NewIdentityExtractor returns an IdentityExtractor that extracts the identity from
+// the certificate field from (one of the IdentityFrom* constants).
+//
+// If regex is empty, the identity is the first value of the field.
+// Otherwise, the identity is taken from the first value of the field that matches regex:
+// if regex has capture groups, the identity is the first capture group, otherwise the entire match.
+func NewIdentityExtractor(from, regex string) (*IdentityExtractor, error) {
+	switch from {
+	case IdentityFromCN, IdentityFromDNSSAN, IdentityFromURISAN:
+	default:
+		return nil, fmt.Errorf("invalid identity source %q, must be one of %q, %q, %q",
+			from, IdentityFromCN, IdentityFromDNSSAN, IdentityFromURISAN)
+	}
+	e := &IdentityExtractor{from: from}
+	if regex != "" {
+		var err error
+		e.regex, err = regexp.Compile(regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity regex: %s", err)
+		}
+	}
+	return e, nil
+}
+
+func (e *IdentityExtractor) String() string {
+	if e.regex == nil {
+		return e.from
+	}
+	return fmt.Sprintf("%s matching %q", e.from, e.regex.String())
+}
+
+func (e *IdentityExtractor) values(cert *x509.Certificate) []string {
+	switch e.from {
+	case IdentityFromCN:
+		if cert.Subject.CommonName == "" {
+			return nil
+		}
+		return []string{cert.Subject.CommonName}
+	case IdentityFromDNSSAN:
+		return cert.DNSNames
+	case IdentityFromURISAN:
+		values := make([]string, len(cert.URIs))
+		for i, u := range cert.URIs {
+			values[i] = u.String()
+		}
+		return values
+	default:
+		panic(fmt.Sprintf("implementation error: %q", e.from))
+	}
+}
+
+// Extract returns the identity in cert.
+func (e *IdentityExtractor) Extract(cert *x509.Certificate) (string, error) {
+	values := e.values(cert)
+	if e.regex == nil {
+		if len(values) == 0 {
+			return "", fmt.Errorf("certificate has no %s", e.from)
+		}
+		return values[0], nil
+	}
+	for _, v := range values {
+		m := e.regex.FindStringSubmatch(v)
+		if m == nil {
+			continue
+		}
+		if len(m) > 1 {
+			return m[1], nil
+		}
+		return m[0], nil
+	}
+	return "", fmt.Errorf("certificate has no %s", e)
+}
+
+// VerifyServerIdentity returns a function for tls.Config.VerifyPeerCertificate that verifies
+// the server's certificate chain against roots and checks that the identity extracted
+// from the server's certificate by e equals expected.
+// The tls.Config must set InsecureSkipVerify to disable the default, hostname-based verification.
+func VerifyServerIdentity(roots *x509.CertPool, e *IdentityExtractor, expected string) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		// verifiedChains is always empty because InsecureSkipVerify is set
+		if len(rawCerts) < 1 {
+			return fmt.Errorf("server did not present a certificate")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("cannot parse server certificate: %s", err)
+			}
+			certs[i] = cert
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, c := range certs[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		if _, err := certs[0].Verify(opts); err != nil {
+			return err
+		}
+		identity, err := e.Extract(certs[0])
+		if err != nil {
+			return err
+		}
+		if identity != expected {
+			return fmt.Errorf("server identity %q does not match expected identity %q", identity, expected)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,140 @@
+package tlsconf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	require.NoError(t, err)
+	return u
+}
+
+func TestIdentityExtractor(t *testing.T) {
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "the-cn"},
+		DNSNames: []string{"a.example.org", "b.example.org"},
+		URIs: []*url.URL{
+			mustParseURL(t, "https://example.org/other"),
+			mustParseURL(t, "spiffe://example.org/zrepl/host1"),
+		},
+	}
+	noCN := &x509.Certificate{DNSNames: []string{"a.example.org"}}
+
+	tcs := []struct {
+		from, regex string
+		cert        *x509.Certificate
+		expect      string // empty means error
+	}{
+		{IdentityFromCN, "", cert, "the-cn"},
+		{IdentityFromCN, "", noCN, ""},
+		{IdentityFromCN, `^the-(.+)$`, cert, "cn"},
+		{IdentityFromDNSSAN, "", cert, "a.example.org"},
+		{IdentityFromDNSSAN, `^b\.`, cert, "b."},
+		{IdentityFromDNSSAN, `^([^.]+)\.example\.org$`, cert, "a"},
+		{IdentityFromDNSSAN, `^c\.`, cert, ""},
+		{IdentityFromURISAN, "", cert, "https://example.org/other"},
+		{IdentityFromURISAN, `^spiffe://example\.org/zrepl/([^/]+)$`, cert, "host1"},
+		{IdentityFromURISAN, "", noCN, ""},
+	}
+	for _, tc := range tcs {
+		e, err := NewIdentityExtractor(tc.from, tc.regex)
+		require.NoError(t, err)
+		identity, err := e.Extract(tc.cert)
+		if tc.expect == "" {
+			assert.Error(t, err, "%s", e)
+		} else {
+			assert.NoError(t, err, "%s", e)
+			assert.Equal(t, tc.expect, identity, "%s", e)
+		}
+	}
+
+	_, err := NewIdentityExtractor("email", "")
+	assert.Error(t, err)
+	_, err = NewIdentityExtractor(IdentityFromCN, "(")
+	assert.Error(t, err)
+}
+
+func TestSPIFFEIdentities(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "ca")
+	files := Files{
+		CA:   filepath.Join(dir, "ca.crt"),
+		Cert: filepath.Join(dir, "server.crt"),
+		Key:  filepath.Join(dir, "server.key"),
+	}
+	writeFile(t, files.CA, ca.pem)
+	// certificates with empty CNs
+	certPEM, keyPEM := ca.issueTemplate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		URIs:         []*url.URL{mustParseURL(t, "spiffe://example.org/zrepl/server1")},
+	})
+	writeFile(t, files.Cert, certPEM)
+	writeFile(t, files.Key, keyPEM)
+	store, err := NewStore(files)
+	require.NoError(t, err)
+
+	spiffe, err := NewIdentityExtractor(IdentityFromURISAN, `^spiffe://example\.org/zrepl/([^/]+)$`)
+	require.NoError(t, err)
+
+	tl, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	l := NewClientAuthListener(tl, store, spiffe, 5*time.Second)
+	defer l.Close()
+
+	clientCertPEM, clientKeyPEM := ca.issueTemplate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		URIs:         []*url.URL{mustParseURL(t, "spiffe://example.org/zrepl/client1")},
+	})
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	connect := func(expectServer string) (clientErr error, clientIdentity string, serverErr error) {
+		type result struct {
+			identity string
+			err      error
+		}
+		accepted := make(chan result, 1)
+		go func() {
+			_, tlsConn, identity, err := l.Accept()
+			if err == nil {
+				tlsConn.Close()
+			}
+			accepted <- result{identity, err}
+		}()
+		conf, err := ClientAuthClient("127.0.0.1", roots, clientCert)
+		require.NoError(t, err)
+		conf.InsecureSkipVerify = true
+		conf.VerifyPeerCertificate = VerifyServerIdentity(roots, spiffe, expectServer)
+		conn, clientErr := tls.Dial("tcp", l.Addr().String(), conf)
+		if clientErr == nil {
+			conn.Close()
+		}
+		res := <-accepted
+		return clientErr, res.identity, res.err
+	}
+
+	clientErr, clientIdentity, serverErr := connect("server1")
+	require.NoError(t, clientErr)
+	require.NoError(t, serverErr)
+	assert.Equal(t, "client1", clientIdentity)
+
+	clientErr, _, _ = connect("server2")
+	require.Error(t, clientErr)
+	assert.Contains(t, clientErr.Error(), `server identity "server1" does not match expected identity "server2"`)
+}
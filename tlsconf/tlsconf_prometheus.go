@@ -0,0 +1,43 @@
+package tlsconf
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var prom struct {
+	reloads    *prometheus.CounterVec
+	expiry     *prometheus.GaugeVec
+	crlExpired *prometheus.GaugeVec
+}
+
+func init() {
+	prom.reloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "zrepl",
+		Subsystem: "tls",
+		Name:      "reloads",
+		Help:      "Number of attempts to (re)load TLS material, by certificate file and result (success or error)",
+	}, []string{"cert", "result"})
+	prom.expiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "zrepl",
+		Subsystem: "tls",
+		Name:      "expiry_timestamp_seconds",
+		Help:      "Expiry (NotAfter) of the loaded certificate (kind=cert), earliest expiry of the CA certificates (kind=ca) and next update of the CRL (kind=crl), as a UNIX timestamp",
+	}, []string{"file", "kind"})
+	prom.crlExpired = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "zrepl",
+		Subsystem: "tls",
+		Name:      "crl_expired",
+		Help:      "1 if the loaded CRL is past its next update time, 0 otherwise",
+	}, []string{"file"})
+}
+
+func PrometheusRegister(registry prometheus.Registerer) error {
+	if err := registry.Register(prom.reloads); err != nil {
+		return err
+	}
+	if err := registry.Register(prom.expiry); err != nil {
+		return err
+	}
+	if err := registry.Register(prom.crlExpired); err != nil {
+		return err
+	}
+	return nil
+}
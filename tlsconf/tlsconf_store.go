@@ -0,0 +1,329 @@
+package tlsconf
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zrepl/zrepl/util/envconst"
+)
+
+// how often a Store checks its files for changes, at most
+var storeCheckInterval = envconst.Duration("ZREPL_TLS_RELOAD_CHECK_INTERVAL", 10*time.Second)
+
+// Files are the paths of the files that make up the TLS material of a Store.
+type Files struct {
+	CA   string
+	Cert string
+	Key  string
+	CRL  string // optional
+	// reject all peer certificates while the CRL is past its next update time
+	RejectExpiredCRL bool
+}
+
+// Material is an immutable snapshot of the files of a Store.
+type Material struct {
+	CA        *x509.CertPool
+	Cert      tls.Certificate
+	CRL       *pkix.CertificateList // nil if no CRL is configured
+	crlIssuer *x509.Certificate     // the certificate in CA that signed CRL
+	revoked   map[string]struct{}   // serial numbers in CRL
+
+	rejectExpiredCRL bool
+}
+
+// CRLExpired returns true if the next update time of m.CRL is before now,
+// i.e., certificates revoked since then are not listed in m.CRL.
+func (m *Material) CRLExpired(now time.Time) bool {
+	if m.CRL == nil {
+		return false
+	}
+	next := m.CRL.TBSCertList.NextUpdate
+	return !next.IsZero() && next.Before(now)
+}
+
+// IsRevoked returns true if cert is listed in m.CRL.
+func (m *Material) IsRevoked(cert *x509.Certificate) bool {
+	if m.CRL == nil || !bytes.Equal(cert.RawIssuer, m.crlIssuer.RawSubject) {
+		return false
+	}
+	_, revoked := m.revoked[cert.SerialNumber.String()]
+	return revoked
+}
+
+// verifyNotRevoked implements tls.Config.VerifyPeerCertificate.
+func (m *Material) verifyNotRevoked(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if m.rejectExpiredCRL && m.CRLExpired(time.Now()) {
+		return fmt.Errorf("crl expired at %s, rejecting all certificates until it is updated", m.CRL.TBSCertList.NextUpdate)
+	}
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			if m.IsRevoked(cert) {
+				return fmt.Errorf("certificate %q (serial %s) is revoked", cert.Subject.CommonName, cert.SerialNumber)
+			}
+		}
+	}
+	return nil
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func statFiles(paths []string) []fileState {
+	states := make([]fileState, len(paths))
+	for i, p := range paths {
+		if fi, err := os.Stat(p); err == nil {
+			states[i] = fileState{fi.ModTime(), fi.Size(), true}
+		}
+	}
+	return states
+}
+
+func fileStatesEqual(a, b []fileState) bool {
+	for i := range a {
+		if a[i].exists != b[i].exists || a[i].size != b[i].size || !a[i].modTime.Equal(b[i].modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// A Store holds the TLS material loaded from Files and reloads it when the files change.
+//
+// Changes are detected by modification time and size, at most every storeCheckInterval,
+// when the material is requested by Get.
+// A reload replaces all material at once, and only if all files could be loaded
+// and the certificate matches the key. Otherwise, the previously loaded material remains in use.
+type Store struct {
+	files Files
+
+	mtx           sync.Mutex
+	cur           *Material
+	curStates     []fileState // states of files when cur was loaded
+	failedStates  []fileState // states of files at the last failed reload
+	lastCheck     time.Time
+	pendingReload error
+	crlExpired    error // set by each check that finds the CRL of cur expired
+}
+
+// NewStore loads the material from files.
+func NewStore(files Files) (*Store, error) {
+	if files.CA == "" || files.Cert == "" || files.Key == "" {
+		return nil, fmt.Errorf("ca, cert and key must be specified")
+	}
+	if files.RejectExpiredCRL && files.CRL == "" {
+		return nil, fmt.Errorf("crl_reject_expired requires crl to be specified")
+	}
+	s := &Store{files: files}
+	states := statFiles(s.paths())
+	m, err := loadMaterial(files)
+	if err != nil {
+		prom.reloads.WithLabelValues(files.Cert, "error").Inc()
+		return nil, err
+	}
+	s.cur, s.curStates, s.lastCheck = m, states, time.Now()
+	prom.reloads.WithLabelValues(files.Cert, "success").Inc()
+	s.checkCRLExpiredLocked(s.lastCheck)
+	return s, nil
+}
+
+func (s *Store) paths() []string {
+	paths := []string{s.files.CA, s.files.Cert, s.files.Key}
+	if s.files.CRL != "" {
+		paths = append(paths, s.files.CRL)
+	}
+	return paths
+}
+
+// Get returns the current material, reloading it if the files changed.
+func (s *Store) Get() *Material {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if time.Since(s.lastCheck) < storeCheckInterval {
+		return s.cur
+	}
+	s.lastCheck = time.Now()
+	s.reloadIfChangedLocked()
+	s.checkCRLExpiredLocked(s.lastCheck)
+	return s.cur
+}
+
+// Reload reloads the material unconditionally.
+func (s *Store) Reload() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.lastCheck = time.Now()
+	return s.reloadLocked(statFiles(s.paths()))
+}
+
+func (s *Store) reloadIfChangedLocked() {
+	states := statFiles(s.paths())
+	if fileStatesEqual(states, s.curStates) {
+		return
+	}
+	if s.failedStates != nil && fileStatesEqual(states, s.failedStates) {
+		return // don't retry until the files change again
+	}
+	s.pendingReload = s.reloadLocked(states)
+}
+
+func (s *Store) reloadLocked(states []fileState) error {
+	m, err := loadMaterial(s.files)
+	if err != nil {
+		s.failedStates = states
+		prom.reloads.WithLabelValues(s.files.Cert, "error").Inc()
+		return fmt.Errorf("cannot reload TLS material, continuing to use previously loaded material: %s", err)
+	}
+	s.cur, s.curStates, s.failedStates = m, states, nil
+	prom.reloads.WithLabelValues(s.files.Cert, "success").Inc()
+	return nil
+}
+
+func (s *Store) checkCRLExpiredLocked(now time.Time) {
+	if s.files.CRL == "" {
+		return
+	}
+	if !s.cur.CRLExpired(now) {
+		s.crlExpired = nil
+		prom.crlExpired.WithLabelValues(s.files.CRL).Set(0)
+		return
+	}
+	consequence := "certificates revoked since then are not rejected"
+	if s.files.RejectExpiredCRL {
+		consequence = "rejecting all certificates until it is updated"
+	}
+	s.crlExpired = fmt.Errorf("crl %q expired at %s, %s", s.files.CRL, s.cur.CRL.TBSCertList.NextUpdate, consequence)
+	prom.crlExpired.WithLabelValues(s.files.CRL).Set(1)
+}
+
+// TakeCRLExpiredError returns an error if the CRL was expired when Get last checked the files,
+// or nil if it was not or the error was already taken since then.
+// Hence, callers that log the error do so at most every storeCheckInterval.
+func (s *Store) TakeCRLExpiredError() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	err := s.crlExpired
+	s.crlExpired = nil
+	return err
+}
+
+// TakeReloadError returns the error of the last failed reload that was triggered by Get,
+// or nil if there was none since the last call.
+func (s *Store) TakeReloadError() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	err := s.pendingReload
+	s.pendingReload = nil
+	return err
+}
+
+func parseCertificates(pemBytes []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("PEM parsing error")
+	}
+	return certs, nil
+}
+
+func loadMaterial(files Files) (*Material, error) {
+	caPEM, err := ioutil.ReadFile(files.CA)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read ca file: %s", err)
+	}
+	caCerts, err := parseCertificates(caPEM)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse ca file: %s", err)
+	}
+	var m Material
+	m.rejectExpiredCRL = files.RejectExpiredCRL
+	m.CA = x509.NewCertPool()
+	for _, c := range caCerts {
+		m.CA.AddCert(c)
+	}
+
+	m.Cert, err = tls.LoadX509KeyPair(files.Cert, files.Key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse cert/key pair: %s", err)
+	}
+	leaf, err := x509.ParseCertificate(m.Cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse cert: %s", err)
+	}
+
+	var crlNextUpdate time.Time
+	if files.CRL != "" {
+		m.CRL, m.crlIssuer, err = loadCRL(files.CRL, caCerts)
+		if err != nil {
+			return nil, err
+		}
+		revoked := m.CRL.TBSCertList.RevokedCertificates
+		m.revoked = make(map[string]struct{}, len(revoked))
+		for _, e := range revoked {
+			m.revoked[e.SerialNumber.String()] = struct{}{}
+		}
+		crlNextUpdate = m.CRL.TBSCertList.NextUpdate
+	}
+
+	// only update metrics once all files are loaded
+	caNotAfter := caCerts[0].NotAfter
+	for _, c := range caCerts {
+		if c.NotAfter.Before(caNotAfter) {
+			caNotAfter = c.NotAfter
+		}
+	}
+	prom.expiry.WithLabelValues(files.CA, "ca").Set(float64(caNotAfter.Unix()))
+	prom.expiry.WithLabelValues(files.Cert, "cert").Set(float64(leaf.NotAfter.Unix()))
+	if files.CRL != "" && !crlNextUpdate.IsZero() {
+		prom.expiry.WithLabelValues(files.CRL, "crl").Set(float64(crlNextUpdate.Unix()))
+	}
+
+	return &m, nil
+}
+
+// loadCRL loads a PEM or DER encoded CRL that must be signed by one of caCerts,
+// and returns it together with the signing certificate.
+func loadCRL(path string, caCerts []*x509.Certificate) (*pkix.CertificateList, *x509.Certificate, error) {
+	crlBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read crl file: %s", err)
+	}
+	if block, _ := pem.Decode(crlBytes); block != nil && block.Type != "X509 CRL" {
+		return nil, nil, fmt.Errorf("crl file: unexpected PEM block type %q", block.Type)
+	}
+	crl, err := x509.ParseCRL(crlBytes) // handles both PEM and DER
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot parse crl file: %s", err)
+	}
+	// the raw issuer is not retained by ParseCRL, so identify the issuer by its signature
+	for _, ca := range caCerts {
+		if ca.CheckCRLSignature(crl) == nil {
+			return crl, ca, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("crl is not issued by a certificate in the ca file")
+}
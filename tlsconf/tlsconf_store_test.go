@@ -0,0 +1,282 @@
+package tlsconf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, cn string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM encoded certificate and key for cn
+func (ca *testCA) issue(t *testing.T, cn string, serial int64, notAfter time.Time) (certPEM, keyPEM []byte) {
+	return ca.issueTemplate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotAfter:     notAfter,
+	})
+}
+
+// issueTemplate returns the PEM encoded certificate and key for tmpl,
+// filling in validity start and key usages
+func (ca *testCA) issueTemplate(t *testing.T, tmpl *x509.Certificate) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	if tmpl.NotAfter.IsZero() {
+		tmpl.NotAfter = time.Now().Add(time.Hour)
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) crl(t *testing.T, revokedSerials ...int64) []byte {
+	return ca.crlWithNextUpdate(t, time.Now().Add(time.Hour), revokedSerials...)
+}
+
+func (ca *testCA) crlWithNextUpdate(t *testing.T, nextUpdate time.Time, revokedSerials ...int64) []byte {
+	var revoked []pkix.RevokedCertificate
+	for _, s := range revokedSerials {
+		revoked = append(revoked, pkix.RevokedCertificate{
+			SerialNumber:   big.NewInt(s),
+			RevocationTime: time.Now(),
+		})
+	}
+	der, err := ca.cert.CreateCRL(rand.Reader, ca.key, revoked, time.Now().Add(-2*time.Hour), nextUpdate)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func writeFile(t *testing.T, path string, content []byte) {
+	require.NoError(t, ioutil.WriteFile(path, content, 0600))
+}
+
+// withCheckInterval sets storeCheckInterval to d and returns a function that restores it
+func withCheckInterval(d time.Duration) (restore func()) {
+	prev := storeCheckInterval
+	storeCheckInterval = d
+	return func() { storeCheckInterval = prev }
+}
+
+// tempDir creates a temporary directory, the caller must remove it
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "zrepl-tlsconf")
+	require.NoError(t, err)
+	return dir
+}
+
+func TestStoreReload(t *testing.T) {
+	defer withCheckInterval(0)()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "ca")
+	files := Files{
+		CA:   filepath.Join(dir, "ca.crt"),
+		Cert: filepath.Join(dir, "server.crt"),
+		Key:  filepath.Join(dir, "server.key"),
+	}
+	writeFile(t, files.CA, ca.pem)
+	certPEM, keyPEM := ca.issue(t, "server", 2, time.Now().Add(time.Hour))
+	writeFile(t, files.Cert, certPEM)
+	writeFile(t, files.Key, keyPEM)
+
+	s, err := NewStore(files)
+	require.NoError(t, err)
+	initial := s.Get()
+	assert.Same(t, initial, s.Get(), "unchanged files must not be reloaded")
+
+	// a new cert without the matching key must not be picked up
+	newCertPEM, newKeyPEM := ca.issue(t, "server", 3, time.Now().Add(2*time.Hour))
+	writeFile(t, files.Cert, newCertPEM)
+	assert.Same(t, initial, s.Get())
+	assert.Error(t, s.TakeReloadError())
+	assert.NoError(t, s.TakeReloadError())
+	assert.Same(t, initial, s.Get(), "failed reload must not be retried until the files change")
+	assert.NoError(t, s.TakeReloadError())
+
+	// once the key is updated, too, the new pair is loaded
+	writeFile(t, files.Key, newKeyPEM)
+	reloaded := s.Get()
+	assert.NoError(t, s.TakeReloadError())
+	require.True(t, initial != reloaded)
+	leaf, err := x509.ParseCertificate(reloaded.Cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), leaf.SerialNumber.Int64())
+}
+
+func TestStoreCRLMustBeIssuedByCA(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca, other := newTestCA(t, "ca"), newTestCA(t, "other")
+	files := Files{
+		CA:   filepath.Join(dir, "ca.crt"),
+		Cert: filepath.Join(dir, "server.crt"),
+		Key:  filepath.Join(dir, "server.key"),
+		CRL:  filepath.Join(dir, "crl.pem"),
+	}
+	writeFile(t, files.CA, ca.pem)
+	certPEM, keyPEM := ca.issue(t, "server", 2, time.Now().Add(time.Hour))
+	writeFile(t, files.Cert, certPEM)
+	writeFile(t, files.Key, keyPEM)
+
+	writeFile(t, files.CRL, other.crl(t))
+	_, err := NewStore(files)
+	assert.Error(t, err)
+
+	writeFile(t, files.CRL, ca.crl(t))
+	_, err = NewStore(files)
+	assert.NoError(t, err)
+}
+
+func TestStoreExpiredCRL(t *testing.T) {
+	defer withCheckInterval(0)()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "ca")
+	files := Files{
+		CA:   filepath.Join(dir, "ca.crt"),
+		Cert: filepath.Join(dir, "server.crt"),
+		Key:  filepath.Join(dir, "server.key"),
+		CRL:  filepath.Join(dir, "crl.pem"),
+	}
+	writeFile(t, files.CA, ca.pem)
+	certPEM, keyPEM := ca.issue(t, "server", 2, time.Now().Add(time.Hour))
+	writeFile(t, files.Cert, certPEM)
+	writeFile(t, files.Key, keyPEM)
+	writeFile(t, files.CRL, ca.crlWithNextUpdate(t, time.Now().Add(-time.Minute)))
+
+	// by default, an expired CRL is reported on each check but still used
+	s, err := NewStore(files)
+	require.NoError(t, err)
+	assert.True(t, s.Get().CRLExpired(time.Now()))
+	err = s.TakeCRLExpiredError()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not rejected")
+	assert.NoError(t, s.TakeCRLExpiredError())
+	assert.NoError(t, s.Get().verifyNotRevoked(nil, nil))
+	assert.Error(t, s.TakeCRLExpiredError(), "must be reported again by the next check")
+
+	files.RejectExpiredCRL = true
+	s, err = NewStore(files)
+	require.NoError(t, err)
+	err = s.Get().verifyNotRevoked(nil, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "crl expired")
+
+	// an updated CRL is picked up and clears the condition
+	writeFile(t, files.CRL, ca.crl(t, 99))
+	m := s.Get()
+	assert.False(t, m.CRLExpired(time.Now()))
+	assert.NoError(t, s.TakeCRLExpiredError())
+	assert.NoError(t, m.verifyNotRevoked(nil, nil))
+
+	files.CRL = ""
+	_, err = NewStore(files)
+	assert.Error(t, err, "crl_reject_expired requires a crl")
+}
+
+func TestClientAuthListenerCRL(t *testing.T) {
+	defer withCheckInterval(0)()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "ca")
+	files := Files{
+		CA:   filepath.Join(dir, "ca.crt"),
+		Cert: filepath.Join(dir, "server.crt"),
+		Key:  filepath.Join(dir, "server.key"),
+		CRL:  filepath.Join(dir, "crl.pem"),
+	}
+	writeFile(t, files.CA, ca.pem)
+	certPEM, keyPEM := ca.issue(t, "server", 2, time.Now().Add(time.Hour))
+	writeFile(t, files.Cert, certPEM)
+	writeFile(t, files.Key, keyPEM)
+	writeFile(t, files.CRL, ca.crl(t))
+	store, err := NewStore(files)
+	require.NoError(t, err)
+
+	tl, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	l := NewClientAuthListener(tl, store, nil, 5*time.Second)
+	defer l.Close()
+
+	clientCertPEM, clientKeyPEM := ca.issue(t, "client", 4, time.Now().Add(time.Hour))
+	clientCert, err := tls.X509KeyPair(clientCertPEM, clientKeyPEM)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	handshake := func() (clientErr, serverErr error) {
+		accepted := make(chan error, 1)
+		go func() {
+			_, tlsConn, cn, err := l.Accept()
+			if err == nil {
+				assert.Equal(t, "client", cn)
+				tlsConn.Close()
+			}
+			accepted <- err
+		}()
+		conf, err := ClientAuthClient("server", roots, clientCert)
+		require.NoError(t, err)
+		conn, clientErr := tls.Dial("tcp", l.Addr().String(), conf)
+		if clientErr == nil {
+			// TLS 1.3 client handshakes complete before the server verified the client certificate
+			_, clientErr = conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+		return clientErr, <-accepted
+	}
+
+	_, serverErr := handshake()
+	require.NoError(t, serverErr)
+
+	// revoke the client certificate, the store picks up the new CRL
+	writeFile(t, files.CRL, ca.crl(t, 4))
+	clientErr, serverErr := handshake()
+	assert.Error(t, clientErr)
+	require.Error(t, serverErr)
+	assert.Contains(t, serverErr.Error(), "revoked")
+}
@@ -10,9 +10,12 @@ import (
 	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/transport"
 	"github.com/zrepl/zrepl/transport/local"
+	"github.com/zrepl/zrepl/transport/psk"
 	"github.com/zrepl/zrepl/transport/ssh"
+	"github.com/zrepl/zrepl/transport/sshnative"
 	"github.com/zrepl/zrepl/transport/tcp"
 	"github.com/zrepl/zrepl/transport/tls"
+	"github.com/zrepl/zrepl/transport/unixsock"
 )
 
 func ListenerFactoryFromConfig(g *config.Global, in config.ServeEnum) (transport.AuthenticatedListenerFactory, error) {
@@ -24,10 +27,16 @@ func ListenerFactoryFromConfig(g *config.Global, in config.ServeEnum) (transport
 	switch v := in.Ret.(type) {
 	case *config.TCPServe:
 		l, err = tcp.TCPListenerFactoryFromConfig(g, v)
+	case *config.TCPPSKServe:
+		l, err = psk.PSKListenerFactoryFromConfig(g, v)
 	case *config.TLSServe:
 		l, err = tls.TLSListenerFactoryFromConfig(g, v)
 	case *config.StdinserverServer:
 		l, err = ssh.MultiStdinserverListenerFactoryFromConfig(g, v)
+	case *config.SSHServe:
+		l, err = sshnative.SSHListenerFactoryFromConfig(g, v)
+	case *config.UnixServe:
+		l, err = unixsock.UnixListenerFactoryFromConfig(g, v)
 	case *config.LocalServe:
 		l, err = local.LocalListenerFactoryFromConfig(g, v)
 	default:
@@ -45,10 +54,16 @@ func ConnecterFromConfig(g *config.Global, in config.ConnectEnum) (transport.Con
 	switch v := in.Ret.(type) {
 	case *config.SSHStdinserverConnect:
 		connecter, err = ssh.SSHStdinserverConnecterFromConfig(v)
+	case *config.SSHConnect:
+		connecter, err = sshnative.SSHConnecterFromConfig(v)
 	case *config.TCPConnect:
 		connecter, err = tcp.TCPConnecterFromConfig(v)
+	case *config.TCPPSKConnect:
+		connecter, err = psk.PSKConnecterFromConfig(v)
 	case *config.TLSConnect:
 		connecter, err = tls.TLSConnecterFromConfig(v)
+	case *config.UnixConnect:
+		connecter, err = unixsock.UnixConnecterFromConfig(v)
 	case *config.LocalConnect:
 		connecter, err = local.LocalConnecterFromConfig(v)
 	default:
@@ -0,0 +1,142 @@
+// Package proxy implements dialing TCP connections through SOCKS5 or HTTP CONNECT proxies
+// for the connecters of the tcp and tls transports.
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	netproxy "golang.org/x/net/proxy"
+
+	"github.com/zrepl/zrepl/config"
+)
+
+// Dialer dials TCP connections, either directly or through a proxy.
+// The dial timeout covers connecting to the proxy and the proxy handshake.
+type Dialer struct {
+	timeout time.Duration
+	d       netproxy.ContextDialer
+}
+
+// NewDialer returns a Dialer that dials through the proxy configured in in.
+// If in is nil, the Dialer dials directly.
+func NewDialer(in *config.ConnectProxy, timeout time.Duration) (*Dialer, error) {
+	direct := &net.Dialer{}
+	if in == nil {
+		return &Dialer{timeout, direct}, nil
+	}
+
+	u, err := url.Parse(in.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid proxy url")
+	}
+	if u.User != nil {
+		return nil, errors.New("proxy url must not contain credentials, use credentials_file instead")
+	}
+	if u.Port() == "" || u.Hostname() == "" {
+		return nil, errors.Errorf("proxy url %q must specify host and port", in.URL)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, errors.Errorf("proxy url %q must not contain a path", in.URL)
+	}
+
+	var auth *netproxy.Auth
+	if in.CredentialsFile != "" {
+		auth, err = loadCredentialsFile(in.CredentialsFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot load proxy credentials")
+		}
+	}
+
+	var viaProxy netproxy.Dialer
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		socks, err := netproxy.SOCKS5("tcp", u.Host, auth, direct)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot create SOCKS5 dialer")
+		}
+		sd, ok := socks.(socksConnDialer)
+		if !ok {
+			panic(fmt.Sprintf("implementation error: %T does not implement DialWithConn", socks))
+		}
+		viaProxy = &socks5Dialer{direct, u.Host, sd}
+	case "http":
+		viaProxy = &httpConnectDialer{direct, u.Host, auth}
+	default:
+		return nil, errors.Errorf("unsupported proxy url scheme %q, must be one of socks5, socks5h, http", u.Scheme)
+	}
+
+	if len(in.NoProxy) == 0 {
+		return &Dialer{timeout, viaProxy.(netproxy.ContextDialer)}, nil
+	}
+	perHost := netproxy.NewPerHost(viaProxy, direct)
+	for _, np := range in.NoProxy {
+		if err := addNoProxy(perHost, np); err != nil {
+			return nil, errors.Wrapf(err, "invalid no_proxy entry %q", np)
+		}
+	}
+	return &Dialer{timeout, perHost}, nil
+}
+
+// addNoProxy adds a no_proxy entry, which is an IP address, a CIDR range,
+// a domain (*.example.com or .example.com, matching example.com and its subdomains)
+// or a host name.
+func addNoProxy(p *netproxy.PerHost, np string) error {
+	np = strings.TrimSpace(np)
+	switch {
+	case np == "":
+		return errors.New("entry must not be empty")
+	case strings.Contains(np, "/"):
+		_, ipNet, err := net.ParseCIDR(np)
+		if err != nil {
+			return err
+		}
+		p.AddNetwork(ipNet)
+	case net.ParseIP(np) != nil:
+		p.AddIP(net.ParseIP(np))
+	case strings.HasPrefix(np, "*."):
+		p.AddZone(np[1:])
+	case strings.HasPrefix(np, "."):
+		p.AddZone(np)
+	default:
+		p.AddHost(np)
+	}
+	return nil
+}
+
+// loadCredentialsFile loads a file that contains username:password on its first line.
+func loadCredentialsFile(path string) (*netproxy.Auth, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	line := string(bytes.TrimRight(bytes.SplitN(content, []byte("\n"), 2)[0], "\r"))
+	colon := strings.Index(line, ":")
+	if colon <= 0 {
+		return nil, fmt.Errorf("credentials file must contain username:password")
+	}
+	return &netproxy.Auth{User: line[:colon], Password: line[colon+1:]}, nil
+}
+
+// DialContext dials address over TCP.
+// The returned connection is the TCP connection to the proxy if a proxy is used,
+// the proxy handshake has completed at that point.
+func (d *Dialer) DialContext(ctx context.Context, address string) (*net.TCPConn, error) {
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	conn, err := d.d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.TCPConn), nil
+}
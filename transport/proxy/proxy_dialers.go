@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	netproxy "golang.org/x/net/proxy"
+)
+
+// socksConnDialer is implemented by the dialer returned by netproxy.SOCKS5.
+// Its DialContext wraps the connection to the proxy, but the transports need the *net.TCPConn.
+type socksConnDialer interface {
+	DialWithConn(ctx context.Context, c net.Conn, network, address string) (net.Addr, error)
+}
+
+type socks5Dialer struct {
+	direct    *net.Dialer
+	proxyAddr string
+	socks     socksConnDialer
+}
+
+func (d *socks5Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *socks5Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.direct.DialContext(ctx, "tcp", d.proxyAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot connect to SOCKS5 proxy")
+	}
+	// DialWithConn applies the deadline and cancellation of ctx to conn
+	if _, err := d.socks.DialWithConn(ctx, conn, network, address); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+type httpConnectDialer struct {
+	direct    *net.Dialer
+	proxyAddr string
+	auth      *netproxy.Auth // may be nil
+}
+
+func (d *httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *httpConnectDialer) DialContext(ctx context.Context, network, address string) (_ net.Conn, err error) {
+	conn, err := d.direct.DialContext(ctx, "tcp", d.proxyAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot connect to HTTP proxy")
+	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+
+	if dl, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(dl); err != nil {
+			return nil, err
+		}
+	}
+	handshakeDone, watcherDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0)) // unblock the handshake
+		case <-handshakeDone:
+		}
+	}()
+
+	err = d.handshake(conn, address)
+	close(handshakeDone)
+	<-watcherDone // must not reset the deadline after we cleared it
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (d *httpConnectDialer) handshake(conn net.Conn, address string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if d.auth != nil {
+		creds := base64.StdEncoding.EncodeToString([]byte(d.auth.User + ":" + d.auth.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		return errors.Wrap(err, "cannot send CONNECT request to HTTP proxy")
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return errors.Wrap(err, "cannot read CONNECT response from HTTP proxy")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("HTTP proxy refused CONNECT to %s: %s", address, resp.Status)
+	}
+	// The server only sends after we did, so the proxy must not have sent anything
+	// beyond the response that we would lose with br.
+	if br.Buffered() > 0 {
+		return errors.New("HTTP proxy sent unexpected data after CONNECT response")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package psk
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/transport"
+)
+
+type PSKConnecter struct {
+	Address        string
+	dialer         net.Dialer
+	clientIdentity string
+	key            []byte
+	encrypt        bool
+}
+
+func PSKConnecterFromConfig(in *config.TCPPSKConnect) (*PSKConnecter, error) {
+	if err := transport.ValidateClientIdentity(in.ClientIdentity); err != nil {
+		return nil, errors.Wrap(err, "invalid client_identity")
+	}
+	key, err := LoadKeyFile(in.KeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot load key")
+	}
+	return NewConnecter(in.Address, in.ClientIdentity, key, in.Encrypt, in.DialTimeout), nil
+}
+
+func NewConnecter(address, clientIdentity string, key []byte, encrypt bool, dialTimeout time.Duration) *PSKConnecter {
+	return &PSKConnecter{
+		Address:        address,
+		dialer:         net.Dialer{Timeout: dialTimeout},
+		clientIdentity: clientIdentity,
+		key:            key,
+		encrypt:        encrypt,
+	}
+}
+
+func (c *PSKConnecter) Connect(dialCtx context.Context) (transport.Wire, error) {
+	if c.dialer.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(dialCtx, c.dialer.Timeout)
+		defer cancel()
+		dialCtx = ctx // shadow
+	}
+	conn, err := c.dialer.DialContext(dialCtx, "tcp", c.Address)
+	if err != nil {
+		return nil, err
+	}
+	nc := conn.(*net.TCPConn)
+	// the dial timeout covers the handshake
+	if dl, ok := dialCtx.Deadline(); ok {
+		if err := nc.SetDeadline(dl); err != nil {
+			nc.Close()
+			return nil, errors.Wrap(err, "cannot set handshake deadline")
+		}
+	}
+	keys, err := clientHandshake(nc, c.clientIdentity, c.key, c.encrypt)
+	if err != nil {
+		nc.Close()
+		return nil, errors.Wrapf(err, "psk handshake with %s failed", c.Address)
+	}
+	if err := nc.SetDeadline(time.Time{}); err != nil {
+		nc.Close()
+		return nil, errors.Wrap(err, "cannot clear handshake deadline")
+	}
+	if keys == nil {
+		return nc, nil
+	}
+	return newEncryptedWire(nc, keys.serverToClient, keys.clientToServer), nil
+}
@@ -0,0 +1,195 @@
+package psk
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Handshake protocol, all messages are sent in this order:
+//
+//   client hello:   magic | flags (1 byte) | len(identity) (1 byte) | identity | client nonce
+//   server proof:   server nonce | HMAC(key, "server" | transcript)
+//   client proof:   HMAC(key, "client" | transcript)
+//   server status:  status (1 byte)
+//
+// where transcript is the client hello followed by the server nonce.
+// The server proves knowledge of the key first, so that clients never send
+// their proof to an impostor. The status is only sent after the client proved
+// knowledge of the key.
+//
+// If the session is encrypted, the keys for both directions are derived from
+// the pre-shared key and the transcript, see sessionKeys.
+
+const (
+	handshakeMagic = "ZREPL-PSK-1\n"
+	nonceLen       = 32
+	macLen         = sha256.Size
+	// MinKeyLen is the minimum length of a pre-shared key in bytes.
+	MinKeyLen = 32
+)
+
+const (
+	flagEncrypt byte = 1 << iota
+)
+
+const (
+	statusOK byte = iota
+	statusAuthFailed
+	statusEncryptMismatch
+)
+
+var errAuthFailed = errors.New("authentication failed: unknown client identity or wrong key")
+
+type sessionKeys struct {
+	clientToServer, serverToClient []byte
+}
+
+func mac(key []byte, label string, transcript []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(label))
+	h.Write(transcript)
+	return h.Sum(nil)
+}
+
+func deriveSessionKeys(key, transcript []byte) *sessionKeys {
+	return &sessionKeys{
+		clientToServer: mac(key, "client-to-server", transcript),
+		serverToClient: mac(key, "server-to-client", transcript),
+	}
+}
+
+func newNonce() []byte {
+	nonce := make([]byte, nonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return nonce
+}
+
+// clientHandshake authenticates the client to the server and vice versa.
+// It returns nil session keys if encrypt is false.
+func clientHandshake(conn net.Conn, identity string, key []byte, encrypt bool) (*sessionKeys, error) {
+	if len(identity) == 0 || len(identity) > 255 {
+		return nil, fmt.Errorf("client identity must be between 1 and 255 bytes long")
+	}
+	var flags byte
+	if encrypt {
+		flags |= flagEncrypt
+	}
+	hello := make([]byte, 0, len(handshakeMagic)+2+len(identity)+nonceLen)
+	hello = append(hello, handshakeMagic...)
+	hello = append(hello, flags, byte(len(identity)))
+	hello = append(hello, identity...)
+	hello = append(hello, newNonce()...)
+	if _, err := conn.Write(hello); err != nil {
+		return nil, err
+	}
+
+	var serverProof [nonceLen + macLen]byte
+	if _, err := io.ReadFull(conn, serverProof[:]); err != nil {
+		return nil, err
+	}
+	transcript := append(hello, serverProof[:nonceLen]...)
+	if !hmac.Equal(serverProof[nonceLen:], mac(key, "server", transcript)) {
+		return nil, errAuthFailed
+	}
+
+	if _, err := conn.Write(mac(key, "client", transcript)); err != nil {
+		return nil, err
+	}
+
+	var status [1]byte
+	if _, err := io.ReadFull(conn, status[:]); err != nil {
+		return nil, err
+	}
+	switch status[0] {
+	case statusOK:
+	case statusAuthFailed:
+		return nil, errAuthFailed
+	case statusEncryptMismatch:
+		return nil, fmt.Errorf("server requires encrypt=%v for client identity %q", !encrypt, identity)
+	default:
+		return nil, fmt.Errorf("unknown handshake status %d", status[0])
+	}
+	if !encrypt {
+		return nil, nil
+	}
+	return deriveSessionKeys(key, transcript), nil
+}
+
+// ClientConfig is the server-side configuration of a client identity.
+type ClientConfig struct {
+	Key     []byte
+	Encrypt bool
+}
+
+// serverHandshake authenticates the client to the server and vice versa.
+// lookup returns the configuration of a client identity, ok is false for unknown identities.
+// It returns nil session keys if the session is not encrypted.
+func serverHandshake(conn net.Conn, lookup func(identity string) (c ClientConfig, ok bool)) (identity string, _ *sessionKeys, _ error) {
+	var hdr [len(handshakeMagic) + 2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return "", nil, err
+	}
+	if string(hdr[:len(handshakeMagic)]) != handshakeMagic {
+		return "", nil, fmt.Errorf("client did not send psk handshake")
+	}
+	flags, identityLen := hdr[len(handshakeMagic)], int(hdr[len(handshakeMagic)+1])
+	rest := make([]byte, identityLen+nonceLen)
+	if _, err := io.ReadFull(conn, rest); err != nil {
+		return "", nil, err
+	}
+	identity = string(rest[:identityLen])
+
+	c, known := lookup(identity)
+	if !known {
+		// continue with a random key so that the client cannot tell unknown identities from wrong keys
+		c = ClientConfig{Key: newNonce()}
+	}
+
+	serverNonce := newNonce()
+	transcript := make([]byte, 0, len(hdr)+len(rest)+nonceLen)
+	transcript = append(transcript, hdr[:]...)
+	transcript = append(transcript, rest...)
+	transcript = append(transcript, serverNonce...)
+	if _, err := conn.Write(append(serverNonce, mac(c.Key, "server", transcript)...)); err != nil {
+		return "", nil, err
+	}
+
+	var clientProof [macLen]byte
+	if _, err := io.ReadFull(conn, clientProof[:]); err != nil {
+		// clients abort the handshake if they cannot verify the server proof
+		if !known {
+			return "", nil, fmt.Errorf("unknown client identity %q", identity)
+		}
+		return "", nil, fmt.Errorf("client %q aborted handshake, keys likely differ: %s", identity, err)
+	}
+	if !known || !hmac.Equal(clientProof[:], mac(c.Key, "client", transcript)) {
+		_, _ = conn.Write([]byte{statusAuthFailed})
+		if !known {
+			return "", nil, fmt.Errorf("unknown client identity %q", identity)
+		}
+		return "", nil, fmt.Errorf("client %q failed to authenticate", identity)
+	}
+	encrypt := flags&flagEncrypt != 0
+	if encrypt != c.Encrypt {
+		_, _ = conn.Write([]byte{statusEncryptMismatch})
+		return "", nil, fmt.Errorf("client %q uses encrypt=%v but server is configured with encrypt=%v", identity, encrypt, c.Encrypt)
+	}
+	if _, err := conn.Write([]byte{statusOK}); err != nil {
+		return "", nil, err
+	}
+	if !encrypt {
+		return identity, nil, nil
+	}
+	return identity, deriveSessionKeys(c.Key, transcript), nil
+}
+
+// used by the record layer
+var recordByteOrder = binary.BigEndian
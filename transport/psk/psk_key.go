@@ -0,0 +1,28 @@
+// Package psk implements the tcp+psk transport: TCP connections that are
+// authenticated by a mutual HMAC challenge-response using a pre-shared key
+// per client identity, and optionally encrypted with AES-256-GCM.
+package psk
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+)
+
+// LoadKeyFile loads a pre-shared key from a file that contains the base64-encoded key,
+// e.g. as generated by `openssl rand -base64 32`.
+func LoadKeyFile(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(content)))
+	if err != nil {
+		return nil, fmt.Errorf("key file must contain a base64-encoded key: %s", err)
+	}
+	if len(key) < MinKeyLen {
+		return nil, fmt.Errorf("key must be at least %d bytes long, got %d", MinKeyLen, len(key))
+	}
+	return key, nil
+}
@@ -0,0 +1,218 @@
+package psk
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/transport"
+)
+
+func newKey(t *testing.T) []byte {
+	key := make([]byte, MinKeyLen)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+type testServer struct {
+	l     *Listener
+	conns chan *transport.AuthConn
+	errs  chan error
+}
+
+func newTestServer(t *testing.T, clients map[string]ClientConfig) *testServer {
+	tl, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	s := &testServer{NewListener(tl, clients, 5*time.Second), make(chan *transport.AuthConn, 10), make(chan error, 10)}
+	go func() {
+		for {
+			conn, err := s.l.Accept(context.Background())
+			if err != nil {
+				if _, ok := err.(*net.OpError); ok {
+					return // listener closed
+				}
+				s.errs <- err
+				continue
+			}
+			s.conns <- conn
+		}
+	}()
+	t.Cleanup(func() { s.l.Close() })
+	return s
+}
+
+func (s *testServer) connect(t *testing.T, identity string, key []byte, encrypt bool) (transport.Wire, error) {
+	return NewConnecter(s.l.Addr().String(), identity, key, encrypt, 5*time.Second).Connect(context.Background())
+}
+
+func TestConnectAccept(t *testing.T) {
+	key1, key2 := newKey(t), newKey(t)
+	s := newTestServer(t, map[string]ClientConfig{
+		"plain":     {Key: key1},
+		"encrypted": {Key: key2, Encrypt: true},
+	})
+
+	// larger than a single record
+	data := make([]byte, 3*maxRecordPlaintext+23)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	for _, c := range []struct {
+		identity string
+		key      []byte
+		encrypt  bool
+	}{{"plain", key1, false}, {"encrypted", key2, true}} {
+		t.Run(c.identity, func(t *testing.T) {
+			client, err := s.connect(t, c.identity, c.key, c.encrypt)
+			require.NoError(t, err)
+			defer client.Close()
+			server := <-s.conns
+			defer server.Close()
+			assert.Equal(t, c.identity, server.ClientIdentity())
+			_, isTCP := client.(*net.TCPConn)
+			assert.Equal(t, !c.encrypt, isTCP)
+
+			go func() {
+				_, err := client.Write(data)
+				assert.NoError(t, err)
+				assert.NoError(t, client.CloseWrite())
+			}()
+			received, err := ioutil.ReadAll(server)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(data, received))
+
+			// the other direction still works after CloseWrite
+			_, err = server.Write([]byte("pong"))
+			require.NoError(t, err)
+			require.NoError(t, server.CloseWrite())
+			received, err = ioutil.ReadAll(client)
+			require.NoError(t, err)
+			assert.Equal(t, "pong", string(received))
+		})
+	}
+}
+
+func TestCloseInterruptsBlockedWrite(t *testing.T) {
+	key := newKey(t)
+	s := newTestServer(t, map[string]ClientConfig{"encrypted": {Key: key, Encrypt: true}})
+	client, err := s.connect(t, "encrypted", key, true)
+	require.NoError(t, err)
+	server := <-s.conns
+	defer server.Close()
+
+	// the server doesn't read, so the write blocks once the socket buffers are full
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := client.Write(make([]byte, 64<<20))
+		writeErr <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- client.Close() }()
+	select {
+	case err := <-closed:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on the concurrent Write")
+	}
+	assert.Error(t, <-writeErr)
+}
+
+func TestAuthenticationFailures(t *testing.T) {
+	key := newKey(t)
+	s := newTestServer(t, map[string]ClientConfig{
+		"client1": {Key: key},
+		"client2": {Key: newKey(t), Encrypt: true},
+	})
+
+	_, err := s.connect(t, "client1", newKey(t), false)
+	assert.Error(t, err, "wrong key")
+	assert.Error(t, <-s.errs)
+
+	_, err = s.connect(t, "unknown", key, false)
+	assert.Error(t, err, "unknown identity")
+	assert.Contains(t, (<-s.errs).Error(), "unknown client identity")
+
+	_, err = s.connect(t, "client1", key, true)
+	require.Error(t, err, "encrypt mismatch")
+	assert.Contains(t, err.Error(), "server requires encrypt=false")
+	assert.Error(t, <-s.errs)
+}
+
+// pipeConn is a net.Conn whose Read and Write use separate buffers
+type pipeConn struct {
+	net.Conn // nil, only Read and Write are used
+	r, w     bytes.Buffer
+}
+
+func (c *pipeConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c *pipeConn) Write(p []byte) (int, error) { return c.w.Write(p) }
+
+func TestRecordLayerTamperingAndTruncation(t *testing.T) {
+	key := newKey(t)
+
+	var c pipeConn
+	w := recordWriter{conn: &c, aead: newAEAD(key)}
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.closeWrite())
+	records := append([]byte(nil), c.w.Bytes()...)
+
+	read := func(records []byte) ([]byte, error) {
+		var c pipeConn
+		c.r.Write(records)
+		r := recordReader{conn: &c, aead: newAEAD(key)}
+		return ioutil.ReadAll(&r)
+	}
+
+	data, err := read(records)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	tampered := append([]byte(nil), records...)
+	tampered[recordHeaderLen+1] ^= 0x1
+	_, err = read(tampered)
+	assert.Error(t, err)
+
+	// drop the end-of-stream record
+	_, err = read(records[:len(records)-recordHeaderLen-newAEAD(key).Overhead()])
+	assert.Equal(t, errTruncated, err)
+
+	// records cannot be reordered or replayed
+	first := records[:len(records)-recordHeaderLen-newAEAD(key).Overhead()]
+	_, err = read(append(append([]byte(nil), first...), records...))
+	assert.Error(t, err)
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-psk")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key := newKey(t)
+	p := filepath.Join(dir, "key")
+	require.NoError(t, ioutil.WriteFile(p, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600))
+	loaded, err := LoadKeyFile(p)
+	require.NoError(t, err)
+	assert.Equal(t, key, loaded)
+
+	require.NoError(t, ioutil.WriteFile(p, []byte(base64.StdEncoding.EncodeToString(key[:16])), 0600))
+	_, err = LoadKeyFile(p)
+	assert.Error(t, err, "short key")
+
+	require.NoError(t, ioutil.WriteFile(p, []byte("not base64!"), 0600))
+	_, err = LoadKeyFile(p)
+	assert.Error(t, err)
+}
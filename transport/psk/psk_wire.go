@@ -0,0 +1,243 @@
+package psk
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// An encrypted session consists of records:
+//
+//   len(sealed) (4 bytes) | sealed
+//
+// where sealed is the AES-256-GCM encryption of up to maxRecordPlaintext bytes.
+// The nonce of a record is its sequence number in its direction.
+// A record with empty plaintext signals the end of the direction (CloseWrite),
+// which protects against truncation of the stream.
+
+const (
+	maxRecordPlaintext = 1 << 14
+	recordHeaderLen    = 4
+)
+
+// encryptedWire implements transport.Wire.
+// It deliberately does not implement timeoutconn.SyscallConner,
+// because vectored I/O on the raw connection would bypass encryption.
+type encryptedWire struct {
+	conn *net.TCPConn
+	r    recordReader
+	w    recordWriter
+}
+
+func newAEAD(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err) // key length is fixed
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+func newEncryptedWire(conn *net.TCPConn, readKey, writeKey []byte) *encryptedWire {
+	w := &encryptedWire{conn: conn}
+	w.r.conn, w.r.aead = conn, newAEAD(readKey)
+	w.w.conn, w.w.aead = conn, newAEAD(writeKey)
+	return w
+}
+
+func recordNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	recordByteOrder.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+type recordReader struct {
+	conn net.Conn
+	aead cipher.AEAD
+	seq  uint64
+
+	// partially read record, preserved across timeouts
+	hdr    [recordHeaderLen]byte
+	hdrN   int
+	sealed []byte
+	sealN  int
+
+	plain []byte // decrypted but not yet returned
+	eof   bool
+	err   error // sticky
+}
+
+var errTruncated = errors.New("psk: connection closed without end-of-stream record")
+
+func (r *recordReader) fill(buf []byte, n *int) error {
+	for *n < len(buf) {
+		m, err := r.conn.Read(buf[*n:])
+		*n += m
+		if err == io.EOF {
+			return errTruncated
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if r.err != nil {
+			return 0, r.err
+		}
+		if err := r.readRecord(); err != nil {
+			if !isTimeout(err) {
+				r.err = err
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *recordReader) readRecord() error {
+	if err := r.fill(r.hdr[:], &r.hdrN); err != nil {
+		return err
+	}
+	if r.sealed == nil {
+		l := int(recordByteOrder.Uint32(r.hdr[:]))
+		if l < r.aead.Overhead() || l > maxRecordPlaintext+r.aead.Overhead() {
+			return fmt.Errorf("psk: invalid record length %d", l)
+		}
+		r.sealed, r.sealN = make([]byte, l), 0
+	}
+	if err := r.fill(r.sealed, &r.sealN); err != nil {
+		return err
+	}
+	plain, err := r.aead.Open(r.sealed[:0], recordNonce(r.aead, r.seq), r.sealed, nil)
+	if err != nil {
+		return fmt.Errorf("psk: cannot decrypt record: %s", err)
+	}
+	r.seq++
+	r.hdrN, r.sealed = 0, nil
+	r.plain = plain
+	if len(plain) == 0 {
+		r.eof = true
+	}
+	return nil
+}
+
+type recordWriter struct {
+	conn net.Conn
+	aead cipher.AEAD
+
+	mtx    sync.Mutex
+	seq    uint64
+	closed bool  // end-of-stream record was sent
+	err    error // sticky, a partially written record breaks the stream
+}
+
+func (w *recordWriter) writeRecord(plain []byte) error {
+	sealed := make([]byte, recordHeaderLen, recordHeaderLen+len(plain)+w.aead.Overhead())
+	sealed = w.aead.Seal(sealed, recordNonce(w.aead, w.seq), plain, nil)
+	recordByteOrder.PutUint32(sealed[:recordHeaderLen], uint32(len(sealed)-recordHeaderLen))
+	w.seq++
+	_, err := w.conn.Write(sealed)
+	return err
+}
+
+func (w *recordWriter) Write(p []byte) (n int, err error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, fmt.Errorf("psk: write after CloseWrite")
+	}
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxRecordPlaintext {
+			chunk = chunk[:maxRecordPlaintext]
+		}
+		if err := w.writeRecord(chunk); err != nil {
+			w.err = err
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// closeWrite sends the end-of-stream record, if it wasn't sent yet.
+func (w *recordWriter) closeWrite() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.closeWriteLocked()
+}
+
+// tryCloseWrite is like closeWrite, but gives up if a Write is in progress,
+// which might be blocked in conn.Write until the connection is closed.
+func (w *recordWriter) tryCloseWrite() {
+	if !w.mtx.TryLock() {
+		return
+	}
+	defer w.mtx.Unlock()
+	_ = w.closeWriteLocked()
+}
+
+func (w *recordWriter) closeWriteLocked() error {
+	if w.closed {
+		return nil
+	}
+	if w.err != nil {
+		return w.err
+	}
+	w.closed = true
+	if err := w.writeRecord(nil); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+func (w *encryptedWire) Read(p []byte) (int, error)  { return w.r.Read(p) }
+func (w *encryptedWire) Write(p []byte) (int, error) { return w.w.Write(p) }
+
+func (w *encryptedWire) CloseWrite() error {
+	if err := w.w.closeWrite(); err != nil {
+		return err
+	}
+	return w.conn.CloseWrite()
+}
+
+// Close sends the end-of-stream record if CloseWrite was not called
+// and no Write is in progress, then closes the connection,
+// which interrupts a concurrent Write.
+func (w *encryptedWire) Close() error {
+	w.w.tryCloseWrite() // best-effort, the caller is expected to have set a deadline
+	return w.conn.Close()
+}
+
+func (w *encryptedWire) LocalAddr() net.Addr                { return w.conn.LocalAddr() }
+func (w *encryptedWire) RemoteAddr() net.Addr               { return w.conn.RemoteAddr() }
+func (w *encryptedWire) SetDeadline(t time.Time) error      { return w.conn.SetDeadline(t) }
+func (w *encryptedWire) SetReadDeadline(t time.Time) error  { return w.conn.SetReadDeadline(t) }
+func (w *encryptedWire) SetWriteDeadline(t time.Time) error { return w.conn.SetWriteDeadline(t) }
@@ -0,0 +1,88 @@
+package psk
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/transport"
+	"github.com/zrepl/zrepl/util/tcpsock"
+)
+
+func PSKListenerFactoryFromConfig(g *config.Global, in *config.TCPPSKServe) (transport.AuthenticatedListenerFactory, error) {
+	if len(in.Clients) == 0 {
+		return nil, errors.New("field 'clients' must not be empty")
+	}
+	clients := make(map[string]ClientConfig, len(in.Clients))
+	for clientIdentity, c := range in.Clients {
+		if err := transport.ValidateClientIdentity(clientIdentity); err != nil {
+			return nil, errors.Wrapf(err, "invalid client identity %q", clientIdentity)
+		}
+		key, err := LoadKeyFile(c.KeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot load key of client %q", clientIdentity)
+		}
+		clients[clientIdentity] = ClientConfig{Key: key, Encrypt: c.Encrypt}
+	}
+	lf := func() (transport.AuthenticatedListener, error) {
+		l, err := tcpsock.Listen(in.Listen, in.ListenFreeBind)
+		if err != nil {
+			return nil, err
+		}
+		return NewListener(l, clients, in.HandshakeTimeout), nil
+	}
+	return lf, nil
+}
+
+type Listener struct {
+	*net.TCPListener
+	clients          map[string]ClientConfig
+	handshakeTimeout time.Duration
+}
+
+var _ transport.AuthenticatedListener = (*Listener)(nil)
+
+// NewListener returns a listener that authenticates the connections accepted from l
+// using the pre-shared keys in clients, indexed by client identity.
+func NewListener(l *net.TCPListener, clients map[string]ClientConfig, handshakeTimeout time.Duration) *Listener {
+	return &Listener{l, clients, handshakeTimeout}
+}
+
+// Accept accepts the next connection and performs the handshake within the handshake timeout.
+func (l *Listener) Accept(ctx context.Context) (*transport.AuthConn, error) {
+	nc, err := l.TCPListener.AcceptTCP()
+	if err != nil {
+		return nil, err
+	}
+	log := transport.GetLogger(ctx)
+	closeConn := func() {
+		if err := nc.Close(); err != nil {
+			log.WithError(err).Error("cannot close connection")
+		}
+	}
+
+	if err := nc.SetDeadline(time.Now().Add(l.handshakeTimeout)); err != nil {
+		closeConn()
+		return nil, errors.Wrap(err, "cannot set handshake deadline")
+	}
+	clientIdentity, keys, err := serverHandshake(nc, func(identity string) (ClientConfig, bool) {
+		c, ok := l.clients[identity]
+		return c, ok
+	})
+	if err != nil {
+		closeConn()
+		return nil, errors.Wrapf(err, "psk handshake with %s failed", nc.RemoteAddr())
+	}
+	if err := nc.SetDeadline(time.Time{}); err != nil {
+		closeConn()
+		return nil, errors.Wrap(err, "cannot clear handshake deadline")
+	}
+
+	if keys == nil {
+		return transport.NewAuthConn(nc, clientIdentity), nil
+	}
+	return transport.NewAuthConn(newEncryptedWire(nc, keys.clientToServer, keys.serverToClient), clientIdentity), nil
+}
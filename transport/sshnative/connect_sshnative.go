@@ -0,0 +1,166 @@
+package sshnative
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/transport"
+)
+
+// clientUser is the SSH user name sent by the client.
+// The server does not use it, client identities are determined by public key.
+const clientUser = "zrepl"
+
+type ClientConfig struct {
+	Identity        ssh.Signer
+	HostKeyCallback ssh.HostKeyCallback
+	DialTimeout     time.Duration
+	// KeepaliveInterval is the interval at which keepalive requests are sent
+	// to the server. Zero disables keepalives.
+	KeepaliveInterval time.Duration
+	// The connection is closed if the server does not reply to a keepalive
+	// request within KeepaliveCountMax * KeepaliveInterval.
+	KeepaliveCountMax int
+}
+
+type SSHConnecter struct {
+	address           string
+	dialer            net.Dialer
+	sshConfig         *ssh.ClientConfig
+	keepaliveInterval time.Duration
+	keepaliveCountMax int
+}
+
+func SSHConnecterFromConfig(in *config.SSHConnect) (*SSHConnecter, error) {
+	if fakeKeyLoading {
+		return &SSHConnecter{address: in.Address}, nil
+	}
+	identity, err := parsePrivateKeyFile(in.IdentityFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse identity file")
+	}
+	hostKeyCallback, err := knownhosts.New(in.KnownHosts)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse known_hosts file")
+	}
+	return NewConnecter(in.Address, ClientConfig{
+		Identity:          identity,
+		HostKeyCallback:   hostKeyCallback,
+		DialTimeout:       in.DialTimeout,
+		KeepaliveInterval: in.KeepaliveInterval,
+		KeepaliveCountMax: in.KeepaliveCountMax,
+	})
+}
+
+func NewConnecter(address string, conf ClientConfig) (*SSHConnecter, error) {
+	if conf.Identity == nil {
+		return nil, errors.New("identity must be set")
+	}
+	if conf.HostKeyCallback == nil {
+		return nil, errors.New("host key callback must be set")
+	}
+	if conf.KeepaliveInterval > 0 && conf.KeepaliveCountMax < 1 {
+		return nil, errors.New("keepalive count max must be positive")
+	}
+	return &SSHConnecter{
+		address: address,
+		dialer:  net.Dialer{Timeout: conf.DialTimeout},
+		sshConfig: &ssh.ClientConfig{
+			User:            clientUser,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(conf.Identity)},
+			HostKeyCallback: conf.HostKeyCallback,
+		},
+		keepaliveInterval: conf.KeepaliveInterval,
+		keepaliveCountMax: conf.KeepaliveCountMax,
+	}, nil
+}
+
+func (c *SSHConnecter) Connect(dialCtx context.Context) (transport.Wire, error) {
+	if c.dialer.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(dialCtx, c.dialer.Timeout)
+		defer cancel()
+		dialCtx = ctx // shadow
+	}
+
+	nc, err := c.dialer.DialContext(dialCtx, "tcp", c.address)
+	if err != nil {
+		return nil, err
+	}
+	// the dial timeout covers the SSH handshake
+	if dl, ok := dialCtx.Deadline(); ok {
+		if err := nc.SetDeadline(dl); err != nil {
+			nc.Close()
+			return nil, errors.Wrap(err, "cannot set handshake deadline")
+		}
+	}
+
+	conn, chans, reqs, err := ssh.NewClientConn(nc, c.address, c.sshConfig)
+	if err != nil {
+		nc.Close()
+		return nil, errors.Wrapf(err, "ssh handshake with %s failed", c.address)
+	}
+	go ssh.DiscardRequests(reqs)
+	go rejectChannels(chans)
+
+	ch, chReqs, err := conn.OpenChannel(channelType, nil)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "cannot open channel to %s", c.address)
+	}
+	go ssh.DiscardRequests(chReqs)
+
+	if err := nc.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "cannot clear handshake deadline")
+	}
+
+	var stopKeepalives func()
+	if c.keepaliveInterval > 0 {
+		done := make(chan struct{})
+		stopKeepalives = func() { close(done) }
+		go keepalive(conn, c.keepaliveInterval, c.keepaliveCountMax, done)
+	}
+	return newWire(ch, conn, stopKeepalives), nil
+}
+
+// keepalive sends a keepalive request every interval and closes conn
+// if a request fails or is not replied to within countMax * interval.
+func keepalive(conn ssh.Conn, interval time.Duration, countMax int, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		replied := make(chan error, 1)
+		go func() {
+			// the server replies with false to unknown requests, which is sufficient
+			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+			replied <- err
+		}()
+		timeout := time.NewTimer(time.Duration(countMax) * interval)
+		select {
+		case err := <-replied:
+			timeout.Stop()
+			if err != nil {
+				conn.Close()
+				return
+			}
+		case <-timeout.C:
+			conn.Close()
+			return
+		case <-done:
+			timeout.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Package sshnative implements the ssh transport in-process, without
+// the system's ssh binary and sshd.
+//
+// Each transport.Wire is carried by a dedicated SSH connection with a single
+// channel of type channelType. The server maps the public key with which a
+// client authenticated to the client identity configured for that key.
+package sshnative
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/transport"
+	"github.com/zrepl/zrepl/util/tcpsock"
+)
+
+// permissionsExtClientIdentity is the key of the ssh.Permissions extension
+// that passes the client identity from the PublicKeyCallback to Accept.
+const permissionsExtClientIdentity = "zrepl-client-identity"
+
+type ServerConfig struct {
+	HostKeys []ssh.Signer
+	// public keys by client identity
+	Clients          map[string][]ssh.PublicKey
+	HandshakeTimeout time.Duration
+}
+
+func SSHListenerFactoryFromConfig(g *config.Global, in *config.SSHServe) (transport.AuthenticatedListenerFactory, error) {
+
+	if len(in.HostKeys) == 0 {
+		return nil, errors.New("field 'host_keys' must not be empty")
+	}
+
+	if fakeKeyLoading {
+		return func() (transport.AuthenticatedListener, error) { return nil, nil }, nil
+	}
+
+	var conf ServerConfig
+	conf.HandshakeTimeout = in.HandshakeTimeout
+	for _, path := range in.HostKeys {
+		signer, err := parsePrivateKeyFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse host key %q", path)
+		}
+		conf.HostKeys = append(conf.HostKeys, signer)
+	}
+
+	conf.Clients = make(map[string][]ssh.PublicKey, len(in.Clients))
+	for clientIdentity, path := range in.Clients {
+		if err := transport.ValidateClientIdentity(clientIdentity); err != nil {
+			return nil, errors.Wrapf(err, "invalid client identity %q", clientIdentity)
+		}
+		keys, err := parseAuthorizedKeysFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot parse authorized keys of client %q", clientIdentity)
+		}
+		conf.Clients[clientIdentity] = keys
+	}
+
+	// validate before the factory is called
+	if _, err := newServerSSHConfig(conf); err != nil {
+		return nil, err
+	}
+
+	lf := func() (transport.AuthenticatedListener, error) {
+		l, err := tcpsock.Listen(in.Listen, in.ListenFreeBind)
+		if err != nil {
+			return nil, err
+		}
+		sl, err := NewListener(l, conf)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		return sl, nil
+	}
+	return lf, nil
+}
+
+func parsePrivateKeyFile(path string) (ssh.Signer, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(pem)
+}
+
+// parseAuthorizedKeysFile parses a file in OpenSSH authorized_keys format.
+// Key options such as command= are ignored.
+func parseAuthorizedKeysFile(path string) ([]ssh.PublicKey, error) {
+	rest, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []ssh.PublicKey
+	for len(rest) > 0 {
+		var key ssh.PublicKey
+		key, _, _, rest, err = ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			break // no more keys, ParseAuthorizedKey skips lines it cannot parse
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("file contains no public keys")
+	}
+	return keys, nil
+}
+
+func newServerSSHConfig(conf ServerConfig) (*ssh.ServerConfig, error) {
+	if len(conf.HostKeys) == 0 {
+		return nil, errors.New("no host keys")
+	}
+	identities := make(map[string]string) // marshaled public key => client identity
+	for clientIdentity, keys := range conf.Clients {
+		for _, key := range keys {
+			k := string(key.Marshal())
+			if other, ok := identities[k]; ok && other != clientIdentity {
+				return nil, errors.Errorf("public key %s is configured for clients %q and %q",
+					ssh.FingerprintSHA256(key), other, clientIdentity)
+			}
+			identities[k] = clientIdentity
+		}
+	}
+
+	sc := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			clientIdentity, ok := identities[string(key.Marshal())]
+			if !ok {
+				return nil, fmt.Errorf("unknown public key %s", ssh.FingerprintSHA256(key))
+			}
+			return &ssh.Permissions{
+				Extensions: map[string]string{permissionsExtClientIdentity: clientIdentity},
+			}, nil
+		},
+	}
+	for _, hk := range conf.HostKeys {
+		sc.AddHostKey(hk)
+	}
+	return sc, nil
+}
+
+type Listener struct {
+	l                net.Listener
+	sshConfig        *ssh.ServerConfig
+	handshakeTimeout time.Duration
+}
+
+var _ transport.AuthenticatedListener = (*Listener)(nil)
+
+// NewListener returns a Listener that performs the SSH server handshake on the connections accepted from l.
+func NewListener(l net.Listener, conf ServerConfig) (*Listener, error) {
+	sc, err := newServerSSHConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{l, sc, conf.HandshakeTimeout}, nil
+}
+
+func (l *Listener) Addr() net.Addr { return l.l.Addr() }
+
+func (l *Listener) Close() error { return l.l.Close() }
+
+// Accept accepts the next connection, authenticates the client and waits for it to open
+// the channel that carries the returned AuthConn.
+// The entire process is subject to the handshake timeout.
+func (l *Listener) Accept(ctx context.Context) (*transport.AuthConn, error) {
+	nc, err := l.l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	log := transport.GetLogger(ctx)
+	closeConn := func() {
+		if err := nc.Close(); err != nil {
+			log.WithError(err).Error("cannot close connection")
+		}
+	}
+
+	if l.handshakeTimeout > 0 {
+		if err := nc.SetDeadline(time.Now().Add(l.handshakeTimeout)); err != nil {
+			closeConn()
+			return nil, errors.Wrap(err, "cannot set handshake deadline")
+		}
+	}
+
+	conn, chans, reqs, err := ssh.NewServerConn(nc, l.sshConfig)
+	if err != nil {
+		closeConn()
+		return nil, errors.Wrapf(err, "ssh handshake with %s failed", nc.RemoteAddr())
+	}
+	go ssh.DiscardRequests(reqs) // replies to keepalives
+	clientIdentity := conn.Permissions.Extensions[permissionsExtClientIdentity]
+
+	newCh, ok := <-chans
+	for ok && newCh.ChannelType() != channelType {
+		_ = newCh.Reject(ssh.UnknownChannelType, "unsupported channel type")
+		newCh, ok = <-chans
+	}
+	if !ok {
+		conn.Close()
+		return nil, errors.Errorf("client %q at %s did not open a channel", clientIdentity, nc.RemoteAddr())
+	}
+	ch, chReqs, err := newCh.Accept()
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "cannot accept channel of client %q at %s", clientIdentity, nc.RemoteAddr())
+	}
+	go ssh.DiscardRequests(chReqs)
+	go rejectChannels(chans)
+
+	if err := nc.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "cannot clear handshake deadline")
+	}
+
+	return transport.NewAuthConn(newWire(ch, conn, nil), clientIdentity), nil
+}
+
+// rejectChannels rejects further channels, there is only one channel per connection.
+func rejectChannels(chans <-chan ssh.NewChannel) {
+	for newCh := range chans {
+		_ = newCh.Reject(ssh.Prohibited, "only a single channel per connection is supported")
+	}
+}
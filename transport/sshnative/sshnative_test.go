@@ -0,0 +1,263 @@
+package sshnative
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/transport"
+)
+
+func genSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return signer
+}
+
+type testServer struct {
+	l       *Listener
+	hostKey ssh.Signer
+	conns   chan *transport.AuthConn
+	errs    chan error
+}
+
+func newTestServer(t *testing.T, clients map[string][]ssh.PublicKey) *testServer {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	hostKey := genSigner(t)
+	l, err := NewListener(nl, ServerConfig{
+		HostKeys:         []ssh.Signer{hostKey},
+		Clients:          clients,
+		HandshakeTimeout: 5 * time.Second,
+	})
+	require.NoError(t, err)
+	s := &testServer{l, hostKey, make(chan *transport.AuthConn, 10), make(chan error, 10)}
+	go func() {
+		for {
+			conn, err := l.Accept(context.Background())
+			if err != nil {
+				if _, ok := err.(*net.OpError); ok {
+					return // listener closed
+				}
+				s.errs <- err
+				continue
+			}
+			s.conns <- conn
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *testServer) connecter(t *testing.T, identity ssh.Signer) *SSHConnecter {
+	cn, err := NewConnecter(s.l.Addr().String(), ClientConfig{
+		Identity:        identity,
+		HostKeyCallback: ssh.FixedHostKey(s.hostKey.PublicKey()),
+		DialTimeout:     5 * time.Second,
+	})
+	require.NoError(t, err)
+	return cn
+}
+
+func TestConnectAccept(t *testing.T) {
+	key1, key2 := genSigner(t), genSigner(t)
+	s := newTestServer(t, map[string][]ssh.PublicKey{
+		"client1": {key1.PublicKey()},
+		"client2": {key2.PublicKey()},
+	})
+
+	for _, c := range []struct {
+		identity ssh.Signer
+		expect   string
+	}{{key1, "client1"}, {key2, "client2"}} {
+		t.Run(c.expect, func(t *testing.T) {
+			client, err := s.connecter(t, c.identity).Connect(context.Background())
+			require.NoError(t, err)
+			defer client.Close()
+			server := <-s.conns
+			defer server.Close()
+			assert.Equal(t, c.expect, server.ClientIdentity())
+
+			_, err = client.Write([]byte("ping"))
+			require.NoError(t, err)
+			require.NoError(t, client.CloseWrite())
+			buf, err := ioutil.ReadAll(server)
+			require.NoError(t, err)
+			assert.Equal(t, "ping", string(buf))
+
+			// the other direction still works after CloseWrite
+			_, err = server.Write([]byte("pong"))
+			require.NoError(t, err)
+			require.NoError(t, server.CloseWrite())
+			buf, err = ioutil.ReadAll(client)
+			require.NoError(t, err)
+			assert.Equal(t, "pong", string(buf))
+		})
+	}
+}
+
+func TestUnknownClientKeyIsRejected(t *testing.T) {
+	s := newTestServer(t, map[string][]ssh.PublicKey{
+		"client1": {genSigner(t).PublicKey()},
+	})
+	_, err := s.connecter(t, genSigner(t)).Connect(context.Background())
+	require.Error(t, err)
+	assert.Error(t, <-s.errs)
+}
+
+func TestUnknownHostKeyIsRejected(t *testing.T) {
+	key := genSigner(t)
+	s := newTestServer(t, map[string][]ssh.PublicKey{"client1": {key.PublicKey()}})
+	cn, err := NewConnecter(s.l.Addr().String(), ClientConfig{
+		Identity:        key,
+		HostKeyCallback: ssh.FixedHostKey(genSigner(t).PublicKey()),
+	})
+	require.NoError(t, err)
+	_, err = cn.Connect(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "host key mismatch")
+}
+
+func TestDuplicateClientKey(t *testing.T) {
+	key := genSigner(t).PublicKey()
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer nl.Close()
+	_, err = NewListener(nl, ServerConfig{
+		HostKeys: []ssh.Signer{genSigner(t)},
+		Clients:  map[string][]ssh.PublicKey{"client1": {key}, "client2": {key}},
+	})
+	assert.Error(t, err)
+}
+
+func TestReadDeadline(t *testing.T) {
+	key := genSigner(t)
+	s := newTestServer(t, map[string][]ssh.PublicKey{"client1": {key.PublicKey()}})
+	client, err := s.connecter(t, key).Connect(context.Background())
+	require.NoError(t, err)
+	defer client.Close()
+	server := <-s.conns
+	defer server.Close()
+
+	// a deadline that is cleared before it expires has no effect
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(time.Hour)))
+	require.NoError(t, server.SetReadDeadline(time.Time{}))
+	_, err = client.Write([]byte("x"))
+	require.NoError(t, err)
+	var buf [1]byte
+	_, err = io.ReadFull(server, buf[:])
+	require.NoError(t, err)
+
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	_, err = server.Read(buf[:])
+	require.Error(t, err)
+	netErr, ok := err.(net.Error)
+	require.True(t, ok, "%T", err)
+	assert.True(t, netErr.Timeout())
+}
+
+func TestKeepalive(t *testing.T) {
+	key := genSigner(t)
+	s := newTestServer(t, map[string][]ssh.PublicKey{"client1": {key.PublicKey()}})
+	cn, err := NewConnecter(s.l.Addr().String(), ClientConfig{
+		Identity:          key,
+		HostKeyCallback:   ssh.FixedHostKey(s.hostKey.PublicKey()),
+		KeepaliveInterval: 10 * time.Millisecond,
+		KeepaliveCountMax: 3,
+	})
+	require.NoError(t, err)
+	client, err := cn.Connect(context.Background())
+	require.NoError(t, err)
+	defer client.Close()
+	server := <-s.conns
+	defer server.Close()
+
+	// the server answers the keepalives, so the connection stays up while idle
+	time.Sleep(100 * time.Millisecond)
+	_, err = client.Write([]byte("x"))
+	require.NoError(t, err)
+	var buf [1]byte
+	_, err = io.ReadFull(server, buf[:])
+	require.NoError(t, err)
+}
+
+func writeFile(t *testing.T, dir, name string, content []byte) string {
+	p := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(p, content, 0600))
+	return p
+}
+
+func marshalPrivateKey(t *testing.T) (ssh.Signer, []byte) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	return signer, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestFromConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zrepl-sshnative")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	hostKey, hostKeyPEM := marshalPrivateKey(t)
+	clientKey, clientKeyPEM := marshalPrivateKey(t)
+
+	lf, err := SSHListenerFactoryFromConfig(nil, &config.SSHServe{
+		Listen:   "127.0.0.1:0",
+		HostKeys: []string{writeFile(t, dir, "host_key", hostKeyPEM)},
+		Clients: map[string]string{
+			"client1": writeFile(t, dir, "client1.pub", append([]byte("# comment\n"), ssh.MarshalAuthorizedKey(clientKey.PublicKey())...)),
+		},
+		HandshakeTimeout: 5 * time.Second,
+	})
+	require.NoError(t, err)
+	l, err := lf()
+	require.NoError(t, err)
+	defer l.Close()
+
+	addr := l.Addr().String()
+	knownHostsLine := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey.PublicKey())
+	cn, err := SSHConnecterFromConfig(&config.SSHConnect{
+		Address:           addr,
+		IdentityFile:      writeFile(t, dir, "identity", clientKeyPEM),
+		KnownHosts:        writeFile(t, dir, "known_hosts", []byte(knownHostsLine+"\n")),
+		DialTimeout:       5 * time.Second,
+		KeepaliveInterval: time.Second,
+		KeepaliveCountMax: 3,
+	})
+	require.NoError(t, err)
+
+	accepted := make(chan *transport.AuthConn, 1)
+	go func() {
+		conn, err := l.Accept(context.Background())
+		assert.NoError(t, err)
+		accepted <- conn
+	}()
+	client, err := cn.Connect(context.Background())
+	require.NoError(t, err)
+	defer client.Close()
+	server := <-accepted
+	require.NotNil(t, server)
+	defer server.Close()
+	assert.Equal(t, "client1", server.ClientIdentity())
+}
@@ -0,0 +1,10 @@
+package sshnative
+
+import "testing"
+
+var fakeKeyLoading bool
+
+func FakeKeyLoading(t *testing.T) {
+	t.Logf("faking ssh key loading")
+	fakeKeyLoading = true
+}
@@ -0,0 +1,170 @@
+package sshnative
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// channelType is the type of the SSH channel that carries a transport.Wire.
+// Each SSH connection carries exactly one such channel.
+const channelType = "zrepl-wire@zrepl.github.io"
+
+// wire adapts an ssh.Channel into a transport.Wire.
+//
+// SSH channels do not support deadlines.
+// Since each wire has its own SSH connection, a wire implements an expired
+// deadline by closing the SSH connection, which unblocks pending reads and writes.
+// Hence, unlike a net.TCPConn, a wire is unusable after a deadline expired.
+type wire struct {
+	ch   ssh.Channel
+	conn ssh.Conn
+
+	readDeadline, writeDeadline deadline
+
+	closeOnce sync.Once
+	closeErr  error
+	onClose   func() // may be nil
+}
+
+var _ net.Conn = (*wire)(nil)
+
+func newWire(ch ssh.Channel, conn ssh.Conn, onClose func()) *wire {
+	return &wire{ch: ch, conn: conn, onClose: onClose}
+}
+
+func (w *wire) Read(p []byte) (int, error) {
+	if w.readDeadline.isExpired() {
+		return 0, errTimeout
+	}
+	n, err := w.ch.Read(p)
+	if err != nil && w.readDeadline.isExpired() {
+		err = errTimeout
+	}
+	return n, err
+}
+
+func (w *wire) Write(p []byte) (int, error) {
+	if w.writeDeadline.isExpired() {
+		return 0, errTimeout
+	}
+	n, err := w.ch.Write(p)
+	if err != nil && w.writeDeadline.isExpired() {
+		err = errTimeout
+	}
+	return n, err
+}
+
+// CloseWrite sends EOF on the channel, the peer's Read calls return io.EOF
+// once it read all data we wrote before.
+func (w *wire) CloseWrite() error {
+	return w.ch.CloseWrite()
+}
+
+func (w *wire) Close() error {
+	w.closeOnce.Do(func() {
+		w.readDeadline.stop()
+		w.writeDeadline.stop()
+		if w.onClose != nil {
+			w.onClose()
+		}
+		if err := w.ch.Close(); err != nil && err != io.EOF {
+			w.closeErr = err
+		}
+		// the connection is already closed if a deadline expired or keepalives failed
+		_ = w.conn.Close()
+	})
+	return w.closeErr
+}
+
+func (w *wire) LocalAddr() net.Addr  { return w.conn.LocalAddr() }
+func (w *wire) RemoteAddr() net.Addr { return w.conn.RemoteAddr() }
+
+func (w *wire) expire() {
+	_ = w.conn.Close() // errors are reported by the pending Read or Write calls
+}
+
+func (w *wire) SetReadDeadline(t time.Time) error {
+	w.readDeadline.set(t, w.expire)
+	return nil
+}
+
+func (w *wire) SetWriteDeadline(t time.Time) error {
+	w.writeDeadline.set(t, w.expire)
+	return nil
+}
+
+func (w *wire) SetDeadline(t time.Time) error {
+	w.readDeadline.set(t, w.expire)
+	w.writeDeadline.set(t, w.expire)
+	return nil
+}
+
+type deadline struct {
+	mtx     sync.Mutex
+	timer   *time.Timer
+	gen     uint64 // invalidates timers that fire concurrently to set or stop
+	expired bool
+}
+
+func (d *deadline) isExpired() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.expired
+}
+
+func (d *deadline) stopLocked() {
+	d.gen++
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
+
+func (d *deadline) stop() {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.stopLocked()
+}
+
+// set arms the deadline to call expire at t.
+// The zero value of t disarms the deadline.
+func (d *deadline) set(t time.Time, expire func()) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.stopLocked()
+	if d.expired || t.IsZero() {
+		return
+	}
+	dur := time.Until(t)
+	if dur <= 0 {
+		d.expired = true
+		go expire()
+		return
+	}
+	gen := d.gen
+	d.timer = time.AfterFunc(dur, func() {
+		d.mtx.Lock()
+		current := d.gen == gen
+		if current {
+			d.expired = true
+		}
+		d.mtx.Unlock()
+		if current {
+			expire()
+		}
+	})
+}
+
+type timeoutError struct{}
+
+var _ net.Error = timeoutError{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var errTimeout error = timeoutError{}
@@ -2,29 +2,28 @@ package tcp
 
 import (
 	"context"
-	"net"
+
+	"github.com/pkg/errors"
 
 	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/transport"
+	"github.com/zrepl/zrepl/transport/proxy"
 )
 
 type TCPConnecter struct {
 	Address string
-	dialer  net.Dialer
+	dialer  *proxy.Dialer
 }
 
 func TCPConnecterFromConfig(in *config.TCPConnect) (*TCPConnecter, error) {
-	dialer := net.Dialer{
-		Timeout: in.DialTimeout,
+	dialer, err := proxy.NewDialer(in.Proxy, in.DialTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid proxy config")
 	}
 
 	return &TCPConnecter{in.Address, dialer}, nil
 }
 
 func (c *TCPConnecter) Connect(dialCtx context.Context) (transport.Wire, error) {
-	conn, err := c.dialer.DialContext(dialCtx, "tcp", c.Address)
-	if err != nil {
-		return nil, err
-	}
-	return conn.(*net.TCPConn), nil
+	return c.dialer.DialContext(dialCtx, c.Address)
 }
@@ -10,47 +10,87 @@ import (
 	"github.com/zrepl/zrepl/config"
 	"github.com/zrepl/zrepl/tlsconf"
 	"github.com/zrepl/zrepl/transport"
+	"github.com/zrepl/zrepl/transport/proxy"
 )
 
 type TLSConnecter struct {
 	Address   string
-	dialer    net.Dialer
-	tlsConfig *tls.Config
+	dialer    *proxy.Dialer
+	store     *tlsconf.Store
+	tlsConfig *tls.Config // Certificates and RootCAs are replaced by the material in store
+	// if non-nil, the server is verified by the identity extracted from its certificate
+	// instead of tls.Config.ServerName
+	serverIdentity       *tlsconf.IdentityExtractor
+	expectServerIdentity string
 }
 
 func TLSConnecterFromConfig(in *config.TLSConnect) (*TLSConnecter, error) {
-	dialer := net.Dialer{
-		Timeout: in.DialTimeout,
+	dialer, err := proxy.NewDialer(in.Proxy, in.DialTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid proxy config")
 	}
 
 	if fakeCertificateLoading {
-		return &TLSConnecter{in.Address, dialer, nil}, nil
+		return &TLSConnecter{Address: in.Address, dialer: dialer}, nil
 	}
 
-	ca, err := tlsconf.ParseCAFile(in.Ca)
-	if err != nil {
-		return nil, errors.Wrap(err, "cannot parse ca file")
+	var serverIdentity *tlsconf.IdentityExtractor
+	serverName := in.ServerCN
+	switch {
+	case in.ServerCN != "" && in.ServerIdentity != nil:
+		return nil, errors.New("fields 'server_cn' and 'server_identity' are mutually exclusive")
+	case in.ServerIdentity != nil:
+		var err error
+		serverIdentity, err = tlsconf.NewIdentityExtractor(in.ServerIdentity.From, in.ServerIdentity.Regex)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid server_identity")
+		}
+		if in.ServerIdentity.Expect == "" {
+			return nil, errors.New("field 'server_identity.expect' must not be empty")
+		}
+		// only used for SNI
+		serverName, _, err = net.SplitHostPort(in.Address)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid address")
+		}
+	case in.ServerCN == "":
+		return nil, errors.New("one of the fields 'server_cn' or 'server_identity' must be specified")
 	}
 
-	cert, err := tls.LoadX509KeyPair(in.Cert, in.Key)
+	store, err := tlsconf.NewStore(tlsconf.Files{CA: in.Ca, Cert: in.Cert, Key: in.Key})
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot parse cert/key pair")
+		return nil, errors.Wrap(err, "cannot load TLS material")
 	}
 
-	tlsConfig, err := tlsconf.ClientAuthClient(in.ServerCN, ca, cert)
+	m := store.Get()
+	tlsConfig, err := tlsconf.ClientAuthClient(serverName, m.CA, m.Cert)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot build tls config")
 	}
 
-	return &TLSConnecter{in.Address, dialer, tlsConfig}, nil
+	c := &TLSConnecter{Address: in.Address, dialer: dialer, store: store, tlsConfig: tlsConfig}
+	if serverIdentity != nil {
+		c.serverIdentity, c.expectServerIdentity = serverIdentity, in.ServerIdentity.Expect
+	}
+	return c, nil
 }
 
 func (c *TLSConnecter) Connect(dialCtx context.Context) (transport.Wire, error) {
-	conn, err := c.dialer.DialContext(dialCtx, "tcp", c.Address)
+	tcpConn, err := c.dialer.DialContext(dialCtx, c.Address)
 	if err != nil {
 		return nil, err
 	}
-	tcpConn := conn.(*net.TCPConn)
-	tlsConn := tls.Client(conn, c.tlsConfig)
+	m := c.store.Get()
+	if reloadErr := c.store.TakeReloadError(); reloadErr != nil {
+		transport.GetLogger(dialCtx).WithError(reloadErr).Error("cannot reload TLS material")
+	}
+	tlsConfig := c.tlsConfig.Clone()
+	tlsConfig.Certificates = []tls.Certificate{m.Cert}
+	tlsConfig.RootCAs = m.CA
+	if c.serverIdentity != nil {
+		tlsConfig.InsecureSkipVerify = true // VerifyPeerCertificate verifies the chain
+		tlsConfig.VerifyPeerCertificate = tlsconf.VerifyServerIdentity(m.CA, c.serverIdentity, c.expectServerIdentity)
+	}
+	tlsConn := tls.Client(tcpConn, tlsConfig)
 	return newWireAdaptor(tlsConn, tcpConn), nil
 }
@@ -2,7 +2,6 @@ package tls
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"time"
 
@@ -29,20 +28,33 @@ func TLSListenerFactoryFromConfig(c *config.Global, in *config.TLSServe) (transp
 		return func() (transport.AuthenticatedListener, error) { return nil, nil }, nil
 	}
 
-	clientCA, err := tlsconf.ParseCAFile(in.Ca)
+	store, err := tlsconf.NewStore(tlsconf.Files{CA: in.Ca, Cert: in.Cert, Key: in.Key, CRL: in.CRL, RejectExpiredCRL: in.CRLRejectExpired})
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot parse ca file")
+		return nil, errors.Wrap(err, "cannot load TLS material")
 	}
 
-	serverCert, err := tls.LoadX509KeyPair(in.Cert, in.Key)
-	if err != nil {
-		return nil, errors.Wrap(err, "cannot parse cert/key pair")
+	var identity *tlsconf.IdentityExtractor
+	if in.ClientIdentity != nil {
+		identity, err = tlsconf.NewIdentityExtractor(in.ClientIdentity.From, in.ClientIdentity.Regex)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid client_identity")
+		}
 	}
 
-	clientCNs := make(map[string]struct{}, len(in.ClientCNs))
-	for i, cn := range in.ClientCNs {
+	allowed, field := in.ClientCNs, "client_cn"
+	if len(in.ClientIdentities) > 0 {
+		if len(in.ClientCNs) > 0 {
+			return nil, errors.New("fields 'client_cns' and 'client_identities' are mutually exclusive")
+		}
+		allowed, field = in.ClientIdentities, "client_identity"
+	}
+	if len(allowed) == 0 {
+		return nil, errors.New("one of the fields 'client_cns' or 'client_identities' must be specified")
+	}
+	clientCNs := make(map[string]struct{}, len(allowed))
+	for i, cn := range allowed {
 		if err := transport.ValidateClientIdentity(cn); err != nil {
-			return nil, errors.Wrapf(err, "unsuitable client_cn #%d %q", i, cn)
+			return nil, errors.Wrapf(err, "unsuitable %s #%d %q", field, i, cn)
 		}
 		// dupes are ok fr now
 		clientCNs[cn] = struct{}{}
@@ -53,8 +65,8 @@ func TLSListenerFactoryFromConfig(c *config.Global, in *config.TLSServe) (transp
 		if err != nil {
 			return nil, err
 		}
-		tl := tlsconf.NewClientAuthListener(l, clientCA, serverCert, handshakeTimeout)
-		return &tlsAuthListener{tl, clientCNs}, nil
+		tl := tlsconf.NewClientAuthListener(l, store, identity, handshakeTimeout)
+		return &tlsAuthListener{tl, store, clientCNs}, nil
 	}
 
 	return lf, nil
@@ -62,15 +74,23 @@ func TLSListenerFactoryFromConfig(c *config.Global, in *config.TLSServe) (transp
 
 type tlsAuthListener struct {
 	*tlsconf.ClientAuthListener
-	clientCNs map[string]struct{}
+	store     *tlsconf.Store
+	clientCNs map[string]struct{} // allowed client identities
 }
 
 func (l tlsAuthListener) Accept(ctx context.Context) (*transport.AuthConn, error) {
 	tcpConn, tlsConn, cn, err := l.ClientAuthListener.Accept()
+	if reloadErr := l.store.TakeReloadError(); reloadErr != nil {
+		transport.GetLogger(ctx).WithError(reloadErr).Error("cannot reload TLS material")
+	}
+	if crlErr := l.store.TakeCRLExpiredError(); crlErr != nil {
+		transport.GetLogger(ctx).WithError(crlErr).Error("TLS CRL expired")
+	}
 	if err != nil {
 		return nil, err
 	}
-	if _, ok := l.clientCNs[cn]; !ok {
+	validationErr := transport.ValidateClientIdentity(cn)
+	if _, ok := l.clientCNs[cn]; !ok || validationErr != nil {
 		log := transport.GetLogger(ctx)
 		if dl, ok := ctx.Deadline(); ok {
 			defer func() {
@@ -85,9 +105,12 @@ func (l tlsAuthListener) Accept(ctx context.Context) (*transport.AuthConn, error
 			}
 		}
 		if err := tlsConn.Close(); err != nil {
-			log.WithError(err).Error("error closing connection with unauthorized common name")
+			log.WithError(err).Error("error closing connection with unauthorized client identity")
+		}
+		if validationErr != nil {
+			return nil, fmt.Errorf("invalid client identity %q from %s: %s", cn, tlsConn.RemoteAddr(), validationErr)
 		}
-		return nil, fmt.Errorf("unauthorized client common name %q from %s", cn, tlsConn.RemoteAddr())
+		return nil, fmt.Errorf("unauthorized client identity %q from %s", cn, tlsConn.RemoteAddr())
 	}
 	adaptor := newWireAdaptor(tlsConn, tcpConn)
 	return transport.NewAuthConn(adaptor, cn), nil
@@ -0,0 +1,31 @@
+package unixsock
+
+import (
+	"context"
+	"net"
+
+	"github.com/pkg/errors"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/transport"
+)
+
+type UnixConnecter struct {
+	Path   string
+	dialer net.Dialer
+}
+
+func UnixConnecterFromConfig(in *config.UnixConnect) (*UnixConnecter, error) {
+	if in.Path == "" {
+		return nil, errors.New("field 'path' must not be empty")
+	}
+	return &UnixConnecter{in.Path, net.Dialer{Timeout: in.DialTimeout}}, nil
+}
+
+func (c *UnixConnecter) Connect(dialCtx context.Context) (transport.Wire, error) {
+	conn, err := c.dialer.DialContext(dialCtx, "unix", c.Path)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UnixConn), nil
+}
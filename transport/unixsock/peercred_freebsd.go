@@ -0,0 +1,50 @@
+package unixsock
+
+import (
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// struct xucred from sys/ucred.h
+type xucred struct {
+	Version uint32
+	UID     uint32
+	Ngroups int16
+	Groups  [16]uint32
+	_       uintptr // union { void *_cr_unused1; pid_t cr_pid; }
+}
+
+const (
+	solLocal      = 0 // SOL_LOCAL
+	localPeercred = 1 // LOCAL_PEERCRED
+	xucredVersion = 0 // XUCRED_VERSION
+)
+
+func peerCred(conn *net.UnixConn) (cred PeerCred, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return cred, err
+	}
+	var xuc xucred
+	var sockoptErr error
+	err = raw.Control(func(fd uintptr) {
+		l := uint32(unsafe.Sizeof(xuc))
+		_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, fd, solLocal, localPeercred, uintptr(unsafe.Pointer(&xuc)), uintptr(unsafe.Pointer(&l)), 0)
+		if errno != 0 {
+			sockoptErr = errno
+		}
+	})
+	if err != nil {
+		return cred, err
+	}
+	if sockoptErr != nil {
+		return cred, sockoptErr
+	}
+	if xuc.Version != xucredVersion || xuc.Ngroups < 1 {
+		return cred, errPeerCredUnsupported
+	}
+	// the first group is the effective group id
+	return PeerCred{UID: xuc.UID, GID: xuc.Groups[0]}, nil
+}
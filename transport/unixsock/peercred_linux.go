@@ -0,0 +1,26 @@
+package unixsock
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+func peerCred(conn *net.UnixConn) (cred PeerCred, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return cred, err
+	}
+	var ucred *unix.Ucred
+	var sockoptErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, sockoptErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return cred, err
+	}
+	if sockoptErr != nil {
+		return cred, sockoptErr
+	}
+	return PeerCred{UID: ucred.Uid, GID: ucred.Gid}, nil
+}
@@ -0,0 +1,9 @@
+// +build !linux,!freebsd
+
+package unixsock
+
+import "net"
+
+func peerCred(conn *net.UnixConn) (PeerCred, error) {
+	return PeerCred{}, errPeerCredUnsupported
+}
@@ -0,0 +1,174 @@
+// Package unixsock implements the unix transport, which connects zrepl daemons
+// on the same host through a unix domain socket.
+// The server authenticates clients by the credentials of the connecting process.
+package unixsock
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/transport"
+)
+
+// PeerCred are the credentials of the process that connected to a unix socket.
+type PeerCred struct {
+	UID, GID uint32
+}
+
+var errPeerCredUnsupported = errors.New("peer credentials of unix sockets are not supported on this platform")
+
+func UnixListenerFactoryFromConfig(g *config.Global, in *config.UnixServe) (transport.AuthenticatedListenerFactory, error) {
+	if in.Path == "" {
+		return nil, errors.New("field 'path' must not be empty")
+	}
+	clientMap, err := clientMapFromConfig(in.Clients)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse client map")
+	}
+	lf := func() (transport.AuthenticatedListener, error) {
+		l, err := listen(in.Path)
+		if err != nil {
+			return nil, err
+		}
+		return NewListener(l, clientMap), nil
+	}
+	return lf, nil
+}
+
+// listen removes a stale socket at path and listens on path.
+// Access control happens in Accept, so the socket is accessible by all users
+// that can access its directory.
+func listen(path string) (*net.UnixListener, error) {
+	if s, err := os.Lstat(path); err == nil {
+		if s.Mode()&os.ModeSocket == 0 {
+			return nil, errors.Errorf("unexpected file type at path %q", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, errors.Errorf("socket %q is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, errors.Wrapf(err, "cannot remove presumably stale socket %q", path)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "cannot stat(2) %q", path)
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(true)
+	if err := os.Chmod(path, 0666); err != nil {
+		l.Close()
+		return nil, errors.Wrapf(err, "cannot chmod(2) %q", path)
+	}
+	return l, nil
+}
+
+// ClientMap maps the credentials of a peer to its client identity.
+// Entries for the peer's user id take precedence over entries for its group id.
+type ClientMap struct {
+	uids map[uint32]string
+	gids map[uint32]string
+}
+
+// clientMapFromConfig parses keys of the form uid:N, gid:N, user:NAME or group:NAME.
+// User and group names are resolved once, when the configuration is loaded.
+func clientMapFromConfig(in map[string]string) (*ClientMap, error) {
+	if len(in) == 0 {
+		return nil, errors.New("must not be empty")
+	}
+	m := &ClientMap{uids: make(map[uint32]string), gids: make(map[uint32]string)}
+	for key, clientIdentity := range in {
+		if err := transport.ValidateClientIdentity(clientIdentity); err != nil {
+			return nil, errors.Wrapf(err, "invalid client identity %q for %q", clientIdentity, key)
+		}
+		colon := strings.Index(key, ":")
+		if colon == -1 {
+			return nil, errors.Errorf("invalid key %q: must be uid:N, gid:N, user:NAME or group:NAME", key)
+		}
+		kind, val := key[:colon], key[colon+1:]
+		var id string
+		var ids map[uint32]string
+		switch kind {
+		case "uid":
+			id, ids = val, m.uids
+		case "gid":
+			id, ids = val, m.gids
+		case "user":
+			u, err := user.Lookup(val)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid key %q", key)
+			}
+			id, ids = u.Uid, m.uids
+		case "group":
+			g, err := user.LookupGroup(val)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid key %q", key)
+			}
+			id, ids = g.Gid, m.gids
+		default:
+			return nil, errors.Errorf("invalid key %q: must be uid:N, gid:N, user:NAME or group:NAME", key)
+		}
+		n, err := strconv.ParseUint(id, 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid key %q", key)
+		}
+		if other, ok := ids[uint32(n)]; ok {
+			return nil, errors.Errorf("key %q maps to the same id as another entry (client identities %q and %q)", key, other, clientIdentity)
+		}
+		ids[uint32(n)] = clientIdentity
+	}
+	return m, nil
+}
+
+func (m *ClientMap) Get(cred PeerCred) (clientIdentity string, err error) {
+	if ci, ok := m.uids[cred.UID]; ok {
+		return ci, nil
+	}
+	if ci, ok := m.gids[cred.GID]; ok {
+		return ci, nil
+	}
+	return "", fmt.Errorf("no client identity for peer with uid %d gid %d", cred.UID, cred.GID)
+}
+
+type UnixAuthListener struct {
+	*net.UnixListener
+	clientMap *ClientMap
+}
+
+var _ transport.AuthenticatedListener = (*UnixAuthListener)(nil)
+
+// NewListener returns a listener that authenticates the connections accepted from l
+// by the peer credentials, using clientMap.
+func NewListener(l *net.UnixListener, clientMap *ClientMap) *UnixAuthListener {
+	return &UnixAuthListener{l, clientMap}
+}
+
+func (l *UnixAuthListener) Accept(ctx context.Context) (*transport.AuthConn, error) {
+	nc, err := l.UnixListener.AcceptUnix()
+	if err != nil {
+		return nil, err
+	}
+	cred, err := peerCred(nc)
+	if err != nil {
+		nc.Close()
+		return nil, errors.Wrap(err, "cannot get peer credentials")
+	}
+	clientIdent, err := l.clientMap.Get(cred)
+	if err != nil {
+		transport.GetLogger(ctx).WithField("uid", cred.UID).WithField("gid", cred.GID).Error("peer not in client map")
+		nc.Close()
+		return nil, err
+	}
+	return transport.NewAuthConn(nc, clientIdent), nil
+}
@@ -0,0 +1,126 @@
+package unixsock
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/config"
+	"github.com/zrepl/zrepl/util/socketpair"
+)
+
+func skipIfPeerCredUnsupported(t *testing.T) {
+	a, b, err := socketpair.SocketPair()
+	require.NoError(t, err)
+	defer a.Close()
+	defer b.Close()
+	if _, err := peerCred(a); err == errPeerCredUnsupported {
+		t.Skip(err)
+	}
+}
+
+func TestPeerCred(t *testing.T) {
+	skipIfPeerCredUnsupported(t)
+	a, b, err := socketpair.SocketPair()
+	require.NoError(t, err)
+	defer a.Close()
+	defer b.Close()
+	cred, err := peerCred(a)
+	require.NoError(t, err)
+	assert.Equal(t, PeerCred{UID: uint32(os.Getuid()), GID: uint32(os.Getgid())}, cred)
+}
+
+func TestClientMap(t *testing.T) {
+	m, err := clientMapFromConfig(map[string]string{
+		"uid:1000": "alice",
+		"gid:100":  "users",
+	})
+	require.NoError(t, err)
+	for _, c := range []struct {
+		cred   PeerCred
+		expect string
+	}{
+		{PeerCred{1000, 100}, "alice"}, // uid takes precedence
+		{PeerCred{1000, 1}, "alice"},
+		{PeerCred{1001, 100}, "users"},
+		{PeerCred{1001, 1}, ""},
+	} {
+		ci, err := m.Get(c.cred)
+		if c.expect == "" {
+			assert.Error(t, err)
+		} else {
+			require.NoError(t, err)
+			assert.Equal(t, c.expect, ci)
+		}
+	}
+
+	for _, invalid := range []map[string]string{
+		nil,
+		{"1000": "alice"},
+		{"uid:alice": "alice"},
+		{"uid:-1": "alice"},
+		{"pid:1": "alice"},
+		{"uid:1000": "not/a/component"},
+		{"uid:1000": "alice", "uid:01000": "bob"},
+		{"user:zrepl-nonexistent-user": "alice"},
+	} {
+		_, err := clientMapFromConfig(invalid)
+		assert.Error(t, err, "%v", invalid)
+	}
+}
+
+func TestListenerAuthenticatesByPeerCred(t *testing.T) {
+	skipIfPeerCredUnsupported(t)
+
+	dir, err := ioutil.TempDir("", "zrepl-unixsock")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sock")
+
+	ctx := context.Background()
+	connecter, err := UnixConnecterFromConfig(&config.UnixConnect{Path: path, DialTimeout: 5 * time.Second})
+	require.NoError(t, err)
+
+	serve := func(clients map[string]string) (accepted chan string, close func()) {
+		lf, err := UnixListenerFactoryFromConfig(nil, &config.UnixServe{Path: path, Clients: clients})
+		require.NoError(t, err)
+		l, err := lf()
+		require.NoError(t, err)
+		accepted = make(chan string, 1)
+		go func() {
+			conn, err := l.Accept(ctx)
+			if err != nil {
+				accepted <- ""
+				return
+			}
+			defer conn.Close()
+			accepted <- conn.ClientIdentity()
+		}()
+		return accepted, func() { l.Close() }
+	}
+
+	accepted, closeListener := serve(map[string]string{fmt.Sprintf("uid:%d", os.Getuid()): "self"})
+	// a second listener must not take over the socket
+	_, err = listen(path)
+	assert.Error(t, err)
+	conn, err := connecter.Connect(ctx)
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, "self", <-accepted)
+	closeListener()
+
+	// the connecting process is not in the client map
+	accepted, closeListener = serve(map[string]string{fmt.Sprintf("uid:%d", os.Getuid()+1): "other"})
+	defer closeListener()
+	conn, err = connecter.Connect(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "", <-accepted)
+}
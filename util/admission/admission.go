@@ -0,0 +1,125 @@
+// Package admission implements admission control for the passive side of a replication setup:
+// requests that exceed a concurrency limit are rejected with a ResourceExhaustedError
+// that tells the client when to retry, instead of blocking until the client's request times out.
+package admission
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zrepl/zrepl/util/semaphore"
+)
+
+// Capability is the rpc capability (versionhandshake extension) that indicates that
+// a client handles ResourceExhaustedErrors.
+// Servers must not reject requests of clients without this capability,
+// because older clients treat the error as permanent.
+const Capability = "resource-exhausted-v1"
+
+// DefaultRetryAfter is the retry-after hint used if none is configured.
+const DefaultRetryAfter = 30 * time.Second
+
+// ResourceExhaustedError is returned by the server if it cannot admit a request right now.
+// It is a temporary net.Error.
+// Admission is only applied to dataconn Send and Receive requests, which transmit
+// the error to the client in a dedicated response header.
+type ResourceExhaustedError struct {
+	// Human-readable description of the exhausted resource.
+	Resource string
+	// The client should not retry before this duration has elapsed.
+	RetryAfter time.Duration
+}
+
+func (e *ResourceExhaustedError) Error() string {
+	return fmt.Sprintf("resource exhausted (retry after %s): %s", e.RetryAfter, e.Resource)
+}
+
+func (e *ResourceExhaustedError) Timeout() bool   { return false }
+func (e *ResourceExhaustedError) Temporary() bool { return true }
+
+// RetryAfterHint implements the interface that the replication driver uses to
+// delay the next attempt.
+func (e *ResourceExhaustedError) RetryAfterHint() time.Duration { return e.RetryAfter }
+
+// Limiter limits the number of concurrent operations per client identity.
+// A nil *Limiter does not limit.
+type Limiter struct {
+	resource     string
+	defaultLimit int            // 0 means unlimited
+	clientLimits map[string]int // overrides defaultLimit, 0 means unlimited
+	retryAfter   time.Duration
+
+	mtx  sync.Mutex
+	sems map[string]*semaphore.S
+}
+
+// NewLimiter returns a Limiter that admits defaultLimit concurrent operations per client identity,
+// or clientLimits[clientIdentity] if present. A limit of 0 means unlimited.
+// resource describes the operations, e.g. "concurrent transfers".
+func NewLimiter(resource string, defaultLimit int, clientLimits map[string]int, retryAfter time.Duration) *Limiter {
+	return &Limiter{
+		resource:     resource,
+		defaultLimit: defaultLimit,
+		clientLimits: clientLimits,
+		retryAfter:   retryAfter,
+		sems:         make(map[string]*semaphore.S),
+	}
+}
+
+// RetryAfter returns the retry-after hint for errors of l.
+func (l *Limiter) RetryAfter() time.Duration {
+	if l == nil || l.retryAfter <= 0 {
+		return DefaultRetryAfter
+	}
+	return l.retryAfter
+}
+
+// returns nil if clientIdentity is not limited
+func (l *Limiter) semaphore(clientIdentity string) (_ *semaphore.S, limit int) {
+	if l == nil {
+		return nil, 0
+	}
+	limit, ok := l.clientLimits[clientIdentity]
+	if !ok {
+		limit = l.defaultLimit
+	}
+	if limit <= 0 {
+		return nil, 0
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	s, ok := l.sems[clientIdentity]
+	if !ok {
+		s = semaphore.New(int64(limit))
+		l.sems[clientIdentity] = s
+	}
+	return s, limit
+}
+
+// Acquire admits an operation of clientIdentity, blocking until it can be admitted.
+func (l *Limiter) Acquire(ctx context.Context, clientIdentity string) (*semaphore.AcquireGuard, error) {
+	s, _ := l.semaphore(clientIdentity)
+	if s == nil {
+		return nil, nil // Release on a nil guard is a no-op
+	}
+	return s.Acquire(ctx)
+}
+
+// TryAcquire admits an operation of clientIdentity if the limit permits,
+// and returns a *ResourceExhaustedError otherwise.
+func (l *Limiter) TryAcquire(clientIdentity string) (*semaphore.AcquireGuard, error) {
+	s, limit := l.semaphore(clientIdentity)
+	if s == nil {
+		return nil, nil
+	}
+	g, ok := s.TryAcquire()
+	if !ok {
+		return nil, &ResourceExhaustedError{
+			Resource:   fmt.Sprintf("%s of client %q (limit %d)", l.resource, clientIdentity, limit),
+			RetryAfter: l.RetryAfter(),
+		}
+	}
+	return g, nil
+}
@@ -0,0 +1,68 @@
+package admission
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/zrepl/zrepl/daemon/logging/trace"
+)
+
+func TestResourceExhaustedError(t *testing.T) {
+	e := &ResourceExhaustedError{Resource: "concurrent transfers of client \"prod1\" (limit 2)", RetryAfter: 90 * time.Second}
+
+	var neterr net.Error = e
+	assert.True(t, neterr.Temporary())
+	assert.False(t, neterr.Timeout())
+	assert.Equal(t, 90*time.Second, e.RetryAfterHint())
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	defer trace.WithTaskFromStackUpdateCtx(&ctx)()
+
+	l := NewLimiter("concurrent transfers", 1, map[string]int{"unlimited": 0, "two": 2}, time.Minute)
+
+	g, err := l.TryAcquire("default")
+	require.NoError(t, err)
+	_, err = l.TryAcquire("default")
+	require.Error(t, err)
+	busy, ok := err.(*ResourceExhaustedError)
+	require.True(t, ok)
+	assert.Equal(t, time.Minute, busy.RetryAfterHint())
+	assert.Contains(t, busy.Resource, `"default"`)
+
+	// limits are per client
+	g2, err := l.TryAcquire("two")
+	require.NoError(t, err)
+	_, err = l.TryAcquire("two")
+	require.NoError(t, err)
+	_, err = l.TryAcquire("two")
+	require.Error(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = l.TryAcquire("unlimited")
+		require.NoError(t, err)
+	}
+
+	g.Release()
+	_, err = l.TryAcquire("default")
+	require.NoError(t, err)
+
+	// Acquire blocks
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = l.Acquire(timeoutCtx, "two")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	g2.Release()
+	_, err = l.Acquire(ctx, "two")
+	require.NoError(t, err)
+
+	var nilLimiter *Limiter
+	_, err = nilLimiter.TryAcquire("default")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRetryAfter, nilLimiter.RetryAfter())
+}
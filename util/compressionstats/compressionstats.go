@@ -0,0 +1,54 @@
+// Package compressionstats records how the ZFS stream of a replication step
+// was compressed in transit.
+//
+// It is a separate package so that the replication logic, which consumes the
+// statistics, does not depend on the transport (rpc/dataconn) that produces them.
+package compressionstats
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Stats counts the bytes before and after compression of a stream.
+// All methods are safe for concurrent use.
+type Stats struct {
+	algorithm    atomic.Value // string
+	compressed   int64
+	uncompressed int64
+}
+
+func (s *Stats) SetAlgorithm(a string) { s.algorithm.Store(a) }
+
+// Algorithm returns the name of the algorithm used for the stream,
+// or "" if the stream was not compressed.
+func (s *Stats) Algorithm() string {
+	a, _ := s.algorithm.Load().(string)
+	return a
+}
+
+func (s *Stats) AddCompressed(n int64) { atomic.AddInt64(&s.compressed, n) }
+
+func (s *Stats) AddUncompressed(n int64) { atomic.AddInt64(&s.uncompressed, n) }
+
+// Compressed returns the number of bytes that were transferred over the wire.
+func (s *Stats) Compressed() int64 { return atomic.LoadInt64(&s.compressed) }
+
+// Uncompressed returns the number of bytes that were produced by zfs send.
+func (s *Stats) Uncompressed() int64 { return atomic.LoadInt64(&s.uncompressed) }
+
+type contextKey int
+
+const contextKeyStats contextKey = 1 + iota
+
+// WithStats returns a context that instructs the transport to record statistics
+// about the compression of the stream of a Send or Receive request in s.
+func WithStats(ctx context.Context, s *Stats) context.Context {
+	return context.WithValue(ctx, contextKeyStats, s)
+}
+
+// FromContext returns the Stats passed to WithStats, or nil.
+func FromContext(ctx context.Context) *Stats {
+	s, _ := ctx.Value(contextKeyStats).(*Stats)
+	return s
+}
@@ -0,0 +1,71 @@
+// Package sdnotify implements the sd_notify(3) protocol that services use to
+// report their state to systemd, including the service watchdog.
+//
+// The implementation does not depend on libsystemd:
+// a notification is a single datagram sent to the unix socket in $NOTIFY_SOCKET.
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends state to the service manager.
+// It returns false and no error if the process was not started by a service manager
+// that expects notifications, i.e., if $NOTIFY_SOCKET is unset.
+func Notify(state string) (sent bool, err error) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return false, nil
+	}
+	addr := &net.UnixAddr{Name: socketPath, Net: "unixgram"}
+	if socketPath[0] == '@' { // abstract namespace
+		addr.Name = "\x00" + socketPath[1:]
+	}
+	conn, err := net.DialUnix(addr.Net, nil, addr)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot connect to NOTIFY_SOCKET")
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, errors.Wrap(err, "cannot write to NOTIFY_SOCKET")
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the watchdog timeout configured by the service manager
+// ($WATCHDOG_USEC, see WatchdogSec= in systemd.service(5)),
+// or false if the watchdog is disabled or not meant for this process.
+//
+// The service must send Watchdog notifications more frequently than the timeout,
+// systemd recommends half the timeout.
+func WatchdogInterval() (time.Duration, bool, error) {
+	usecStr := os.Getenv("WATCHDOG_USEC")
+	if usecStr == "" {
+		return 0, false, nil
+	}
+	if pidStr := os.Getenv("WATCHDOG_PID"); pidStr != "" {
+		pid, err := strconv.Atoi(pidStr)
+		if err != nil {
+			return 0, false, errors.Wrapf(err, "invalid WATCHDOG_PID %q", pidStr)
+		}
+		if pid != os.Getpid() {
+			return 0, false, nil
+		}
+	}
+	usec, err := strconv.ParseInt(usecStr, 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false, errors.Errorf("invalid WATCHDOG_USEC %q", usecStr)
+	}
+	return time.Duration(usec) * time.Microsecond, true, nil
+}
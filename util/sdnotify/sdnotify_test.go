@@ -0,0 +1,76 @@
+package sdnotify
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setenv(t *testing.T, key, value string) {
+	prev, wasSet := os.LookupEnv(key)
+	require.NoError(t, os.Setenv(key, value))
+	t.Cleanup(func() {
+		if wasSet {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestNotify(t *testing.T) {
+	setenv(t, "NOTIFY_SOCKET", "")
+	sent, err := Notify(Ready)
+	assert.NoError(t, err)
+	assert.False(t, sent)
+
+	dir, err := ioutil.TempDir("", "zrepl-sdnotify")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	sockpath := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sockpath, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	setenv(t, "NOTIFY_SOCKET", sockpath)
+	sent, err = Notify(Ready)
+	require.NoError(t, err)
+	assert.True(t, sent)
+
+	buf := make([]byte, 128)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	setenv(t, "WATCHDOG_USEC", "")
+	setenv(t, "WATCHDOG_PID", "")
+	_, enabled, err := WatchdogInterval()
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+
+	setenv(t, "WATCHDOG_USEC", "30000000")
+	d, enabled, err := WatchdogInterval()
+	assert.NoError(t, err)
+	assert.True(t, enabled)
+	assert.Equal(t, 30*time.Second, d)
+
+	setenv(t, "WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	_, enabled, err = WatchdogInterval()
+	assert.NoError(t, err)
+	assert.False(t, enabled, "watchdog is meant for another process")
+
+	setenv(t, "WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	setenv(t, "WATCHDOG_USEC", "garbage")
+	_, _, err = WatchdogInterval()
+	assert.Error(t, err)
+}
@@ -31,6 +31,16 @@ func (s *S) Acquire(ctx context.Context) (*AcquireGuard, error) {
 	return &AcquireGuard{s, false}, nil
 }
 
+// TryAcquire acquires the semaphore without blocking.
+// ok is false if the semaphore could not be acquired.
+// The returned AcquireGuard is not goroutine-safe.
+func (s *S) TryAcquire() (guard *AcquireGuard, ok bool) {
+	if !s.ws.TryAcquire(1) {
+		return nil, false
+	}
+	return &AcquireGuard{s, false}, true
+}
+
 func (g *AcquireGuard) Release() {
 	if g == nil || g.released {
 		return
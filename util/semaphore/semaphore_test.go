@@ -53,3 +53,15 @@ func TestSemaphore(t *testing.T) {
 	assert.True(t, acquisitions.afterT == numGoroutines-concurrentSemaphore)
 
 }
+
+func TestTryAcquire(t *testing.T) {
+	sem := New(1)
+	g, ok := sem.TryAcquire()
+	require.True(t, ok)
+	_, ok = sem.TryAcquire()
+	assert.False(t, ok)
+	g.Release()
+	g, ok = sem.TryAcquire()
+	require.True(t, ok)
+	g.Release()
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"os/exec"
 	"regexp"
@@ -1145,6 +1146,10 @@ func ZFSRecv(ctx context.Context, fs string, v *ZFSSendArgVersion, stream io.Rea
 		return nil
 	} else if _, isReadErr := waitErr.(*RecvCannotReadFromStreamErr); isReadErr {
 		return copierErr // likely network error reading from stream
+	} else if netErr, ok := copierErr.(net.Error); ok && netErr.Temporary() {
+		// we killed zfs recv because reading from stream failed temporarily
+		// (e.g. stream checksum mismatch) => waitErr is merely a symptom
+		return copierErr
 	} else {
 		return waitErr // almost always more interesting info. NOTE: do not wrap!
 	}